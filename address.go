@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Address is the postal address of an employee, stored as a document nested
+// inside the employee's
+type Address struct {
+	Street     string `json:"street" bson:"street" xml:"street"`
+	City       string `json:"city" bson:"city" xml:"city"`
+	State      string `json:"state" bson:"state" xml:"state"`
+	PostalCode string `json:"postalCode" bson:"postalCode" xml:"postalCode"`
+	// Country is the ISO 3166-1 alpha-2 code, like US or DE
+	Country string `json:"country" bson:"country" xml:"country"`
+}
+
+// maxAddressFieldLength is the longest street, city, state or postal code we accept
+const maxAddressFieldLength = 200
+
+var (
+	countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	// postal codes are letters and digits, with spaces and dashes between
+	// them, which covers the formats from 12345-6789 to SW1A 1AA
+	postalCodePattern = regexp.MustCompile(`^[A-Za-z0-9]+([ -][A-Za-z0-9]+)*$`)
+)
+
+// validate checks the address fields, naming them by their path in the
+// employee like address.country. Every field is optional, an address can be
+// filled in a bit at a time
+func (a *Address) validate() map[string]string {
+	errs := make(map[string]string)
+	for field, value := range map[string]string{"street": a.Street, "city": a.City, "state": a.State} {
+		if msg := checkAddressField(field, value); msg != "" {
+			errs["address."+field] = msg
+		}
+	}
+	if msg := checkPostalCode(a.PostalCode); msg != "" {
+		errs["address.postalCode"] = msg
+	}
+	if msg := checkCountry(a.Country); msg != "" {
+		errs["address.country"] = msg
+	}
+	return errs
+}
+
+// the rules for each address field, shared by full and partial updates like
+// the employee ones
+func checkAddressField(field, value string) string {
+	if len(value) > maxAddressFieldLength {
+		return fmt.Sprintf("%s can be at most %d characters", field, maxAddressFieldLength)
+	}
+	return ""
+}
+
+func checkPostalCode(postalCode string) string {
+	if msg := checkAddressField("postalCode", postalCode); msg != "" {
+		return msg
+	}
+	if postalCode != "" && !postalCodePattern.MatchString(postalCode) {
+		return "postalCode can only have letters and digits, separated by single spaces or dashes"
+	}
+	return ""
+}
+
+func checkCountry(country string) string {
+	if country != "" && !countryCodePattern.MatchString(country) {
+		return "country must be a 2 letter ISO 3166 code in capitals, like US"
+	}
+	return ""
+}
+
+// AddressPatch is the address part of a partial update. Only the fields sent
+// are changed, the rest of the address is kept
+type AddressPatch struct {
+	Street     *string `json:"street"`
+	City       *string `json:"city"`
+	State      *string `json:"state"`
+	PostalCode *string `json:"postalCode"`
+	Country    *string `json:"country"`
+}
+
+// validate checks only the fields present in the patch, using the same rules as Address
+func (p *AddressPatch) validate() map[string]string {
+	errs := make(map[string]string)
+	for field, value := range map[string]*string{"street": p.Street, "city": p.City, "state": p.State} {
+		if value != nil {
+			if msg := checkAddressField(field, *value); msg != "" {
+				errs["address."+field] = msg
+			}
+		}
+	}
+	if p.PostalCode != nil {
+		if msg := checkPostalCode(*p.PostalCode); msg != "" {
+			errs["address.postalCode"] = msg
+		}
+	}
+	if p.Country != nil {
+		if msg := checkCountry(*p.Country); msg != "" {
+			errs["address.country"] = msg
+		}
+	}
+	return errs
+}
+
+// setFields builds the $set of the fields sent, in dot notation. Setting the
+// address itself would replace the whole document and lose the fields left out
+func (p *AddressPatch) setFields() bson.D {
+	fields := bson.D{}
+
+	if p.Street != nil {
+		fields = append(fields, bson.E{Key: "address.street", Value: *p.Street})
+	}
+	if p.City != nil {
+		fields = append(fields, bson.E{Key: "address.city", Value: *p.City})
+	}
+	if p.State != nil {
+		fields = append(fields, bson.E{Key: "address.state", Value: *p.State})
+	}
+	if p.PostalCode != nil {
+		fields = append(fields, bson.E{Key: "address.postalCode", Value: *p.PostalCode})
+	}
+	if p.Country != nil {
+		fields = append(fields, bson.E{Key: "address.country", Value: *p.Country})
+	}
+	return fields
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAddressRoundTrips(t *testing.T) {
+	e := john
+	e.Address = &Address{Street: "1 Main St", City: "Springfield", State: "IL", PostalCode: "62701", Country: "US"}
+
+	// stored as a nested document, not flattened into the employee
+	raw, err := bson.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if city, ok := bson.Raw(raw).Lookup("address", "city").StringValueOK(); !ok || city != "Springfield" {
+		t.Errorf("bson address.city = %v, want Springfield", bson.Raw(raw).Lookup("address", "city"))
+	}
+	var fromBSON Employee
+	if err := bson.Unmarshal(raw, &fromBSON); err != nil || fromBSON.Address == nil || *fromBSON.Address != *e.Address {
+		t.Errorf("decoding bson: address = %+v, err %v, want %+v", fromBSON.Address, err, e.Address)
+	}
+
+	out, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"address":{"street":"1 Main St","city":"Springfield","state":"IL","postalCode":"62701","country":"US"}`; !strings.Contains(string(out), want) {
+		t.Errorf("JSON = %s, want %s", out, want)
+	}
+	var fromJSON Employee
+	if err := json.Unmarshal(out, &fromJSON); err != nil || fromJSON.Address == nil || *fromJSON.Address != *e.Address {
+		t.Errorf("decoding JSON: address = %+v, err %v, want %+v", fromJSON.Address, err, e.Address)
+	}
+
+	// an employee without an address has none stored or sent
+	raw, err = bson.Marshal(john)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bson.Raw(raw).LookupErr("address"); err == nil {
+		t.Error("an employee without an address was marshalled with one")
+	}
+	if out, _ := json.Marshal(john); strings.Contains(string(out), "address") {
+		t.Errorf("JSON = %s, want no address", out)
+	}
+}
+
+func TestAddressValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		address Address
+		want    map[string]string
+	}{
+		{name: "valid", address: Address{Street: "10 Downing St", City: "London", PostalCode: "SW1A 2AA", Country: "GB"}, want: map[string]string{}},
+		{name: "empty", address: Address{}, want: map[string]string{}},
+		{name: "lowercase country", address: Address{Country: "us"}, want: map[string]string{"address.country": "country must be a 2 letter ISO 3166 code in capitals, like US"}},
+		{name: "country name", address: Address{Country: "Germany"}, want: map[string]string{"address.country": "country must be a 2 letter ISO 3166 code in capitals, like US"}},
+		{name: "postal code punctuation", address: Address{PostalCode: "12345!"}, want: map[string]string{"address.postalCode": "postalCode can only have letters and digits, separated by single spaces or dashes"}},
+		{name: "long city", address: Address{City: strings.Repeat("x", maxAddressFieldLength+1)}, want: map[string]string{"address.city": "city can be at most 200 characters"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.address.validate()
+			if len(got) != len(tt.want) {
+				t.Fatalf("errors = %v, want %v", got, tt.want)
+			}
+			for field, msg := range tt.want {
+				if got[field] != msg {
+					t.Errorf("errors[%s] = %q, want %q", field, got[field], msg)
+				}
+			}
+		})
+	}
+}
+
+func TestEmployeeAddressUpdates(t *testing.T) {
+	repo := newFakeRepository(john)
+	app := newTestApp(repo, newFakeDepartmentRepository(engineering))
+	path := "/employee/" + johnID.Hex()
+	put := `{"name":"John Doe","email":"john@example.com","salary":50000,"age":30,"departmentId":"` + engineeringID.Hex() + `",` +
+		`"address":{"street":"1 Main St","city":"Springfield","postalCode":"62701","country":"US"}}`
+
+	req := newRequest(t, roleAdmin, "PUT", path, put)
+	req.Header.Set("If-Match", "*")
+	if resp, body := send(t, app, req); resp.StatusCode != 200 {
+		t.Fatalf("PUT: status = %d, body %q", resp.StatusCode, body)
+	}
+	// a patch only changes the fields sent, the street and postal code stay
+	if status, body := request(t, app, roleAdmin, "PATCH", path, `{"address":{"city":"Shelbyville","state":"IL"}}`); status != 200 {
+		t.Fatalf("PATCH: status = %d, body %q", status, body)
+	}
+	want := Address{Street: "1 Main St", City: "Shelbyville", State: "IL", PostalCode: "62701", Country: "US"}
+	if got := repo.employees[johnID].Address; got == nil || *got != want {
+		t.Errorf("address = %+v, want %+v", got, want)
+	}
+
+	status, body := request(t, app, roleAdmin, "PATCH", path, `{"address":{"country":"usa"}}`)
+	if status != 422 || !strings.Contains(body, `"field":"address.country"`) || !strings.Contains(body, `"value":"usa"`) {
+		t.Errorf("bad country: status = %d, body %q, want a 422 for address.country", status, body)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// dateLayout is how a day is written in the attendance records and query params
+const dateLayout = "2006-01-02"
+
+// maxAttendanceDays is the longest range GET /employee/:id/attendance reports on
+const maxAttendanceDays = 366
+
+// AttendanceRecord is one stretch of work, from a check-in to the matching
+// check-out. Open is set until the employee checks out, and Hours is filled in
+// then. A record counts towards the day it was checked in on, in UTC
+type AttendanceRecord struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty" swaggertype:"string"`
+	EmployeeID primitive.ObjectID `json:"employeeId" bson:"employeeId" swaggertype:"string"`
+	Date       string             `json:"date" bson:"date"`
+	CheckIn    time.Time          `json:"checkIn" bson:"checkIn"`
+	CheckOut   *time.Time         `json:"checkOut,omitempty" bson:"checkOut,omitempty"`
+	Open       bool               `json:"open" bson:"open"`
+	Hours      float64            `json:"hours" bson:"hours"`
+	// DeletedAt is set while the employee is deleted, which hides the record
+	DeletedAt *time.Time `json:"-" bson:"deletedAt,omitempty"`
+}
+
+// AttendanceDay is the records of one day and the hours worked in them
+type AttendanceDay struct {
+	Date    string             `json:"date"`
+	Hours   float64            `json:"hours"`
+	Records []AttendanceRecord `json:"records"`
+}
+
+// AttendanceReport is the body of GET /employee/:id/attendance
+type AttendanceReport struct {
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	TotalHours float64         `json:"totalHours"`
+	Days       []AttendanceDay `json:"days"`
+}
+
+// hoursBetween is the time from checkIn to checkOut in hours, rounded to the minute
+func hoursBetween(checkIn, checkOut time.Time) float64 {
+	return roundHours(checkOut.Sub(checkIn).Round(time.Minute).Hours())
+}
+
+// roundHours rounds to 2 decimal places, so sums of rounded hours don't show
+// float noise like 16.500000000000004
+func roundHours(hours float64) float64 {
+	return math.Round(hours*100) / 100
+}
+
+// newAttendanceReport groups the records, oldest first, into days. Only days
+// with records are listed, and open records count as no hours yet
+func newAttendanceReport(from, to time.Time, records []AttendanceRecord) AttendanceReport {
+	report := AttendanceReport{From: from.Format(dateLayout), To: to.Format(dateLayout), Days: make([]AttendanceDay, 0)}
+	for _, record := range records {
+		if n := len(report.Days); n == 0 || report.Days[n-1].Date != record.Date {
+			report.Days = append(report.Days, AttendanceDay{Date: record.Date})
+		}
+		day := &report.Days[len(report.Days)-1]
+		day.Records = append(day.Records, record)
+		day.Hours = roundHours(day.Hours + record.Hours)
+		report.TotalHours = roundHours(report.TotalHours + record.Hours)
+	}
+	return report
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AttendanceHandler holds the HTTP handlers for checking employees in and out
+// and reporting on the hours they worked
+type AttendanceHandler struct {
+	repo      AttendanceRepository
+	employees EmployeeRepository
+	audit     AuditRepository
+}
+
+// NewAttendanceHandler creates the attendance handlers on top of the repositories
+func NewAttendanceHandler(repo AttendanceRepository, employees EmployeeRepository, audit AuditRepository) *AttendanceHandler {
+	return &AttendanceHandler{repo: repo, employees: employees, audit: audit}
+}
+
+// CheckIn records that an employee started working now
+//
+// @Summary Check an employee in
+// @Tags attendance
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Success 201 {object} AttendanceRecord
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /employee/{id}/checkin [post]
+func (h *AttendanceHandler) CheckIn(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.employees.FindByID(c.UserContext(), employeeID); err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	record, err := h.repo.CheckIn(c.UserContext(), employeeID, time.Now())
+	if err != nil {
+		return repositoryError(err, "employee")
+	}
+	recordAudit(c, h.audit, auditCreate, attendanceCollection, record.ID.Hex(), nil, record)
+	return c.Status(201).JSON(record)
+}
+
+// CheckOut records that an employee stopped working now, closing the record
+// opened by their check-in
+//
+// @Summary Check an employee out
+// @Tags attendance
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Success 200 {object} AttendanceRecord
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /employee/{id}/checkout [post]
+func (h *AttendanceHandler) CheckOut(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.employees.FindByID(c.UserContext(), employeeID); err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	record, err := h.repo.CheckOut(c.UserContext(), employeeID, time.Now())
+	if err != nil {
+		return repositoryError(err, "employee")
+	}
+	// before the check-out the record was open, without the hours
+	before := *record
+	before.CheckOut, before.Open, before.Hours = nil, true, 0
+	recordAudit(c, h.audit, auditUpdate, attendanceCollection, record.ID.Hex(), &before, record)
+	return c.JSON(record)
+}
+
+// Report returns an employee's attendance records for a range of days, by
+// day, with the hours worked each day and in total. The range defaults to the
+// last 30 days
+//
+// @Summary Report on an employee's attendance
+// @Tags attendance
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Param from query string false "First day, like 2026-01-31"
+// @Param to query string false "Last day, like 2026-01-31, defaults to today"
+// @Success 200 {object} AttendanceReport
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /employee/{id}/attendance [get]
+func (h *AttendanceHandler) Report(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	from, to, err := parseDayRange(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.employees.FindByID(c.UserContext(), employeeID); err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	records, err := h.repo.FindByEmployee(c.UserContext(), employeeID, from.Format(dateLayout), to.Format(dateLayout))
+	if err != nil {
+		return err
+	}
+	return c.JSON(newAttendanceReport(from, to, records))
+}
+
+// parseDayRange reads the ?from= and ?to= days of a report, both included
+func parseDayRange(c *fiber.Ctx) (time.Time, time.Time, error) {
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if s := c.Query("to"); s != "" {
+		day, err := time.Parse(dateLayout, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, fiber.NewError(fiber.StatusBadRequest, "to must be a day like 2026-01-31")
+		}
+		to = day
+	}
+	from := to.AddDate(0, 0, -29)
+	if s := c.Query("from"); s != "" {
+		day, err := time.Parse(dateLayout, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, fiber.NewError(fiber.StatusBadRequest, "from must be a day like 2026-01-31")
+		}
+		from = day
+	}
+
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fiber.NewError(fiber.StatusBadRequest, "to can't be before from")
+	}
+	if to.Sub(from) >= maxAttendanceDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("a report can cover at most %d days", maxAttendanceDays))
+	}
+	return from, to, nil
+}
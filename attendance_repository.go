@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrAlreadyCheckedIn is returned when checking in an employee who hasn't checked out since
+	ErrAlreadyCheckedIn = errors.New("the employee is already checked in")
+	// ErrNotCheckedIn is returned when checking out an employee who isn't checked in
+	ErrNotCheckedIn = errors.New("the employee is not checked in")
+)
+
+// AttendanceRepository is everything the handlers need from the attendance store
+type AttendanceRepository interface {
+	// CheckIn opens a record for the employee, or fails with ErrAlreadyCheckedIn
+	CheckIn(ctx context.Context, employeeID primitive.ObjectID, at time.Time) (*AttendanceRecord, error)
+	// CheckOut closes the employee's open record, or fails with ErrNotCheckedIn
+	CheckOut(ctx context.Context, employeeID primitive.ObjectID, at time.Time) (*AttendanceRecord, error)
+	// FindByEmployee returns the employee's records for the days from and to,
+	// both included, oldest first
+	FindByEmployee(ctx context.Context, employeeID primitive.ObjectID, from, to string) ([]AttendanceRecord, error)
+	// DeleteByEmployee removes the employee's attendance records, returning how many there were
+	DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error)
+	// SoftDeleteByEmployees hides the records of the employees, who are being
+	// deleted, returning how many it hid
+	SoftDeleteByEmployees(ctx context.Context, employeeIDs []primitive.ObjectID) (int64, error)
+	// RestoreByEmployee brings back the records of the employee, who is being
+	// restored, returning how many came back
+	RestoreByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error)
+}
+
+// MongoAttendanceRepository is the AttendanceRepository backed by a mongo collection
+type MongoAttendanceRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoAttendanceRepository creates a repository storing attendance records in the collection
+func NewMongoAttendanceRepository(collection *mongo.Collection) *MongoAttendanceRepository {
+	return &MongoAttendanceRepository{collection: collection}
+}
+
+// EnsureIndexes creates the index records are read with, and a unique index on
+// the open records so an employee can only have one of them, even when two
+// check-ins race
+func (r *MongoAttendanceRepository) EnsureIndexes(ctx context.Context) error {
+	return ensureIndexes(ctx, r.collection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "employeeId", Value: 1}, {Key: "date", Value: 1}, {Key: "checkIn", Value: 1}},
+			Options: options.Index().SetName("employee_attendance"),
+		},
+		{
+			Keys: bson.D{{Key: "employeeId", Value: 1}},
+			Options: options.Index().SetName("open_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.D{{Key: "open", Value: true}}),
+		},
+	})
+}
+
+// CheckIn opens a record for the employee at the time
+func (r *MongoAttendanceRepository) CheckIn(ctx context.Context, employeeID primitive.ObjectID, at time.Time) (*AttendanceRecord, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	at = at.UTC()
+	record := &AttendanceRecord{
+		EmployeeID: employeeID,
+		Date:       at.Format(dateLayout),
+		CheckIn:    at,
+		Open:       true,
+	}
+	result, err := r.collection.InsertOne(ctx, record)
+	if err != nil {
+		return nil, mapError(err, ErrAlreadyCheckedIn)
+	}
+	record.ID = result.InsertedID.(primitive.ObjectID)
+	return record, nil
+}
+
+// CheckOut closes the employee's open record at the time and works out the
+// hours in it
+func (r *MongoAttendanceRepository) CheckOut(ctx context.Context, employeeID primitive.ObjectID, at time.Time) (*AttendanceRecord, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	open := new(AttendanceRecord)
+	err = r.collection.FindOne(ctx, bson.D{{Key: "employeeId", Value: employeeID}, {Key: "open", Value: true}, notDeleted}).Decode(open)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotCheckedIn
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	at = at.UTC()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "checkOut", Value: at},
+			{Key: "open", Value: false},
+			{Key: "hours", Value: hoursBetween(open.CheckIn, at)},
+		}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	// open is part of the filter, so a check-out racing this one can't close the record twice
+	closed := new(AttendanceRecord)
+	err = r.collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: open.ID}, {Key: "open", Value: true}}, update, opts).Decode(closed)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotCheckedIn
+	}
+	if err != nil {
+		return nil, err
+	}
+	return closed, nil
+}
+
+// FindByEmployee returns the employee's records for the days from and to, both
+// included, oldest first. The days are compared as strings, which sorts them
+// by date since they are all written the same way
+func (r *MongoAttendanceRepository) FindByEmployee(ctx context.Context, employeeID primitive.ObjectID, from, to string) ([]AttendanceRecord, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	filter := bson.D{
+		{Key: "employeeId", Value: employeeID},
+		{Key: "date", Value: bson.D{{Key: "$gte", Value: from}, {Key: "$lte", Value: to}}},
+		notDeleted,
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "checkIn", Value: 1}})
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]AttendanceRecord, 0)
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// DeleteByEmployee removes the employee's attendance records, open or not
+func (r *MongoAttendanceRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	result, err := r.collection.DeleteMany(ctx, bson.D{{Key: "employeeId", Value: employeeID}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// SoftDeleteByEmployees sets deletedAt on the employees' records that don't
+// have it yet
+func (r *MongoAttendanceRepository) SoftDeleteByEmployees(ctx context.Context, employeeIDs []primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	return softDeleteByEmployees(ctx, r.collection, employeeIDs)
+}
+
+// RestoreByEmployee unsets deletedAt on the employee's records
+func (r *MongoAttendanceRepository) RestoreByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	return restoreByEmployee(ctx, r.collection, employeeID)
+}
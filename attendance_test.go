@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeAttendanceRepository is an in-memory AttendanceRepository, keeping the
+// records in the order they were checked in. Deleted records are hidden like
+// the real one hides them
+type fakeAttendanceRepository struct {
+	records []AttendanceRecord
+}
+
+func newFakeAttendanceRepository(records ...AttendanceRecord) *fakeAttendanceRepository {
+	return &fakeAttendanceRepository{records: records}
+}
+
+func (r *fakeAttendanceRepository) CheckIn(ctx context.Context, employeeID primitive.ObjectID, at time.Time) (*AttendanceRecord, error) {
+	for _, record := range r.records {
+		if record.EmployeeID == employeeID && record.Open {
+			return nil, ErrAlreadyCheckedIn
+		}
+	}
+	at = at.UTC()
+	record := AttendanceRecord{ID: primitive.NewObjectID(), EmployeeID: employeeID, Date: at.Format(dateLayout), CheckIn: at, Open: true}
+	r.records = append(r.records, record)
+	return &record, nil
+}
+
+func (r *fakeAttendanceRepository) CheckOut(ctx context.Context, employeeID primitive.ObjectID, at time.Time) (*AttendanceRecord, error) {
+	for i, record := range r.records {
+		if record.EmployeeID == employeeID && record.Open && record.DeletedAt == nil {
+			at = at.UTC()
+			record.CheckOut, record.Open, record.Hours = &at, false, hoursBetween(record.CheckIn, at)
+			r.records[i] = record
+			return &record, nil
+		}
+	}
+	return nil, ErrNotCheckedIn
+}
+
+func (r *fakeAttendanceRepository) FindByEmployee(ctx context.Context, employeeID primitive.ObjectID, from, to string) ([]AttendanceRecord, error) {
+	records := make([]AttendanceRecord, 0)
+	for _, record := range r.records {
+		if record.EmployeeID == employeeID && record.Date >= from && record.Date <= to && record.DeletedAt == nil {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (r *fakeAttendanceRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	kept := r.records[:0]
+	for _, record := range r.records {
+		if record.EmployeeID != employeeID {
+			kept = append(kept, record)
+		}
+	}
+	deleted := int64(len(r.records) - len(kept))
+	r.records = kept
+	return deleted, nil
+}
+
+func (r *fakeAttendanceRepository) SoftDeleteByEmployees(ctx context.Context, employeeIDs []primitive.ObjectID) (int64, error) {
+	now := time.Now().UTC()
+	var deleted int64
+	for i, record := range r.records {
+		if record.DeletedAt == nil && isOneOf(employeeIDs, record.EmployeeID) {
+			r.records[i].DeletedAt = &now
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *fakeAttendanceRepository) RestoreByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	var restored int64
+	for i, record := range r.records {
+		if record.DeletedAt != nil && record.EmployeeID == employeeID {
+			r.records[i].DeletedAt = nil
+			restored++
+		}
+	}
+	return restored, nil
+}
+
+func TestCheckInAndOut(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+	path := "/employee/" + johnID.Hex()
+
+	if status, body := request(t, app, roleAdmin, "POST", path+"/checkout", ""); status != 409 {
+		t.Errorf("checking out before checking in: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, roleAdmin, "POST", path+"/checkin", ""); status != 201 {
+		t.Fatalf("check in: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, roleAdmin, "POST", path+"/checkin", ""); status != 409 {
+		t.Errorf("checking in twice: status = %d, body %q", status, body)
+	}
+
+	status, body := request(t, app, roleAdmin, "POST", path+"/checkout", "")
+	var record AttendanceRecord
+	if err := json.Unmarshal([]byte(body), &record); err != nil || status != 200 {
+		t.Fatalf("check out: status = %d, body %q", status, body)
+	}
+	if record.Open || record.CheckOut == nil {
+		t.Errorf("checked out record = %+v, want it closed", record)
+	}
+	if status, body := request(t, app, roleAdmin, "POST", path+"/checkin", ""); status != 201 {
+		t.Errorf("checking in again after checking out: status = %d, body %q", status, body)
+	}
+
+	runHandlerTests(t, []handlerTest{
+		{name: "unknown employee", method: "POST", path: "/employee/" + missingID + "/checkin", wantStatus: 404, wantBody: "employee not found"},
+		{name: "malformed id", method: "POST", path: "/employee/nope/checkout", wantStatus: 400},
+		{name: "viewer can't check in", role: roleViewer, method: "POST", path: path + "/checkin", wantStatus: 403},
+	})
+}
+
+func TestAttendanceAudit(t *testing.T) {
+	audit := newFakeAuditRepository()
+	app := newApp(testConfig(), Repositories{
+		Employees:       newFakeRepository(john),
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       audit,
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+	path := "/employee/" + johnID.Hex()
+
+	status, body := request(t, app, roleAdmin, "POST", path+"/checkin", "")
+	var record AttendanceRecord
+	if err := json.Unmarshal([]byte(body), &record); err != nil || status != 201 {
+		t.Fatalf("check in: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, roleAdmin, "POST", path+"/checkout", ""); status != 200 {
+		t.Fatalf("check out: status = %d, body %q", status, body)
+	}
+
+	if len(audit.entries) != 2 {
+		t.Fatalf("audit entries = %+v, want a check-in and a check-out", audit.entries)
+	}
+	for _, entry := range audit.entries {
+		if entry.Collection != attendanceCollection || entry.DocumentID != record.ID.Hex() || entry.Actor != "tester" {
+			t.Errorf("entry %+v, want one for the record by tester", entry)
+		}
+	}
+	checkIn, checkOut := audit.entries[0], audit.entries[1]
+	if checkIn.Action != auditCreate || checkIn.Before != nil || checkIn.After["open"] != true {
+		t.Errorf("check-in entry = %+v, want an open record created", checkIn)
+	}
+	if checkOut.Action != auditUpdate || checkOut.Before["open"] != true || checkOut.After["open"] != false || checkOut.After["checkOut"] == nil {
+		t.Errorf("check-out entry = %+v, want the record closed", checkOut)
+	}
+}
+
+func TestAttendanceReport(t *testing.T) {
+	at := func(s string) time.Time {
+		parsed, _ := time.Parse(time.RFC3339, s)
+		return parsed
+	}
+	closed := func(checkIn, checkOut string) AttendanceRecord {
+		out := at(checkOut)
+		return AttendanceRecord{ID: primitive.NewObjectID(), EmployeeID: johnID, Date: checkIn[:10], CheckIn: at(checkIn), CheckOut: &out, Hours: hoursBetween(at(checkIn), out)}
+	}
+	attendance := newFakeAttendanceRepository(
+		closed("2026-03-01T09:00:00Z", "2026-03-01T12:30:00Z"),
+		closed("2026-03-01T13:00:00Z", "2026-03-01T17:20:00Z"),
+		closed("2026-03-02T09:00:00Z", "2026-03-02T17:00:00Z"),
+		closed("2026-03-05T09:00:00Z", "2026-03-05T10:00:00Z"),
+	)
+	app := newApp(testConfig(), Repositories{
+		Employees:       newFakeRepository(john),
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      attendance,
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+	path := "/employee/" + johnID.Hex() + "/attendance"
+
+	status, body := request(t, app, roleViewer, "GET", path+"?from=2026-03-01&to=2026-03-02", "")
+	var report AttendanceReport
+	if err := json.Unmarshal([]byte(body), &report); err != nil || status != 200 {
+		t.Fatalf("report: status = %d, body %q", status, body)
+	}
+	if report.TotalHours != 15.83 || len(report.Days) != 2 {
+		t.Fatalf("report = %+v, want 15.83 hours over 2 days", report)
+	}
+	if day := report.Days[0]; day.Date != "2026-03-01" || day.Hours != 7.83 || len(day.Records) != 2 {
+		t.Errorf("first day = %+v, want 7.83 hours in 2 records on 2026-03-01", day)
+	}
+
+	runHandlerTests(t, []handlerTest{
+		{name: "malformed day", method: "GET", path: path + "?from=March", wantStatus: 400, wantBody: "from must be a day"},
+		{name: "backwards range", method: "GET", path: path + "?from=2026-03-02&to=2026-03-01", wantStatus: 400},
+		{name: "too long a range", method: "GET", path: path + "?from=2020-01-01&to=2026-01-01", wantStatus: 400},
+		{name: "unknown employee", method: "GET", path: "/employee/" + missingID + "/attendance", wantStatus: 404},
+		{name: "no records", role: roleViewer, method: "GET", path: path, wantStatus: 200, wantBody: `"days":[]`},
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// the collections audit entries refer to. Entries always name the employees
+// by employeesCollection, even when EMPLOYEES_COLLECTION stores them elsewhere
+const (
+	employeesCollection     = "employees"
+	departmentsCollection   = "departments"
+	leaveRequestsCollection = "leave_requests"
+	salaryChangesCollection = "salary_changes"
+	attendanceCollection    = "attendance"
+	photosCollection        = photosBucket
+	usersCollection         = "users"
+)
+
+// the actions recorded in the audit log
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditDelete  = "delete"
+	auditRestore = "restore"
+	auditImport  = "import"
+	auditPurge   = "purge"
+	// auditPassword is a user's password being changed. The entry holds no
+	// hash, only who changed whose password and when
+	auditPassword = "password"
+)
+
+// recordAudit writes an entry for a change the request made, taking the actor
+// from the request's token. The change has already happened by the time this
+// runs, so a failure to write the entry is logged rather than failing the request
+func recordAudit(c *fiber.Ctx, audit AuditRepository, action, collection, documentID string, before, after interface{}) {
+	entry := &AuditEntry{
+		Action:     action,
+		Collection: collection,
+		DocumentID: documentID,
+		Before:     auditSnapshot(before),
+		After:      auditSnapshot(after),
+		Timestamp:  time.Now().UTC(),
+	}
+	if claims := currentClaims(c); claims != nil {
+		entry.Actor = claims.Subject
+	}
+
+	if err := audit.Record(c.UserContext(), entry); err != nil {
+		requestLog(c).Error("writing audit entry",
+			slog.String("action", action),
+			slog.String("collection", collection),
+			slog.String("document_id", documentID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// auditSnapshot turns a record into the document stored in an audit entry, so
+// entries can hold any kind of record and still be read back as plain JSON
+func auditSnapshot(v interface{}) bson.M {
+	// a typed nil, like a *Employee that was never found, is no record either
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil
+	}
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return bson.M{"error": "could not snapshot record: " + err.Error()}
+	}
+	var doc bson.M
+	if err := bson.UnmarshalWithRegistry(moneyRegistry, raw, &doc); err != nil {
+		return bson.M{"error": "could not snapshot record: " + err.Error()}
+	}
+	return doc
+}
+
+// auditHandler lists the audit log, newest first. It can be narrowed down to
+// the history of one record with ?documentId=<id>, and by ?collection and ?action
+//
+// @Summary List the audit log
+// @Tags audit
+// @Produce json
+// @Security BearerAuth
+// @Param documentId query string false "Only changes to this record"
+// @Param collection query string false "Only changes to this collection" Enums(employees, departments, leave_requests, salary_changes, attendance, employee_photos, users)
+// @Param action query string false "Only this kind of change" Enums(create, update, delete, restore, import, purge, password)
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Entries per page, at most 100"
+// @Success 200 {object} AuditList
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /audit [get]
+func auditHandler(audit AuditRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query := bson.D{}
+		for _, field := range []string{"documentId", "collection", "action"} {
+			if value := c.Query(field); value != "" {
+				query = append(query, bson.E{Key: field, Value: value})
+			}
+		}
+
+		page, limit := parsePagination(c)
+		findOptions := options.Find().
+			SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+			SetSkip((page - 1) * limit).
+			SetLimit(limit)
+
+		total, err := audit.Count(c.UserContext(), query)
+		if err != nil {
+			return err
+		}
+		entries, err := audit.FindAll(c.UserContext(), query, findOptions)
+		if err != nil {
+			return err
+		}
+		return c.JSON(AuditList{Data: entries, Page: page, Limit: limit, Total: total})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEntry records one change to a record: what was done, by who, and the
+// record as it was before and after. Before is empty for creates and After is
+// empty for deletes
+type AuditEntry struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Action     string             `json:"action" bson:"action"`
+	Collection string             `json:"collection" bson:"collection"`
+	DocumentID string             `json:"documentId,omitempty" bson:"documentId,omitempty"`
+	Actor      string             `json:"actor" bson:"actor"`
+	Before     bson.M             `json:"before,omitempty" bson:"before,omitempty" swaggertype:"object"`
+	After      bson.M             `json:"after,omitempty" bson:"after,omitempty" swaggertype:"object"`
+	Timestamp  time.Time          `json:"timestamp" bson:"timestamp"`
+}
+
+// AuditList wraps a page of audit entries together with the pagination metadata
+type AuditList struct {
+	Data  []AuditEntry `json:"data"`
+	Page  int64        `json:"page"`
+	Limit int64        `json:"limit"`
+	Total int64        `json:"total"`
+}
+
+// AuditRepository is everything the handlers need from the audit log
+type AuditRepository interface {
+	Record(ctx context.Context, entry *AuditEntry) error
+	FindAll(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]AuditEntry, error)
+	Count(ctx context.Context, filter bson.D) (int64, error)
+	// DeleteByEmployee removes the entries about the employee, their photo, leave
+	// requests, salary changes and attendance, returning how many there were
+	DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error)
+}
+
+// MongoAuditRepository is the AuditRepository backed by a mongo collection
+type MongoAuditRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoAuditRepository creates a repository storing the audit log in the
+// collection. Salaries in the snapshots are read back as Money
+func NewMongoAuditRepository(collection *mongo.Collection) *MongoAuditRepository {
+	collection = collection.Database().Collection(collection.Name(), options.Collection().SetRegistry(moneyRegistry))
+	return &MongoAuditRepository{collection: collection}
+}
+
+// EnsureIndexes creates the index the history of a single record is read with
+func (r *MongoAuditRepository) EnsureIndexes(ctx context.Context) error {
+	return ensureIndexes(ctx, r.collection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "documentId", Value: 1}, {Key: "timestamp", Value: -1}},
+			Options: options.Index().SetName("document_history"),
+		},
+	})
+}
+
+// Record adds the entry to the audit log. Entries are never changed, and only
+// removed when the employee they are about is purged
+func (r *MongoAuditRepository) Record(ctx context.Context, entry *AuditEntry) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	_, err = r.collection.InsertOne(ctx, entry)
+	return err
+}
+
+// FindAll returns the audit entries matching the filter, sorted and paged by opts
+func (r *MongoAuditRepository) FindAll(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]AuditEntry, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0)
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// DeleteByEmployee removes the entries about the employee and their photo, and
+// the entries about their leave requests, salary changes and attendance
+// records, whose snapshots hold the employee's id
+func (r *MongoAuditRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "collection", Value: bson.D{{Key: "$in", Value: bson.A{employeesCollection, photosCollection}}}}, {Key: "documentId", Value: employeeID.Hex()}},
+		bson.D{{Key: "collection", Value: bson.D{{Key: "$in", Value: bson.A{leaveRequestsCollection, salaryChangesCollection, attendanceCollection}}}}, {Key: "$or", Value: bson.A{
+			bson.D{{Key: "before.employeeId", Value: employeeID}},
+			bson.D{{Key: "after.employeeId", Value: employeeID}},
+		}}},
+	}}}
+	result, err := r.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// Count returns how many audit entries match the filter
+func (r *MongoAuditRepository) Count(ctx context.Context, filter bson.D) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	return r.collection.CountDocuments(ctx, filter)
+}
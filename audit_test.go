@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeAuditRepository is an in-memory AuditRepository. It only filters by the
+// exact field matches the audit handler builds, and doesn't sort or page
+type fakeAuditRepository struct {
+	entries []AuditEntry
+}
+
+func newFakeAuditRepository() *fakeAuditRepository {
+	return &fakeAuditRepository{}
+}
+
+func (r *fakeAuditRepository) Record(ctx context.Context, entry *AuditEntry) error {
+	r.entries = append(r.entries, *entry)
+	return nil
+}
+
+func (r *fakeAuditRepository) FindAll(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+	for _, e := range r.entries {
+		fields := map[string]string{"documentId": e.DocumentID, "collection": e.Collection, "action": e.Action}
+		matches := true
+		for _, condition := range filter {
+			if fields[condition.Key] != condition.Value {
+				matches = false
+			}
+		}
+		if matches {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (r *fakeAuditRepository) Count(ctx context.Context, filter bson.D) (int64, error) {
+	entries, err := r.FindAll(ctx, filter, nil)
+	return int64(len(entries)), err
+}
+
+// DeleteByEmployee only removes the entries whose documentId is the employee,
+// the fake doesn't look inside the snapshots of leave requests
+func (r *fakeAuditRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	kept := r.entries[:0]
+	for _, e := range r.entries {
+		if (e.Collection != employeesCollection && e.Collection != photosCollection) || e.DocumentID != employeeID.Hex() {
+			kept = append(kept, e)
+		}
+	}
+	deleted := int64(len(r.entries) - len(kept))
+	r.entries = kept
+	return deleted, nil
+}
+
+func TestAuditLog(t *testing.T) {
+	audit := newFakeAuditRepository()
+	app := newApp(testConfig(), Repositories{
+		Employees:       newFakeRepository(john),
+		Departments:     newFakeDepartmentRepository(engineering),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       audit,
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+	path := "/employee/" + johnID.Hex()
+
+	request(t, app, roleAdmin, "PATCH", path, `{"salary":55000}`)
+	request(t, app, roleAdmin, "DELETE", path+"?confirm=true", "")
+	request(t, app, roleAdmin, "POST", path+"/restore", "")
+	request(t, app, roleAdmin, "PUT", "/department/"+engineeringID.Hex(), `{"name":"Platform"}`)
+	// failed changes leave no trace
+	request(t, app, roleAdmin, "PATCH", path, `{"age":1}`)
+
+	if status, _ := request(t, app, roleViewer, "GET", "/audit", ""); status != 403 {
+		t.Errorf("viewer reading the audit log: status = %d, want 403", status)
+	}
+
+	status, body := request(t, app, roleAdmin, "GET", "/audit?documentId="+johnID.Hex(), "")
+	if status != 200 {
+		t.Fatalf("status = %d, body %q", status, body)
+	}
+	var list AuditList
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatalf("decoding audit list: %v", err)
+	}
+	if list.Total != 3 {
+		t.Fatalf("got %d entries for the employee, want 3: %s", list.Total, body)
+	}
+
+	wantActions := []string{auditUpdate, auditDelete, auditRestore}
+	for i, entry := range list.Data {
+		if entry.Action != wantActions[i] || entry.Collection != employeesCollection || entry.Actor != "tester" {
+			t.Errorf("entry %d = %s on %s by %q, want %s on employees by tester", i, entry.Action, entry.Collection, entry.Actor, wantActions[i])
+		}
+	}
+	update := list.Data[0]
+	if update.Before["salary"] != 50000.0 || update.After["salary"] != 55000.0 {
+		t.Errorf("update snapshots: before %v, after %v, want the salary going from 50000 to 55000", update.Before, update.After)
+	}
+	if list.Data[1].After != nil {
+		t.Errorf("delete has an after snapshot: %v", list.Data[1].After)
+	}
+
+	_, body = request(t, app, roleAdmin, "GET", "/audit?collection=departments", "")
+	if !strings.Contains(body, `"total":1`) || !strings.Contains(body, `"name":"Platform"`) {
+		t.Errorf("department entries: %s", body)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	jwtware "github.com/gofiber/jwt/v3"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// userKey is the key the validated token is stored under in the fiber locals
+const userKey = "user"
+
+// the roles a token can carry. Admins can change data, viewers can only read it
+const (
+	roleAdmin  = "admin"
+	roleViewer = "viewer"
+)
+
+// Claims are the contents of our access tokens. RequireRole checks the "role"
+// claim, which must be one of roleAdmin or roleViewer
+type Claims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// LoginRequest is the body of POST /login
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// jwtMiddleware rejects any request without a valid bearer token signed with
+// the JWT secret. The parsed token is stored in the locals under userKey
+func jwtMiddleware(cfg Config) fiber.Handler {
+	return jwtware.New(jwtware.Config{
+		SigningKey:    []byte(cfg.JWTSecret),
+		SigningMethod: jwtware.HS256,
+		ContextKey:    userKey,
+		Claims:        &Claims{},
+		// missing, malformed and expired tokens are all just unauthorized
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid or missing token")
+		},
+	})
+}
+
+// TokenResponse is the answer of a login or a refresh. The refresh token is
+// only given out at login, a refresh keeps using the same one
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// RefreshToken is exchanged for a new access token at POST /refresh until
+	// RefreshExpiresAt, or until it is revoked at POST /logout
+	RefreshToken     string     `json:"refreshToken,omitempty"`
+	RefreshExpiresAt *time.Time `json:"refreshExpiresAt,omitempty"`
+}
+
+// RefreshRequest is the body of POST /refresh and POST /logout
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// loginHandler checks the username and password against the users collection
+// and answers with an access token and a refresh token. After
+// cfg.LoginMaxAttempts wrong passwords in a row the user is locked out for
+// cfg.LoginLockout, and every login answers 423 until it is over
+//
+// @Summary Log in
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param credentials body LoginRequest true "Username and password"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 423 {object} ErrorResponse
+// @Router /login [post]
+func loginHandler(cfg Config, users UserRepository, tokens RefreshTokenRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		login := new(LoginRequest)
+		if err := parseJSON(c, login); err != nil {
+			return err
+		}
+
+		// an unknown user is still checked against a password, so the response
+		// time doesn't tell which usernames exist
+		user, err := users.FindByUsername(c.UserContext(), login.Username)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		// while locked out not even the right password gets in, so guesses
+		// made during the lockout learn nothing
+		now := time.Now()
+		if user != nil && user.locked(now) {
+			return lockedOut(c, *user.LockedUntil)
+		}
+		if !checkPassword(user, login.Password) {
+			if user != nil && cfg.LoginMaxAttempts > 0 {
+				updated, err := users.RecordFailedLogin(c.UserContext(), user.ID, cfg.LoginMaxAttempts, cfg.LoginLockout)
+				if err != nil && !errors.Is(err, ErrNotFound) {
+					return err
+				}
+				if updated != nil && updated.locked(now) {
+					return lockedOut(c, *updated.LockedUntil)
+				}
+			}
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid username or password")
+		}
+		// a deactivated user gets the same answer as a wrong password
+		if !user.Active {
+			return fiber.NewError(fiber.StatusUnauthorized, "invalid username or password")
+		}
+		if user.FailedLogins > 0 || user.LockedUntil != nil {
+			if err := users.ResetFailedLogins(c.UserContext(), user.ID); err != nil {
+				return err
+			}
+		}
+
+		token, expiresAt, err := issueToken(cfg, user.Username, user.Role)
+		if err != nil {
+			return err
+		}
+		refreshToken, hash, err := newRefreshToken()
+		if err != nil {
+			return err
+		}
+		now = now.UTC()
+		refreshExpiresAt := now.Add(cfg.RefreshTokenTTL)
+		err = tokens.Create(c.UserContext(), &RefreshToken{Hash: hash, UserID: user.ID, CreatedAt: now, ExpiresAt: refreshExpiresAt})
+		if err != nil {
+			return err
+		}
+		return c.Status(200).JSON(TokenResponse{
+			Token:            token,
+			ExpiresAt:        expiresAt,
+			RefreshToken:     refreshToken,
+			RefreshExpiresAt: &refreshExpiresAt,
+		})
+	}
+}
+
+// lockedOut is the 423 for a user locked out until the time, with a
+// Retry-After header saying how many seconds are left
+func lockedOut(c *fiber.Ctx, until time.Time) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	return fiber.NewError(fiber.StatusLocked, "too many failed logins, try again after "+until.UTC().Format(time.RFC3339))
+}
+
+// refreshHandler exchanges a refresh token for a new access token, with the
+// role the user has now
+//
+// @Summary Get a new access token
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token body RefreshRequest true "The refresh token from the login"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Router /refresh [post]
+func refreshHandler(cfg Config, users UserRepository, tokens RefreshTokenRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		refresh := new(RefreshRequest)
+		if err := parseJSON(c, refresh); err != nil {
+			return err
+		}
+
+		// revoked, expired and unknown tokens are all just unauthorized, and so
+		// is the token of a user that is gone or deactivated
+		invalid := fiber.NewError(fiber.StatusUnauthorized, "invalid or expired refresh token")
+		stored, err := tokens.Find(c.UserContext(), hashRefreshToken(refresh.RefreshToken))
+		if errors.Is(err, ErrNotFound) {
+			return invalid
+		}
+		if err != nil {
+			return err
+		}
+		if !stored.usable(time.Now()) {
+			return invalid
+		}
+		user, err := users.FindByID(c.UserContext(), stored.UserID)
+		if errors.Is(err, ErrNotFound) {
+			return invalid
+		}
+		if err != nil {
+			return err
+		}
+		if !user.Active {
+			return invalid
+		}
+
+		token, expiresAt, err := issueToken(cfg, user.Username, user.Role)
+		if err != nil {
+			return err
+		}
+		return c.Status(200).JSON(TokenResponse{Token: token, ExpiresAt: expiresAt})
+	}
+}
+
+// logoutHandler revokes a refresh token, so it can't get any more access
+// tokens. The access tokens it already got work until they expire
+//
+// @Summary Log out
+// @Tags auth
+// @Accept json
+// @Param token body RefreshRequest true "The refresh token to revoke"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Router /logout [post]
+func logoutHandler(tokens RefreshTokenRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		refresh := new(RefreshRequest)
+		if err := parseJSON(c, refresh); err != nil {
+			return err
+		}
+		if err := tokens.Revoke(c.UserContext(), hashRefreshToken(refresh.RefreshToken)); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// ensureAdminUser creates the admin from ADMIN_USERNAME and ADMIN_PASSWORD when
+// there is no user with the name yet, so a fresh database has someone to log
+// in as. An existing user is left as it is, changing ADMIN_PASSWORD later
+// doesn't change their password
+func ensureAdminUser(ctx context.Context, cfg Config, users UserRepository) error {
+	if cfg.AdminUsername == "" || cfg.AdminPassword == "" {
+		return nil
+	}
+	_, err := users.FindByUsername(ctx, cfg.AdminUsername)
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	hash, err := hashPassword(cfg.AdminPassword)
+	if err != nil {
+		return err
+	}
+	_, err = users.Create(ctx, &User{Username: cfg.AdminUsername, PasswordHash: hash, Role: roleAdmin, Active: true})
+	// another instance starting at the same time got there first
+	if errors.Is(err, ErrDuplicateUsername) {
+		return nil
+	}
+	return err
+}
+
+// issueToken signs an access token for the user and role that expires after the configured TTL
+func issueToken(cfg Config, username, role string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(cfg.TokenTTL)
+
+	claims := Claims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.JWTSecret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// RequireRole only lets the request through when the validated token carries
+// the given role claim, answering 403 otherwise. It must run after jwtMiddleware
+func RequireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := currentClaims(c)
+		if claims == nil || claims.Role != role {
+			return fiber.NewError(fiber.StatusForbidden, "you do not have permission to do that")
+		}
+		return c.Next()
+	}
+}
+
+// currentClaims returns the claims of the token jwtMiddleware validated, or nil
+// when the request did not go through it
+func currentClaims(c *fiber.Ctx) *Claims {
+	token, ok := c.Locals(userKey).(*jwt.Token)
+	if !ok {
+		return nil
+	}
+	claims, _ := token.Claims.(*Claims)
+	return claims
+}
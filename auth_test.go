@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeUserRepository is an in-memory UserRepository
+type fakeUserRepository struct {
+	mu    sync.Mutex
+	users map[primitive.ObjectID]User
+}
+
+func newFakeUserRepository(users ...User) *fakeUserRepository {
+	repo := &fakeUserRepository{users: make(map[primitive.ObjectID]User)}
+	for _, u := range users {
+		repo.users[u.ID] = u
+	}
+	return repo
+}
+
+func (r *fakeUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+func (r *fakeUserRepository) FindByUsername(ctx context.Context, username string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if u.Username == username {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *fakeUserRepository) FindAll(ctx context.Context) ([]User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := make([]User, 0, len(r.users))
+	for _, u := range r.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func (r *fakeUserRepository) Create(ctx context.Context, user *User) (*User, error) {
+	if _, err := r.FindByUsername(ctx, user.Username); err == nil {
+		return nil, ErrDuplicateUsername
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	created := *user
+	created.ID = primitive.NewObjectID()
+	created.CreatedAt, created.UpdatedAt = time.Now().UTC(), time.Now().UTC()
+	r.users[created.ID] = created
+	return &created, nil
+}
+
+func (r *fakeUserRepository) Deactivate(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	u.Active, u.UpdatedAt = false, time.Now().UTC()
+	r.users[id] = u
+	return &u, nil
+}
+
+func (r *fakeUserRepository) SetPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.PasswordHash, u.UpdatedAt = passwordHash, time.Now().UTC()
+	r.users[id] = u
+	return nil
+}
+
+func (r *fakeUserRepository) RecordFailedLogin(ctx context.Context, id primitive.ObjectID, maxAttempts int, lockout time.Duration) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	u, ok := r.users[id]
+	if !ok || u.locked(now) {
+		return nil, ErrNotFound
+	}
+	u.FailedLogins++
+	u.LockedUntil = nil
+	if u.FailedLogins >= maxAttempts {
+		until := now.Add(lockout)
+		u.FailedLogins, u.LockedUntil = 0, &until
+	}
+	r.users[id] = u
+	return &u, nil
+}
+
+func (r *fakeUserRepository) ResetFailedLogins(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if u, ok := r.users[id]; ok {
+		u.FailedLogins, u.LockedUntil = 0, nil
+		r.users[id] = u
+	}
+	return nil
+}
+
+// fakeRefreshTokenRepository is an in-memory RefreshTokenRepository
+type fakeRefreshTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+func newFakeRefreshTokenRepository() *fakeRefreshTokenRepository {
+	return &fakeRefreshTokenRepository{tokens: make(map[string]RefreshToken)}
+}
+
+func (r *fakeRefreshTokenRepository) Create(ctx context.Context, token *RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens[token.Hash] = *token
+	return nil
+}
+
+func (r *fakeRefreshTokenRepository) Find(ctx context.Context, hash string) (*RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, ok := r.tokens[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &token, nil
+}
+
+func (r *fakeRefreshTokenRepository) Revoke(ctx context.Context, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if token, ok := r.tokens[hash]; ok && token.RevokedAt == nil {
+		now := time.Now().UTC()
+		token.RevokedAt = &now
+		r.tokens[hash] = token
+	}
+	return nil
+}
+
+func (r *fakeRefreshTokenRepository) RevokeAll(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	for hash, token := range r.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			r.tokens[hash] = token
+		}
+	}
+	return nil
+}
+
+// newTestUser is an active user with the password, hashed the way they are stored
+func newTestUser(t *testing.T, username, password, role string) User {
+	t.Helper()
+	hash, err := hashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return User{ID: primitive.NewObjectID(), Username: username, PasswordHash: hash, Role: role, Active: true}
+}
+
+// newAuthTestApp serves the routes with the users and refresh tokens
+func newAuthTestApp(users *fakeUserRepository, tokens *fakeRefreshTokenRepository) *fiber.App {
+	return newApp(testConfig(), Repositories{
+		Database:        fakePinger{},
+		Employees:       newFakeRepository(john),
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           users,
+		RefreshTokens:   tokens,
+		Events:          newFakeEventPublisher(),
+	})
+}
+
+func TestLogin(t *testing.T) {
+	alice := newTestUser(t, "alice", "correct horse", roleViewer)
+	tokens := newFakeRefreshTokenRepository()
+	app := newAuthTestApp(newFakeUserRepository(alice), tokens)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "wrong password", body: `{"username":"alice","password":"wrong"}`, wantStatus: 401, wantBody: "invalid username or password"},
+		{name: "unknown user", body: `{"username":"bob","password":"correct horse"}`, wantStatus: 401, wantBody: "invalid username or password"},
+		{name: "no body", wantStatus: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := request(t, app, "", "POST", "/login", tt.body)
+			if status != tt.wantStatus || !strings.Contains(body, tt.wantBody) {
+				t.Errorf("status = %d, body %q, want %d and %q", status, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+	if len(tokens.tokens) != 0 {
+		t.Errorf("%d refresh tokens stored for failed logins, want none", len(tokens.tokens))
+	}
+
+	status, body := request(t, app, "", "POST", "/login", `{"username":"alice","password":"correct horse"}`)
+	var login TokenResponse
+	if err := json.Unmarshal([]byte(body), &login); err != nil || status != 200 {
+		t.Fatalf("login: status = %d, body %q", status, body)
+	}
+	if login.Token == "" || login.RefreshToken == "" || login.RefreshExpiresAt == nil {
+		t.Fatalf("login = %+v, want an access and a refresh token", login)
+	}
+	// only the hash of the refresh token is kept
+	stored, ok := tokens.tokens[hashRefreshToken(login.RefreshToken)]
+	if !ok || stored.UserID != alice.ID || len(tokens.tokens) != 1 {
+		t.Errorf("stored tokens = %+v, want alice's under the hash", tokens.tokens)
+	}
+
+	// the token carries alice's role, so she can read but not write
+	req := newRequest(t, "", "GET", "/employee/"+johnID.Hex(), "")
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+	if resp, body := send(t, app, req); resp.StatusCode != 200 {
+		t.Errorf("GET with the token: status = %d, body %q", resp.StatusCode, body)
+	}
+	req = newRequest(t, "", "DELETE", "/employee/"+johnID.Hex()+"?confirm=true", "")
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+	if resp, _ := send(t, app, req); resp.StatusCode != 403 {
+		t.Errorf("DELETE with a viewer's token: status = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	alice := newTestUser(t, "alice", "correct horse", roleViewer)
+	users := newFakeUserRepository(alice)
+	app := newAuthTestApp(users, newFakeRefreshTokenRepository())
+	wrong := `{"username":"alice","password":"wrong"}`
+	right := `{"username":"alice","password":"correct horse"}`
+
+	// a successful login starts the count over
+	for i := 1; i < defaultLoginAttempts; i++ {
+		if status, _ := request(t, app, "", "POST", "/login", wrong); status != 401 {
+			t.Fatalf("wrong password %d: status = %d, want 401", i, status)
+		}
+	}
+	if got := users.users[alice.ID].FailedLogins; got != defaultLoginAttempts-1 {
+		t.Errorf("failedLogins = %d, want %d", got, defaultLoginAttempts-1)
+	}
+	if status, body := request(t, app, "", "POST", "/login", right); status != 200 {
+		t.Fatalf("right password: status = %d, body %q", status, body)
+	}
+	if got := users.users[alice.ID].FailedLogins; got != 0 {
+		t.Errorf("failedLogins after logging in = %d, want 0", got)
+	}
+
+	// the attempt that reaches the limit is the one that locks
+	for i := 1; i < defaultLoginAttempts; i++ {
+		request(t, app, "", "POST", "/login", wrong)
+	}
+	resp, body := send(t, app, newRequest(t, "", "POST", "/login", wrong))
+	if resp.StatusCode != 423 || !strings.Contains(body, "too many failed logins") {
+		t.Fatalf("last wrong password: status = %d, body %q, want 423", resp.StatusCode, body)
+	}
+	if retry := resp.Header.Get("Retry-After"); retry != strconv.Itoa(int(defaultLoginLockout.Seconds())) {
+		t.Errorf("Retry-After = %q, want the %v lockout in seconds", retry, defaultLoginLockout)
+	}
+	// while locked the right password doesn't get in either, and guesses don't count
+	if status, _ := request(t, app, "", "POST", "/login", right); status != 423 {
+		t.Errorf("right password while locked: status = %d, want 423", status)
+	}
+	request(t, app, "", "POST", "/login", wrong)
+	if got := users.users[alice.ID].FailedLogins; got != 0 {
+		t.Errorf("failedLogins while locked = %d, want 0", got)
+	}
+
+	// once the lockout is over a wrong password counts from one again
+	locked := users.users[alice.ID]
+	ended := time.Now().Add(-time.Second)
+	locked.LockedUntil = &ended
+	users.users[alice.ID] = locked
+	if status, _ := request(t, app, "", "POST", "/login", wrong); status != 401 {
+		t.Errorf("wrong password after the lockout: status = %d, want 401", status)
+	}
+	if u := users.users[alice.ID]; u.FailedLogins != 1 || u.LockedUntil != nil {
+		t.Errorf("after the lockout failedLogins = %d, lockedUntil %v, want 1 and none", u.FailedLogins, u.LockedUntil)
+	}
+	if status, body := request(t, app, "", "POST", "/login", right); status != 200 {
+		t.Errorf("right password after the lockout: status = %d, body %q", status, body)
+	}
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	alice := newTestUser(t, "alice", "correct horse", roleAdmin)
+	users := newFakeUserRepository(alice)
+	tokens := newFakeRefreshTokenRepository()
+	app := newAuthTestApp(users, tokens)
+
+	_, body := request(t, app, "", "POST", "/login", `{"username":"alice","password":"correct horse"}`)
+	var login TokenResponse
+	if err := json.Unmarshal([]byte(body), &login); err != nil {
+		t.Fatalf("login: body %q", body)
+	}
+	refreshBody := `{"refreshToken":"` + login.RefreshToken + `"}`
+
+	status, body := request(t, app, "", "POST", "/refresh", refreshBody)
+	var refreshed TokenResponse
+	if err := json.Unmarshal([]byte(body), &refreshed); err != nil || status != 200 {
+		t.Fatalf("refresh: status = %d, body %q", status, body)
+	}
+	if refreshed.Token == "" || refreshed.RefreshToken != "" {
+		t.Errorf("refresh = %+v, want only a new access token", refreshed)
+	}
+
+	if status, body := request(t, app, "", "POST", "/refresh", `{"refreshToken":"made-up"}`); status != 401 {
+		t.Errorf("unknown token: status = %d, body %q, want 401", status, body)
+	}
+	expired := RefreshToken{Hash: hashRefreshToken("expired"), UserID: alice.ID, ExpiresAt: time.Now().Add(-time.Minute)}
+	tokens.tokens[expired.Hash] = expired
+	if status, body := request(t, app, "", "POST", "/refresh", `{"refreshToken":"expired"}`); status != 401 {
+		t.Errorf("expired token: status = %d, body %q, want 401", status, body)
+	}
+
+	if status, body := request(t, app, "", "POST", "/logout", refreshBody); status != 204 {
+		t.Fatalf("logout: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, "", "POST", "/refresh", refreshBody); status != 401 || !strings.Contains(body, "invalid or expired refresh token") {
+		t.Errorf("refresh after logout: status = %d, body %q, want 401", status, body)
+	}
+	// logging out twice is fine
+	if status, _ := request(t, app, "", "POST", "/logout", refreshBody); status != 204 {
+		t.Errorf("second logout: status = %d, want 204", status)
+	}
+}
+
+func TestEnsureAdminUser(t *testing.T) {
+	cfg := testConfig()
+	cfg.AdminUsername, cfg.AdminPassword = "admin", "s3cret"
+	users := newFakeUserRepository()
+	ctx := context.Background()
+
+	if err := ensureAdminUser(ctx, cfg, users); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := users.FindByUsername(ctx, "admin")
+	if err != nil || admin.Role != roleAdmin || !checkPassword(admin, "s3cret") {
+		t.Fatalf("admin = %+v, err %v, want an admin with the configured password", admin, err)
+	}
+
+	// a second start leaves the admin as it is, password and all
+	cfg.AdminPassword = "changed"
+	if err := ensureAdminUser(ctx, cfg, users); err != nil {
+		t.Fatal(err)
+	}
+	admin, err = users.FindByUsername(ctx, "admin")
+	if err != nil || len(users.users) != 1 || !checkPassword(admin, "s3cret") {
+		t.Errorf("users = %+v, want just the first admin", users.users)
+	}
+}
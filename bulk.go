@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bulkUpdateFields are the fields a bulk update can set, the ones a reorg
+// changes. Names, emails and ages belong to each employee on their own, and
+// salaries are changed in bulk with a raise
+var bulkUpdateFields = map[string]bool{
+	"position":     true,
+	"departmentId": true,
+	"managerId":    true,
+}
+
+// BulkUpdateRequest is the body of a bulk update. Filter picks the employees
+// like a search does, and Set has the fields to give every one of them
+type BulkUpdateRequest struct {
+	Filter SearchFilter     `json:"filter"`
+	Set    BulkUpdateFields `json:"set"`
+}
+
+// BulkUpdateFields are the fields a bulk update sets. Those left out or null
+// are left as they are
+type BulkUpdateFields struct {
+	Position     *string             `json:"position"`
+	DepartmentID *primitive.ObjectID `json:"departmentId" swaggertype:"string"`
+	ManagerID    *primitive.ObjectID `json:"managerId" swaggertype:"string"`
+}
+
+// patch is the partial update setting the fields, which validates them the
+// same way a PATCH of one employee does
+func (f BulkUpdateFields) patch() *EmployeePatch {
+	return &EmployeePatch{Position: f.Position, DepartmentID: f.DepartmentID, ManagerID: f.ManagerID}
+}
+
+// changes reports whether setting the fields would change the employee
+func (f BulkUpdateFields) changes(e *Employee) bool {
+	differs := func(set, current *primitive.ObjectID) bool {
+		return set != nil && (current == nil || *current != *set)
+	}
+	return (f.Position != nil && *f.Position != e.Position) ||
+		differs(f.DepartmentID, e.DepartmentID) ||
+		differs(f.ManagerID, e.ManagerID)
+}
+
+// BulkUpdateResult is the response of a bulk update. Matched is how many
+// employees the filter matched, Modified how many of them were changed, the
+// rest already had the values that were set. When DryRun is set nothing was
+// changed, Modified is how many would have been and Employees are the matched
+// ones as they are now
+type BulkUpdateResult struct {
+	Matched   int64      `json:"matched"`
+	Modified  int64      `json:"modified"`
+	DryRun    bool       `json:"dryRun,omitempty"`
+	Employees []Employee `json:"employees,omitempty"`
+}
+
+// BulkUpdate sets the same fields on every employee matching a filter, e.g
+// moves everyone with a position to another department with
+// {"filter": {"field": "position", "op": "eq", "value": "Accountant"}, "set": {"departmentId": "<id>"}}.
+// Only position, departmentId and managerId can be set. Leaving the filter
+// out updates every employee. ?dryRun=true checks the update and lists the
+// employees it matches without changing them
+//
+// @Summary Update the employees matching a filter
+// @Tags employees
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param update body BulkUpdateRequest true "Which employees, and the fields to set on them"
+// @Param dryRun query bool false "Only list the employees that would be updated"
+// @Success 200 {object} BulkUpdateResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /employee/bulk-update [post]
+func (h *EmployeeHandler) BulkUpdate(c *fiber.Ctx) error {
+	if err := checkOperatorKeys(c); err != nil {
+		return err
+	}
+	// the fields outside the list are checked first, the decoder would only
+	// say it doesn't know them
+	var set struct {
+		Set map[string]json.RawMessage `json:"set"`
+	}
+	if c.Is("json") && json.Unmarshal(c.Body(), &set) == nil {
+		errs := make(map[string]string)
+		for field := range set.Set {
+			if !bulkUpdateFields[field] {
+				errs[field] = fmt.Sprintf("%s can't be set in a bulk update, only %s can", field, bulkUpdateFieldList())
+			}
+		}
+		if len(errs) > 0 {
+			return nestFieldErrors(newValidationError(errs), "set")
+		}
+	}
+
+	update := new(BulkUpdateRequest)
+	if err := parseJSON(c, update); err != nil {
+		return err
+	}
+	patch := update.Set.patch()
+	if errs := patch.validate(); len(errs) > 0 {
+		return nestFieldErrors(newValidationError(errs), "set")
+	}
+	fields := patch.setFields()
+	if len(fields) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "no fields to update, set at least one of "+bulkUpdateFieldList())
+	}
+	if err := h.checkDepartment(c, patch.DepartmentID); err != nil {
+		return nestFieldErrors(err, "set")
+	}
+
+	filter, err := update.Filter.toQuery()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	query := bson.D{notDeleted}
+	if len(filter) > 0 {
+		query = append(query, bson.E{Key: "$and", Value: bson.A{filter}})
+	}
+
+	// the employees as they were, for the audit log, and to leave alone the
+	// ones that already have the values
+	matching, err := h.repo.FindAll(c.UserContext(), query, nil)
+	if err != nil {
+		return err
+	}
+	matchingIDs := make([]primitive.ObjectID, len(matching))
+	before := make(map[primitive.ObjectID]*Employee, len(matching))
+	var ids []primitive.ObjectID
+	for i := range matching {
+		matchingIDs[i] = matching[i].ID
+		before[matching[i].ID] = &matching[i]
+		if update.Set.changes(&matching[i]) {
+			ids = append(ids, matching[i].ID)
+		}
+	}
+	if err := h.checkManager(c, patch.ManagerID, matchingIDs...); err != nil {
+		return nestFieldErrors(err, "set")
+	}
+
+	result := BulkUpdateResult{Matched: int64(len(matching))}
+	if isDryRun(c) {
+		result.Modified, result.DryRun, result.Employees = int64(len(ids)), true, matching
+		return c.JSON(result)
+	}
+	if len(ids) == 0 {
+		return c.JSON(result)
+	}
+	updated, err := h.repo.UpdateMany(c.UserContext(), ids, fields)
+	if err != nil {
+		return err
+	}
+	for i := range updated {
+		recordAudit(c, h.audit, auditUpdate, employeesCollection, updated[i].ID.Hex(), before[updated[i].ID], &updated[i])
+		h.events.Publish(eventEmployeeUpdated, &updated[i])
+		recordRevision(c, h.history, &updated[i])
+	}
+	employeeChanges.WithLabelValues(actionUpdated).Add(float64(len(updated)))
+
+	result.Modified = int64(len(updated))
+	return c.JSON(result)
+}
+
+// bulkUpdateFieldList names the fields a bulk update can set, for the errors
+func bulkUpdateFieldList() string {
+	fields := make([]string, 0, len(bulkUpdateFields))
+	for field := range bulkUpdateFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ", ")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBulkUpdate(t *testing.T) {
+	jane := Employee{ID: primitive.NewObjectID(), Name: "Jane Doe", Email: "jane@example.com", Position: "Engineer", DepartmentID: &engineeringID}
+	sam := Employee{ID: primitive.NewObjectID(), Name: "Sam Roe", Email: "sam@example.com"}
+	repo := newFakeRepository(john, jane, sam)
+	app := newTestApp(repo, newFakeDepartmentRepository(engineering, finance))
+	inEngineering := `{"field":"departmentId","op":"eq","value":"` + engineeringID.Hex() + `"}`
+
+	update := func(body string) BulkUpdateResult {
+		t.Helper()
+		status, resp := request(t, app, roleAdmin, "POST", "/employee/bulk-update", body)
+		var result BulkUpdateResult
+		if err := json.Unmarshal([]byte(resp), &result); err != nil || status != 200 {
+			t.Fatalf("status = %d, body %q", status, resp)
+		}
+		return result
+	}
+
+	// jane is an engineer already, so only john changes
+	if got := update(`{"filter":` + inEngineering + `,"set":{"position":"Engineer"}}`); got.Matched != 2 || got.Modified != 1 {
+		t.Errorf("position: result = %+v, want 2 matched and 1 modified", got)
+	}
+	if got := update(`{"filter":` + inEngineering + `,"set":{"departmentId":"` + financeID.Hex() + `","managerId":"` + sam.ID.Hex() + `"}}`); got.Matched != 2 || got.Modified != 2 || got.DryRun {
+		t.Errorf("reorg: result = %+v, want both engineers moved", got)
+	}
+	for _, id := range []primitive.ObjectID{johnID, jane.ID} {
+		e := repo.employees[id]
+		if e.Position != "Engineer" || *e.DepartmentID != financeID || *e.ManagerID != sam.ID {
+			t.Errorf("%s = %+v, want an engineer in finance reporting to sam", e.Name, e)
+		}
+	}
+	if e := repo.employees[sam.ID]; e.DepartmentID != nil || e.ManagerID != nil {
+		t.Errorf("sam = %+v, want sam left alone", e)
+	}
+}
+
+func TestBulkUpdateIsValidated(t *testing.T) {
+	jane := Employee{ID: primitive.NewObjectID(), Name: "Jane Doe", Email: "jane@example.com", ManagerID: &johnID}
+
+	tests := []struct {
+		name       string
+		role       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "field outside the list", body: `{"set":{"name":"Same Name"}}`, wantStatus: 422, wantBody: `"field":"set.name","message":"name can't be set in a bulk update, only departmentId, managerId, position can"`},
+		{name: "invalid position", body: `{"set":{"position":"` + strings.Repeat("x", maxPositionLength+1) + `"}}`, wantStatus: 422, wantBody: `"field":"set.position"`},
+		{name: "nothing to set", body: `{"set":{}}`, wantStatus: 400, wantBody: "no fields to update"},
+		{name: "no set", body: `{"filter":{"field":"name","op":"eq","value":"John Doe"}}`, wantStatus: 400, wantBody: "no fields to update"},
+		{name: "operator key", body: `{"filter":{"$where":"1"},"set":{"position":"Boss"}}`, wantStatus: 400, wantBody: "keys can't start with $"},
+		{name: "bad filter", body: `{"filter":{"field":"salary","op":"contains","value":"1"},"set":{"position":"Boss"}}`, wantStatus: 400},
+		{name: "unknown department", body: `{"set":{"departmentId":"` + missingID + `"}}`, wantStatus: 422, wantBody: `"field":"set.departmentId","message":"department does not exist","value":"` + missingID + `"`},
+		{name: "unknown manager", body: `{"set":{"managerId":"` + missingID + `"}}`, wantStatus: 422, wantBody: `"field":"set.managerId","message":"manager does not exist","value":"` + missingID + `"`},
+		// everyone includes jane, who would manage herself
+		{name: "own manager", body: `{"set":{"managerId":"` + jane.ID.Hex() + `"}}`, wantStatus: 422, wantBody: "their own manager"},
+		{name: "manager under an employee", body: `{"filter":{"field":"departmentId","op":"eq","value":"` + engineeringID.Hex() + `"},"set":{"managerId":"` + jane.ID.Hex() + `"}}`, wantStatus: 422, wantBody: "reports to employee " + johnID.Hex()},
+		{name: "viewer forbidden", role: roleViewer, body: `{"set":{"position":"Boss"}}`, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeRepository(john, jane)
+			app := newTestApp(repo, newFakeDepartmentRepository(engineering))
+			role := tt.role
+			if role == "" {
+				role = roleAdmin
+			}
+
+			status, body := request(t, app, role, "POST", "/employee/bulk-update", tt.body)
+			if status != tt.wantStatus || !strings.Contains(body, tt.wantBody) {
+				t.Errorf("status = %d, body %q, want %d and %q", status, body, tt.wantStatus, tt.wantBody)
+			}
+			if repo.employees[johnID] != john {
+				t.Error("john was changed")
+			}
+		})
+	}
+}
+
+func TestBulkChangesDryRun(t *testing.T) {
+	jane := Employee{ID: primitive.NewObjectID(), Name: "Jane Doe", Email: "jane@example.com", Position: "Engineer", DepartmentID: &engineeringID}
+	sam := Employee{ID: primitive.NewObjectID(), Name: "Sam Roe", Email: "sam@example.com"}
+	inEngineering := `{"field":"departmentId","op":"eq","value":"` + engineeringID.Hex() + `"}`
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantBody string
+	}{
+		{name: "bulk update", path: "/employee/bulk-update?dryRun=true", body: `{"filter":` + inEngineering + `,"set":{"position":"Engineer"}}`, wantBody: `"matched":2,"modified":1,"dryRun":true`},
+		{name: "raise", path: "/employee/raise?dryRun=true", body: `{"percent":10,"filter":` + inEngineering + `}`, wantBody: `"raised":2`},
+		{name: "batch delete", path: "/employee/batch-delete?dryRun=true", body: `["` + johnID.Hex() + `","` + sam.ID.Hex() + `","` + missingID + `"]`, wantBody: `"deleted":2,"invalid":[],"notFound":["` + missingID + `"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeRepository(john, jane, sam)
+			events := newFakeEventPublisher()
+			app := newApp(testConfig(), Repositories{
+				Database:        fakePinger{},
+				Employees:       repo,
+				Departments:     newFakeDepartmentRepository(engineering),
+				Transactor:      fakeTransactor{},
+				IdempotencyKeys: newFakeIdempotencyRepository(),
+				AuditLogs:       newFakeAuditRepository(),
+				History:         newFakeHistoryRepository(),
+				LeaveRequests:   newFakeLeaveRepository(),
+				SalaryChanges:   newFakeSalaryChangeRepository(),
+				Attendance:      newFakeAttendanceRepository(),
+				Photos:          newFakePhotoRepository(),
+				Users:           newFakeUserRepository(),
+				RefreshTokens:   newFakeRefreshTokenRepository(),
+				Events:          events,
+			})
+
+			status, body := request(t, app, roleAdmin, "POST", tt.path, tt.body)
+			if status != 200 || !strings.Contains(body, tt.wantBody) || !strings.Contains(body, `"dryRun":true`) || !strings.Contains(body, `"name":"John Doe"`) {
+				t.Errorf("status = %d, body %q, want 200 and %q with john as he is", status, body, tt.wantBody)
+			}
+			for _, e := range []Employee{john, jane, sam} {
+				if repo.employees[e.ID] != e {
+					t.Errorf("%s = %+v, want them left alone", e.Name, repo.employees[e.ID])
+				}
+			}
+			if got := events.types(); len(got) > 0 {
+				t.Errorf("events = %v, want none", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// maxCacheEntries bounds the memory the cache can use. Every distinct query
+// string is its own entry, so without a bound clients could grow it forever
+const maxCacheEntries = 1000
+
+// the response header saying whether a response came from the cache
+const headerCache = "X-Cache"
+
+// responseCache keeps successful GET responses in memory for a while, keyed
+// by their format, path and query string. Any successful write empties it, so clients
+// never see stale data after changing something through this instance. Other
+// instances behind a load balancer can serve stale data for up to the TTL.
+// version counts those writes, and goes into the ETags Conditional hands out
+type responseCache struct {
+	ttl     time.Duration
+	version atomic.Uint64
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	status      int
+	contentType []byte
+	body        []byte
+	expires     time.Time
+}
+
+// newResponseCache creates a cache keeping responses for ttl, zero disables it
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+// Middleware answers from the cache when it can, and caches the response when
+// it can't. ?noCache=true always goes to the database
+func (rc *responseCache) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if rc.ttl <= 0 || c.Method() != fiber.MethodGet || c.Query("noCache") == "true" {
+			return c.Next()
+		}
+
+		// the url points into a buffer fasthttp reuses, and the key outlives the
+		// request. The same url is cached once for each format it is asked in
+		key := responseFormat(c) + " " + utils.CopyString(c.OriginalURL())
+		if entry, ok := rc.get(key); ok {
+			c.Set(headerCache, "HIT")
+			// the entry was picked by the format the Accept header asked for
+			c.Vary(fiber.HeaderAccept)
+			c.Response().Header.SetContentTypeBytes(entry.contentType)
+			return c.Status(entry.status).Send(entry.body)
+		}
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+		c.Set(headerCache, "MISS")
+		if status := c.Response().StatusCode(); status == fiber.StatusOK {
+			// fasthttp reuses the response buffers once the request is done, so keep copies
+			rc.set(key, cacheEntry{
+				status:      status,
+				contentType: append([]byte(nil), c.Response().Header.ContentType()...),
+				body:        append([]byte(nil), c.Response().Body()...),
+				expires:     time.Now().Add(rc.ttl),
+			})
+		}
+		return nil
+	}
+}
+
+// Conditional lets clients skip downloading a list they already have. A
+// successful GET gets a weak ETag made of the write version and a hash of the
+// body, and when the request's If-None-Match already holds it the answer is a
+// 304 without the body. The hash alone changes whenever the data does, across
+// every instance, while the version makes sure an ETag from before a write is
+// never taken for the current one even if the hashes happen to collide. It
+// goes before Middleware so responses from the cache get ETags too
+func (rc *responseCache) Conditional() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+		if c.Method() != fiber.MethodGet || c.Response().StatusCode() != fiber.StatusOK {
+			return nil
+		}
+
+		// weak, since compression changes the bytes but not what they mean
+		body := c.Response().Body()
+		c.Set(fiber.HeaderETag, fmt.Sprintf(`W/"%d-%d-%08x"`, rc.version.Load(), len(body), crc32.ChecksumIEEE(body)))
+		// the browser may keep the list, but has to check it is current each time
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		if c.Fresh() {
+			c.Status(fiber.StatusNotModified)
+			c.Response().ResetBody()
+		}
+		return nil
+	}
+}
+
+// Invalidate empties the cache after every request that successfully changed
+// data. Writes are rare next to reads, so throwing everything away is simpler
+// than working out which entries a write affected
+func (rc *responseCache) Invalidate() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return err
+		}
+		if c.Locals(keepCacheKey) == true {
+			return err
+		}
+		// an error here is answered later by the error handler, so the status isn't set yet
+		if err == nil && c.Response().StatusCode() < 400 {
+			rc.version.Add(1)
+			rc.clear()
+		}
+		return err
+	}
+}
+
+// keepCacheKey is the local Keep sets on requests Invalidate should ignore
+const keepCacheKey = "keepcache"
+
+// Keep marks a route that doesn't change anything even though it isn't a GET,
+// like a search sent as a POST, so Invalidate leaves the cache alone
+func (rc *responseCache) Keep() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals(keepCacheKey, true)
+		return c.Next()
+	}
+}
+
+func (rc *responseCache) get(key string) (cacheEntry, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	entry, ok := rc.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (rc *responseCache) set(key string, entry cacheEntry) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if len(rc.entries) >= maxCacheEntries {
+		// drop whatever has expired, and everything if that wasn't enough
+		now := time.Now()
+		for k, e := range rc.entries {
+			if now.After(e.expires) {
+				delete(rc.entries, k)
+			}
+		}
+		if len(rc.entries) >= maxCacheEntries {
+			rc.entries = make(map[string]cacheEntry)
+		}
+	}
+	rc.entries[key] = entry
+}
+
+func (rc *responseCache) clear() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.entries = make(map[string]cacheEntry)
+}
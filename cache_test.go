@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListCache(t *testing.T) {
+	repo := newFakeRepository(john)
+	cfg := testConfig()
+	cfg.ListCacheTTL = time.Minute
+	app := newApp(cfg, Repositories{
+		Employees:       repo,
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+
+	call := func(method, path, body string) (string, string) {
+		t.Helper()
+		resp, respBody := send(t, app, newRequest(t, roleAdmin, method, path, body))
+		return resp.Header.Get(headerCache), respBody
+	}
+
+	if cache, _ := call("GET", "/employee", ""); cache != "MISS" {
+		t.Errorf("first list: X-Cache = %q, want MISS", cache)
+	}
+
+	// change the data behind the cache's back, the cached list is still served
+	delete(repo.employees, johnID)
+	if cache, body := call("GET", "/employee", ""); cache != "HIT" || !strings.Contains(body, "John Doe") {
+		t.Errorf("second list: X-Cache = %q, body %q, want the cached list", cache, body)
+	}
+	if cache, body := call("GET", "/employee?noCache=true", ""); cache != "" || strings.Contains(body, "John Doe") {
+		t.Errorf("noCache list: X-Cache = %q, body %q, want a fresh list", cache, body)
+	}
+
+	// a search is a POST, but doesn't change anything
+	call("POST", "/employee/search", `{}`)
+	if cache, _ := call("GET", "/employee", ""); cache != "HIT" {
+		t.Errorf("list after search: X-Cache = %q, want HIT", cache)
+	}
+
+	// a write empties the cache
+	call("POST", "/employee", `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`)
+	if cache, body := call("GET", "/employee", ""); cache != "MISS" || !strings.Contains(body, "Jane Doe") {
+		t.Errorf("list after create: X-Cache = %q, body %q, want a fresh list", cache, body)
+	}
+}
+
+func TestListConditionalGet(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+
+	list := func(ifNoneMatch string) (int, string, string) {
+		t.Helper()
+		req := newRequest(t, roleViewer, "GET", "/employee", "")
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, body := send(t, app, req)
+		return resp.StatusCode, resp.Header.Get("ETag"), body
+	}
+
+	status, etag, _ := list("")
+	if status != 200 || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("first list: status = %d, ETag %q, want 200 with a weak ETag", status, etag)
+	}
+	if status, _, body := list(etag); status != 304 || body != "" {
+		t.Errorf("unchanged list: status = %d, body %q, want 304 without a body", status, body)
+	}
+	if status, _, _ := list(`W/"0-1-00000000"`); status != 200 {
+		t.Errorf("other ETag: status = %d, want 200", status)
+	}
+
+	// a write moves the list on to another version
+	request(t, app, roleAdmin, "POST", "/employee", `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`)
+	status, newETag, body := list(etag)
+	if status != 200 || newETag == etag || !strings.Contains(body, "Jane Doe") {
+		t.Errorf("list after create: status = %d, ETag %q, want 200 with an ETag other than %q", status, newETag, etag)
+	}
+}
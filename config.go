@@ -0,0 +1,603 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// Config holds the settings the app needs at startup. Every value can be
+// overridden from the environment so the same binary runs locally, in staging
+// and against a production cluster
+type Config struct {
+	// Env is "production" or "development". Development shows clients the
+	// internal errors behind a 500, which production keeps to the logs
+	Env string
+
+	// MongoURI can be a mongodb+srv:// URI, like the ones Atlas hands out, which
+	// turns on TLS by itself
+	MongoURI string
+	DBName   string
+	// EmployeesCollection is the collection the employees are stored in, so
+	// test runs and tenants sharing a database can each have their own
+	EmployeesCollection string
+
+	// the mongo credentials, for when they aren't in MongoURI. MongoAuthSource is
+	// the database they are checked against, "admin" when it is empty
+	MongoUsername   string
+	MongoPassword   string
+	MongoAuthSource string
+
+	// MongoTLS turns on TLS to mongo. MongoTLSCAFile is a PEM file with the
+	// certificates to trust instead of the system ones, and implies MongoTLS
+	MongoTLS       bool
+	MongoTLSCAFile string
+
+	// the server listens on BindAddr:Port. An empty BindAddr listens on every interface
+	Port     string
+	BindAddr string
+
+	// ProxyHeader is the header the reverse proxy in front of the app passes the
+	// client IP in, like X-Forwarded-For, for the rate limits and the logs. It
+	// is only believed on requests coming from TrustedProxies, IPs or CIDR
+	// ranges, since any client can send the header. Empty uses the IP the
+	// request came from
+	ProxyHeader    string
+	TrustedProxies []string
+
+	// the size of the mongo connection pool. The pool grows up to
+	// MongoMaxPoolSize under load and keeps MongoMinPoolSize idle connections
+	MongoMaxPoolSize uint64
+	MongoMinPoolSize uint64
+
+	// MongoConnectTimeout bounds connecting to mongo, at startup and for each new
+	// pooled connection. MongoSocketTimeout bounds every read and write on a
+	// connection, zero means no limit. MongoOperationTimeout is how long each
+	// database call made while serving a request can take, a request making
+	// several gets it for every one of them, within RequestTimeout
+	MongoConnectTimeout   time.Duration
+	MongoSocketTimeout    time.Duration
+	MongoOperationTimeout time.Duration
+
+	// DBMaxInFlight is how many database operations can run at once, zero
+	// means no limit. Others queue for up to DBQueueTimeout and then fail,
+	// which a request answers with a 503. A queue timeout of zero turns them
+	// away straight away
+	DBMaxInFlight  int
+	DBQueueTimeout time.Duration
+
+	// MongoConnectRetries is how many more times connecting at startup is tried
+	// after the first attempt fails. The wait between attempts starts at
+	// MongoRetryBackoff and doubles each time
+	MongoConnectRetries int
+	MongoRetryBackoff   time.Duration
+
+	// MongoReadPreference is where the list and stats endpoints read from, like
+	// "secondaryPreferred" on a replica set, every other read goes to the
+	// primary. MongoWriteConcern is "majority" or how many members must
+	// acknowledge a write. Empty leaves them to MongoURI, which defaults to
+	// the primary and acknowledged writes
+	MongoReadPreference string
+	MongoWriteConcern   string
+
+	// LogFormat is either "text" or "json", for shipping logs to an
+	// aggregator. It defaults to json in production and text in development.
+	// LogLevel is debug, info, warn or error
+	LogFormat string
+	LogLevel  string
+
+	// AllowedOrigins is a comma separated list of origins allowed to call the
+	// API from a browser, "*" allows any origin
+	AllowedOrigins string
+
+	// JWTSecret signs and verifies the access tokens, it has no default and must be set
+	JWTSecret string
+	TokenTTL  time.Duration
+	// RefreshTokenTTL is how long a refresh token from POST /login can be
+	// exchanged for new access tokens
+	RefreshTokenTTL time.Duration
+
+	// the admin user created at startup when there is no user with the name
+	// yet, so there is someone to log in as. Nothing is created when they are empty
+	AdminUsername string
+	AdminPassword string
+
+	// LoginMaxAttempts is how many wrong passwords in a row lock a user out
+	// for LoginLockout, zero turns the lockout off
+	LoginMaxAttempts int
+	LoginLockout     time.Duration
+
+	// RateLimit is how many requests a client IP can make in each RateWindow.
+	// WriteRateLimit is the stricter limit for requests that change data
+	RateLimit      int
+	WriteRateLimit int
+	RateWindow     time.Duration
+
+	// ListCacheTTL is how long employee list responses are cached for, zero turns the cache off
+	ListCacheTTL time.Duration
+
+	// HistoryMaxRevisions is how many revisions of each employee are kept, zero keeps them all
+	HistoryMaxRevisions int
+
+	// ReadOnly starts the service refusing every change to the data with a 503,
+	// for maintenance. Admins can turn it off at runtime with PUT /read-only
+	ReadOnly bool
+
+	// SalaryChangeInterval is how often the scheduled salary changes that have
+	// become due are applied, zero turns the scheduler off
+	SalaryChangeInterval time.Duration
+
+	// JobBatchSize is how many employees a recalculation job reads and writes at
+	// a time, unless the request that starts it picks another size
+	JobBatchSize int
+
+	// BodyLimit is the largest request body accepted, in bytes. Bigger ones are refused with a 413
+	BodyLimit int
+
+	// RequestTimeout is the deadline of every request as a whole, however many
+	// database calls it makes, zero means no limit. A request past it is
+	// answered with a 504
+	RequestTimeout time.Duration
+
+	// CompressLevel is how hard responses are gzipped for clients that accept
+	// it: "off", "speed", "default" or "best"
+	CompressLevel string
+
+	// WebhookURLs are POSTed an event for every change to the employees, signed
+	// with WebhookSecret. No urls turns the webhooks off
+	WebhookURLs   []string
+	WebhookSecret string
+}
+
+// the environments the app can run in
+const (
+	envProduction  = "production"
+	envDevelopment = "development"
+)
+
+// Development reports whether the app runs in development, where errors are shown in full
+func (c Config) Development() bool {
+	return c.Env == envDevelopment
+}
+
+// default settings, matching what the app used before they were configurable
+const (
+	defaultDBName   = "fiber-hrms"
+	defaultMongoURI = "mongodb://localhost:27017/" + defaultDBName
+	defaultPort     = "3000"
+
+	defaultMongoMaxPoolSize      = 100
+	defaultMongoMinPoolSize      = 0
+	defaultMongoConnectTimeout   = 30 * time.Second
+	defaultMongoSocketTimeout    = 0
+	defaultMongoOperationTimeout = 5 * time.Second
+	defaultDBQueueTimeout        = time.Second
+	defaultMongoConnectRetries   = 5
+	defaultMongoRetryBackoff     = time.Second
+
+	defaultLogLevel       = "info"
+	defaultAllowedOrigins = "*"
+	defaultTokenTTL       = time.Hour
+	defaultRefreshTTL     = 30 * 24 * time.Hour
+	defaultLoginAttempts  = 5
+	defaultLoginLockout   = 15 * time.Minute
+	defaultRateLimit      = 100
+	defaultWriteRateLimit = 20
+	defaultRateWindow     = time.Minute
+	defaultListCacheTTL   = 10 * time.Second
+
+	defaultHistoryMaxRevisions = 50
+	defaultJobBatchSize        = 500
+	defaultSalaryInterval      = time.Minute
+	defaultBodyLimit           = 4 * 1024 * 1024
+	defaultRequestTimeout      = 30 * time.Second
+	defaultCompressLevel       = "default"
+	defaultEmployeesCollection = "employees"
+	defaultEnv                 = envProduction
+)
+
+// LoadConfig reads the config from the environment, using the defaults for
+// anything that is not set. It returns an error for values that are malformed
+// or required settings that are missing
+func LoadConfig() (Config, error) {
+	maxPoolSize, err := getEnvInt("MONGO_MAX_POOL_SIZE", defaultMongoMaxPoolSize)
+	if err != nil {
+		return Config{}, err
+	}
+	minPoolSize, err := getEnvInt("MONGO_MIN_POOL_SIZE", defaultMongoMinPoolSize)
+	if err != nil {
+		return Config{}, err
+	}
+	connectTimeout, err := getEnvDuration("MONGO_CONNECT_TIMEOUT", defaultMongoConnectTimeout)
+	if err != nil {
+		return Config{}, err
+	}
+	socketTimeout, err := getEnvDuration("MONGO_SOCKET_TIMEOUT", defaultMongoSocketTimeout)
+	if err != nil {
+		return Config{}, err
+	}
+	operationTimeout, err := getEnvDuration("MONGO_OPERATION_TIMEOUT", defaultMongoOperationTimeout)
+	if err != nil {
+		return Config{}, err
+	}
+	// the default keeps as many operations running as there are connections for them
+	dbMaxInFlight, err := getEnvInt("DB_MAX_IN_FLIGHT", maxPoolSize)
+	if err != nil {
+		return Config{}, err
+	}
+	dbQueueTimeout, err := getEnvDuration("DB_QUEUE_TIMEOUT", defaultDBQueueTimeout)
+	if err != nil {
+		return Config{}, err
+	}
+	connectRetries, err := getEnvInt("MONGO_CONNECT_RETRIES", defaultMongoConnectRetries)
+	if err != nil {
+		return Config{}, err
+	}
+	retryBackoff, err := getEnvDuration("MONGO_RETRY_BACKOFF", defaultMongoRetryBackoff)
+	if err != nil {
+		return Config{}, err
+	}
+	tokenTTL, err := getEnvDuration("TOKEN_TTL", defaultTokenTTL)
+	if err != nil {
+		return Config{}, err
+	}
+	refreshTokenTTL, err := getEnvDuration("REFRESH_TOKEN_TTL", defaultRefreshTTL)
+	if err != nil {
+		return Config{}, err
+	}
+	loginMaxAttempts, err := getEnvInt("LOGIN_MAX_ATTEMPTS", defaultLoginAttempts)
+	if err != nil {
+		return Config{}, err
+	}
+	loginLockout, err := getEnvDuration("LOGIN_LOCKOUT", defaultLoginLockout)
+	if err != nil {
+		return Config{}, err
+	}
+	rateLimit, err := getEnvInt("RATE_LIMIT", defaultRateLimit)
+	if err != nil {
+		return Config{}, err
+	}
+	writeRateLimit, err := getEnvInt("WRITE_RATE_LIMIT", defaultWriteRateLimit)
+	if err != nil {
+		return Config{}, err
+	}
+	rateWindow, err := getEnvDuration("RATE_WINDOW", defaultRateWindow)
+	if err != nil {
+		return Config{}, err
+	}
+	listCacheTTL, err := getEnvDuration("LIST_CACHE_TTL", defaultListCacheTTL)
+	if err != nil {
+		return Config{}, err
+	}
+	historyMaxRevisions, err := getEnvInt("HISTORY_MAX_REVISIONS", defaultHistoryMaxRevisions)
+	if err != nil {
+		return Config{}, err
+	}
+	jobBatchSize, err := getEnvInt("JOB_BATCH_SIZE", defaultJobBatchSize)
+	if err != nil {
+		return Config{}, err
+	}
+	salaryChangeInterval, err := getEnvDuration("SALARY_CHANGE_INTERVAL", defaultSalaryInterval)
+	if err != nil {
+		return Config{}, err
+	}
+	bodyLimit, err := getEnvInt("BODY_LIMIT", defaultBodyLimit)
+	if err != nil {
+		return Config{}, err
+	}
+	requestTimeout, err := getEnvDuration("REQUEST_TIMEOUT", defaultRequestTimeout)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// a negative size would wrap around to a huge pool when converted to uint64
+	if maxPoolSize < 1 || minPoolSize < 0 || minPoolSize > maxPoolSize {
+		return Config{}, errors.New("MONGO_MAX_POOL_SIZE must be at least 1 and MONGO_MIN_POOL_SIZE between 0 and it")
+	}
+	if dbMaxInFlight < 0 || dbQueueTimeout < 0 {
+		return Config{}, errors.New("DB_MAX_IN_FLIGHT and DB_QUEUE_TIMEOUT can't be negative")
+	}
+	if connectTimeout <= 0 || socketTimeout < 0 || operationTimeout <= 0 {
+		return Config{}, errors.New("MONGO_CONNECT_TIMEOUT and MONGO_OPERATION_TIMEOUT must be positive, MONGO_SOCKET_TIMEOUT can't be negative")
+	}
+
+	if tokenTTL <= 0 || refreshTokenTTL <= 0 {
+		return Config{}, errors.New("TOKEN_TTL and REFRESH_TOKEN_TTL must be positive")
+	}
+	if loginMaxAttempts < 0 {
+		return Config{}, errors.New("LOGIN_MAX_ATTEMPTS can't be negative")
+	}
+	if loginMaxAttempts > 0 && loginLockout <= 0 {
+		return Config{}, errors.New("LOGIN_LOCKOUT must be positive when LOGIN_MAX_ATTEMPTS is set")
+	}
+	if connectRetries < 0 || retryBackoff < 0 {
+		return Config{}, errors.New("MONGO_CONNECT_RETRIES and MONGO_RETRY_BACKOFF can't be negative")
+	}
+	if historyMaxRevisions < 0 {
+		return Config{}, errors.New("HISTORY_MAX_REVISIONS can't be negative")
+	}
+	if jobBatchSize < 1 || jobBatchSize > maxJobBatchSize {
+		return Config{}, fmt.Errorf("JOB_BATCH_SIZE must be between 1 and %d", maxJobBatchSize)
+	}
+	if salaryChangeInterval < 0 {
+		return Config{}, errors.New("SALARY_CHANGE_INTERVAL can't be negative")
+	}
+	// fiber treats 0 as its own default, so it has to be at least a byte
+	if bodyLimit < 1 {
+		return Config{}, errors.New("BODY_LIMIT must be at least 1")
+	}
+	if requestTimeout < 0 {
+		return Config{}, errors.New("REQUEST_TIMEOUT can't be negative")
+	}
+
+	mongoTLS, err := getEnvBool("MONGO_TLS", false)
+	if err != nil {
+		return Config{}, err
+	}
+	readOnly, err := getEnvBool("READ_ONLY", false)
+	if err != nil {
+		return Config{}, err
+	}
+	if os.Getenv("MONGO_PASSWORD") != "" && os.Getenv("MONGO_USERNAME") == "" {
+		return Config{}, errors.New("MONGO_PASSWORD is set without MONGO_USERNAME")
+	}
+
+	employeesCollection := getEnv("EMPLOYEES_COLLECTION", defaultEmployeesCollection)
+	// mongo refuses these names, or keeps them for itself
+	if strings.ContainsAny(employeesCollection, "$\x00") || strings.HasPrefix(employeesCollection, "system.") {
+		return Config{}, fmt.Errorf("EMPLOYEES_COLLECTION %q is not a collection name mongo allows", employeesCollection)
+	}
+
+	env := getEnv("ENV", defaultEnv)
+	if env != envProduction && env != envDevelopment {
+		return Config{}, fmt.Errorf("ENV must be %s or %s, got %q", envProduction, envDevelopment, env)
+	}
+
+	logFormat := logFormatText
+	if env == envProduction {
+		logFormat = logFormatJSON
+	}
+	logFormat = getEnv("LOG_FORMAT", logFormat)
+	if logFormat != logFormatText && logFormat != logFormatJSON {
+		return Config{}, fmt.Errorf("LOG_FORMAT must be %s or %s, got %q", logFormatText, logFormatJSON, logFormat)
+	}
+	logLevel := getEnv("LOG_LEVEL", defaultLogLevel)
+	if _, ok := logLevels[logLevel]; !ok {
+		return Config{}, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", logLevel)
+	}
+
+	readPreference := os.Getenv("MONGO_READ_PREFERENCE")
+	if _, err := parseReadPreference(readPreference); err != nil {
+		return Config{}, err
+	}
+	writeConcern := os.Getenv("MONGO_WRITE_CONCERN")
+	if _, err := parseWriteConcern(writeConcern); err != nil {
+		return Config{}, err
+	}
+
+	compressLevel := getEnv("COMPRESS_LEVEL", defaultCompressLevel)
+	if _, ok := compressLevels[compressLevel]; !ok {
+		return Config{}, fmt.Errorf("COMPRESS_LEVEL must be off, speed, default or best, got %q", compressLevel)
+	}
+
+	webhookURLs, err := parseWebhookURLs(os.Getenv("WEBHOOK_URLS"))
+	if err != nil {
+		return Config{}, err
+	}
+	webhookSecret := os.Getenv("WEBHOOK_SECRET")
+	if len(webhookURLs) > 0 && webhookSecret == "" {
+		return Config{}, errors.New("WEBHOOK_SECRET must be set along with WEBHOOK_URLS, receivers need it to check the events are ours")
+	}
+
+	proxyHeader := os.Getenv("PROXY_HEADER")
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return Config{}, err
+	}
+	if proxyHeader != "" && len(trustedProxies) == 0 {
+		return Config{}, errors.New("TRUSTED_PROXIES must be set along with PROXY_HEADER, otherwise any client could pick its own IP")
+	}
+
+	port := getEnv("PORT", defaultPort)
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return Config{}, fmt.Errorf("PORT must be a number between 1 and 65535, got %q", port)
+	}
+
+	cfg := Config{
+		Env: env,
+
+		MongoURI: getEnv("MONGO_URI", defaultMongoURI),
+		DBName:   getEnv("DB_NAME", defaultDBName),
+
+		EmployeesCollection: employeesCollection,
+
+		Port:     port,
+		BindAddr: os.Getenv("BIND_ADDR"),
+
+		ProxyHeader:    proxyHeader,
+		TrustedProxies: trustedProxies,
+
+		MongoUsername:   os.Getenv("MONGO_USERNAME"),
+		MongoPassword:   os.Getenv("MONGO_PASSWORD"),
+		MongoAuthSource: os.Getenv("MONGO_AUTH_SOURCE"),
+		MongoTLS:        mongoTLS,
+		MongoTLSCAFile:  os.Getenv("MONGO_TLS_CA_FILE"),
+
+		MongoMaxPoolSize:      uint64(maxPoolSize),
+		MongoMinPoolSize:      uint64(minPoolSize),
+		MongoConnectTimeout:   connectTimeout,
+		MongoSocketTimeout:    socketTimeout,
+		MongoOperationTimeout: operationTimeout,
+		MongoConnectRetries:   connectRetries,
+		MongoRetryBackoff:     retryBackoff,
+		MongoReadPreference:   readPreference,
+		MongoWriteConcern:     writeConcern,
+
+		DBMaxInFlight:  dbMaxInFlight,
+		DBQueueTimeout: dbQueueTimeout,
+
+		LogFormat:      logFormat,
+		LogLevel:       logLevel,
+		AllowedOrigins: getEnv("ALLOWED_ORIGINS", defaultAllowedOrigins),
+
+		JWTSecret:       os.Getenv("JWT_SECRET"),
+		TokenTTL:        tokenTTL,
+		RefreshTokenTTL: refreshTokenTTL,
+
+		AdminUsername: os.Getenv("ADMIN_USERNAME"),
+		AdminPassword: os.Getenv("ADMIN_PASSWORD"),
+
+		LoginMaxAttempts: loginMaxAttempts,
+		LoginLockout:     loginLockout,
+
+		RateLimit:      rateLimit,
+		WriteRateLimit: writeRateLimit,
+		RateWindow:     rateWindow,
+
+		ListCacheTTL: listCacheTTL,
+
+		HistoryMaxRevisions: historyMaxRevisions,
+
+		ReadOnly: readOnly,
+
+		SalaryChangeInterval: salaryChangeInterval,
+
+		JobBatchSize: jobBatchSize,
+
+		BodyLimit:      bodyLimit,
+		RequestTimeout: requestTimeout,
+		CompressLevel:  compressLevel,
+
+		WebhookURLs:   webhookURLs,
+		WebhookSecret: webhookSecret,
+	}
+
+	if cfg.JWTSecret == "" {
+		return Config{}, errors.New("JWT_SECRET must be set")
+	}
+	return cfg, nil
+}
+
+// ListenAddr is the address the server listens on, like "127.0.0.1:3000"
+func (c Config) ListenAddr() string {
+	return net.JoinHostPort(c.BindAddr, c.Port)
+}
+
+// parseWebhookURLs reads the comma separated WEBHOOK_URLS, each of which has
+// to be an absolute http or https url
+func parseWebhookURLs(value string) ([]string, error) {
+	var urls []string
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("WEBHOOK_URLS must be http or https urls separated by commas, got %q", raw)
+		}
+		urls = append(urls, raw)
+	}
+	return urls, nil
+}
+
+// parseTrustedProxies reads the comma separated TRUSTED_PROXIES, each of which
+// is an IP like 10.0.0.5 or a CIDR range like 10.0.0.0/8
+func parseTrustedProxies(value string) ([]string, error) {
+	var proxies []string
+	for _, proxy := range strings.Split(value, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES must be IPs or CIDR ranges separated by commas, got %q", proxy)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// parseReadPreference reads MONGO_READ_PREFERENCE, nil when it is empty
+func parseReadPreference(value string) (*readpref.ReadPref, error) {
+	if value == "" {
+		return nil, nil
+	}
+	mode, err := readpref.ModeFromString(value)
+	if err != nil {
+		return nil, fmt.Errorf("MONGO_READ_PREFERENCE must be primary, primaryPreferred, secondary, secondaryPreferred or nearest, got %q", value)
+	}
+	return readpref.New(mode)
+}
+
+// parseWriteConcern reads MONGO_WRITE_CONCERN, nil when it is empty
+func parseWriteConcern(value string) (*writeconcern.WriteConcern, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if value == "majority" {
+		return writeconcern.New(writeconcern.WMajority()), nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("MONGO_WRITE_CONCERN must be majority or how many members acknowledge a write, got %q", value)
+	}
+	return writeconcern.New(writeconcern.W(n)), nil
+}
+
+// getEnv returns the value of the environment variable, or fallback when it is empty
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvDuration parses the environment variable as a duration like "30s" or "1h",
+// returning fallback when it is empty
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration like 30s or 1h: %w", key, err)
+	}
+	return d, nil
+}
+
+// getEnvBool parses the environment variable as a boolean like "true" or "0",
+// returning fallback when it is empty
+func getEnvBool(key string, fallback bool) (bool, error) {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false: %w", key, err)
+	}
+	return b, nil
+}
+
+// getEnvInt parses the environment variable as an integer, returning fallback when it is empty
+func getEnvInt(key string, fallback int) (int, error) {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a whole number: %w", key, err)
+	}
+	return n, nil
+}
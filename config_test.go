@@ -0,0 +1,297 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListenAddr(t *testing.T) {
+	tests := []struct {
+		port, bindAddr string
+		want           string
+		wantErr        bool
+	}{
+		{port: "", want: ":3000"},
+		{port: "8080", bindAddr: "127.0.0.1", want: "127.0.0.1:8080"},
+		{port: "8080", bindAddr: "::1", want: "[::1]:8080"},
+		{port: "http", wantErr: true},
+		{port: "0", wantErr: true},
+		{port: "65536", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", "test-secret")
+		t.Setenv("PORT", tt.port)
+		t.Setenv("BIND_ADDR", tt.bindAddr)
+
+		cfg, err := LoadConfig()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("PORT=%q: no error", tt.port)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("PORT=%q BIND_ADDR=%q: %v", tt.port, tt.bindAddr, err)
+		} else if got := cfg.ListenAddr(); got != tt.want {
+			t.Errorf("PORT=%q BIND_ADDR=%q: listening on %q, want %q", tt.port, tt.bindAddr, got, tt.want)
+		}
+	}
+}
+
+func TestEmployeesCollection(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: "employees"},
+		{value: "employees_run_42", want: "employees_run_42"},
+		{value: "system.users", wantErr: true},
+		{value: "employees$", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", "test-secret")
+		t.Setenv("EMPLOYEES_COLLECTION", tt.value)
+
+		cfg, err := LoadConfig()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("EMPLOYEES_COLLECTION=%q: no error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("EMPLOYEES_COLLECTION=%q: %v", tt.value, err)
+		} else if cfg.EmployeesCollection != tt.want {
+			t.Errorf("EMPLOYEES_COLLECTION=%q: collection %q, want %q", tt.value, cfg.EmployeesCollection, tt.want)
+		}
+	}
+}
+
+func TestWebhookConfig(t *testing.T) {
+	tests := []struct {
+		urls    string
+		secret  string
+		want    []string
+		wantErr bool
+	}{
+		{urls: "", want: nil},
+		{urls: "https://billing.example.com/hooks, http://localhost:9000/events", secret: "s", want: []string{"https://billing.example.com/hooks", "http://localhost:9000/events"}},
+		{urls: "https://billing.example.com/hooks", wantErr: true},
+		{urls: "billing.example.com/hooks", secret: "s", wantErr: true},
+		{urls: "ftp://billing.example.com", secret: "s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", "test-secret")
+		t.Setenv("WEBHOOK_URLS", tt.urls)
+		t.Setenv("WEBHOOK_SECRET", tt.secret)
+
+		cfg, err := LoadConfig()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("WEBHOOK_URLS=%q: no error", tt.urls)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("WEBHOOK_URLS=%q: %v", tt.urls, err)
+		} else if strings.Join(cfg.WebhookURLs, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("WEBHOOK_URLS=%q: urls %q, want %q", tt.urls, cfg.WebhookURLs, tt.want)
+		}
+	}
+}
+
+func TestReadPreferenceAndWriteConcernConfig(t *testing.T) {
+	tests := []struct {
+		readPreference string
+		writeConcern   string
+		wantErr        bool
+	}{
+		{},
+		{readPreference: "secondaryPreferred", writeConcern: "majority"},
+		{readPreference: "nearest", writeConcern: "2"},
+		{readPreference: "secondaries", wantErr: true},
+		{writeConcern: "all", wantErr: true},
+		{writeConcern: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", "test-secret")
+		t.Setenv("MONGO_READ_PREFERENCE", tt.readPreference)
+		t.Setenv("MONGO_WRITE_CONCERN", tt.writeConcern)
+
+		cfg, err := LoadConfig()
+		if tt.wantErr != (err != nil) {
+			t.Errorf("MONGO_READ_PREFERENCE=%q MONGO_WRITE_CONCERN=%q: err = %v, want an error %v", tt.readPreference, tt.writeConcern, err, tt.wantErr)
+		} else if err == nil && (cfg.MongoReadPreference != tt.readPreference || cfg.MongoWriteConcern != tt.writeConcern) {
+			t.Errorf("MONGO_READ_PREFERENCE=%q MONGO_WRITE_CONCERN=%q: cfg has %q and %q", tt.readPreference, tt.writeConcern, cfg.MongoReadPreference, cfg.MongoWriteConcern)
+		}
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	tests := []struct {
+		header  string
+		proxies string
+		want    []string
+		wantErr bool
+	}{
+		{},
+		{header: "X-Forwarded-For", proxies: "10.0.0.0/8, 192.168.1.10", want: []string{"10.0.0.0/8", "192.168.1.10"}},
+		{header: "X-Forwarded-For", wantErr: true},
+		{header: "X-Real-IP", proxies: "nginx", wantErr: true},
+		{header: "X-Real-IP", proxies: "10.0.0.0/33", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", "test-secret")
+		t.Setenv("PROXY_HEADER", tt.header)
+		t.Setenv("TRUSTED_PROXIES", tt.proxies)
+
+		cfg, err := LoadConfig()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("PROXY_HEADER=%q TRUSTED_PROXIES=%q: no error", tt.header, tt.proxies)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("PROXY_HEADER=%q TRUSTED_PROXIES=%q: %v", tt.header, tt.proxies, err)
+		} else if cfg.ProxyHeader != tt.header || strings.Join(cfg.TrustedProxies, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("PROXY_HEADER=%q TRUSTED_PROXIES=%q: got %q and %q", tt.header, tt.proxies, cfg.ProxyHeader, cfg.TrustedProxies)
+		}
+	}
+}
+
+func TestRequestTimeoutConfig(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: defaultRequestTimeout},
+		{value: "1m", want: time.Minute},
+		{value: "0", want: 0},
+		{value: "-1s", wantErr: true},
+		{value: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", "test-secret")
+		t.Setenv("REQUEST_TIMEOUT", tt.value)
+
+		cfg, err := LoadConfig()
+		if tt.wantErr != (err != nil) {
+			t.Errorf("REQUEST_TIMEOUT=%q: err = %v, want an error %v", tt.value, err, tt.wantErr)
+		} else if err == nil && cfg.RequestTimeout != tt.want {
+			t.Errorf("REQUEST_TIMEOUT=%q: got %v, want %v", tt.value, cfg.RequestTimeout, tt.want)
+		}
+	}
+}
+
+func TestLoginLockoutConfig(t *testing.T) {
+	tests := []struct {
+		attempts    string
+		lockout     string
+		wantAttempt int
+		wantLockout time.Duration
+		wantErr     bool
+	}{
+		{wantAttempt: defaultLoginAttempts, wantLockout: defaultLoginLockout},
+		{attempts: "3", lockout: "1h", wantAttempt: 3, wantLockout: time.Hour},
+		{attempts: "0", lockout: "0", wantAttempt: 0, wantLockout: 0},
+		{attempts: "-1", wantErr: true},
+		{attempts: "3", lockout: "0", wantErr: true},
+		{attempts: "many", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", "test-secret")
+		t.Setenv("LOGIN_MAX_ATTEMPTS", tt.attempts)
+		t.Setenv("LOGIN_LOCKOUT", tt.lockout)
+
+		cfg, err := LoadConfig()
+		if tt.wantErr != (err != nil) {
+			t.Errorf("LOGIN_MAX_ATTEMPTS=%q LOGIN_LOCKOUT=%q: err = %v, want an error %v", tt.attempts, tt.lockout, err, tt.wantErr)
+		} else if err == nil && (cfg.LoginMaxAttempts != tt.wantAttempt || cfg.LoginLockout != tt.wantLockout) {
+			t.Errorf("LOGIN_MAX_ATTEMPTS=%q LOGIN_LOCKOUT=%q: got %d and %v, want %d and %v", tt.attempts, tt.lockout, cfg.LoginMaxAttempts, cfg.LoginLockout, tt.wantAttempt, tt.wantLockout)
+		}
+	}
+}
+
+func TestJobBatchSizeConfig(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "", want: defaultJobBatchSize},
+		{value: "1000", want: 1000},
+		{value: "0", wantErr: true},
+		{value: "10001", wantErr: true},
+		{value: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", "test-secret")
+		t.Setenv("JOB_BATCH_SIZE", tt.value)
+
+		cfg, err := LoadConfig()
+		if tt.wantErr != (err != nil) {
+			t.Errorf("JOB_BATCH_SIZE=%q: err = %v, want an error %v", tt.value, err, tt.wantErr)
+		} else if err == nil && cfg.JobBatchSize != tt.want {
+			t.Errorf("JOB_BATCH_SIZE=%q: got %d, want %d", tt.value, cfg.JobBatchSize, tt.want)
+		}
+	}
+}
+
+func TestSalaryChangeIntervalConfig(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: defaultSalaryInterval},
+		{value: "5m", want: 5 * time.Minute},
+		{value: "0", want: 0},
+		{value: "-1s", wantErr: true},
+		{value: "often", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", "test-secret")
+		t.Setenv("SALARY_CHANGE_INTERVAL", tt.value)
+
+		cfg, err := LoadConfig()
+		if tt.wantErr != (err != nil) {
+			t.Errorf("SALARY_CHANGE_INTERVAL=%q: err = %v, want an error %v", tt.value, err, tt.wantErr)
+		} else if err == nil && cfg.SalaryChangeInterval != tt.want {
+			t.Errorf("SALARY_CHANGE_INTERVAL=%q: got %v, want %v", tt.value, cfg.SalaryChangeInterval, tt.want)
+		}
+	}
+}
+
+func TestLogConfig(t *testing.T) {
+	tests := []struct {
+		env        string
+		format     string
+		level      string
+		wantFormat string
+		wantLevel  string
+		wantErr    bool
+	}{
+		{env: envProduction, wantFormat: logFormatJSON, wantLevel: defaultLogLevel},
+		{env: envDevelopment, wantFormat: logFormatText, wantLevel: defaultLogLevel},
+		{env: envProduction, format: "text", level: "debug", wantFormat: logFormatText, wantLevel: "debug"},
+		{env: envProduction, format: "xml", wantErr: true},
+		{env: envProduction, level: "verbose", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_SECRET", "test-secret")
+		t.Setenv("ENV", tt.env)
+		t.Setenv("LOG_FORMAT", tt.format)
+		t.Setenv("LOG_LEVEL", tt.level)
+
+		cfg, err := LoadConfig()
+		if tt.wantErr != (err != nil) {
+			t.Errorf("ENV=%q LOG_FORMAT=%q LOG_LEVEL=%q: err = %v, want an error %v", tt.env, tt.format, tt.level, err, tt.wantErr)
+		} else if err == nil && (cfg.LogFormat != tt.wantFormat || cfg.LogLevel != tt.wantLevel) {
+			t.Errorf("ENV=%q LOG_FORMAT=%q LOG_LEVEL=%q: got %s at %s, want %s at %s", tt.env, tt.format, tt.level, cfg.LogFormat, cfg.LogLevel, tt.wantFormat, tt.wantLevel)
+		}
+	}
+}
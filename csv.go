@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// csvHeader is the first row of the employee CSV export, and the columns the
+// import expects
+var csvHeader = []string{"id", "name", "email", "age", "salary"}
+
+// exportTimeout bounds how long a single export can keep a cursor open
+const exportTimeout = 10 * time.Minute
+
+// exportContext is the context an export's stream writer starts from. The
+// request's is gone by the time the writer runs, so it is a new one, still
+// marked to read wherever the request could. It holds a database slot, taken
+// while the request can still be answered with a 503, for the whole stream:
+// release gives it back once the writer is done
+func exportContext(c *fiber.Ctx) (ctx context.Context, release func(), err error) {
+	ctx = withConcurrencyLimiter(context.Background(), concurrencyLimiterOf(c.UserContext()))
+	if hasListReads(c.UserContext()) {
+		ctx = withListReads(ctx)
+	}
+	return acquireSlot(ctx)
+}
+
+// ExportCSV streams the employees as a CSV download. It takes the same filters
+// as the list endpoint, but isn't paginated. Rows are written as they come off
+// the cursor, so memory use stays flat however big the collection is. An
+// interrupted download is resumed with ?offset, which leaves out the header
+// so the rest can be appended to what was already saved
+//
+// @Summary Export employees as CSV
+// @Tags employees
+// @Produce text/csv
+// @Security BearerAuth
+// @Param search query string false "Only employees whose name contains this"
+// @Param offset query int false "Rows to skip, to resume an interrupted export"
+// @Success 200 {file} file "id,name,email,age,salary rows"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /employee/export.csv [get]
+func (h *EmployeeHandler) ExportCSV(c *fiber.Ctx) error {
+	query, err := buildEmployeeFilter(c)
+	if err != nil {
+		return err
+	}
+	offset, err := parseExportOffset(c)
+	if err != nil {
+		return err
+	}
+	findOptions := options.Find().SetSort(exportSort).SetSkip(offset)
+
+	streamCtx, release, err := exportContext(c)
+	if err != nil {
+		return err
+	}
+
+	setExportHeaders(c)
+	c.Set(fiber.HeaderContentType, "text/csv")
+	c.Set(fiber.HeaderContentDisposition, "attachment; filename=employees.csv")
+
+	// the stream writer runs after the handler has returned, once fiber starts
+	// sending the response, so it can't use the request context. By then the
+	// status is already sent, so errors can only be logged
+	reqLog := requestLog(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer release()
+		ctx, cancel := context.WithTimeout(streamCtx, exportTimeout)
+		defer cancel()
+
+		writer := csv.NewWriter(w)
+		if offset == 0 {
+			if err := writer.Write(csvHeader); err != nil {
+				reqLog.Error("exporting employees", slog.Any("error", err))
+				return
+			}
+		}
+
+		err := h.repo.Stream(ctx, query, findOptions, func(e *Employee) error {
+			return writer.Write([]string{
+				e.ID.Hex(),
+				e.Name,
+				e.Email,
+				strconv.FormatFloat(e.Age, 'f', -1, 64),
+				e.Salary.String(),
+			})
+		})
+		writer.Flush()
+		if err == nil {
+			err = writer.Error()
+		}
+		if err != nil {
+			reqLog.Error("exporting employees", slog.Any("error", err))
+		}
+	})
+	return nil
+}
+
+// ImportSummary is the result of a CSV import
+type ImportSummary struct {
+	Imported int         `json:"imported"`
+	Failed   []ImportRow `json:"failed"`
+}
+
+// ImportRow is a row of an imported CSV that couldn't be added. Row counts
+// lines in the file, so the header is row 1
+type ImportRow struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// ImportCSV adds the employees in an uploaded CSV file, sent as the "file" field
+// of a multipart form. It takes the columns the export writes, the id column is
+// ignored since mongo creates the ids. Rows that fail validation are reported
+// back rather than failing the whole import
+//
+// @Summary Import employees from CSV
+// @Tags employees
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "CSV with the columns id,name,email,age,salary"
+// @Success 200 {object} ImportSummary
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /employee/import [post]
+func (h *EmployeeHandler) ImportCSV(c *fiber.Ctx) error {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "a CSV file must be uploaded in the file field")
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	// every row must have exactly the header's columns, anything else is a malformed file
+	reader.FieldsPerRecord = len(csvHeader)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return csvError(err)
+	}
+	for i, column := range header {
+		if strings.ToLower(column) != csvHeader[i] {
+			return fiber.NewError(fiber.StatusBadRequest, "the header row must be "+strings.Join(csvHeader, ","))
+		}
+	}
+
+	summary := ImportSummary{Failed: make([]ImportRow, 0)}
+	var employees []*Employee
+	var rows []int
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return csvError(err)
+		}
+
+		employee, errs := parseCSVEmployee(record)
+		if len(errs) > 0 {
+			summary.Failed = append(summary.Failed, ImportRow{Row: row, Errors: errs})
+			continue
+		}
+		employees = append(employees, employee)
+		rows = append(rows, row)
+	}
+
+	rowErrors, err := h.repo.CreateMany(c.UserContext(), employees)
+	if err != nil {
+		return err
+	}
+	for i, rowErr := range rowErrors {
+		switch {
+		case rowErr == nil:
+			summary.Imported++
+			recordRevision(c, h.history, employees[i])
+		case errors.Is(rowErr, ErrDuplicateEmail):
+			summary.Failed = append(summary.Failed, ImportRow{Row: rows[i], Errors: map[string]string{"email": rowErr.Error()}})
+		default:
+			summary.Failed = append(summary.Failed, ImportRow{Row: rows[i], Errors: map[string]string{"row": rowErr.Error()}})
+		}
+	}
+
+	employeeChanges.WithLabelValues(actionCreated).Add(float64(summary.Imported))
+	recordAudit(c, h.audit, auditImport, employeesCollection, "", nil, summary)
+	// the imported employees aren't read back, so there is one event for the
+	// import as a whole rather than one for each employee
+	if summary.Imported > 0 {
+		h.events.Publish(eventEmployeesImported, summary)
+	}
+	return c.JSON(summary)
+}
+
+// parseCSVEmployee turns a CSV row into an employee, returning the problems
+// with it the same way validate does
+func parseCSVEmployee(record []string) (*Employee, map[string]string) {
+	errs := make(map[string]string)
+	employee := &Employee{Name: record[1], Email: record[2]}
+
+	age, err := strconv.ParseFloat(record[3], 64)
+	if err != nil {
+		errs["age"] = "age must be a number"
+	}
+	employee.Age = age
+	salary, err := ParseMoney(record[4])
+	if err != nil {
+		errs["salary"] = "salary must be a number"
+	}
+	employee.Salary = salary
+
+	// only check the rest of the fields, the number errors say more than range checks would
+	for field, message := range employee.validate() {
+		if _, ok := errs[field]; !ok {
+			errs[field] = message
+		}
+	}
+	return employee, errs
+}
+
+// csvError answers 400 for a CSV file that can't be parsed, saying where it went wrong
+func csvError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("malformed CSV on line %d: %v", parseErr.StartLine, parseErr.Err))
+	}
+	if errors.Is(err, io.EOF) {
+		return fiber.NewError(fiber.StatusBadRequest, "the CSV file is empty")
+	}
+	return fiber.NewError(fiber.StatusBadRequest, "malformed CSV: "+err.Error())
+}
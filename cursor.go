@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// errBadCursor is answered when an ?after cursor can't be read
+var errBadCursor = errors.New("after is not a cursor from a previous page")
+
+// employeeCursor marks the place of the last employee of a page in the list's
+// sort order, so the next page can start right after it. It is sent to the
+// client as opaque base64 JSON, and carries the sort it was made for so it
+// isn't used with another one
+type employeeCursor struct {
+	SortBy string             `json:"s"`
+	Order  string             `json:"o"`
+	Value  json.RawMessage    `json:"v"`
+	ID     primitive.ObjectID `json:"id"`
+}
+
+// normalizeSort fills in the defaults sortDocument uses for an empty sortBy and order
+func normalizeSort(sortBy, order string) (string, string) {
+	if sortBy == "" {
+		sortBy = "name"
+	}
+	if order == "" {
+		order = "asc"
+	}
+	return sortBy, strings.ToLower(order)
+}
+
+// encodeCursor is the cursor for the page after the employee, in the order
+// sorted by sortBy
+func encodeCursor(employee *Employee, sortBy, order string) (string, error) {
+	sortBy, order = normalizeSort(sortBy, order)
+	var value interface{}
+	switch sortBy {
+	case "name":
+		value = employee.Name
+	case "salary":
+		value = employee.Salary
+	case "age":
+		value = employee.Age
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(employeeCursor{SortBy: sortBy, Order: order, Value: raw, ID: employee.ID})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// cursorFilter reads a cursor into the condition matching the employees after
+// it, for the same sortBy and order as the page it came from. Employees are
+// sorted by the field and then _id, so that is those with a later value, or
+// the same value and a bigger _id
+func cursorFilter(raw, sortBy, order string) (bson.E, error) {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return bson.E{}, errBadCursor
+	}
+	var cursor employeeCursor
+	if err := json.Unmarshal(b, &cursor); err != nil || cursor.ID.IsZero() {
+		return bson.E{}, errBadCursor
+	}
+	sortBy, order = normalizeSort(sortBy, order)
+	if cursor.SortBy != sortBy || cursor.Order != order {
+		return bson.E{}, errors.New("after is a cursor for a different sortBy or order, start again from the first page")
+	}
+
+	var value interface{}
+	switch sortBy {
+	case "name":
+		var name string
+		err = json.Unmarshal(cursor.Value, &name)
+		value = name
+	case "salary":
+		var salary Money
+		err = json.Unmarshal(cursor.Value, &salary)
+		value = salary
+	case "age":
+		var age float64
+		err = json.Unmarshal(cursor.Value, &age)
+		value = age
+	default:
+		err = errBadCursor
+	}
+	if err != nil {
+		return bson.E{}, errBadCursor
+	}
+
+	past := "$gt"
+	if order == "desc" {
+		past = "$lt"
+	}
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.D{{Key: sortBy, Value: bson.D{{Key: past, Value: value}}}},
+		bson.D{{Key: sortBy, Value: value}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: cursor.ID}}}},
+	}}, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursorFilter(t *testing.T) {
+	tests := []struct {
+		sortBy, order string
+		want          bson.E
+	}{
+		{"", "", bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "name", Value: bson.D{{Key: "$gt", Value: "John Doe"}}}},
+			bson.D{{Key: "name", Value: "John Doe"}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: johnID}}}},
+		}}},
+		{"salary", "DESC", bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "salary", Value: bson.D{{Key: "$lt", Value: MoneyFromInt(50000)}}}},
+			bson.D{{Key: "salary", Value: MoneyFromInt(50000)}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: johnID}}}},
+		}}},
+		{"age", "asc", bson.E{Key: "$or", Value: bson.A{
+			bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: 30.0}}}},
+			bson.D{{Key: "age", Value: 30.0}, {Key: "_id", Value: bson.D{{Key: "$gt", Value: johnID}}}},
+		}}},
+	}
+	for _, tt := range tests {
+		cursor, err := encodeCursor(&john, tt.sortBy, tt.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := cursorFilter(cursor, tt.sortBy, tt.order)
+		if err != nil {
+			t.Errorf("sortBy=%q order=%q: %v", tt.sortBy, tt.order, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sortBy=%q order=%q: filter %v, want %v", tt.sortBy, tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestCursorFilterRejects(t *testing.T) {
+	byName, _ := encodeCursor(&john, "name", "asc")
+	tests := []struct {
+		name, cursor, sortBy, order string
+	}{
+		{"not base64", "%%%", "", ""},
+		{"not a cursor", "bm90IGpzb24", "", ""},
+		{"another sort", byName, "salary", "asc"},
+		{"another order", byName, "name", "desc"},
+	}
+	for _, tt := range tests {
+		if _, err := cursorFilter(tt.cursor, tt.sortBy, tt.order); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestListEmployeesAfterCursor(t *testing.T) {
+	// the fake ignores the cursor condition and the sort, so this only checks
+	// how the page is cut and linked
+	app := newTestApp(newFakeRepository(
+		john,
+		Employee{ID: primitive.NewObjectID(), Name: "Jane Doe", Email: "jane@example.com"},
+		Employee{ID: primitive.NewObjectID(), Name: "Jim Doe", Email: "jim@example.com"},
+	), newFakeDepartmentRepository())
+
+	// an offset page hands out the cursor to carry on from
+	status, body := request(t, app, roleViewer, "GET", "/employee?limit=1", "")
+	var first EmployeeList
+	if err := json.Unmarshal([]byte(body), &first); err != nil || status != 200 || first.NextCursor == nil {
+		t.Fatalf("first page: status = %d, body %q, want a nextCursor", status, body)
+	}
+
+	status, body = request(t, app, roleViewer, "GET", "/employee?limit=2&after="+*first.NextCursor, "")
+	var page EmployeeCursorList
+	if err := json.Unmarshal([]byte(body), &page); err != nil || status != 200 {
+		t.Fatalf("after: status = %d, body %q", status, body)
+	}
+	if len(page.Data) != 2 || page.NextCursor == nil || !strings.Contains(link(page.Next), "after="+*page.NextCursor) {
+		t.Errorf("after: got %d employees, next %q, cursor %v, want two and a link to the rest", len(page.Data), link(page.Next), page.NextCursor)
+	}
+	if strings.Contains(body, `"total"`) || strings.Contains(body, `"page"`) {
+		t.Errorf("after: body %q has a page number or total", body)
+	}
+
+	// the last page has nowhere to go
+	status, body = request(t, app, roleViewer, "GET", "/employee?limit=3&after="+*first.NextCursor, "")
+	if status != 200 || !strings.Contains(body, `"next":null,"nextCursor":null`) {
+		t.Errorf("last page: status = %d, body %q, want no next page", status, body)
+	}
+
+	for _, path := range []string{"/employee?after=garbage", "/employee?sortBy=age&after=" + *first.NextCursor} {
+		if status, body := request(t, app, roleViewer, "GET", path, ""); status != 400 {
+			t.Errorf("%s: status = %d, body %q, want 400", path, status, body)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Department groups employees, e.g engineering or finance. Employees point at
+// their department with Employee.DepartmentID
+type Department struct {
+	ID          string    `json:"id,omitempty" bson:"_id,omitempty"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// validate checks the department fields and returns a map of field name to
+// the reason it failed. An empty map means the department is valid
+func (d *Department) validate() map[string]string {
+	errs := make(map[string]string)
+
+	if strings.TrimSpace(d.Name) == "" {
+		errs["name"] = "name is required"
+	}
+	return errs
+}
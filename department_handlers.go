@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DepartmentHandler holds the HTTP handlers for the department routes. It needs
+// the employees too, to list a department's employees and to stop departments
+// that still have employees from being deleted
+type DepartmentHandler struct {
+	repo      DepartmentRepository
+	employees EmployeeRepository
+	tx        Transactor
+	audit     AuditRepository
+}
+
+// NewDepartmentHandler creates the department handlers on top of the
+// repositories. The transactor makes writes to both of them atomic, and every
+// change is recorded in the audit log
+func NewDepartmentHandler(repo DepartmentRepository, employees EmployeeRepository, tx Transactor, audit AuditRepository) *DepartmentHandler {
+	return &DepartmentHandler{repo: repo, employees: employees, tx: tx, audit: audit}
+}
+
+// List returns every department
+//
+// @Summary List departments
+// @Tags departments
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} Department
+// @Failure 401 {object} ErrorResponse
+// @Router /department [get]
+func (h *DepartmentHandler) List(c *fiber.Ctx) error {
+	departments, err := h.repo.FindAll(c.UserContext())
+	if err != nil {
+		return err
+	}
+	return c.JSON(departments)
+}
+
+// Get returns a single department by id
+//
+// @Summary Get a department
+// @Tags departments
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Department id"
+// @Success 200 {object} Department
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /department/{id} [get]
+func (h *DepartmentHandler) Get(c *fiber.Ctx) error {
+	departmentID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	department, err := h.repo.FindByID(c.UserContext(), departmentID)
+	if err != nil {
+		return repositoryError(err, "department")
+	}
+	return c.Status(200).JSON(department)
+}
+
+// Create adds a new department
+//
+// @Summary Create a department
+// @Tags departments
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param department body Department true "The new department"
+// @Success 201 {object} Department
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /department [post]
+func (h *DepartmentHandler) Create(c *fiber.Ctx) error {
+	department := new(Department)
+	if err := parseJSON(c, department); err != nil {
+		return err
+	}
+	if errs := department.validate(); len(errs) > 0 {
+		return newValidationError(errs)
+	}
+
+	createdDepartment, err := h.repo.Create(c.UserContext(), department)
+	if err != nil {
+		return repositoryError(err, "department")
+	}
+	recordAudit(c, h.audit, auditCreate, departmentsCollection, createdDepartment.ID, nil, createdDepartment)
+	return c.Status(201).JSON(createdDepartment)
+}
+
+// Update replaces the details of an existing department
+//
+// @Summary Replace a department
+// @Tags departments
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Department id"
+// @Param department body Department true "The new details"
+// @Success 200 {object} Department
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /department/{id} [put]
+func (h *DepartmentHandler) Update(c *fiber.Ctx) error {
+	departmentID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	department := new(Department)
+	if err := parseJSON(c, department); err != nil {
+		return err
+	}
+	if errs := department.validate(); len(errs) > 0 {
+		return newValidationError(errs)
+	}
+
+	before, _ := h.repo.FindByID(c.UserContext(), departmentID)
+	updatedDepartment, err := h.repo.Update(c.UserContext(), departmentID, department)
+	if err != nil {
+		return repositoryError(err, "department")
+	}
+	recordAudit(c, h.audit, auditUpdate, departmentsCollection, departmentID.Hex(), before, updatedDepartment)
+	return c.Status(200).JSON(updatedDepartment)
+}
+
+// Delete removes a department, as long as none of the employees belong to it
+// any more. With ?reassignTo=<id> its employees are moved to that department
+// first, in the same transaction as the delete
+//
+// @Summary Delete a department
+// @Tags departments
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Department id"
+// @Param reassignTo query string false "Department to move the employees to"
+// @Success 200 {string} string
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /department/{id} [delete]
+func (h *DepartmentHandler) Delete(c *fiber.Ctx) error {
+	departmentID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	if c.Query("reassignTo") != "" {
+		return h.reassignAndDelete(c, departmentID)
+	}
+
+	// deleting a department with employees would leave them pointing at nothing
+	inUse, err := h.employees.Count(c.UserContext(), bson.D{{Key: "departmentId", Value: departmentID}, notDeleted})
+	if err != nil {
+		return err
+	}
+	if inUse > 0 {
+		return repositoryError(ErrDepartmentInUse, "department")
+	}
+
+	before, _ := h.repo.FindByID(c.UserContext(), departmentID)
+	if err := h.repo.Delete(c.UserContext(), departmentID); err != nil {
+		return repositoryError(err, "department")
+	}
+	recordAudit(c, h.audit, auditDelete, departmentsCollection, departmentID.Hex(), before, nil)
+	return c.Status(200).JSON("record deleted...")
+}
+
+// reassignAndDelete moves the employees of the department to the one in
+// ?reassignTo, then deletes it. Either both happen or neither does
+func (h *DepartmentHandler) reassignAndDelete(c *fiber.Ctx, departmentID primitive.ObjectID) error {
+	targetID, err := primitive.ObjectIDFromHex(c.Query("reassignTo"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "reassignTo must be a 24 character hex string")
+	}
+	if targetID == departmentID {
+		return fiber.NewError(fiber.StatusBadRequest, "employees can't be reassigned to the department being deleted")
+	}
+	if _, err := h.repo.FindByID(c.UserContext(), targetID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return newValidationError(map[string]string{"reassignTo": "department does not exist"})
+		}
+		return err
+	}
+
+	before, _ := h.repo.FindByID(c.UserContext(), departmentID)
+	err = h.tx.WithTransaction(c.UserContext(), func(ctx context.Context) error {
+		if _, err := h.employees.ReassignDepartment(ctx, departmentID, &targetID); err != nil {
+			return err
+		}
+		return h.repo.Delete(ctx, departmentID)
+	})
+	if err != nil {
+		return repositoryError(err, "department")
+	}
+	recordAudit(c, h.audit, auditDelete, departmentsCollection, departmentID.Hex(), before, nil)
+	return c.Status(200).JSON("record deleted...")
+}
+
+// Employees returns a page of the employees in the department
+//
+// @Summary List the employees in a department
+// @Tags departments
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Department id"
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Employees per page, at most 100"
+// @Success 200 {object} EmployeeList
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /department/{id}/employees [get]
+func (h *DepartmentHandler) Employees(c *fiber.Ctx) error {
+	departmentID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	// make sure the department exists, so an unknown id is a 404 rather than an empty list
+	if _, err := h.repo.FindByID(c.UserContext(), departmentID); err != nil {
+		return repositoryError(err, "department")
+	}
+
+	query := bson.D{{Key: "departmentId", Value: departmentID}, notDeleted}
+	page, limit := parsePagination(c)
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	total, err := h.employees.Count(c.UserContext(), query)
+	if err != nil {
+		return err
+	}
+	employees, err := h.employees.FindAll(c.UserContext(), query, findOptions)
+	if err != nil {
+		return err
+	}
+
+	next, prev := pageLinks(c, page, limit, total)
+	return c.JSON(EmployeeList{
+		Data:  employees,
+		Page:  page,
+		Limit: limit,
+		Total: total,
+		Next:  next,
+		Prev:  prev,
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeDepartmentRepository is an in-memory DepartmentRepository. When err is
+// set every method fails with it
+type fakeDepartmentRepository struct {
+	departments map[primitive.ObjectID]Department
+	err         error
+}
+
+func newFakeDepartmentRepository(departments ...Department) *fakeDepartmentRepository {
+	repo := &fakeDepartmentRepository{departments: make(map[primitive.ObjectID]Department)}
+	for _, d := range departments {
+		id, _ := primitive.ObjectIDFromHex(d.ID)
+		repo.departments[id] = d
+	}
+	return repo
+}
+
+func (r *fakeDepartmentRepository) FindAll(ctx context.Context) ([]Department, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	departments := make([]Department, 0, len(r.departments))
+	for _, d := range r.departments {
+		departments = append(departments, d)
+	}
+	return departments, nil
+}
+
+func (r *fakeDepartmentRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Department, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	d, ok := r.departments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &d, nil
+}
+
+func (r *fakeDepartmentRepository) Create(ctx context.Context, department *Department) (*Department, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for _, d := range r.departments {
+		if d.Name == department.Name {
+			return nil, ErrDuplicateDepartment
+		}
+	}
+	id := primitive.NewObjectID()
+	department.ID = id.Hex()
+	r.departments[id] = *department
+	return department, nil
+}
+
+func (r *fakeDepartmentRepository) Update(ctx context.Context, id primitive.ObjectID, department *Department) (*Department, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if _, ok := r.departments[id]; !ok {
+		return nil, ErrNotFound
+	}
+	department.ID = id.Hex()
+	r.departments[id] = *department
+	return department, nil
+}
+
+func (r *fakeDepartmentRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if r.err != nil {
+		return r.err
+	}
+	if _, ok := r.departments[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.departments, id)
+	return nil
+}
+
+func TestDepartmentHandlers(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "list", role: roleViewer, method: "GET", path: "/department", wantStatus: 200, wantBody: `"name":"Engineering"`},
+		{name: "get", role: roleViewer, method: "GET", path: "/department/" + engineeringID.Hex(), wantStatus: 200, wantBody: `"name":"Engineering"`},
+		{name: "get not found", method: "GET", path: "/department/" + missingID, wantStatus: 404, wantBody: "department not found"},
+		{name: "create", method: "POST", path: "/department", body: `{"name":"Marketing"}`, wantStatus: 201, wantBody: `"name":"Marketing"`},
+		{name: "create validation failure", method: "POST", path: "/department", body: `{"name":" "}`, wantStatus: 422, wantBody: `"field":"name","message":"name is required"`},
+		{name: "create duplicate", method: "POST", path: "/department", body: `{"name":"Engineering"}`, wantStatus: 409},
+		{name: "create viewer forbidden", role: roleViewer, method: "POST", path: "/department", body: `{"name":"Marketing"}`, wantStatus: 403},
+		{name: "update", method: "PUT", path: "/department/" + engineeringID.Hex(), body: `{"name":"Platform"}`, wantStatus: 200, wantBody: `"name":"Platform"`},
+		{name: "update not found", method: "PUT", path: "/department/" + missingID, body: `{"name":"Platform"}`, wantStatus: 404},
+		{name: "delete", method: "DELETE", path: "/department/" + financeID.Hex(), wantStatus: 200},
+		{name: "delete with employees", method: "DELETE", path: "/department/" + engineeringID.Hex(), wantStatus: 409, wantBody: ErrDepartmentInUse.Error()},
+		{name: "delete not found", method: "DELETE", path: "/department/" + missingID, wantStatus: 404},
+		{name: "delete reassigning employees", method: "DELETE", path: "/department/" + engineeringID.Hex() + "?reassignTo=" + financeID.Hex(), wantStatus: 200},
+		{name: "delete reassigning to itself", method: "DELETE", path: "/department/" + engineeringID.Hex() + "?reassignTo=" + engineeringID.Hex(), wantStatus: 400},
+		{name: "delete reassigning to unknown department", method: "DELETE", path: "/department/" + engineeringID.Hex() + "?reassignTo=" + missingID, wantStatus: 422, wantBody: `"field":"reassignTo","message":"department does not exist"`},
+		{name: "delete reassigning malformed id", method: "DELETE", path: "/department/" + engineeringID.Hex() + "?reassignTo=finance", wantStatus: 400},
+		{name: "employees", role: roleViewer, method: "GET", path: "/department/" + engineeringID.Hex() + "/employees", wantStatus: 200, wantBody: `"name":"John Doe"`},
+		{name: "employees of empty department", method: "GET", path: "/department/" + financeID.Hex() + "/employees", wantStatus: 200, wantBody: `"data":[],`},
+		{name: "employees not found", method: "GET", path: "/department/" + missingID + "/employees", wantStatus: 404},
+		{name: "database error", repoErr: errDatabase, method: "GET", path: "/department", wantStatus: 500, wantBody: "internal server error"},
+	})
+}
+
+func TestDeleteDepartmentReassignsEmployees(t *testing.T) {
+	repo := newFakeRepository(john)
+	departmentRepo := newFakeDepartmentRepository(engineering, finance)
+	app := newTestApp(repo, departmentRepo)
+
+	status, body := request(t, app, roleAdmin, "DELETE", "/department/"+engineeringID.Hex()+"?reassignTo="+financeID.Hex(), "")
+	if status != 200 {
+		t.Fatalf("status = %d, want 200 (body %q)", status, body)
+	}
+	if _, ok := departmentRepo.departments[engineeringID]; ok {
+		t.Error("department was not deleted")
+	}
+	if got := repo.employees[johnID].DepartmentID; got == nil || *got != financeID {
+		t.Errorf("employee department = %v, want %v", got, financeID)
+	}
+}
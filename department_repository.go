@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DepartmentRepository is everything the handlers need from the department store
+type DepartmentRepository interface {
+	FindAll(ctx context.Context) ([]Department, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*Department, error)
+	Create(ctx context.Context, department *Department) (*Department, error)
+	Update(ctx context.Context, id primitive.ObjectID, department *Department) (*Department, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// MongoDepartmentRepository is the DepartmentRepository backed by a mongo collection
+type MongoDepartmentRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoDepartmentRepository creates a repository storing departments in the collection
+func NewMongoDepartmentRepository(collection *mongo.Collection) *MongoDepartmentRepository {
+	return &MongoDepartmentRepository{collection: collection}
+}
+
+// EnsureIndexes makes sure department names are unique. Like the employee
+// indexes this is a no-op when the index already exists
+func (r *MongoDepartmentRepository) EnsureIndexes(ctx context.Context) error {
+	return ensureIndexes(ctx, r.collection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}},
+			Options: options.Index().SetName("name_unique").SetUnique(true),
+		},
+	})
+}
+
+// FindAll returns every department sorted by name. There are few enough of
+// them that they are not paginated
+func (r *MongoDepartmentRepository) FindAll(ctx context.Context) ([]Department, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	departments := make([]Department, 0)
+	if err := cursor.All(ctx, &departments); err != nil {
+		return nil, err
+	}
+	return departments, nil
+}
+
+// FindByID returns the department with the id, or ErrNotFound
+func (r *MongoDepartmentRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Department, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	department := new(Department)
+	if err := r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(department); err != nil {
+		return nil, mapError(err, ErrDuplicateDepartment)
+	}
+	return department, nil
+}
+
+// Create inserts the department and returns the record as it was stored
+func (r *MongoDepartmentRepository) Create(ctx context.Context, department *Department) (*Department, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	now := time.Now().UTC()
+	department.ID = ""
+	department.CreatedAt = now
+	department.UpdatedAt = now
+
+	insertionResult, err := r.collection.InsertOne(ctx, department)
+	if err != nil {
+		return nil, mapError(err, ErrDuplicateDepartment)
+	}
+
+	createdDepartment := new(Department)
+	filter := bson.D{{Key: "_id", Value: insertionResult.InsertedID}}
+	if err := r.collection.FindOne(ctx, filter).Decode(createdDepartment); err != nil {
+		return nil, fmt.Errorf("reading back the created department: %w", err)
+	}
+	return createdDepartment, nil
+}
+
+// Update replaces the name and description of the department and returns the stored result
+func (r *MongoDepartmentRepository) Update(ctx context.Context, id primitive.ObjectID, department *Department) (*Department, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "name", Value: department.Name},
+			{Key: "description", Value: department.Description},
+			{Key: "updatedAt", Value: time.Now().UTC()},
+		}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	updatedDepartment := new(Department)
+	err = r.collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: id}}, update, opts).Decode(updatedDepartment)
+	if err != nil {
+		return nil, mapError(err, ErrDuplicateDepartment)
+	}
+	return updatedDepartment, nil
+}
+
+// Delete removes the department. Unlike employees there is no history to keep,
+// so this is a real delete. Callers must make sure no employees still point at it
+func (r *MongoDepartmentRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	result, err := r.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
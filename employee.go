@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// creating a struct instance for the employees of the company
+type Employee struct {
+	XMLName xml.Name `json:"-" bson:"-" xml:"employee"`
+	// ID is generated by mongo, and read and written as a hex string in JSON
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty" xml:"id" swaggertype:"string"`
+	Name   string             `json:"name" xml:"name"`
+	Email  string             `json:"email" xml:"email"`
+	Salary Money              `json:"salary" xml:"salary" swaggertype:"number"`
+	Age    float64            `json:"age" xml:"age"`
+	// Bonus is set by the recalculation job, POST /jobs/recalculate, and can't be written otherwise
+	Bonus *Money `json:"bonus,omitempty" bson:"bonus,omitempty" xml:"bonus,omitempty" swaggertype:"number"`
+	// Position is the job title, e.g "Software Engineer"
+	Position string `json:"position" xml:"position"`
+	// HireDate is when the employee started. Records from before it was kept
+	// have the zero time
+	HireDate time.Time `json:"hireDate" bson:"hireDate" xml:"hireDate"`
+	// DepartmentID is the department the employee belongs to, if any
+	DepartmentID *primitive.ObjectID `json:"departmentId,omitempty" bson:"departmentId,omitempty" xml:"departmentId,omitempty"`
+	// ManagerID is the employee this one reports to, if any
+	ManagerID *primitive.ObjectID `json:"managerId,omitempty" bson:"managerId,omitempty" xml:"managerId,omitempty"`
+	// Address is the postal address, if HR has one
+	Address   *Address  `json:"address,omitempty" bson:"address,omitempty" xml:"address,omitempty"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt" xml:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt" xml:"updatedAt"`
+	// DeletedAt is stored as null rather than left out until the employee is
+	// deleted, for the partial index keeping active emails unique
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt" xml:"deletedAt,omitempty"`
+}
+
+// the range of ages we accept for an employee
+const (
+	minEmployeeAge = 16
+	maxEmployeeAge = 120
+)
+
+// maxPositionLength is the longest job title we accept
+const maxPositionLength = 100
+
+// validate checks the employee fields and returns a map of field name to
+// the reason it failed. An empty map means the employee is valid
+func (e *Employee) validate() map[string]string {
+	errs := make(map[string]string)
+
+	if msg := checkName(e.Name); msg != "" {
+		errs["name"] = msg
+	}
+	if msg := checkEmail(e.Email); msg != "" {
+		errs["email"] = msg
+	}
+	if msg := checkSalary(e.Salary); msg != "" {
+		errs["salary"] = msg
+	}
+	if msg := checkAge(e.Age); msg != "" {
+		errs["age"] = msg
+	}
+	if msg := checkPosition(e.Position); msg != "" {
+		errs["position"] = msg
+	}
+	if msg := checkHireDate(e.HireDate); msg != "" {
+		errs["hireDate"] = msg
+	}
+	if e.Address != nil {
+		for field, msg := range e.Address.validate() {
+			errs[field] = msg
+		}
+	}
+	return errs
+}
+
+// the rules for each employee field, shared by full and partial updates. Each
+// returns the reason the value is invalid, or an empty string when it is fine
+func checkName(name string) string {
+	if strings.TrimSpace(name) == "" {
+		return "name is required"
+	}
+	return ""
+}
+
+func checkEmail(email string) string {
+	if strings.TrimSpace(email) == "" {
+		return "email is required"
+	}
+	// ParseAddress also accepts "Name <address>", we only want the bare address
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return "email must be a valid email address"
+	}
+	return ""
+}
+
+func checkSalary(salary Money) string {
+	if salary.Sign() < 0 {
+		return "salary must be greater than or equal to 0"
+	}
+	if salary.DecimalPlaces() > 2 {
+		return "salary can have at most 2 decimal places"
+	}
+	return ""
+}
+
+func checkAge(age float64) string {
+	if age < minEmployeeAge || age > maxEmployeeAge {
+		return fmt.Sprintf("age must be between %d and %d", minEmployeeAge, maxEmployeeAge)
+	}
+	return ""
+}
+
+func checkPosition(position string) string {
+	if len(position) > maxPositionLength {
+		return fmt.Sprintf("position can be at most %d characters", maxPositionLength)
+	}
+	return ""
+}
+
+// a hire date is optional, but nobody can have been hired in the future
+func checkHireDate(hireDate time.Time) string {
+	if hireDate.After(time.Now()) {
+		return "hireDate can't be in the future"
+	}
+	return ""
+}
+
+// EmployeePatch is the body of a partial update. The fields are pointers so we
+// can tell a field that was left out (nil) from one set to its zero value
+type EmployeePatch struct {
+	Name   *string  `json:"name"`
+	Email  *string  `json:"email"`
+	Salary *Money   `json:"salary" swaggertype:"number"`
+	Age    *float64 `json:"age"`
+	// a null or left out position or hireDate is left as it is
+	Position *string    `json:"position"`
+	HireDate *time.Time `json:"hireDate"`
+	// DepartmentID moves the employee to another department
+	DepartmentID *primitive.ObjectID `json:"departmentId"`
+	// ManagerID has the employee report to someone else
+	ManagerID *primitive.ObjectID `json:"managerId"`
+	// Address changes only the address fields sent, the others are kept
+	Address *AddressPatch `json:"address"`
+}
+
+// validate checks only the fields present in the patch, using the same rules as Employee
+func (p *EmployeePatch) validate() map[string]string {
+	errs := make(map[string]string)
+
+	if p.Name != nil {
+		if msg := checkName(*p.Name); msg != "" {
+			errs["name"] = msg
+		}
+	}
+	if p.Email != nil {
+		if msg := checkEmail(*p.Email); msg != "" {
+			errs["email"] = msg
+		}
+	}
+	if p.Salary != nil {
+		if msg := checkSalary(*p.Salary); msg != "" {
+			errs["salary"] = msg
+		}
+	}
+	if p.Age != nil {
+		if msg := checkAge(*p.Age); msg != "" {
+			errs["age"] = msg
+		}
+	}
+	if p.Position != nil {
+		if msg := checkPosition(*p.Position); msg != "" {
+			errs["position"] = msg
+		}
+	}
+	if p.HireDate != nil {
+		if msg := checkHireDate(*p.HireDate); msg != "" {
+			errs["hireDate"] = msg
+		}
+	}
+	if p.Address != nil {
+		for field, msg := range p.Address.validate() {
+			errs[field] = msg
+		}
+	}
+	return errs
+}
+
+// setFields builds the $set document for the patch, containing only the fields the client sent
+func (p *EmployeePatch) setFields() bson.D {
+	fields := bson.D{}
+
+	if p.Name != nil {
+		fields = append(fields, bson.E{Key: "name", Value: *p.Name})
+	}
+	if p.Email != nil {
+		fields = append(fields, bson.E{Key: "email", Value: *p.Email})
+	}
+	if p.Salary != nil {
+		fields = append(fields, bson.E{Key: "salary", Value: *p.Salary})
+	}
+	if p.Age != nil {
+		fields = append(fields, bson.E{Key: "age", Value: *p.Age})
+	}
+	if p.Position != nil {
+		fields = append(fields, bson.E{Key: "position", Value: *p.Position})
+	}
+	if p.HireDate != nil {
+		fields = append(fields, bson.E{Key: "hireDate", Value: *p.HireDate})
+	}
+	if p.DepartmentID != nil {
+		fields = append(fields, bson.E{Key: "departmentId", Value: *p.DepartmentID})
+	}
+	if p.ManagerID != nil {
+		fields = append(fields, bson.E{Key: "managerId", Value: *p.ManagerID})
+	}
+	if p.Address != nil {
+		fields = append(fields, p.Address.setFields()...)
+	}
+	return fields
+}
+
+// EmployeeList wraps a page of employees together with the pagination metadata.
+// Next and Prev link to the pages either side, and are null when there is no
+// such page. They are always null for a search, whose page is sent in the body
+type EmployeeList struct {
+	XMLName xml.Name   `json:"-" xml:"employees"`
+	Data    []Employee `json:"data" xml:"employee"`
+	Page    int64      `json:"page" xml:"page,attr"`
+	Limit   int64      `json:"limit" xml:"limit,attr"`
+	Total   int64      `json:"total" xml:"total,attr"`
+	Next    *string    `json:"next" xml:"next,attr,omitempty"`
+	Prev    *string    `json:"prev" xml:"prev,attr,omitempty"`
+	// NextCursor is the ?after for the next page, when the list has one
+	NextCursor *string `json:"nextCursor,omitempty" xml:"nextCursor,attr,omitempty"`
+}
+
+// DistinctValues is the response of GET /employee/distinct/{field}, the
+// different values the field has, sorted
+type DistinctValues struct {
+	Field  string   `json:"field"`
+	Values []string `json:"values"`
+}
+
+// EmployeeCursorList is a page of employees read with an ?after cursor. There
+// is no page number or total, following the cursors is the way through
+type EmployeeCursorList struct {
+	XMLName xml.Name   `json:"-" xml:"employees"`
+	Data    []Employee `json:"data" xml:"employee"`
+	Limit   int64      `json:"limit" xml:"limit,attr"`
+	// Next is the link to the page after this one, and NextCursor its ?after.
+	// Both are null on the last page
+	Next       *string `json:"next" xml:"next,attr,omitempty"`
+	NextCursor *string `json:"nextCursor" xml:"nextCursor,attr,omitempty"`
+}
+
+// BatchDeleteResult is the response of a batch delete. Invalid lists the ids
+// that aren't valid ObjectIDs, NotFound the ones that matched no employee or
+// one that was already deleted
+type BatchDeleteResult struct {
+	Deleted  int      `json:"deleted"`
+	Invalid  []string `json:"invalid"`
+	NotFound []string `json:"notFound"`
+	// how many leave requests, attendance records and photos were deleted along with the employees
+	LeaveRequests int64 `json:"leaveRequests"`
+	Attendance    int64 `json:"attendance"`
+	Photos        int64 `json:"photos"`
+
+	// DryRun is set when nothing was deleted, ?dryRun=true only listed the
+	// Employees that would have been
+	DryRun    bool       `json:"dryRun,omitempty"`
+	Employees []Employee `json:"employees,omitempty"`
+}
+
+// DeleteResult is the response of deleting an employee, with how many of their
+// leave requests, attendance records and photo files were deleted along with them
+type DeleteResult struct {
+	EmployeeID    string `json:"employeeId"`
+	LeaveRequests int64  `json:"leaveRequests"`
+	Attendance    int64  `json:"attendance"`
+	Photos        int64  `json:"photos"`
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// APIError is an error with the HTTP status it should be answered with. Every
+// error response has the same shape:
+//
+//	{"error": {"code": 404, "message": "employee not found"}}
+//
+// Details carries extra information when there is some, like the list of
+// FieldErrors of a validation error
+type APIError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// FieldError is one field that failed validation, in the details of a 422
+//
+//	{"field": "salary", "message": "salary can't be negative", "value": -1}
+//
+// Field is a dotted path for a field in a nested object, like "set.position".
+// Value is what was sent for the field, left out when it wasn't sent or isn't
+// a plain value, see submittedValue
+type FieldError struct {
+	Field   string      `json:"field"`
+	Message string      `json:"message"`
+	Value   interface{} `json:"value,omitempty"`
+}
+
+// maxFieldErrorValue is how many characters of a submitted string a
+// FieldError repeats, the rest is cut off
+const maxFieldErrorValue = 100
+
+// secretFields are the words in a field name that keep its value out of a
+// FieldError
+var secretFields = []string{"password", "secret", "token"}
+
+// newValidationError is the 422 answered when the request fails validation,
+// errs being the message for each failing field. The fields are listed in
+// order, and the error handler fills in the values that were sent
+func newValidationError(errs map[string]string) error {
+	fields := make([]FieldError, 0, len(errs))
+	for field, message := range errs {
+		fields = append(fields, FieldError{Field: field, Message: message})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return &APIError{Code: fiber.StatusUnprocessableEntity, Message: "validation failed", Details: fields}
+}
+
+// nestFieldErrors moves the failing fields of a validation error under parent,
+// for fields checked on their own that the request sent inside an object.
+// Other errors are returned as they are
+func nestFieldErrors(err error, parent string) error {
+	apiErr := new(APIError)
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	if fields, ok := apiErr.Details.([]FieldError); ok {
+		for i := range fields {
+			fields[i].Field = parent + "." + fields[i].Field
+		}
+	}
+	return err
+}
+
+// addSubmittedValues sets the value of each failing field to what the request
+// sent for it, from the JSON body or else the query string
+func addSubmittedValues(c *fiber.Ctx, fields []FieldError) {
+	var body interface{}
+	if c.Is("json") {
+		decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+		// numbers are repeated as they were sent, not as a float64 would print them
+		decoder.UseNumber()
+		if decoder.Decode(&body) != nil {
+			body = nil
+		}
+	}
+	for i := range fields {
+		value, ok := lookupPath(body, fields[i].Field)
+		if !ok {
+			if query := c.Query(fields[i].Field); query != "" {
+				value, ok = query, true
+			}
+		}
+		if ok {
+			fields[i].Value = submittedValue(fields[i].Field, value)
+		}
+	}
+}
+
+// lookupPath finds the value at the dotted path in a decoded JSON body
+func lookupPath(body interface{}, path string) (interface{}, bool) {
+	value := body
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// submittedValue is the value to repeat back for the field. Secrets are never
+// repeated, long strings are cut short, and objects and arrays are left out,
+// the field path points inside them when that is where the problem is
+func submittedValue(field string, value interface{}) interface{} {
+	name := strings.ToLower(field)
+	for _, secret := range secretFields {
+		if strings.Contains(name, secret) {
+			return nil
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		if runes := []rune(v); len(runes) > maxFieldErrorValue {
+			return string(runes[:maxFieldErrorValue]) + "..."
+		}
+		return v
+	case json.Number, bool:
+		return v
+	}
+	return nil
+}
+
+// ErrorDebug is what a 500 or 504 says about the error that caused it, in
+// development only
+type ErrorDebug struct {
+	Error string `json:"error"`
+	// Stack is where the handler panicked, when it did
+	Stack string `json:"stack,omitempty"`
+}
+
+// panicStackKey is the key the stack of a recovered panic is stored under in
+// the fiber locals, for the error handler to show in development
+const panicStackKey = "panicstack"
+
+// newErrorHandler writes every error returned by a handler or middleware in the
+// standard envelope. APIErrors and fiber errors keep their status and message,
+// a call that found the database too busy is a 503 with a Retry-After,
+// database calls that ran past their deadline are a 504, and anything else is
+// an unexpected failure (usually the database) and becomes a 500 with a generic
+// message, so internal details don't leak to clients. In development the 500s
+// and 504s carry the error, and the stack of a panic, in their details
+func newErrorHandler(cfg Config) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		apiErr := new(APIError)
+		var fiberErr *fiber.Error
+
+		switch {
+		case errors.As(err, &apiErr):
+			if fields, ok := apiErr.Details.([]FieldError); ok {
+				addSubmittedValues(c, fields)
+			}
+		case errors.As(err, &fiberErr):
+			apiErr = &APIError{Code: fiberErr.Code, Message: fiberErr.Message}
+		case errors.Is(err, ErrDatabaseBusy):
+			c.Set(fiber.HeaderRetryAfter, "1")
+			apiErr = &APIError{Code: fiber.StatusServiceUnavailable, Message: err.Error()}
+		case mongo.IsTimeout(err):
+			requestLog(c).Error("database timed out", slog.Any("error", err))
+			apiErr = &APIError{Code: fiber.StatusGatewayTimeout, Message: "the database took too long to respond", Details: debugDetails(cfg, c, err)}
+		default:
+			requestLog(c).Error("request failed", slog.Any("error", err))
+			apiErr = &APIError{Code: fiber.StatusInternalServerError, Message: "internal server error", Details: debugDetails(cfg, c, err)}
+		}
+
+		return c.Status(apiErr.Code).JSON(ErrorResponse{Error: apiErr})
+	}
+}
+
+// debugDetails are the details of an unexpected error, which are only shown in
+// development. The nil interface keeps them out of the response otherwise
+func debugDetails(cfg Config, c *fiber.Ctx, err error) interface{} {
+	if !cfg.Development() {
+		return nil
+	}
+	stack, _ := c.Locals(panicStackKey).(string)
+	return &ErrorDebug{Error: err.Error(), Stack: stack}
+}
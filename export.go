@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportSort is the order the exports are written in. It ends with _id so the
+// order is the same on every run, which is what lets ?offset resume one
+var exportSort = bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}
+
+// parseExportOffset reads ?offset, how many employees an export skips to carry
+// on from where an interrupted one stopped
+func parseExportOffset(c *fiber.Ctx) (int64, error) {
+	raw := c.Query("offset")
+	if raw == "" {
+		return 0, nil
+	}
+	offset, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "offset must be a whole number, at least 0")
+	}
+	return offset, nil
+}
+
+// setExportHeaders says the exports can't be fetched in byte ranges. They are
+// written as they are read from the database, so their length isn't known up
+// front, and employees changing between two requests would shift the bytes
+// under a range. A Range header is ignored and the whole export sent, resuming
+// is done with ?offset instead, which counts employees rather than bytes
+func setExportHeaders(c *fiber.Ctx) {
+	c.Set(fiber.HeaderAcceptRanges, "none")
+}
+
+// ExportJSON streams the employees as one JSON array, for consumers that want
+// the whole roster rather than pages of it. Like the CSV export it takes the
+// list filters, and each employee is written as it comes off the cursor, so
+// memory use stays flat however big the collection is. The response is sent
+// chunked since its length isn't known up front. If the export fails part way
+// the array is left unterminated, so the client can't mistake it for a
+// complete one. ?offset resumes an interrupted export, with an array of the
+// employees after the ones already received
+//
+// @Summary Export employees as a JSON array
+// @Tags employees
+// @Produce json
+// @Security BearerAuth
+// @Param search query string false "Only employees whose name contains this"
+// @Param offset query int false "Employees to skip, to resume an interrupted export"
+// @Success 200 {array} Employee
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /employee/export.json [get]
+func (h *EmployeeHandler) ExportJSON(c *fiber.Ctx) error {
+	query, err := buildEmployeeFilter(c)
+	if err != nil {
+		return err
+	}
+	offset, err := parseExportOffset(c)
+	if err != nil {
+		return err
+	}
+	findOptions := options.Find().SetSort(exportSort).SetSkip(offset)
+
+	streamCtx, release, err := exportContext(c)
+	if err != nil {
+		return err
+	}
+
+	setExportHeaders(c)
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+
+	// as with the CSV export, the stream writer runs once fiber starts sending
+	// the response, after the request context is gone and the status is sent
+	reqLog := requestLog(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer release()
+		ctx, cancel := context.WithTimeout(streamCtx, exportTimeout)
+		defer cancel()
+
+		encoder := json.NewEncoder(w)
+		separator := "["
+		err := h.repo.Stream(ctx, query, findOptions, func(e *Employee) error {
+			if _, err := w.WriteString(separator); err != nil {
+				return err
+			}
+			separator = ","
+			return encoder.Encode(e)
+		})
+		if err == nil && separator == "[" {
+			// no employees, the array still has to be opened
+			_, err = w.WriteString(separator)
+		}
+		if err == nil {
+			_, err = w.WriteString("]")
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			reqLog.Error("exporting employees", slog.Any("error", err))
+		}
+	})
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// the formats the employee endpoints can answer in
+const (
+	formatJSON = "json"
+	formatXML  = "xml"
+)
+
+// responseFormat picks the format to answer in from the Accept header. XML is
+// for the consumers that ask for application/xml or text/xml, everyone else,
+// including those sending no Accept header or one we can't serve, gets JSON
+func responseFormat(c *fiber.Ctx) string {
+	switch c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMEApplicationXML, fiber.MIMETextXML) {
+	case fiber.MIMEApplicationXML, fiber.MIMETextXML:
+		return formatXML
+	default:
+		return formatJSON
+	}
+}
+
+// respond sends v with the status in the format the client asked for. Vary
+// tells caches on the way that the answer depends on the Accept header
+func respond(c *fiber.Ctx, status int, v interface{}) error {
+	c.Vary(fiber.HeaderAccept)
+	if responseFormat(c) == formatXML {
+		return c.Status(status).XML(v)
+	}
+	return c.Status(status).JSON(v)
+}
+
+// onlyJSON refuses an XML request for a response that can only be JSON, like a
+// list trimmed with ?fields, whose employees are maps rather than Employees
+func onlyJSON(c *fiber.Ctx, what string) error {
+	if responseFormat(c) == formatXML {
+		return fiber.NewError(fiber.StatusNotAcceptable, what+" can only be sent as JSON")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestResponseFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		accept     string
+		path       string
+		wantStatus int
+		wantType   string
+		wantBody   string
+	}{
+		{name: "no Accept", path: "/employee/" + johnID.Hex(), wantStatus: 200, wantType: "application/json", wantBody: `"name":"John Doe"`},
+		{name: "json", accept: "application/json", path: "/employee/" + johnID.Hex(), wantStatus: 200, wantType: "application/json", wantBody: `"name":"John Doe"`},
+		{name: "anything", accept: "*/*", path: "/employee/" + johnID.Hex(), wantStatus: 200, wantType: "application/json", wantBody: `"name":"John Doe"`},
+		{name: "unknown", accept: "text/csv", path: "/employee/" + johnID.Hex(), wantStatus: 200, wantType: "application/json", wantBody: `"name":"John Doe"`},
+		{name: "xml", accept: "application/xml", path: "/employee/" + johnID.Hex(), wantStatus: 200, wantType: "application/xml", wantBody: `<employee><id>` + johnID.Hex() + `</id><name>John Doe</name>`},
+		{name: "text/xml", accept: "text/xml", path: "/employee/" + johnID.Hex(), wantStatus: 200, wantType: "application/xml", wantBody: `<salary>50000</salary>`},
+		{name: "xml list", accept: "application/xml", path: "/employee", wantStatus: 200, wantType: "application/xml", wantBody: `<employees page="1" limit="20" total="1"><employee>`},
+		{name: "xml list with fields", accept: "application/xml", path: "/employee?fields=id,name", wantStatus: 406, wantBody: "can only be sent as JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+			req := newRequest(t, roleViewer, "GET", tt.path, "")
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, body := send(t, app, req)
+			if resp.StatusCode != tt.wantStatus || !strings.Contains(body, tt.wantBody) {
+				t.Fatalf("status = %d, body %q, want %d and %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+			if tt.wantType != "" && !strings.HasPrefix(resp.Header.Get("Content-Type"), tt.wantType) {
+				t.Errorf("Content-Type = %q, want %s", resp.Header.Get("Content-Type"), tt.wantType)
+			}
+		})
+	}
+}
+
+func TestCreateAsXML(t *testing.T) {
+	app := newTestApp(newFakeRepository(), newFakeDepartmentRepository())
+	req := newRequest(t, roleAdmin, "POST", "/employee", `{"name":"Jane Doe","email":"jane@example.com","salary":60000.5,"age":28}`)
+	req.Header.Set("Accept", "application/xml")
+	resp, body := send(t, app, req)
+	if resp.StatusCode != 201 {
+		t.Fatalf("status = %d, body %q, want 201", resp.StatusCode, body)
+	}
+
+	var created struct {
+		Name   string `xml:"name"`
+		Salary string `xml:"salary"`
+	}
+	if err := xml.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+	if created.Name != "Jane Doe" || created.Salary != "60000.5" {
+		t.Errorf("created = %+v, want Jane Doe earning 60000.5", created)
+	}
+}
@@ -0,0 +1,905 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EmployeeHandler holds the HTTP handlers for the employee routes. It only
+// talks to the database through the repository, so tests can swap in a fake
+type EmployeeHandler struct {
+	repo        EmployeeRepository
+	departments DepartmentRepository
+	audit       AuditRepository
+	history     HistoryRepository
+	events      EventPublisher
+	tx          Transactor
+	leave       LeaveRepository
+	attendance  AttendanceRepository
+	photos      PhotoRepository
+}
+
+// NewEmployeeHandler creates the employee handlers on top of the repositories.
+// The departments are used to check the department an employee is put in
+// exists, every change is recorded in the audit log and published to the
+// events, and each new state of an employee is kept in its history. Deleting
+// and restoring an employee takes their leave requests, attendance and photo
+// with them, in a transaction
+func NewEmployeeHandler(repos Repositories) *EmployeeHandler {
+	return &EmployeeHandler{
+		repo:        repos.Employees,
+		departments: repos.Departments,
+		audit:       repos.AuditLogs,
+		history:     repos.History,
+		events:      repos.Events,
+		tx:          repos.Transactor,
+		leave:       repos.LeaveRequests,
+		attendance:  repos.Attendance,
+		photos:      repos.Photos,
+	}
+}
+
+// List returns a page of employees, filtered and sorted by the query params.
+// Pages are picked with page and limit, or for infinite scrolling with the
+// nextCursor of the page before sent as ?after, which doesn't slow down the
+// further in it goes. A page read with ?after has no page number or total
+//
+// @Summary List employees
+// @Tags employees
+// @Produce json
+// @Produce xml
+// @Security BearerAuth
+// @Param search query string false "Only employees whose name contains this"
+// @Param minSalary query number false "Lowest salary"
+// @Param maxSalary query number false "Highest salary"
+// @Param minAge query number false "Lowest age"
+// @Param maxAge query number false "Highest age"
+// @Param includeDeleted query bool false "Include soft deleted employees, admins only"
+// @Param sortBy query string false "Field to sort by" Enums(name, salary, age)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Param page query int false "Page number, from 1"
+// @Param limit query int false "Employees per page, at most 100"
+// @Param after query string false "The nextCursor of the page before, instead of page"
+// @Param fields query string false "Comma separated fields to return, like id,name. Defaults to all of them"
+// @Param noCache query bool false "Skip the response cache"
+// @Param If-None-Match header string false "The ETag of a list already fetched, answered with 304 while it is unchanged"
+// @Success 200 {object} EmployeeList
+// @Header 200 {string} ETag "Version of the list, for If-None-Match"
+// @Success 304 "The list hasn't changed since the ETag in If-None-Match"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /employee [get]
+func (h *EmployeeHandler) List(c *fiber.Ctx) error {
+	// build the query from the filters in the url, e.g ?minSalary=50000&maxSalary=80000
+	query, err := buildEmployeeFilter(c)
+	if err != nil {
+		return err
+	}
+
+	// sort the employees server side, e.g ?sortBy=salary&order=desc
+	sort, err := parseSort(c)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	// work out which page of employees the client wants, and skip the ones before it
+	page, limit := parsePagination(c)
+	findOptions := options.Find().SetSort(sort).SetSkip((page - 1) * limit).SetLimit(limit)
+
+	// only fetch the fields the client needs, e.g ?fields=id,name for a dropdown
+	projection, fields, err := parseFields(c)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if projection != nil {
+		if err := onlyJSON(c, "a list with ?fields"); err != nil {
+			return err
+		}
+		// the cursor of the page is made from the sort field and id of its last
+		// employee, so they are fetched whatever the fields
+		projection[0].Value = 1
+		if !containsField(fields, sort[0].Key) {
+			projection = append(projection, bson.E{Key: sort[0].Key, Value: 1})
+		}
+		findOptions.SetProjection(projection)
+	}
+
+	// ?after carries on from the page before without counting or skipping
+	// anything, it is only as slow as the page itself
+	if after := c.Query("after"); after != "" {
+		return h.listAfter(c, query, after, limit, findOptions.SetSkip(0), fields)
+	}
+
+	// count all the matching employees so the client knows how many pages there are
+	total, err := h.repo.Count(c.UserContext(), query)
+	if err != nil {
+		return err
+	}
+
+	employees, err := h.repo.FindAll(c.UserContext(), query, findOptions)
+	if err != nil {
+		return err
+	}
+	// the client can switch to ?after from any page
+	var nextCursor *string
+	if page*limit < total && len(employees) > 0 {
+		cursor, err := encodeCursor(&employees[len(employees)-1], c.Query("sortBy"), c.Query("order"))
+		if err != nil {
+			return err
+		}
+		nextCursor = &cursor
+	}
+	if fields != nil {
+		data, err := pickFields(employees, fields)
+		if err != nil {
+			return err
+		}
+		next, prev := pageLinks(c, page, limit, total)
+		return c.JSON(fiber.Map{"data": data, "page": page, "limit": limit, "total": total, "next": next, "prev": prev, "nextCursor": nextCursor})
+	}
+
+	// if all goes well, return employees. No need to marshal the json file because
+	// fiber c client take care of it underhood
+	next, prev := pageLinks(c, page, limit, total)
+	return respond(c, fiber.StatusOK, EmployeeList{
+		Data:       employees,
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		Next:       next,
+		Prev:       prev,
+		NextCursor: nextCursor,
+	})
+}
+
+// listAfter answers a list page read with an ?after cursor. One more employee
+// than the limit is fetched to tell whether there is a page after this one
+func (h *EmployeeHandler) listAfter(c *fiber.Ctx, query bson.D, after string, limit int64, findOptions *options.FindOptions, fields []string) error {
+	condition, err := cursorFilter(after, c.Query("sortBy"), c.Query("order"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	employees, err := h.repo.FindAll(c.UserContext(), append(query, condition), findOptions.SetLimit(limit+1))
+	if err != nil {
+		return err
+	}
+
+	var nextCursor, next *string
+	if int64(len(employees)) > limit {
+		employees = employees[:limit]
+		cursor, err := encodeCursor(&employees[limit-1], c.Query("sortBy"), c.Query("order"))
+		if err != nil {
+			return err
+		}
+		nextCursor, next = &cursor, cursorLink(c, cursor, limit)
+	}
+	if fields != nil {
+		data, err := pickFields(employees, fields)
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"data": data, "limit": limit, "next": next, "nextCursor": nextCursor})
+	}
+	return respond(c, fiber.StatusOK, EmployeeCursorList{Data: employees, Limit: limit, Next: next, NextCursor: nextCursor})
+}
+
+// Count returns how many employees match the same filters the list takes,
+// without fetching any of them
+//
+// @Summary Count employees
+// @Tags employees
+// @Produce json
+// @Security BearerAuth
+// @Param search query string false "Only employees whose name contains this"
+// @Param minSalary query number false "Lowest salary"
+// @Param maxSalary query number false "Highest salary"
+// @Param minAge query number false "Lowest age"
+// @Param maxAge query number false "Highest age"
+// @Param includeDeleted query bool false "Include soft deleted employees, admins only"
+// @Success 200 {object} map[string]int64 "count"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /employee/count [get]
+func (h *EmployeeHandler) Count(c *fiber.Ctx) error {
+	query, err := buildEmployeeFilter(c)
+	if err != nil {
+		return err
+	}
+
+	count, err := h.repo.Count(c.UserContext(), query)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"count": count})
+}
+
+// Distinct returns the different values a field has among the employees
+// matching the same filters the list takes, sorted, so a filter dropdown can
+// be filled without fetching every employee. Ids are returned as hex strings,
+// and employees without the field don't add an empty value
+//
+// @Summary List the distinct values of a field
+// @Tags employees
+// @Produce json
+// @Security BearerAuth
+// @Param field path string true "position, departmentId, managerId, address.city, address.state or address.country"
+// @Param search query string false "Only employees whose name contains this"
+// @Param minSalary query number false "Lowest salary"
+// @Param maxSalary query number false "Highest salary"
+// @Param minAge query number false "Lowest age"
+// @Param maxAge query number false "Highest age"
+// @Param includeDeleted query bool false "Include soft deleted employees, admins only"
+// @Success 200 {object} DistinctValues
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /employee/distinct/{field} [get]
+func (h *EmployeeHandler) Distinct(c *fiber.Ctx) error {
+	field := c.Params("field")
+	if !distinctFields[field] {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("cannot list the values of %q, must be one of position, departmentId, managerId, address.city, address.state or address.country", field))
+	}
+	query, err := buildEmployeeFilter(c)
+	if err != nil {
+		return err
+	}
+
+	raw, err := h.repo.Distinct(c.UserContext(), field, query)
+	if err != nil {
+		return err
+	}
+	values := make([]string, 0, len(raw))
+	for _, v := range raw {
+		switch v := v.(type) {
+		case string:
+			if v != "" {
+				values = append(values, v)
+			}
+		case primitive.ObjectID:
+			values = append(values, v.Hex())
+		}
+	}
+	sort.Strings(values)
+	return c.JSON(DistinctValues{Field: field, Values: values})
+}
+
+// Get returns a single employee by id
+//
+// @Summary Get an employee
+// @Tags employees
+// @Produce json
+// @Produce xml
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Success 200 {object} Employee
+// @Header 200 {string} ETag "Version of the employee, for If-Match"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /employee/{id} [get]
+func (h *EmployeeHandler) Get(c *fiber.Ctx) error {
+	// capturing the id of the employee and making sure it is a valid mongo id
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	employee, err := h.repo.FindByID(c.UserContext(), employeeID)
+	if err != nil {
+		return repositoryError(err, "employee")
+	}
+	c.Set(fiber.HeaderETag, employeeETag(employee))
+	return respond(c, fiber.StatusOK, employee)
+}
+
+// Create adds a new employee
+//
+// @Summary Create an employee
+// @Tags employees
+// @Accept json
+// @Produce json
+// @Produce xml
+// @Security BearerAuth
+// @Param employee body Employee true "The new employee"
+// @Param Idempotency-Key header string false "Retrying with the same key returns the first response instead of creating another employee"
+// @Success 201 {object} Employee
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /employee [post]
+func (h *EmployeeHandler) Create(c *fiber.Ctx) error {
+	// creating a new employee variable
+	employee := new(Employee)
+	// this APi reads the incoming request from user(employee details being
+	// added to the db). The Body Parser elps to also format the details into the struct template
+	if err := parseJSON(c, employee); err != nil {
+		return err
+	}
+
+	// make sure the employee details are sane before touching the database
+	if errs := employee.validate(); len(errs) > 0 {
+		return newValidationError(errs)
+	}
+	if err := h.checkDepartment(c, employee.DepartmentID); err != nil {
+		return err
+	}
+	if err := h.checkManager(c, employee.ManagerID); err != nil {
+		return err
+	}
+
+	createdEmployee, err := h.repo.Create(c.UserContext(), employee)
+	if err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	employeeChanges.WithLabelValues(actionCreated).Inc()
+	recordAudit(c, h.audit, auditCreate, employeesCollection, createdEmployee.ID.Hex(), nil, createdEmployee)
+	h.events.Publish(eventEmployeeCreated, createdEmployee)
+	recordRevision(c, h.history, createdEmployee)
+	// serve the created record in JSON format to the front end
+	return respond(c, fiber.StatusCreated, createdEmployee)
+}
+
+// Update replaces the details of an existing employee. The request must send
+// the ETag of the version it is replacing in If-Match, or "*" to replace
+// whatever is there, so two admins can't overwrite each other's changes
+//
+// @Summary Replace an employee
+// @Tags employees
+// @Accept json
+// @Produce json
+// @Produce xml
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Param employee body Employee true "The new details"
+// @Success 200 {object} Employee
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 412 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Failure 428 {object} ErrorResponse
+// @Router /employee/{id} [put]
+func (h *EmployeeHandler) Update(c *fiber.Ctx) error {
+	// capturing the id of the employee to be updated using c.Params
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	// get the data into the body parser using a variable Employee declaration
+	employee := new(Employee)
+	if err := parseJSON(c, employee); err != nil {
+		return err
+	}
+
+	// validate the new details before they replace the existing ones
+	if errs := employee.validate(); len(errs) > 0 {
+		return newValidationError(errs)
+	}
+	if err := h.checkDepartment(c, employee.DepartmentID); err != nil {
+		return err
+	}
+	if err := h.checkManager(c, employee.ManagerID, employeeID); err != nil {
+		return err
+	}
+
+	// the response is what is really stored after the update rather than an echo of the request
+	before := h.snapshot(c, employeeID)
+	updatedEmployee, err := h.repo.Update(c.UserContext(), employeeID, employee, version)
+	if err != nil {
+		return repositoryError(err, "employee")
+	}
+	employeeChanges.WithLabelValues(actionUpdated).Inc()
+	recordAudit(c, h.audit, auditUpdate, employeesCollection, employeeID.Hex(), before, updatedEmployee)
+	h.events.Publish(eventEmployeeUpdated, updatedEmployee)
+	recordRevision(c, h.history, updatedEmployee)
+	c.Set(fiber.HeaderETag, employeeETag(updatedEmployee))
+	return respond(c, fiber.StatusOK, updatedEmployee)
+}
+
+// Patch only updates the fields that are present in the request body
+//
+// @Summary Update some fields of an employee
+// @Tags employees
+// @Accept json
+// @Produce json
+// @Produce xml
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Param employee body EmployeePatch true "The fields to change"
+// @Success 200 {object} Employee
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /employee/{id} [patch]
+func (h *EmployeeHandler) Patch(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	patch := new(EmployeePatch)
+	if err := parseJSON(c, patch); err != nil {
+		return err
+	}
+	if errs := patch.validate(); len(errs) > 0 {
+		return newValidationError(errs)
+	}
+	if err := h.checkDepartment(c, patch.DepartmentID); err != nil {
+		return err
+	}
+	if err := h.checkManager(c, patch.ManagerID, employeeID); err != nil {
+		return err
+	}
+
+	fields := patch.setFields()
+	if len(fields) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "no fields to update")
+	}
+
+	before := h.snapshot(c, employeeID)
+	updatedEmployee, err := h.repo.Patch(c.UserContext(), employeeID, fields)
+	if err != nil {
+		return repositoryError(err, "employee")
+	}
+	employeeChanges.WithLabelValues(actionUpdated).Inc()
+	recordAudit(c, h.audit, auditUpdate, employeesCollection, employeeID.Hex(), before, updatedEmployee)
+	h.events.Publish(eventEmployeeUpdated, updatedEmployee)
+	recordRevision(c, h.history, updatedEmployee)
+	return respond(c, fiber.StatusOK, updatedEmployee)
+}
+
+// Delete soft deletes an employee, along with their leave requests and
+// attendance so they don't count towards the reports over those any more, and
+// their photo. It has to be confirmed with ?confirm=true
+//
+// @Summary Delete an employee
+// @Tags employees
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Param confirm query bool true "Must be true"
+// @Success 200 {object} DeleteResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /employee/{id} [delete]
+func (h *EmployeeHandler) Delete(c *fiber.Ctx) error {
+	// capturing the ID of the employer and handling errors
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	// if nothing matched, the employee was not found or is already deleted
+	before := h.snapshot(c, employeeID)
+	var result DeleteResult
+	err = h.tx.WithTransaction(c.UserContext(), func(ctx context.Context) error {
+		// the transaction can be retried, so the counts start over each time
+		result = DeleteResult{EmployeeID: employeeID.Hex()}
+		if err := h.repo.Delete(ctx, employeeID); err != nil {
+			return err
+		}
+		var err error
+		result.LeaveRequests, result.Attendance, result.Photos, err = h.deleteRelated(ctx, employeeID)
+		return err
+	})
+	if err != nil {
+		return repositoryError(err, "employee")
+	}
+	employeeChanges.WithLabelValues(actionDeleted).Inc()
+	recordAudit(c, h.audit, auditDelete, employeesCollection, employeeID.Hex(), before, nil)
+	h.events.Publish(eventEmployeeDeleted, before)
+	return c.JSON(result)
+}
+
+// deleteRelated soft deletes the leave requests, attendance and photos of the
+// employees, returning how many of each it deleted
+func (h *EmployeeHandler) deleteRelated(ctx context.Context, employeeIDs ...primitive.ObjectID) (leave, attendance, photos int64, err error) {
+	if leave, err = h.leave.SoftDeleteByEmployees(ctx, employeeIDs); err != nil {
+		return 0, 0, 0, err
+	}
+	if attendance, err = h.attendance.SoftDeleteByEmployees(ctx, employeeIDs); err != nil {
+		return 0, 0, 0, err
+	}
+	if photos, err = h.photos.SoftDeleteByEmployees(ctx, employeeIDs); err != nil {
+		return 0, 0, 0, err
+	}
+	return leave, attendance, photos, nil
+}
+
+// maxBatchDelete is the most employees one batch delete can take
+const maxBatchDelete = 500
+
+// BatchDelete soft deletes every employee in a JSON array of ids, in one
+// database update, and their leave requests, attendance and photos with them
+// in the same transaction. The ids that couldn't be deleted are listed in the
+// response rather than failing the whole batch. With ?dryRun=true nothing is
+// deleted, the response lists the employees that would be instead
+//
+// @Summary Delete several employees
+// @Tags employees
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param ids body []string true "Ids of the employees to delete"
+// @Param dryRun query bool false "Only list the employees that would be deleted"
+// @Success 200 {object} BatchDeleteResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Router /employee/batch-delete [post]
+func (h *EmployeeHandler) BatchDelete(c *fiber.Ctx) error {
+	var ids []string
+	if err := parseJSON(c, &ids); err != nil {
+		return err
+	}
+	if len(ids) == 0 || len(ids) > maxBatchDelete {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("send between 1 and %d ids", maxBatchDelete))
+	}
+
+	result := BatchDeleteResult{Invalid: []string{}, NotFound: []string{}}
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			result.Invalid = append(result.Invalid, id)
+			continue
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+
+	deleted := []Employee{}
+	if len(objectIDs) > 0 && isDryRun(c) {
+		query := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: objectIDs}}}, notDeleted}
+		matching, err := h.repo.FindAll(c.UserContext(), query, nil)
+		if err != nil {
+			return err
+		}
+		deleted = matching
+	} else if len(objectIDs) > 0 {
+		err := h.tx.WithTransaction(c.UserContext(), func(ctx context.Context) error {
+			var err error
+			if deleted, err = h.repo.DeleteMany(ctx, objectIDs); err != nil || len(deleted) == 0 {
+				return err
+			}
+			deletedIDs := make([]primitive.ObjectID, len(deleted))
+			for i := range deleted {
+				deletedIDs[i] = deleted[i].ID
+			}
+			result.LeaveRequests, result.Attendance, result.Photos, err = h.deleteRelated(ctx, deletedIDs...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// whatever was valid but not deleted wasn't there to delete
+	wasDeleted := make(map[primitive.ObjectID]bool, len(deleted))
+	for i := range deleted {
+		wasDeleted[deleted[i].ID] = true
+	}
+	for _, objectID := range objectIDs {
+		if !wasDeleted[objectID] {
+			result.NotFound = append(result.NotFound, objectID.Hex())
+		}
+	}
+	result.Deleted = len(deleted)
+	if isDryRun(c) {
+		result.DryRun = true
+		result.Employees = deleted
+		return c.JSON(result)
+	}
+
+	for i := range deleted {
+		recordAudit(c, h.audit, auditDelete, employeesCollection, deleted[i].ID.Hex(), &deleted[i], nil)
+		h.events.Publish(eventEmployeeDeleted, &deleted[i])
+	}
+	employeeChanges.WithLabelValues(actionDeleted).Add(float64(len(deleted)))
+	return c.Status(200).JSON(result)
+}
+
+// Restore brings back a soft deleted employee, with the leave requests,
+// attendance and photo that were deleted along with them
+//
+// @Summary Restore a deleted employee
+// @Tags employees
+// @Produce json
+// @Produce xml
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Success 200 {object} Employee
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /employee/{id}/restore [post]
+func (h *EmployeeHandler) Restore(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	var restoredEmployee *Employee
+	err = h.tx.WithTransaction(c.UserContext(), func(ctx context.Context) error {
+		var err error
+		if restoredEmployee, err = h.repo.Restore(ctx, employeeID); err != nil {
+			return err
+		}
+		if _, err := h.leave.RestoreByEmployee(ctx, employeeID); err != nil {
+			return err
+		}
+		if _, err := h.attendance.RestoreByEmployee(ctx, employeeID); err != nil {
+			return err
+		}
+		_, err = h.photos.RestoreByEmployee(ctx, employeeID)
+		return err
+	})
+	if err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	recordAudit(c, h.audit, auditRestore, employeesCollection, employeeID.Hex(), nil, restoredEmployee)
+	h.events.Publish(eventEmployeeRestored, restoredEmployee)
+	return respond(c, fiber.StatusOK, restoredEmployee)
+}
+
+// SalaryStats returns salary analytics grouped by department, optionally just
+// for one department with ?department=<id>
+//
+// @Summary Salary statistics per department
+// @Tags stats
+// @Produce json
+// @Security BearerAuth
+// @Param department query string false "Only this department id"
+// @Success 200 {array} SalaryStats
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /stats/salary [get]
+func (h *EmployeeHandler) SalaryStats(c *fiber.Ctx) error {
+	var departmentID *primitive.ObjectID
+	if raw := c.Query("department"); raw != "" {
+		id, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "department must be a 24 character hex string")
+		}
+		departmentID = &id
+	}
+
+	stats, err := h.repo.SalaryStats(c.UserContext(), departmentID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(stats)
+}
+
+// snapshot returns the employee as it is before a change, for the audit log.
+// It is nil when the employee can't be read, the change itself will fail with
+// the reason if it is missing
+func (h *EmployeeHandler) snapshot(c *fiber.Ctx, id primitive.ObjectID) *Employee {
+	employee, err := h.repo.FindByID(c.UserContext(), id)
+	if err != nil {
+		return nil
+	}
+	return employee
+}
+
+// checkDepartment makes sure the department an employee is being put in
+// exists, answering 422 when it doesn't. No department at all is fine
+func (h *EmployeeHandler) checkDepartment(c *fiber.Ctx, departmentID *primitive.ObjectID) error {
+	if departmentID == nil {
+		return nil
+	}
+
+	_, err := h.departments.FindByID(c.UserContext(), *departmentID)
+	if errors.Is(err, ErrNotFound) {
+		return newValidationError(map[string]string{"departmentId": "department does not exist"})
+	}
+	return err
+}
+
+// parseJSON decodes the JSON request body into out. BodyParser would just as
+// happily take a form or XML body and leave out whatever doesn't match, so
+// anything that isn't JSON is refused with a 415. A missing body is a 400
+// before anything else, it would otherwise decode into all zero values.
+// Fields out doesn't have are a 400 listing each of them, rather than being
+// dropped, so a typo like "salaray" isn't taken as leaving the salary out
+func parseJSON(c *fiber.Ctx, out interface{}) error {
+	if len(bytes.TrimSpace(c.Body())) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "the request body is empty, send the details as JSON")
+	}
+	if !c.Is("json") {
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "the body must be JSON, send it with Content-Type: application/json")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(out)
+	if name, ok := strings.CutPrefix(fmt.Sprint(err), "json: unknown field "); ok {
+		// the decoder stops at the first one, the rest are found by going through the body
+		var body interface{}
+		_ = json.Unmarshal(c.Body(), &body)
+		names := unknownFields(body, reflect.TypeOf(out), "")
+		if len(names) == 0 {
+			names = []string{strings.Trim(name, `"`)}
+		}
+		fields := make([]FieldError, len(names))
+		for i, name := range names {
+			fields[i] = FieldError{Field: name, Message: name + " is not a known field"}
+		}
+		return &APIError{Code: fiber.StatusBadRequest, Message: "the body has unknown fields", Details: fields}
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	// like json.Unmarshal, anything after the value is an error rather than ignored
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return fiber.NewError(fiber.StatusBadRequest, "the body must be a single JSON value, found more after it")
+	}
+	return nil
+}
+
+// jsonUnmarshaler is the type of the values that decode themselves, so the
+// decoder doesn't check their fields
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// unknownFields lists the dotted paths of the fields in the decoded JSON
+// value that the type t has no field for, sorted. Names are matched like
+// encoding/json does, without regard to case
+func unknownFields(value interface{}, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(jsonUnmarshaler) {
+		return nil
+	}
+
+	var unknown []string
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		fields := jsonFields(t)
+		for key, child := range value {
+			name := key
+			if path != "" {
+				name = path + "." + key
+			}
+			fieldType, ok := fields[strings.ToLower(key)]
+			if !ok {
+				unknown = append(unknown, name)
+				continue
+			}
+			unknown = append(unknown, unknownFields(child, fieldType, name)...)
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return nil
+		}
+		for i, child := range value {
+			unknown = append(unknown, unknownFields(child, t.Elem(), fmt.Sprintf("%s.%d", path, i))...)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// jsonFields maps the lower cased JSON names of the struct's fields to their
+// types, taking in the fields of embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embedded, fieldType := range jsonFields(field.Type) {
+				fields[embedded] = fieldType
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = field.Type
+	}
+	return fields
+}
+
+// isDryRun reports whether a bulk change was sent with ?dryRun=true, to see what
+// it would change without changing anything
+func isDryRun(c *fiber.Ctx) bool {
+	return c.Query("dryRun") == "true"
+}
+
+// parseID reads the :id route param as a mongo ObjectID, answering 400 when it isn't one
+func parseID(c *fiber.Ctx) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return primitive.NilObjectID, fiber.NewError(fiber.StatusBadRequest, "invalid id, must be a 24 character hex string")
+	}
+	return id, nil
+}
+
+// employeeETag is the version of the employee, taken from when it was last
+// updated. Mongo stores times to the millisecond, so that is all it uses
+func employeeETag(employee *Employee) string {
+	return `"` + strconv.FormatInt(employee.UpdatedAt.UnixMilli(), 10) + `"`
+}
+
+// ifMatchVersion reads the If-Match header as the updatedAt the client expects
+// the employee to still have. "*" matches any version and gives nil.
+// Updates without the header are refused with a 428 so they can't clobber a
+// change the client never saw, and a tag that isn't one of ours can never match
+func ifMatchVersion(c *fiber.Ctx) (*time.Time, error) {
+	ifMatch := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if ifMatch == "" {
+		return nil, fiber.NewError(fiber.StatusPreconditionRequired, "the If-Match header is required, send the ETag from GET /employee/:id")
+	}
+	if ifMatch == "*" {
+		return nil, nil
+	}
+	millis, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+	if err != nil {
+		return nil, repositoryError(ErrVersionMismatch, "employee")
+	}
+	version := time.UnixMilli(millis).UTC()
+	return &version, nil
+}
+
+// repositoryError turns the errors the repositories know about into responses,
+// resource names the kind of record for the not found message. Anything else is
+// passed through to the error handler as a 500
+func repositoryError(err error, resource string) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return fiber.NewError(fiber.StatusNotFound, resource+" not found")
+	case errors.Is(err, ErrVersionMismatch):
+		return fiber.NewError(fiber.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, ErrDuplicateEmail), errors.Is(err, ErrDuplicateDepartment), errors.Is(err, ErrDuplicateUsername), errors.Is(err, ErrDepartmentInUse),
+		errors.Is(err, ErrNotDeleted), errors.Is(err, ErrLeaveDecided),
+		errors.Is(err, ErrAlreadyCheckedIn), errors.Is(err, ErrNotCheckedIn):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	default:
+		return err
+	}
+}
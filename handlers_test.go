@@ -0,0 +1,1851 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math/big"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// fakeRepository is an in-memory EmployeeRepository. When err is set every
+// method fails with it, to exercise the database error paths. projection is
+// that of the last FindAll, and streamed the context of the last Stream
+type fakeRepository struct {
+	employees  map[primitive.ObjectID]Employee
+	err        error
+	projection interface{}
+	streamed   context.Context
+}
+
+func newFakeRepository(employees ...Employee) *fakeRepository {
+	repo := &fakeRepository{employees: make(map[primitive.ObjectID]Employee)}
+	for _, e := range employees {
+		repo.employees[e.ID] = e
+	}
+	return repo
+}
+
+// matches only understands the departmentId condition, on its own or as an
+// $eq inside an $and like a search builds, an _id $in list and leaving out the
+// deleted, every other part of the filter is ignored
+func (r *fakeRepository) matches(e Employee, filter bson.D) bool {
+	for _, condition := range filter {
+		switch condition.Key {
+		case "deletedAt":
+			if condition.Value == nil && e.DeletedAt != nil {
+				return false
+			}
+		case "_id":
+			if op, isOp := condition.Value.(bson.D); isOp && op[0].Key == "$in" {
+				if ids, ok := op[0].Value.([]primitive.ObjectID); ok && !isOneOf(ids, e.ID) {
+					return false
+				}
+			}
+		case "departmentId":
+			id, ok := condition.Value.(primitive.ObjectID)
+			if op, isOp := condition.Value.(bson.D); isOp && op[0].Key == "$eq" {
+				id, ok = op[0].Value.(primitive.ObjectID)
+			}
+			if ok && (e.DepartmentID == nil || *e.DepartmentID != id) {
+				return false
+			}
+		case "$and":
+			for _, sub := range condition.Value.(bson.A) {
+				if sub, ok := sub.(bson.D); ok && !r.matches(e, sub) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func (r *fakeRepository) FindAll(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]Employee, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if opts != nil {
+		r.projection = opts.Projection
+	}
+	employees := make([]Employee, 0, len(r.employees))
+	for _, e := range r.employees {
+		if r.matches(e, filter) {
+			employees = append(employees, e)
+		}
+	}
+	return employees, nil
+}
+
+func (r *fakeRepository) Stream(ctx context.Context, filter bson.D, opts *options.FindOptions, fn func(*Employee) error) error {
+	r.streamed = ctx
+	employees, err := r.FindAll(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	for i := range employees {
+		if err := fn(&employees[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepository) Count(ctx context.Context, filter bson.D) (int64, error) {
+	employees, err := r.FindAll(ctx, filter, nil)
+	return int64(len(employees)), err
+}
+
+func (r *fakeRepository) Distinct(ctx context.Context, field string, filter bson.D) ([]interface{}, error) {
+	employees, err := r.FindAll(ctx, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[interface{}]bool{}
+	values := []interface{}{}
+	for _, e := range employees {
+		var value interface{}
+		switch field {
+		case "position":
+			value = e.Position
+		case "departmentId":
+			if e.DepartmentID != nil {
+				value = *e.DepartmentID
+			}
+		case "managerId":
+			if e.ManagerID != nil {
+				value = *e.ManagerID
+			}
+		case "address.city", "address.state", "address.country":
+			if e.Address != nil {
+				value = map[string]string{"address.city": e.Address.City, "address.state": e.Address.State, "address.country": e.Address.Country}[field]
+			}
+		}
+		if value != nil && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+func (r *fakeRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Employee, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	e, ok := r.active(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &e, nil
+}
+
+func (r *fakeRepository) Create(ctx context.Context, employee *Employee) (*Employee, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	// like the partial unique index, deleted employees don't hold on to their email
+	for _, e := range r.employees {
+		if e.Email == employee.Email && e.DeletedAt == nil {
+			return nil, ErrDuplicateEmail
+		}
+	}
+	id := primitive.NewObjectID()
+	employee.ID = id
+	employee.Bonus = nil
+	employee.CreatedAt = time.Now().UTC()
+	employee.UpdatedAt = employee.CreatedAt
+	r.employees[id] = *employee
+	return employee, nil
+}
+
+func (r *fakeRepository) CreateMany(ctx context.Context, employees []*Employee) ([]error, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	rowErrors := make([]error, len(employees))
+	for i, employee := range employees {
+		_, rowErrors[i] = r.Create(ctx, employee)
+	}
+	return rowErrors, nil
+}
+
+func (r *fakeRepository) Update(ctx context.Context, id primitive.ObjectID, employee *Employee, version *time.Time) (*Employee, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	existing, ok := r.active(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// mongo only keeps milliseconds
+	if version != nil && existing.UpdatedAt.UnixMilli() != version.UnixMilli() {
+		return nil, ErrVersionMismatch
+	}
+	existing.UpdatedAt = time.Now().UTC()
+	existing.Name = employee.Name
+	existing.Email = employee.Email
+	existing.Age = employee.Age
+	existing.Salary = employee.Salary
+	existing.Position = employee.Position
+	existing.HireDate = employee.HireDate
+	existing.DepartmentID = employee.DepartmentID
+	existing.ManagerID = employee.ManagerID
+	existing.Address = employee.Address
+	r.employees[id] = existing
+	return &existing, nil
+}
+
+func (r *fakeRepository) Patch(ctx context.Context, id primitive.ObjectID, fields bson.D) (*Employee, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	existing, ok := r.active(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	for _, field := range fields {
+		switch field.Key {
+		case "name":
+			existing.Name = field.Value.(string)
+		case "email":
+			existing.Email = field.Value.(string)
+		case "age":
+			existing.Age = field.Value.(float64)
+		case "salary":
+			existing.Salary = field.Value.(Money)
+		case "position":
+			existing.Position = field.Value.(string)
+		case "hireDate":
+			existing.HireDate = field.Value.(time.Time)
+		case "bonus":
+			bonus := field.Value.(Money)
+			existing.Bonus = &bonus
+		case "departmentId":
+			id := field.Value.(primitive.ObjectID)
+			existing.DepartmentID = &id
+		case "managerId":
+			id := field.Value.(primitive.ObjectID)
+			existing.ManagerID = &id
+		case "address.street", "address.city", "address.state", "address.postalCode", "address.country":
+			// a copy, so the address of the employee the test started with is kept
+			address := Address{}
+			if existing.Address != nil {
+				address = *existing.Address
+			}
+			value := field.Value.(string)
+			switch strings.TrimPrefix(field.Key, "address.") {
+			case "street":
+				address.Street = value
+			case "city":
+				address.City = value
+			case "state":
+				address.State = value
+			case "postalCode":
+				address.PostalCode = value
+			case "country":
+				address.Country = value
+			}
+			existing.Address = &address
+		}
+	}
+	r.employees[id] = existing
+	return &existing, nil
+}
+
+func (r *fakeRepository) Restore(ctx context.Context, id primitive.ObjectID) (*Employee, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	existing, ok := r.employees[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if existing.DeletedAt == nil {
+		return nil, ErrNotDeleted
+	}
+	for _, e := range r.employees {
+		if e.ID != id && e.Email == existing.Email && e.DeletedAt == nil {
+			return nil, ErrDuplicateEmail
+		}
+	}
+	existing.DeletedAt = nil
+	r.employees[id] = existing
+	return &existing, nil
+}
+
+func (r *fakeRepository) Purge(ctx context.Context, id primitive.ObjectID) error {
+	if r.err != nil {
+		return r.err
+	}
+	if _, ok := r.employees[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.employees, id)
+	return nil
+}
+
+func (r *fakeRepository) ReassignDepartment(ctx context.Context, from primitive.ObjectID, to *primitive.ObjectID) (int64, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	var moved int64
+	for id, e := range r.employees {
+		if e.DepartmentID != nil && *e.DepartmentID == from {
+			e.DepartmentID = to
+			r.employees[id] = e
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// SalaryStats works the stats out in Go, without the department names
+func (r *fakeRepository) SalaryStats(ctx context.Context, departmentID *primitive.ObjectID) ([]SalaryStats, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	var filter bson.D
+	if departmentID != nil {
+		filter = bson.D{{Key: "departmentId", Value: *departmentID}}
+	}
+	employees, _ := r.FindAll(ctx, filter, nil)
+
+	byDepartment := make(map[primitive.ObjectID]*SalaryStats)
+	stats := make([]SalaryStats, 0)
+	for _, e := range employees {
+		var key primitive.ObjectID
+		if e.DepartmentID != nil {
+			key = *e.DepartmentID
+		}
+		s, ok := byDepartment[key]
+		if !ok {
+			s = &SalaryStats{DepartmentID: e.DepartmentID, Min: e.Salary, Max: e.Salary}
+			byDepartment[key] = s
+		}
+		s.Count++
+		s.Total = s.Total.Add(e.Salary)
+		if e.Salary.Cmp(s.Min) < 0 {
+			s.Min = e.Salary
+		}
+		if e.Salary.Cmp(s.Max) > 0 {
+			s.Max = e.Salary
+		}
+		total, _ := new(big.Rat).SetString(s.Total.String())
+		s.Average, _ = ParseMoney(total.Quo(total, big.NewRat(s.Count, 1)).FloatString(2))
+	}
+	for _, s := range byDepartment {
+		stats = append(stats, *s)
+	}
+	return stats, nil
+}
+
+func (r *fakeRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if r.err != nil {
+		return r.err
+	}
+	existing, ok := r.active(id)
+	if !ok {
+		return ErrNotFound
+	}
+	now := time.Now().UTC()
+	existing.DeletedAt = &now
+	r.employees[id] = existing
+	return nil
+}
+
+func (r *fakeRepository) DeleteMany(ctx context.Context, ids []primitive.ObjectID) ([]Employee, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	deleted := []Employee{}
+	for _, id := range ids {
+		if existing, ok := r.active(id); ok {
+			deleted = append(deleted, existing)
+			r.Delete(ctx, id)
+		}
+	}
+	return deleted, nil
+}
+
+// Raise rounds half away from zero, where mongo rounds half to even. The
+// tests stay clear of salaries where that matters
+func (r *fakeRepository) Raise(ctx context.Context, id primitive.ObjectID, factor Money) (*Employee, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	existing, ok := r.active(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	salary, _ := new(big.Rat).SetString(existing.Salary.String())
+	by, _ := new(big.Rat).SetString(factor.String())
+	existing.Salary, _ = ParseMoney(salary.Mul(salary, by).FloatString(2))
+	existing.UpdatedAt = time.Now().UTC()
+	r.employees[id] = existing
+	return &existing, nil
+}
+
+func (r *fakeRepository) RaiseMany(ctx context.Context, ids []primitive.ObjectID, factor Money) ([]Employee, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	raised := []Employee{}
+	for _, id := range ids {
+		if e, err := r.Raise(ctx, id, factor); err == nil {
+			raised = append(raised, *e)
+		}
+	}
+	return raised, nil
+}
+
+func (r *fakeRepository) UpdateMany(ctx context.Context, ids []primitive.ObjectID, fields bson.D) ([]Employee, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	updated := []Employee{}
+	for _, id := range ids {
+		if e, err := r.Patch(ctx, id, fields); err == nil {
+			updated = append(updated, *e)
+		}
+	}
+	return updated, nil
+}
+
+// Recalculate goes through the active employees in id order like mongo does,
+// patching the ones transform returns fields for
+func (r *fakeRepository) Recalculate(ctx context.Context, batchSize int, transform func(*Employee) bson.D, progress func(processed, updated int64)) error {
+	if r.err != nil {
+		return r.err
+	}
+	employees, _ := r.FindAll(ctx, bson.D{notDeleted}, nil)
+	sort.Slice(employees, func(i, j int) bool { return employees[i].ID.Hex() < employees[j].ID.Hex() })
+
+	var processed, updated int64
+	for i := range employees {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if fields := transform(&employees[i]); len(fields) > 0 {
+			if _, err := r.Patch(ctx, employees[i].ID, fields); err != nil {
+				return err
+			}
+			updated++
+		}
+		processed++
+		if processed%int64(batchSize) == 0 || i == len(employees)-1 {
+			progress(processed, updated)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepository) ManagementChain(ctx context.Context, id primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	e, ok := r.employees[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var chain []primitive.ObjectID
+	seen := map[primitive.ObjectID]bool{}
+	for e.ManagerID != nil && !seen[*e.ManagerID] {
+		seen[*e.ManagerID] = true
+		chain = append(chain, *e.ManagerID)
+		if e, ok = r.employees[*e.ManagerID]; !ok {
+			break
+		}
+	}
+	return chain, nil
+}
+
+// Reports walks down the reporting lines a level at a time, like $graphLookup
+func (r *fakeRepository) Reports(ctx context.Context, id primitive.ObjectID, all bool) ([]Report, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	reports := []Report{}
+	managers := map[primitive.ObjectID]bool{id: true}
+	seen := map[primitive.ObjectID]bool{id: true}
+	for level := 1; len(managers) > 0 && (all || level == 1); level++ {
+		var found []Report
+		next := map[primitive.ObjectID]bool{}
+		for _, e := range r.employees {
+			if e.DeletedAt == nil && e.ManagerID != nil && managers[*e.ManagerID] && !seen[e.ID] {
+				found = append(found, Report{Employee: e, Level: level})
+				next[e.ID], seen[e.ID] = true, true
+			}
+		}
+		sort.Slice(found, func(i, j int) bool { return found[i].Name < found[j].Name })
+		reports = append(reports, found...)
+		managers = next
+	}
+	return reports, nil
+}
+
+// active returns the employee with the id unless it doesn't exist or is soft deleted
+func (r *fakeRepository) active(id primitive.ObjectID) (Employee, bool) {
+	e, ok := r.employees[id]
+	return e, ok && e.DeletedAt == nil
+}
+
+var (
+	errDatabase = errors.New("database is down")
+
+	engineeringID = primitive.NewObjectID()
+	engineering   = Department{ID: engineeringID.Hex(), Name: "Engineering"}
+
+	// nobody works in finance
+	financeID = primitive.NewObjectID()
+	finance   = Department{ID: financeID.Hex(), Name: "Finance"}
+
+	johnID = primitive.NewObjectID()
+	john   = Employee{ID: johnID, Name: "John Doe", Email: "john@example.com", Salary: MoneyFromInt(50000), Age: 30, DepartmentID: &engineeringID}
+
+	missingID = primitive.NewObjectID().Hex()
+)
+
+func testConfig() Config {
+	return Config{
+		JWTSecret:        "test-secret",
+		TokenTTL:         time.Hour,
+		RefreshTokenTTL:  24 * time.Hour,
+		LoginMaxAttempts: defaultLoginAttempts,
+		LoginLockout:     defaultLoginLockout,
+		RateLimit:        1000,
+		WriteRateLimit:   1000,
+		RateWindow:       time.Minute,
+		AllowedOrigins:   "*",
+
+		MongoMaxPoolSize:      defaultMongoMaxPoolSize,
+		MongoConnectTimeout:   defaultMongoConnectTimeout,
+		MongoOperationTimeout: defaultMongoOperationTimeout,
+
+		BodyLimit:      defaultBodyLimit,
+		RequestTimeout: defaultRequestTimeout,
+		CompressLevel:  defaultCompressLevel,
+
+		EmployeesCollection: defaultEmployeesCollection,
+		JobBatchSize:        defaultJobBatchSize,
+	}
+}
+
+// fakeTransactor runs the function straight away. The fakes have no way to
+// roll back, so it only checks the calls are made inside a transaction
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// fakePinger is a database that answers pings with err
+type fakePinger struct{ err error }
+
+func (p fakePinger) Ping(ctx context.Context) error {
+	return p.err
+}
+
+// newTestApp builds the app on top of the fakes
+func newTestApp(repo *fakeRepository, departmentRepo *fakeDepartmentRepository) *fiber.App {
+	return newApp(testConfig(), Repositories{
+		Database:        fakePinger{},
+		Employees:       repo,
+		Departments:     departmentRepo,
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+}
+
+// request sends a request through the app as a user with the role, returning
+// the status and body
+func request(t *testing.T, app *fiber.App, role, method, path, body string) (int, string) {
+	t.Helper()
+
+	resp, respBody := send(t, app, newRequest(t, role, method, path, body))
+	return resp.StatusCode, respBody
+}
+
+// newRequest builds a request with a JSON body, made as a user with the role
+// unless role is empty
+func newRequest(t *testing.T, role, method, path, body string) *http.Request {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if role != "" {
+		token, _, err := issueToken(testConfig(), "tester", role)
+		if err != nil {
+			t.Fatalf("issuing token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// send runs the request through the app, returning the response and its body
+func send(t *testing.T, app *fiber.App, req *http.Request) (*http.Response, string) {
+	t.Helper()
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response body: %v", err)
+	}
+	return resp, string(respBody)
+}
+
+type handlerTest struct {
+	name    string
+	repoErr error
+	role    string
+	method  string
+	path    string
+	body    string
+	// ifMatch is sent as the If-Match header when it isn't empty
+	ifMatch    string
+	wantStatus int
+	// wantBody is a substring the response body must contain
+	wantBody string
+}
+
+func runHandlerTests(t *testing.T, tests []handlerTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeRepository(john)
+			repo.err = tt.repoErr
+			departmentRepo := newFakeDepartmentRepository(engineering, finance)
+			departmentRepo.err = tt.repoErr
+			app := newTestApp(repo, departmentRepo)
+
+			role := tt.role
+			if role == "" {
+				role = roleAdmin
+			}
+			req := newRequest(t, role, tt.method, tt.path, tt.body)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			resp, body := send(t, app, req)
+			if status := resp.StatusCode; status != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestListEmployees(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "success", role: roleViewer, method: "GET", path: "/employee", wantStatus: 200, wantBody: `"name":"John Doe"`},
+		{name: "pagination metadata", method: "GET", path: "/employee?page=2&limit=500", wantStatus: 200, wantBody: `"page":2,"limit":100,"total":1`},
+		{name: "bad filter", method: "GET", path: "/employee?minSalary=lots", wantStatus: 400, wantBody: "minSalary must be a number"},
+		{name: "bad sort field", method: "GET", path: "/employee?sortBy=password", wantStatus: 400, wantBody: "cannot sort by"},
+		{name: "only some fields", method: "GET", path: "/employee?fields=id,name", wantStatus: 200, wantBody: `"data":[{"id":"` + johnID.Hex() + `","name":"John Doe"}]`},
+		{name: "unknown field", method: "GET", path: "/employee?fields=name,password", wantStatus: 400, wantBody: `fields can't include \"password\"`},
+		{name: "repeated field", method: "GET", path: "/employee?fields=id,name,%20name", wantStatus: 200, wantBody: `"data":[{"id":"` + johnID.Hex() + `","name":"John Doe"}]`},
+		{name: "nested field", method: "GET", path: "/employee?fields=address,address.city", wantStatus: 400, wantBody: `fields can't include \"address.city\"`},
+		{name: "database error", repoErr: errDatabase, method: "GET", path: "/employee", wantStatus: 500, wantBody: `{"error":{"code":500,"message":"internal server error"}}`},
+		{name: "database timeout", repoErr: context.DeadlineExceeded, method: "GET", path: "/employee", wantStatus: 504, wantBody: `{"error":{"code":504,"message":"the database took too long to respond"}}`},
+	})
+}
+
+func TestListEmployeesRepeatedField(t *testing.T) {
+	repo := newFakeRepository(john)
+	app := newTestApp(repo, newFakeDepartmentRepository())
+
+	if status, body := request(t, app, roleViewer, "GET", "/employee?fields=name,id,name", ""); status != 200 {
+		t.Fatalf("status = %d, body %q", status, body)
+	}
+	names := 0
+	for _, e := range repo.projection.(bson.D) {
+		if e.Key == "name" {
+			names++
+		}
+	}
+	if names != 1 {
+		t.Errorf("projection = %v, want name in it once", repo.projection)
+	}
+}
+
+func TestListEmployeesPageLinks(t *testing.T) {
+	app := newTestApp(newFakeRepository(
+		john,
+		Employee{ID: primitive.NewObjectID(), Name: "Jane Doe", Email: "jane@example.com"},
+		Employee{ID: primitive.NewObjectID(), Name: "Jim Doe", Email: "jim@example.com"},
+	), newFakeDepartmentRepository())
+
+	tests := []struct {
+		path, next, prev string
+	}{
+		{"/employee?limit=1", "/employee?limit=1&page=2", ""},
+		{"/employee?limit=1&page=2&sortBy=name", "/employee?limit=1&page=3&sortBy=name", "/employee?limit=1&page=1&sortBy=name"},
+		{"/api/v1/employee?limit=1&page=3", "", "/api/v1/employee?limit=1&page=2"},
+		{"/employee", "", ""},
+	}
+	for _, tt := range tests {
+		status, body := request(t, app, roleViewer, "GET", tt.path, "")
+		var list EmployeeList
+		if err := json.Unmarshal([]byte(body), &list); err != nil || status != 200 {
+			t.Fatalf("%s: status = %d, body %q", tt.path, status, body)
+		}
+		if link(list.Next) != tt.next || link(list.Prev) != tt.prev {
+			t.Errorf("%s: next %q and prev %q, want %q and %q", tt.path, link(list.Next), link(list.Prev), tt.next, tt.prev)
+		}
+	}
+}
+
+// link is the page link, or "" when there is none
+func link(l *string) string {
+	if l == nil {
+		return ""
+	}
+	return *l
+}
+
+func TestCountEmployees(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "success", role: roleViewer, method: "GET", path: "/employee/count", wantStatus: 200, wantBody: `{"count":1}`},
+		{name: "bad filter", method: "GET", path: "/employee/count?maxAge=old", wantStatus: 400, wantBody: "maxAge must be a number"},
+		{name: "database error", repoErr: errDatabase, method: "GET", path: "/employee/count", wantStatus: 500},
+	})
+}
+
+func TestDistinctValues(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "departments", role: roleViewer, method: "GET", path: "/employee/distinct/departmentId", wantStatus: 200, wantBody: `{"field":"departmentId","values":["` + engineeringID.Hex() + `"]}`},
+		{name: "nobody has one", method: "GET", path: "/employee/distinct/position", wantStatus: 200, wantBody: `"values":[]`},
+		{name: "not on the whitelist", method: "GET", path: "/employee/distinct/salary", wantStatus: 400, wantBody: `cannot list the values of \"salary\"`},
+		{name: "bad filter", method: "GET", path: "/employee/distinct/position?maxAge=old", wantStatus: 400, wantBody: "maxAge must be a number"},
+		{name: "database error", repoErr: errDatabase, method: "GET", path: "/employee/distinct/position", wantStatus: 500},
+	})
+
+	// sorted and without repeats
+	employees := []Employee{john}
+	for i, position := range []string{"Engineer", "Accountant", "Engineer", ""} {
+		employees = append(employees, Employee{ID: primitive.NewObjectID(), Name: "Someone", Email: fmt.Sprintf("someone%d@example.com", i), Position: position})
+	}
+	app := newTestApp(newFakeRepository(employees...), newFakeDepartmentRepository())
+	if status, body := request(t, app, roleViewer, "GET", "/employee/distinct/position", ""); status != 200 || body != `{"field":"position","values":["Accountant","Engineer"]}` {
+		t.Errorf("positions: status = %d, body %q", status, body)
+	}
+}
+
+func TestGetEmployee(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "success", role: roleViewer, method: "GET", path: "/employee/" + johnID.Hex(), wantStatus: 200, wantBody: `"email":"john@example.com"`},
+		{name: "malformed id", method: "GET", path: "/employee/not-an-id", wantStatus: 400, wantBody: `"message":"invalid id`},
+		{name: "not found", method: "GET", path: "/employee/" + missingID, wantStatus: 404, wantBody: `{"error":{"code":404,"message":"employee not found"}}`},
+		{name: "database error", repoErr: errDatabase, method: "GET", path: "/employee/" + johnID.Hex(), wantStatus: 500, wantBody: `{"error":{"code":500,"message":"internal server error"}}`},
+	})
+}
+
+func TestCreateEmployee(t *testing.T) {
+	valid := `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`
+
+	runHandlerTests(t, []handlerTest{
+		{name: "success", method: "POST", path: "/employee", body: valid, wantStatus: 201, wantBody: `"name":"Jane Doe"`},
+		{name: "validation failure", method: "POST", path: "/employee", body: `{"name":"","email":"jane@example.com","salary":-1,"age":900}`, wantStatus: 422, wantBody: `"message":"validation failed","details":[{"field":"age"`},
+		{name: "malformed json", method: "POST", path: "/employee", body: `{"name":`, wantStatus: 400},
+		{name: "exact salary", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1234.57,"age":20}`, wantStatus: 201, wantBody: `"salary":1234.57,`},
+		{name: "fraction of a cent", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1234.567,"age":20}`, wantStatus: 422, wantBody: `"field":"salary","message":"salary can have at most 2 decimal places"`},
+		{name: "salary as a string", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":"1234","age":20}`, wantStatus: 400},
+		{name: "invalid email", method: "POST", path: "/employee", body: `{"name":"Jane","email":"Jane <jane@example.com>","salary":1,"age":20}`, wantStatus: 422, wantBody: `"field":"email","message":"email must be a valid email address"`},
+		{name: "hire date in the future", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"hireDate":"2999-01-01T00:00:00Z"}`, wantStatus: 422, wantBody: `"field":"hireDate","message":"hireDate can't be in the future"`},
+		{name: "with position and hire date", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"position":"Accountant","hireDate":"2020-03-01T00:00:00Z"}`, wantStatus: 201, wantBody: `"position":"Accountant","hireDate":"2020-03-01T00:00:00Z"`},
+		{name: "in a department", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"departmentId":"` + engineeringID.Hex() + `"}`, wantStatus: 201, wantBody: `"departmentId":"` + engineeringID.Hex() + `"`},
+		{name: "unknown department", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"departmentId":"` + missingID + `"}`, wantStatus: 422, wantBody: `"field":"departmentId","message":"department does not exist"`},
+		{name: "duplicate email", method: "POST", path: "/employee", body: `{"name":"John","email":"john@example.com","salary":1,"age":20}`, wantStatus: 409, wantBody: ErrDuplicateEmail.Error()},
+		{name: "viewer forbidden", role: roleViewer, method: "POST", path: "/employee", body: valid, wantStatus: 403},
+		{name: "database error", repoErr: errDatabase, method: "POST", path: "/employee", body: valid, wantStatus: 500, wantBody: `{"error":{"code":500,"message":"internal server error"}}`},
+	})
+}
+
+func TestUnknownFieldsAreRejected(t *testing.T) {
+	path := "/employee/" + johnID.Hex()
+
+	runHandlerTests(t, []handlerTest{
+		// a typo would otherwise leave the salary as it was without a word
+		{name: "misspelled field", method: "PATCH", path: path, body: `{"salaray":70000}`, wantStatus: 400, wantBody: `{"error":{"code":400,"message":"the body has unknown fields","details":[{"field":"salaray","message":"salaray is not a known field","value":70000}]}}`},
+		{name: "extra field", method: "POST", path: "/department", body: `{"name":"Sales","budget":1000}`, wantStatus: 400, wantBody: `"field":"budget","message":"budget is not a known field"`},
+		{name: "every unknown field is listed", method: "PATCH", path: path, body: `{"name":"John","nmae":"Jon","agee":3}`, wantStatus: 400, wantBody: `"details":[{"field":"agee","message":"agee is not a known field","value":3},{"field":"nmae"`},
+		{name: "nested field", method: "PATCH", path: path, body: `{"address":{"city":"Berlin","zip":"10115"}}`, wantStatus: 400, wantBody: `"field":"address.zip"`},
+		{name: "field of a search filter", method: "POST", path: "/employee/search", body: `{"filter":{"or":[{"field":"name","op":"eq","value":"John"},{"feild":"age"}]}}`, wantStatus: 400, wantBody: `"field":"filter.or.1.feild"`},
+		// encoding/json matches names without regard to case, and so do we
+		{name: "other case", method: "PATCH", path: path, body: `{"Position":"Boss"}`, wantStatus: 200, wantBody: `"position":"Boss"`},
+		{name: "trailing data", method: "PATCH", path: path, body: `{"position":"Boss"} {"position":"Intern"}`, wantStatus: 400, wantBody: "a single JSON value"},
+	})
+}
+
+func TestNonJSONBodyIsRejected(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+	}{
+		{name: "form create", method: "POST", path: "/employee", contentType: "application/x-www-form-urlencoded", body: "name=Jane&email=jane@example.com"},
+		{name: "no content type", method: "PUT", path: "/employee/" + johnID.Hex(), body: `{"name":"Jane"}`},
+		{name: "xml patch", method: "PATCH", path: "/employee/" + johnID.Hex(), contentType: "application/xml", body: "<name>Jane</name>"},
+		{name: "form department", method: "POST", path: "/department", contentType: "application/x-www-form-urlencoded", body: "name=Sales"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeRepository(john)
+			app := newTestApp(repo, newFakeDepartmentRepository())
+
+			req := newRequest(t, roleAdmin, tt.method, tt.path, tt.body)
+			req.Header.Del("Content-Type")
+			req.Header.Set("If-Match", "*")
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			resp, body := send(t, app, req)
+			if resp.StatusCode != 415 || !strings.Contains(body, "Content-Type: application/json") {
+				t.Errorf("status = %d, body %q, want a 415", resp.StatusCode, body)
+			}
+			if len(repo.employees) != 1 || repo.employees[johnID].Name != john.Name {
+				t.Error("the employees were changed")
+			}
+		})
+	}
+
+	// a charset parameter is fine
+	app := newTestApp(newFakeRepository(), newFakeDepartmentRepository())
+	req := newRequest(t, roleAdmin, "POST", "/employee", `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if resp, body := send(t, app, req); resp.StatusCode != 201 {
+		t.Errorf("status = %d, body %q, want 201", resp.StatusCode, body)
+	}
+}
+
+func TestEmptyBodyIsRejected(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "no body", method: "POST", path: "/employee", wantStatus: 400, wantBody: "the request body is empty"},
+		{name: "only whitespace", method: "POST", path: "/employee", body: " \r\n\t", wantStatus: 400, wantBody: "the request body is empty"},
+		{name: "no body on update", method: "PUT", path: "/employee/" + johnID.Hex(), wantStatus: 400, wantBody: "the request body is empty"},
+		// an empty object is a body, just not a valid employee
+		{name: "empty object", method: "POST", path: "/employee", body: "{}", wantStatus: 422, wantBody: `"field":"name","message":"name is required"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeRepository(john)
+			app := newTestApp(repo, newFakeDepartmentRepository())
+
+			req := newRequest(t, roleAdmin, tt.method, tt.path, tt.body)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", "*")
+			resp, body := send(t, app, req)
+			if resp.StatusCode != tt.wantStatus || !strings.Contains(body, tt.wantBody) {
+				t.Errorf("status = %d, body %q, want %d and %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+			if len(repo.employees) != 1 || repo.employees[johnID].Name != john.Name {
+				t.Error("the employees were changed")
+			}
+		})
+	}
+}
+
+func TestUpdateEmployee(t *testing.T) {
+	valid := `{"name":"John Smith","email":"john@example.com","salary":70000,"age":31}`
+
+	runHandlerTests(t, []handlerTest{
+		{name: "success", method: "PUT", path: "/employee/" + johnID.Hex(), body: valid, ifMatch: "*", wantStatus: 200, wantBody: `"name":"John Smith"`},
+		{name: "validation failure", method: "PUT", path: "/employee/" + johnID.Hex(), body: `{"name":"John","email":"","salary":1,"age":30}`, ifMatch: "*", wantStatus: 422, wantBody: `"field":"email","message":"email is required"`},
+		{name: "malformed id", method: "PUT", path: "/employee/not-an-id", body: valid, ifMatch: "*", wantStatus: 400, wantBody: `{"error":{"code":400,"message":"invalid id, must be a 24 character hex string"}}`},
+		// the id is checked before the body is read
+		{name: "malformed id and body", method: "PUT", path: "/employee/not-an-id", body: `{"name":`, ifMatch: "*", wantStatus: 400, wantBody: `"message":"invalid id`},
+		{name: "not found", method: "PUT", path: "/employee/" + missingID, body: valid, ifMatch: "*", wantStatus: 404},
+		{name: "database error", repoErr: errDatabase, method: "PUT", path: "/employee/" + johnID.Hex(), body: valid, ifMatch: "*", wantStatus: 500, wantBody: `{"error":{"code":500,"message":"internal server error"}}`},
+		{name: "no If-Match", method: "PUT", path: "/employee/" + johnID.Hex(), body: valid, wantStatus: 428, wantBody: "If-Match header is required"},
+		{name: "stale If-Match", method: "PUT", path: "/employee/" + johnID.Hex(), body: valid, ifMatch: `"1"`, wantStatus: 412, wantBody: ErrVersionMismatch.Error()},
+		{name: "unknown If-Match", method: "PUT", path: "/employee/" + johnID.Hex(), body: valid, ifMatch: `W/"abc"`, wantStatus: 412},
+	})
+}
+
+func TestUpdateEmployeeWithETag(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository(engineering))
+	path := "/employee/" + johnID.Hex()
+
+	resp, _ := send(t, app, newRequest(t, roleViewer, "GET", path, ""))
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("GET has no ETag")
+	}
+
+	// the first admin saves with the tag they read
+	req := newRequest(t, roleAdmin, "PUT", path, `{"name":"John Smith","email":"john@example.com","salary":70000,"age":31}`)
+	req.Header.Set("If-Match", etag)
+	resp, body := send(t, app, req)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %q, want 200", resp.StatusCode, body)
+	}
+	if newTag := resp.Header.Get("ETag"); newTag == "" || newTag == etag {
+		t.Errorf("ETag after the update = %q, want a new one", newTag)
+	}
+
+	// the second one read the same version, and is stopped from overwriting the first
+	req = newRequest(t, roleAdmin, "PUT", path, `{"name":"Johnny Doe","email":"john@example.com","salary":50000,"age":30}`)
+	req.Header.Set("If-Match", etag)
+	resp, body = send(t, app, req)
+	if resp.StatusCode != 412 {
+		t.Fatalf("status = %d, body %q, want 412", resp.StatusCode, body)
+	}
+	if _, body := request(t, app, roleViewer, "GET", path, ""); !strings.Contains(body, "John Smith") {
+		t.Errorf("the first update was lost: %q", body)
+	}
+}
+
+func TestPatchEmployee(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "success", method: "PATCH", path: "/employee/" + johnID.Hex(), body: `{"salary":55000}`, wantStatus: 200, wantBody: `"salary":55000`},
+		{name: "keeps omitted fields", method: "PATCH", path: "/employee/" + johnID.Hex(), body: `{"salary":55000}`, wantStatus: 200, wantBody: `"name":"John Doe"`},
+		{name: "validation failure", method: "PATCH", path: "/employee/" + johnID.Hex(), body: `{"age":12}`, wantStatus: 422, wantBody: `"field":"age","message":"age must be between 16 and 120"`},
+		{name: "position", method: "PATCH", path: "/employee/" + johnID.Hex(), body: `{"position":"Team Lead"}`, wantStatus: 200, wantBody: `"position":"Team Lead"`},
+		{name: "unknown department", method: "PATCH", path: "/employee/" + johnID.Hex(), body: `{"departmentId":"` + missingID + `"}`, wantStatus: 422, wantBody: `"field":"departmentId","message":"department does not exist"`},
+		{name: "no fields", method: "PATCH", path: "/employee/" + johnID.Hex(), body: `{}`, wantStatus: 400, wantBody: "no fields to update"},
+		{name: "not found", method: "PATCH", path: "/employee/" + missingID, body: `{"salary":55000}`, wantStatus: 404},
+		{name: "database error", repoErr: errDatabase, method: "PATCH", path: "/employee/" + johnID.Hex(), body: `{"salary":55000}`, wantStatus: 500, wantBody: `{"error":{"code":500,"message":"internal server error"}}`},
+	})
+}
+
+func TestDeleteEmployee(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "success", method: "DELETE", path: "/employee/" + johnID.Hex() + "?confirm=true", wantStatus: 200, wantBody: `{"employeeId":"` + johnID.Hex() + `","leaveRequests":0,"attendance":0,"photos":0}`},
+		{name: "not confirmed", method: "DELETE", path: "/employee/" + johnID.Hex(), wantStatus: 400, wantBody: "?confirm=true"},
+		{name: "malformed id", method: "DELETE", path: "/employee/not-an-id?confirm=true", wantStatus: 400, wantBody: `"message":"invalid id`},
+		{name: "not found", method: "DELETE", path: "/employee/" + missingID + "?confirm=true", wantStatus: 404},
+		{name: "viewer forbidden", role: roleViewer, method: "DELETE", path: "/employee/" + johnID.Hex() + "?confirm=true", wantStatus: 403},
+		{name: "database error", repoErr: errDatabase, method: "DELETE", path: "/employee/" + johnID.Hex() + "?confirm=true", wantStatus: 500, wantBody: `{"error":{"code":500,"message":"internal server error"}}`},
+	})
+}
+
+func TestBatchDeleteEmployees(t *testing.T) {
+	jane := Employee{ID: primitive.NewObjectID(), Name: "Jane Doe", Email: "jane@example.com"}
+	body := `["` + johnID.Hex() + `","` + jane.ID.Hex() + `","nope","` + missingID + `","` + johnID.Hex() + `"]`
+
+	repo := newFakeRepository(john, jane)
+	app := newTestApp(repo, newFakeDepartmentRepository())
+	status, respBody := request(t, app, roleAdmin, "POST", "/employee/batch-delete", body)
+	want := `{"deleted":2,"invalid":["nope"],"notFound":["` + missingID + `"],"leaveRequests":0,"attendance":0,"photos":0}`
+	if status != 200 || respBody != want {
+		t.Fatalf("status = %d, body %q, want 200 %q", status, respBody, want)
+	}
+	for _, id := range []string{johnID.Hex(), jane.ID.Hex()} {
+		if status, _ := request(t, app, roleViewer, "GET", "/employee/"+id, ""); status != 404 {
+			t.Errorf("GET %s after the batch delete: status = %d, want 404", id, status)
+		}
+	}
+
+	runHandlerTests(t, []handlerTest{
+		{name: "empty", method: "POST", path: "/employee/batch-delete", body: `[]`, wantStatus: 400, wantBody: "send between 1 and 500 ids"},
+		{name: "not an array", method: "POST", path: "/employee/batch-delete", body: `{"ids":[]}`, wantStatus: 400},
+		{name: "viewer forbidden", role: roleViewer, method: "POST", path: "/employee/batch-delete", body: `["` + johnID.Hex() + `"]`, wantStatus: 403},
+		{name: "database error", repoErr: errDatabase, method: "POST", path: "/employee/batch-delete", body: `["` + johnID.Hex() + `"]`, wantStatus: 500},
+	})
+}
+
+func TestDeleteTakesRelatedRecords(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+	path := "/employee/" + johnID.Hex()
+	request(t, app, roleAdmin, "POST", path+"/leave", `{"startDate":"2026-07-01T00:00:00Z","endDate":"2026-07-02T00:00:00Z","type":"sick"}`)
+	request(t, app, roleAdmin, "POST", path+"/checkin", "")
+	uploadPhoto(t, app, roleAdmin, path+"/photo", "photo", testImage(t, func(w *bytes.Buffer, m image.Image) error { return png.Encode(w, m) }))
+
+	status, body := request(t, app, roleAdmin, "DELETE", path+"?confirm=true", "")
+	var result DeleteResult
+	if err := json.Unmarshal([]byte(body), &result); err != nil || status != 200 {
+		t.Fatalf("delete: status = %d, body %q", status, body)
+	}
+	if want := (DeleteResult{EmployeeID: johnID.Hex(), LeaveRequests: 1, Attendance: 1, Photos: 1}); result != want {
+		t.Errorf("delete result = %+v, want %+v", result, want)
+	}
+	if _, body := request(t, app, roleAdmin, "GET", "/leave?status=pending", ""); body != "[]" {
+		t.Errorf("pending leave after the delete = %s, want none", body)
+	}
+
+	if status, body := request(t, app, roleAdmin, "POST", path+"/restore", ""); status != 200 {
+		t.Fatalf("restore: status = %d, body %q", status, body)
+	}
+	if _, body := request(t, app, roleAdmin, "GET", "/leave?status=pending", ""); !strings.Contains(body, johnID.Hex()) {
+		t.Errorf("pending leave after the restore = %s, want john's back", body)
+	}
+	// john is still checked in from before the delete
+	if status, _ := request(t, app, roleAdmin, "POST", path+"/checkout", ""); status != 200 {
+		t.Errorf("checking out after the restore: status = %d, want 200", status)
+	}
+	if status, _ := request(t, app, roleViewer, "GET", path+"/photo", ""); status != 200 {
+		t.Errorf("photo after the restore: status = %d, want 200", status)
+	}
+}
+
+func TestRestoreEmployee(t *testing.T) {
+	repo := newFakeRepository(john)
+	app := newTestApp(repo, newFakeDepartmentRepository())
+	path := "/employee/" + johnID.Hex()
+
+	if status, body := request(t, app, roleAdmin, "POST", path+"/restore", ""); status != 409 {
+		t.Errorf("restore active employee: status = %d, body %q, want 409", status, body)
+	}
+	request(t, app, roleAdmin, "DELETE", path+"?confirm=true", "")
+	if status, _ := request(t, app, roleViewer, "GET", path, ""); status != 404 {
+		t.Fatalf("get deleted employee: status = %d, want 404", status)
+	}
+	if status, body := request(t, app, roleAdmin, "GET", "/employee?includeDeleted=true", ""); status != 200 || !strings.Contains(body, johnID.Hex()) {
+		t.Errorf("admin listing deleted employees: status = %d, body %q, want john", status, body)
+	}
+	if status, body := request(t, app, roleViewer, "GET", "/employee?includeDeleted=true", ""); status != 403 {
+		t.Errorf("viewer listing deleted employees: status = %d, body %q, want 403", status, body)
+	}
+
+	if status, body := request(t, app, roleViewer, "POST", path+"/restore", ""); status != 403 {
+		t.Errorf("restore as viewer: status = %d, body %q, want 403", status, body)
+	}
+	status, body := request(t, app, roleAdmin, "POST", path+"/restore", "")
+	if status != 200 || strings.Contains(body, "deletedAt") {
+		t.Errorf("restore: status = %d, body %q, want 200 without deletedAt", status, body)
+	}
+	if status, _ := request(t, app, roleViewer, "GET", path, ""); status != 200 {
+		t.Errorf("get restored employee: status = %d, want 200", status)
+	}
+	if status, _ := request(t, app, roleAdmin, "POST", "/employee/"+missingID+"/restore", ""); status != 404 {
+		t.Errorf("restore missing employee: status = %d, want 404", status)
+	}
+}
+
+func TestDeletedEmployeeEmailReused(t *testing.T) {
+	repo := newFakeRepository(john)
+	app := newTestApp(repo, newFakeDepartmentRepository())
+	path := "/employee/" + johnID.Hex()
+	rehire := `{"name":"John Again","email":"john@example.com","salary":1,"age":20}`
+
+	request(t, app, roleAdmin, "DELETE", path+"?confirm=true", "")
+	if status, body := request(t, app, roleAdmin, "POST", "/employee", rehire); status != 201 {
+		t.Fatalf("create with a deleted employee's email: status = %d, body %q, want 201", status, body)
+	}
+	status, body := request(t, app, roleAdmin, "POST", path+"/restore", "")
+	if status != 409 || !strings.Contains(body, ErrDuplicateEmail.Error()) {
+		t.Errorf("restore with the email taken: status = %d, body %q, want 409", status, body)
+	}
+}
+
+func TestEmployeeRoutesRequireToken(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+
+	status, body := request(t, app, "", "GET", "/employee", "")
+	if status != 401 {
+		t.Errorf("status = %d, want 401", status)
+	}
+	if want := `{"error":{"code":401,"message":"invalid or missing token"}}`; body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestCreatedEmployeeIsReturned(t *testing.T) {
+	app := newTestApp(newFakeRepository(), newFakeDepartmentRepository())
+
+	status, body := request(t, app, roleAdmin, "POST", "/employee", `{"name":"Jane","email":"jane@example.com","salary":1,"age":20}`)
+	if status != 201 {
+		t.Fatalf("status = %d, want 201", status)
+	}
+
+	var created Employee
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+	if created.ID.IsZero() || created.CreatedAt.IsZero() {
+		t.Errorf("created employee is missing server set fields: %+v", created)
+	}
+}
+
+func TestUnknownRouteUsesErrorEnvelope(t *testing.T) {
+	app := newTestApp(newFakeRepository(), newFakeDepartmentRepository())
+
+	status, body := request(t, app, "", "GET", "/nowhere", "")
+	if status != 404 {
+		t.Errorf("status = %d, want 404", status)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || resp.Error == nil || resp.Error.Code != 404 {
+		t.Errorf("body = %q, want a 404 error envelope", body)
+	}
+}
+
+func TestOversizedBodyIsRejected(t *testing.T) {
+	cfg := testConfig()
+	cfg.BodyLimit = 1024
+	app := newApp(cfg, Repositories{
+		Employees:       newFakeRepository(),
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+
+	// app.Test hands the error to the test rather than answering it, so this
+	// goes through a real listener
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	huge := `["` + strings.Repeat(johnID.Hex()+`","`, 100) + johnID.Hex() + `"]`
+	req := newRequest(t, roleAdmin, "POST", "http://"+ln.Addr().String()+"/employee/batch-delete", huge)
+	req.RequestURI = ""
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 413 || !strings.Contains(string(body), `"code":413`) {
+		t.Errorf("got %d %s, want a 413 error envelope", resp.StatusCode, body)
+	}
+
+	if status, body := request(t, app, roleAdmin, "POST", "/employee/batch-delete", `["`+johnID.Hex()+`"]`); status != 200 {
+		t.Errorf("small body: status = %d, body %s", status, body)
+	}
+}
+
+// panickingRepository panics when an employee is read by id
+type panickingRepository struct {
+	*fakeRepository
+}
+
+func (panickingRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Employee, error) {
+	panic("boom")
+}
+
+func TestPanicIsRecovered(t *testing.T) {
+	for _, env := range []string{envProduction, envDevelopment} {
+		t.Run(env, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Env = env
+			app := newApp(cfg, Repositories{
+				Employees:       panickingRepository{newFakeRepository(john)},
+				Departments:     newFakeDepartmentRepository(),
+				Transactor:      fakeTransactor{},
+				IdempotencyKeys: newFakeIdempotencyRepository(),
+				AuditLogs:       newFakeAuditRepository(),
+				History:         newFakeHistoryRepository(),
+				LeaveRequests:   newFakeLeaveRepository(),
+				SalaryChanges:   newFakeSalaryChangeRepository(),
+				Attendance:      newFakeAttendanceRepository(),
+				Photos:          newFakePhotoRepository(),
+				Users:           newFakeUserRepository(),
+				RefreshTokens:   newFakeRefreshTokenRepository(),
+				Events:          newFakeEventPublisher(),
+			})
+
+			status, body := request(t, app, roleViewer, "GET", "/employee/"+johnID.Hex(), "")
+			if env == envProduction {
+				if status != 500 || body != `{"error":{"code":500,"message":"internal server error"}}` {
+					t.Errorf("got %d %s, want a 500 error envelope without details", status, body)
+				}
+			} else {
+				var resp struct {
+					Error struct {
+						Code    int
+						Details ErrorDebug
+					}
+				}
+				if err := json.Unmarshal([]byte(body), &resp); err != nil || status != 500 {
+					t.Fatalf("got %d %s, err %v", status, body, err)
+				}
+				// development shows the panic and where it happened
+				if resp.Error.Details.Error != "boom" || !strings.Contains(resp.Error.Details.Stack, "panickingRepository") {
+					t.Errorf("details = %+v, want the panic and its stack", resp.Error.Details)
+				}
+			}
+			// the app keeps serving requests after a panic
+			if status, body := request(t, app, roleViewer, "GET", "/employee", ""); status != 200 {
+				t.Errorf("after the panic: status = %d, body %s", status, body)
+			}
+		})
+	}
+}
+
+func TestDevelopmentErrorDetails(t *testing.T) {
+	repo := newFakeRepository(john)
+	repo.err = errDatabase
+	cfg := testConfig()
+	cfg.Env = envDevelopment
+	app := newApp(cfg, Repositories{
+		Employees:       repo,
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+
+	status, body := request(t, app, roleViewer, "GET", "/employee/"+johnID.Hex(), "")
+	want := `{"error":{"code":500,"message":"internal server error","details":{"error":"` + errDatabase.Error() + `"}}}`
+	if status != 500 || body != want {
+		t.Errorf("got %d %s, want %s", status, body, want)
+	}
+	// errors meant for the client are the same as in production
+	if status, body := request(t, app, roleViewer, "GET", "/employee/not-an-id", ""); status != 400 || strings.Contains(body, "details") {
+		t.Errorf("got %d %s, want a 400 without details", status, body)
+	}
+}
+
+func TestSalaryStats(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "all departments", role: roleViewer, method: "GET", path: "/stats/salary", wantStatus: 200, wantBody: `"count":1,"total":50000,"average":50000,"min":50000,"max":50000`},
+		{name: "one department", method: "GET", path: "/stats/salary?department=" + financeID.Hex(), wantStatus: 200, wantBody: `[]`},
+		{name: "bad department", method: "GET", path: "/stats/salary?department=finance", wantStatus: 400},
+		{name: "database error", repoErr: errDatabase, method: "GET", path: "/stats/salary", wantStatus: 500},
+	})
+}
+
+func TestExportCSV(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+
+	status, body := request(t, app, roleViewer, "GET", "/employee/export.csv", "")
+	if status != 200 {
+		t.Fatalf("status = %d, want 200 (body %q)", status, body)
+	}
+	want := "id,name,email,age,salary\n" + johnID.Hex() + ",John Doe,john@example.com,30,50000\n"
+	if body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestResponsesAreCompressed(t *testing.T) {
+	employees := make([]Employee, 20)
+	for i := range employees {
+		employees[i] = john
+		employees[i].ID = primitive.NewObjectID()
+	}
+	app := newTestApp(newFakeRepository(employees...), newFakeDepartmentRepository())
+
+	req := newRequest(t, roleViewer, "GET", "/employee", "")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, body := send(t, app, req)
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("status = %d, Content-Encoding = %q, want a gzipped 200", resp.StatusCode, resp.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("reading gzip: %v", err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading gzip: %v", err)
+	}
+	if !strings.Contains(string(plain), "John Doe") || len(body) >= len(plain) {
+		t.Errorf("got %d compressed bytes for %d plain ones, %q", len(body), len(plain), plain)
+	}
+
+	// a client that doesn't ask for it gets plain JSON
+	resp, body = send(t, app, newRequest(t, roleViewer, "GET", "/employee", ""))
+	if resp.Header.Get("Content-Encoding") != "" || !strings.Contains(body, "John Doe") {
+		t.Errorf("Content-Encoding = %q, body %q, want plain JSON", resp.Header.Get("Content-Encoding"), body)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	jane := Employee{ID: primitive.NewObjectID(), Name: "Jane Doe", Email: "jane@example.com"}
+	tests := []struct {
+		name      string
+		employees []Employee
+		want      int
+	}{
+		{name: "empty", want: 0},
+		{name: "one", employees: []Employee{john}, want: 1},
+		{name: "several", employees: []Employee{john, jane}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(newFakeRepository(tt.employees...), newFakeDepartmentRepository())
+
+			resp, body := send(t, app, newRequest(t, roleViewer, "GET", "/employee/export.json", ""))
+			var got []Employee
+			if err := json.Unmarshal([]byte(body), &got); err != nil || resp.StatusCode != 200 {
+				t.Fatalf("status = %d, body %q, err %v", resp.StatusCode, body, err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("got %d employees, want %d: %q", len(got), tt.want, body)
+			}
+			if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("Content-Type = %q, want JSON", ct)
+			}
+			// streamed, so the length isn't known up front
+			if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+				t.Errorf("Transfer-Encoding = %v, want chunked", resp.TransferEncoding)
+			}
+		})
+	}
+}
+
+func TestExportListReads(t *testing.T) {
+	for _, path := range []string{"/employee/export.csv", "/employee/export.json"} {
+		repo := newFakeRepository(john)
+		app := newTestApp(repo, newFakeDepartmentRepository())
+
+		if resp, body := send(t, app, newRequest(t, roleViewer, "GET", path, "")); resp.StatusCode != 200 {
+			t.Fatalf("%s: status = %d, body %q", path, resp.StatusCode, body)
+		}
+		// the stream outlives the request, but still reads like a list
+		if repo.streamed == nil || !hasListReads(repo.streamed) {
+			t.Errorf("%s: the export isn't streamed with the list read preference", path)
+		}
+	}
+}
+
+func TestExportResume(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+
+	// a byte range can't be served from a stream, so the whole export comes back
+	req := newRequest(t, roleViewer, "GET", "/employee/export.csv", "")
+	req.Header.Set("Range", "bytes=10-")
+	resp, body := send(t, app, req)
+	if resp.StatusCode != 200 || resp.Header.Get("Accept-Ranges") != "none" || !strings.HasPrefix(body, "id,name") {
+		t.Errorf("range: status = %d, Accept-Ranges %q, body %q, want the whole export", resp.StatusCode, resp.Header.Get("Accept-Ranges"), body)
+	}
+
+	// resuming leaves out the header, so the rows can be appended to the first part
+	status, body := request(t, app, roleViewer, "GET", "/employee/export.csv?offset=1", "")
+	if status != 200 || strings.Contains(body, "id,name") {
+		t.Errorf("resume: status = %d, body %q, want the rows without the header", status, body)
+	}
+
+	for _, path := range []string{"/employee/export.csv?offset=-1", "/employee/export.json?offset=many"} {
+		if status, body := request(t, app, roleViewer, "GET", path, ""); status != 400 || !strings.Contains(body, "offset must be") {
+			t.Errorf("%s: status = %d, body %q, want 400", path, status, body)
+		}
+	}
+}
+
+// uploadCSV posts the csv to /employee/import as an admin, the way a browser form would
+func uploadCSV(t *testing.T, app *fiber.App, content string) (int, string) {
+	t.Helper()
+
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("file", "employees.csv")
+	if err != nil {
+		t.Fatalf("creating form file: %v", err)
+	}
+	part.Write([]byte(content))
+	form.Close()
+
+	req := newRequest(t, roleAdmin, "POST", "/employee/import", body.String())
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, respBody := send(t, app, req)
+	return resp.StatusCode, respBody
+}
+
+func TestImportCSV(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "all rows imported",
+			content:    "id,name,email,age,salary\n,Jane Doe,jane@example.com,28,60000\n,Sam Roe,sam@example.com,40,70000\n",
+			wantStatus: 200,
+			wantBody:   `{"imported":2,"failed":[]}`,
+		},
+		{
+			name:       "bad rows are reported",
+			content:    "id,name,email,age,salary\n,Jane Doe,jane@example.com,old,60000\n,,sam@example.com,40,70000\n,John Again,john@example.com,30,1\n,Sam Roe,sam@example.com,40,70000\n",
+			wantStatus: 200,
+			wantBody:   `{"imported":1,"failed":[{"row":2,"errors":{"age":"age must be a number"}},{"row":3,"errors":{"name":"name is required"}},{"row":4,"errors":{"email":"an employee with that email already exists"}}]}`,
+		},
+		{
+			name:       "wrong column count",
+			content:    "id,name,email,age,salary\n,Jane Doe,jane@example.com,28\n",
+			wantStatus: 400,
+			wantBody:   "malformed CSV on line 2",
+		},
+		{
+			name:       "wrong header",
+			content:    "name,email,age,salary,id\n",
+			wantStatus: 400,
+			wantBody:   "the header row must be id,name,email,age,salary",
+		},
+		{
+			name:       "empty file",
+			content:    "",
+			wantStatus: 400,
+			wantBody:   "the CSV file is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+			status, body := uploadCSV(t, app, tt.content)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", status, tt.wantStatus, body)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDocsAreServed(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+
+	status, body := request(t, app, "", "GET", "/docs/doc.json", "")
+	if status != 200 {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if !strings.Contains(body, `"/employee/{id}"`) {
+		t.Errorf("spec is missing the employee routes: %q", body)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	app := newTestApp(newFakeRepository(), newFakeDepartmentRepository())
+
+	// public, like the probes
+	status, body := request(t, app, "", "GET", "/version", "")
+	var info BuildInfo
+	if err := json.Unmarshal([]byte(body), &info); err != nil || status != 200 {
+		t.Fatalf("status = %d, body %q, err %v", status, body, err)
+	}
+	if info.GoVersion != runtime.Version() || info.Commit == "" || info.BuildTime == "" {
+		t.Errorf("got %+v, want the go version and a commit and build time, even if unknown", info)
+	}
+}
+
+func TestAPIVersioning(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+
+	resp, body := send(t, app, newRequest(t, roleViewer, "GET", "/api/v1/employee/"+johnID.Hex(), ""))
+	if resp.StatusCode != 200 || !strings.Contains(body, "John Doe") {
+		t.Errorf("versioned path: status = %d, body %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Deprecation") != "" {
+		t.Error("versioned path is marked deprecated")
+	}
+
+	resp, body = send(t, app, newRequest(t, roleViewer, "GET", "/employee/"+johnID.Hex(), ""))
+	if resp.StatusCode != 200 || !strings.Contains(body, "John Doe") {
+		t.Errorf("unversioned path: status = %d, body %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Deprecation"); got != "true" {
+		t.Errorf("Deprecation = %q, want true", got)
+	}
+	want := `</api/v1/employee/` + johnID.Hex() + `>; rel="successor-version"`
+	if got := resp.Header.Get("Link"); got != want {
+		t.Errorf("Link = %q, want %q", got, want)
+	}
+
+	// the probes aren't part of the versioned API
+	if resp, _ := send(t, app, newRequest(t, "", "GET", "/health", "")); resp.Header.Get("Deprecation") != "" {
+		t.Error("/health is marked deprecated")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	repo := newFakeRepository()
+	if err := seed(context.Background(), repo, 20, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.employees) != 20 {
+		t.Fatalf("seeded %d employees, want 20", len(repo.employees))
+	}
+	for _, employee := range repo.employees {
+		if errs := employee.validate(); len(errs) > 0 {
+			t.Errorf("seeded an invalid employee %+v: %v", employee, errs)
+		}
+	}
+
+	// seeding again is a no-op, unless it is forced
+	if err := seed(context.Background(), repo, 20, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.employees) != 20 {
+		t.Errorf("seeding a non-empty collection left %d employees, want 20", len(repo.employees))
+	}
+	if err := seed(context.Background(), repo, 5, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.employees) != 25 {
+		t.Errorf("forced seed left %d employees, want 25", len(repo.employees))
+	}
+}
+
+func TestEmployeeIDRoundTrips(t *testing.T) {
+	out, err := json.Marshal(john)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `"id":"`+johnID.Hex()+`"`) {
+		t.Errorf("JSON = %s, want the id as a hex string", out)
+	}
+	var fromJSON Employee
+	if err := json.Unmarshal(out, &fromJSON); err != nil || fromJSON.ID != johnID {
+		t.Errorf("decoding JSON: id = %v, err %v, want %v", fromJSON.ID, err, johnID)
+	}
+
+	// stored as an ObjectID, and left for mongo to generate when it is empty
+	raw, err := bson.Marshal(john)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := bson.Raw(raw).Lookup("_id").ObjectIDOK(); !ok || id != johnID {
+		t.Errorf("bson _id = %v, want the ObjectID %v", bson.Raw(raw).Lookup("_id"), johnID)
+	}
+	raw, err = bson.Marshal(Employee{Name: "New"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bson.Raw(raw).LookupErr("_id"); err == nil {
+		t.Error("an employee without an id was marshalled with one")
+	}
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		pingErr    error
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "reachable", wantStatus: 200, wantBody: `{"status":"ok"}`},
+		{name: "unreachable", pingErr: errors.New("server selection timeout"), wantStatus: 503, wantBody: `{"error":"server selection timeout","status":"unavailable"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newApp(testConfig(), Repositories{
+				Database:        fakePinger{err: tt.pingErr},
+				Employees:       newFakeRepository(),
+				Departments:     newFakeDepartmentRepository(),
+				Transactor:      fakeTransactor{},
+				IdempotencyKeys: newFakeIdempotencyRepository(),
+				AuditLogs:       newFakeAuditRepository(),
+				History:         newFakeHistoryRepository(),
+				LeaveRequests:   newFakeLeaveRepository(),
+				SalaryChanges:   newFakeSalaryChangeRepository(),
+				Attendance:      newFakeAttendanceRepository(),
+				Photos:          newFakePhotoRepository(),
+				Users:           newFakeUserRepository(),
+				RefreshTokens:   newFakeRefreshTokenRepository(),
+				Events:          newFakeEventPublisher(),
+			})
+			status, body := request(t, app, "", "GET", "/ready", "")
+			if status != tt.wantStatus || body != tt.wantBody {
+				t.Errorf("status = %d, body %q, want %d %q", status, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestValidationErrorDetails(t *testing.T) {
+	app := newTestApp(newFakeRepository(), newFakeDepartmentRepository())
+	status, body := request(t, app, roleAdmin, "POST", "/employee", `{"name":"","email":"jane@example.com","salary":-1.50,"age":900,"position":"`+strings.Repeat("x", 300)+`"}`)
+
+	var resp struct {
+		Error struct {
+			Details []FieldError `json:"details"`
+		} `json:"error"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&resp); err != nil || status != 422 {
+		t.Fatalf("status = %d, body %q", status, body)
+	}
+	var got []string
+	for _, f := range resp.Error.Details {
+		got = append(got, fmt.Sprintf("%s=%v", f.Field, f.Value))
+	}
+	// the fields in order, each with what was sent, the number as it was written
+	want := []string{"age=900", "name=", "position=" + strings.Repeat("x", maxFieldErrorValue) + "...", "salary=-1.50"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("details = %v, want %v", got, want)
+	}
+
+	if v := submittedValue("user.password", "hunter2"); v != nil {
+		t.Errorf("password value = %v, want it left out", v)
+	}
+	if v := submittedValue("tags", []interface{}{"a"}); v != nil {
+		t.Errorf("array value = %v, want it left out", v)
+	}
+}
+
+func TestRateLimitBehindProxy(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxies    []string
+		wantStatus int
+	}{
+		// the test requests come from 0.0.0.0
+		{name: "trusted proxy", proxies: []string{"0.0.0.0"}, wantStatus: 200},
+		{name: "untrusted proxy", proxies: []string{"10.0.0.0/8"}, wantStatus: 429},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.RateLimit = 1
+			cfg.ProxyHeader = "X-Forwarded-For"
+			cfg.TrustedProxies = tt.proxies
+			app := newApp(cfg, Repositories{
+				Database:        fakePinger{},
+				Employees:       newFakeRepository(john),
+				Departments:     newFakeDepartmentRepository(),
+				Transactor:      fakeTransactor{},
+				IdempotencyKeys: newFakeIdempotencyRepository(),
+				AuditLogs:       newFakeAuditRepository(),
+				History:         newFakeHistoryRepository(),
+				LeaveRequests:   newFakeLeaveRepository(),
+				SalaryChanges:   newFakeSalaryChangeRepository(),
+				Attendance:      newFakeAttendanceRepository(),
+				Photos:          newFakePhotoRepository(),
+				Users:           newFakeUserRepository(),
+				RefreshTokens:   newFakeRefreshTokenRepository(),
+				Events:          newFakeEventPublisher(),
+			})
+			get := func(clientIP string) int {
+				req := newRequest(t, roleViewer, "GET", "/employee", "")
+				req.Header.Set("X-Forwarded-For", clientIP+", 10.0.0.7")
+				resp, _ := send(t, app, req)
+				return resp.StatusCode
+			}
+
+			if status := get("203.0.113.1"); status != 200 {
+				t.Fatalf("first client: status = %d, want 200", status)
+			}
+			if status := get("203.0.113.1"); status != 429 {
+				t.Errorf("first client again: status = %d, want 429", status)
+			}
+			// another client behind a trusted proxy has a limit of their own,
+			// behind any other the header is ignored
+			if status := get("203.0.113.2"); status != tt.wantStatus {
+				t.Errorf("second client: status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// slowRepository takes its time over an employee, until the request is given up on
+type slowRepository struct {
+	*fakeRepository
+	delay time.Duration
+}
+
+func (r slowRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Employee, error) {
+	select {
+	case <-time.After(r.delay):
+		return r.fakeRepository.FindByID(ctx, id)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r slowRepository) Stream(ctx context.Context, filter bson.D, opts *options.FindOptions, fn func(*Employee) error) error {
+	time.Sleep(r.delay)
+	return r.fakeRepository.Stream(ctx, filter, opts, fn)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = 50 * time.Millisecond
+	app := newApp(cfg, Repositories{
+		Database:        fakePinger{},
+		Employees:       slowRepository{fakeRepository: newFakeRepository(john), delay: 200 * time.Millisecond},
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+
+	start := time.Now()
+	status, body := request(t, app, roleViewer, "GET", "/employee/"+johnID.Hex(), "")
+	if status != 504 || time.Since(start) >= 200*time.Millisecond {
+		t.Errorf("status = %d after %v, body %q, want a 504 once the request timed out", status, time.Since(start), body)
+	}
+
+	// the export is streamed after the handler returns, past the request's deadline
+	status, body = request(t, app, roleViewer, "GET", "/employee/export.csv", "")
+	if status != 200 || !strings.Contains(body, "John Doe") {
+		t.Errorf("export: status = %d, body %q, want the whole export", status, body)
+	}
+}
+
+// callsRepository takes delay over each call, within the deadline the
+// repositories give a single database call
+type callsRepository struct {
+	*fakeRepository
+	delay time.Duration
+}
+
+func (r callsRepository) wait(ctx context.Context) error {
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+	select {
+	case <-time.After(r.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r callsRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Employee, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.fakeRepository.FindByID(ctx, id)
+}
+
+func (r callsRepository) Patch(ctx context.Context, id primitive.ObjectID, fields bson.D) (*Employee, error) {
+	if err := r.wait(ctx); err != nil {
+		return nil, err
+	}
+	return r.fakeRepository.Patch(ctx, id, fields)
+}
+
+func TestRequestTimeoutOutlastsOperationTimeout(t *testing.T) {
+	t.Parallel()
+	// a PATCH reads the employee and then updates it, 3s each: more than the
+	// 5s of one call in total, but well within the request's 10s
+	cfg := testConfig()
+	cfg.RequestTimeout = 10 * time.Second
+	cfg.MongoOperationTimeout = 5 * time.Second
+	app := newApp(cfg, Repositories{
+		Database:        fakePinger{},
+		Employees:       callsRepository{fakeRepository: newFakeRepository(john), delay: 3 * time.Second},
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+
+	resp, body := send(t, app, newRequest(t, roleAdmin, "PATCH", "/employee/"+johnID.Hex(), `{"salary":51000}`))
+	if resp.StatusCode != 200 || !strings.Contains(body, `"salary":51000`) {
+		t.Errorf("status = %d, body %q, want the patch to finish", resp.StatusCode, body)
+	}
+}
+
+func TestOperationContext(t *testing.T) {
+	call, cancel := operationContext(context.Background())
+	if _, ok := call.Deadline(); ok {
+		t.Error("deadline without a limit on the context, want none")
+	}
+	cancel()
+
+	ctx := withOperationTimeout(context.Background(), time.Minute)
+	call, cancel = operationContext(ctx)
+	defer cancel()
+	if deadline, ok := call.Deadline(); !ok || time.Until(deadline) > time.Minute || time.Until(deadline) < 50*time.Second {
+		t.Errorf("deadline = %v, want a minute from now", deadline)
+	}
+
+	// a sooner deadline of the request's is kept
+	soon, cancelSoon := context.WithTimeout(ctx, time.Second)
+	defer cancelSoon()
+	call, cancel = operationContext(soon)
+	defer cancel()
+	if deadline, _ := call.Deadline(); time.Until(deadline) > time.Second {
+		t.Errorf("deadline = %v, want the request's, a second from now", deadline)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+
+	req := httptest.NewRequest("OPTIONS", "/employee", nil)
+	req.Header.Set("Origin", "https://hr.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization,Content-Type,Idempotency-Key")
+	resp, _ := send(t, app, req)
+	if allowed := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(allowed, "Idempotency-Key") || !strings.Contains(allowed, "If-Match") {
+		t.Errorf("allowed headers = %q, want Idempotency-Key and If-Match among them", allowed)
+	}
+
+	req = newRequest(t, roleViewer, "GET", "/employee/"+johnID.Hex(), "")
+	req.Header.Set("Origin", "https://hr.example.com")
+	resp, _ = send(t, app, req)
+	exposed := resp.Header.Get("Access-Control-Expose-Headers")
+	for _, header := range []string{"ETag", "Idempotent-Replayed", "Retry-After", "Deprecation", "Link"} {
+		if !strings.Contains(exposed, header) {
+			t.Errorf("exposed headers = %q, want %s among them", exposed, header)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// recordRevision adds the employee as it is after a change to its history. Like
+// the audit log, a failure is logged rather than failing a change that has
+// already been made
+func recordRevision(c *fiber.Ctx, history HistoryRepository, employee *Employee) {
+	revision := &EmployeeRevision{
+		EmployeeID: employee.ID,
+		Employee:   *employee,
+		RecordedAt: time.Now().UTC(),
+	}
+	if claims := currentClaims(c); claims != nil {
+		revision.ChangedBy = claims.Subject
+	}
+
+	if err := history.Record(c.UserContext(), revision); err != nil {
+		requestLog(c).Error("writing employee revision", slog.String("employee_id", employee.ID.Hex()), slog.Any("error", err))
+	}
+}
+
+// History returns the past states of an employee, oldest first: as it was
+// created with POST /employee or a CSV import, then after every PUT and PATCH.
+// Only the newest HISTORY_MAX_REVISIONS are kept
+//
+// @Summary Get the history of an employee
+// @Tags employees
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Success 200 {array} EmployeeRevision
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /employee/{id}/history [get]
+func (h *EmployeeHandler) History(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	revisions, err := h.history.FindByEmployee(c.UserContext(), employeeID)
+	if err != nil {
+		return err
+	}
+	// no history could just be an employee from before it was kept, so only
+	// answer 404 when the employee doesn't exist either
+	if len(revisions) == 0 {
+		if _, err := h.repo.FindByID(c.UserContext(), employeeID); err != nil {
+			return repositoryError(err, "employee")
+		}
+	}
+	return c.JSON(revisions)
+}
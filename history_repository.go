@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EmployeeRevision is the state of an employee after one change to it, who
+// made the change and when
+type EmployeeRevision struct {
+	ID         primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	EmployeeID primitive.ObjectID `json:"employeeId" bson:"employeeId"`
+	Employee   Employee           `json:"employee" bson:"employee"`
+	ChangedBy  string             `json:"changedBy" bson:"changedBy"`
+	RecordedAt time.Time          `json:"recordedAt" bson:"recordedAt"`
+}
+
+// HistoryRepository stores the revisions of each employee
+type HistoryRepository interface {
+	Record(ctx context.Context, revision *EmployeeRevision) error
+	// FindByEmployee returns the revisions of the employee, oldest first
+	FindByEmployee(ctx context.Context, employeeID primitive.ObjectID) ([]EmployeeRevision, error)
+	// DeleteByEmployee removes the employee's whole history, returning how many revisions it had
+	DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error)
+}
+
+// MongoHistoryRepository is the HistoryRepository backed by a mongo collection.
+// It keeps the newest maxRevisions revisions of each employee, zero keeps them all
+type MongoHistoryRepository struct {
+	collection   *mongo.Collection
+	maxRevisions int
+}
+
+// NewMongoHistoryRepository creates a repository storing the revisions in the
+// collection, trimming each employee's history to maxRevisions
+func NewMongoHistoryRepository(collection *mongo.Collection, maxRevisions int) *MongoHistoryRepository {
+	return &MongoHistoryRepository{collection: collection, maxRevisions: maxRevisions}
+}
+
+// EnsureIndexes creates the index the history of an employee is read with
+func (r *MongoHistoryRepository) EnsureIndexes(ctx context.Context) error {
+	return ensureIndexes(ctx, r.collection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "employeeId", Value: 1}, {Key: "recordedAt", Value: -1}},
+			Options: options.Index().SetName("employee_revisions"),
+		},
+	})
+}
+
+// Record adds the revision, then removes the employee's oldest revisions if
+// there are now more than maxRevisions
+func (r *MongoHistoryRepository) Record(ctx context.Context, revision *EmployeeRevision) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if _, err := r.collection.InsertOne(ctx, revision); err != nil {
+		return err
+	}
+	if r.maxRevisions <= 0 {
+		return nil
+	}
+
+	// everything past the newest maxRevisions is dropped
+	filter := bson.D{{Key: "employeeId", Value: revision.EmployeeID}}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "recordedAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(r.maxRevisions)).
+		SetProjection(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	var expired []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &expired); err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	ids := make(bson.A, len(expired))
+	for i, e := range expired {
+		ids[i] = e.ID
+	}
+	_, err = r.collection.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	return err
+}
+
+// FindByEmployee returns the revisions of the employee, oldest first
+func (r *MongoHistoryRepository) FindByEmployee(ctx context.Context, employeeID primitive.ObjectID) ([]EmployeeRevision, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	opts := options.Find().SetSort(bson.D{{Key: "recordedAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.D{{Key: "employeeId", Value: employeeID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]EmployeeRevision, 0)
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// DeleteByEmployee removes the employee's whole history
+func (r *MongoHistoryRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	result, err := r.collection.DeleteMany(ctx, bson.D{{Key: "employeeId", Value: employeeID}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeHistoryRepository is an in-memory HistoryRepository that keeps every revision
+type fakeHistoryRepository struct {
+	revisions []EmployeeRevision
+}
+
+func newFakeHistoryRepository() *fakeHistoryRepository {
+	return &fakeHistoryRepository{}
+}
+
+func (r *fakeHistoryRepository) Record(ctx context.Context, revision *EmployeeRevision) error {
+	r.revisions = append(r.revisions, *revision)
+	return nil
+}
+
+func (r *fakeHistoryRepository) FindByEmployee(ctx context.Context, employeeID primitive.ObjectID) ([]EmployeeRevision, error) {
+	revisions := make([]EmployeeRevision, 0)
+	for _, revision := range r.revisions {
+		if revision.EmployeeID == employeeID {
+			revisions = append(revisions, revision)
+		}
+	}
+	return revisions, nil
+}
+
+func (r *fakeHistoryRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	kept := r.revisions[:0]
+	for _, revision := range r.revisions {
+		if revision.EmployeeID != employeeID {
+			kept = append(kept, revision)
+		}
+	}
+	deleted := int64(len(r.revisions) - len(kept))
+	r.revisions = kept
+	return deleted, nil
+}
+
+func TestEmployeeHistory(t *testing.T) {
+	app := newTestApp(newFakeRepository(), newFakeDepartmentRepository())
+
+	status, body := request(t, app, roleAdmin, "POST", "/employee", `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`)
+	var created Employee
+	if err := json.Unmarshal([]byte(body), &created); err != nil || status != 201 {
+		t.Fatalf("create: status = %d, body %q", status, body)
+	}
+	path := "/employee/" + created.ID.Hex()
+
+	req := newRequest(t, roleAdmin, "PUT", path, `{"name":"Jane Doe","email":"jane@example.com","salary":65000,"age":28}`)
+	req.Header.Set("If-Match", "*")
+	if resp, body := send(t, app, req); resp.StatusCode != 200 {
+		t.Fatalf("put: status = %d, body %q", resp.StatusCode, body)
+	}
+	if status, body := request(t, app, roleAdmin, "PATCH", path, `{"salary":70000}`); status != 200 {
+		t.Fatalf("patch: status = %d, body %q", status, body)
+	}
+
+	status, body = request(t, app, roleViewer, "GET", path+"/history", "")
+	var revisions []EmployeeRevision
+	if err := json.Unmarshal([]byte(body), &revisions); err != nil || status != 200 {
+		t.Fatalf("history: status = %d, body %q", status, body)
+	}
+	var salaries []string
+	for _, revision := range revisions {
+		salaries = append(salaries, revision.Employee.Salary.String())
+		if revision.ChangedBy != "tester" || revision.RecordedAt.IsZero() {
+			t.Errorf("revision %+v is missing who made it or when", revision)
+		}
+	}
+	if strings.Join(salaries, ",") != "60000,65000,70000" {
+		t.Errorf("salaries = %v, want 60000, 65000 then 70000", salaries)
+	}
+
+	runHandlerTests(t, []handlerTest{
+		{name: "no history yet", role: roleViewer, method: "GET", path: "/employee/" + johnID.Hex() + "/history", wantStatus: 200, wantBody: "[]"},
+		{name: "unknown employee", method: "GET", path: "/employee/" + missingID + "/history", wantStatus: 404, wantBody: "employee not found"},
+		{name: "malformed id", method: "GET", path: "/employee/nope/history", wantStatus: 400},
+	})
+}
+
+func TestImportedEmployeeHistory(t *testing.T) {
+	repo := newFakeRepository()
+	app := newTestApp(repo, newFakeDepartmentRepository())
+
+	if status, body := uploadCSV(t, app, "id,name,email,age,salary\n,Jane Doe,jane@example.com,28,60000\n,,sam@example.com,40,70000\n"); status != 200 {
+		t.Fatalf("import: status = %d, body %q", status, body)
+	}
+	if len(repo.employees) != 1 {
+		t.Fatalf("imported %d employees, want 1", len(repo.employees))
+	}
+	for id := range repo.employees {
+		status, body := request(t, app, roleViewer, "GET", "/employee/"+id.Hex()+"/history", "")
+		var revisions []EmployeeRevision
+		if err := json.Unmarshal([]byte(body), &revisions); err != nil || status != 200 {
+			t.Fatalf("history: status = %d, body %q", status, body)
+		}
+		if len(revisions) != 1 || revisions[0].Employee.Email != "jane@example.com" || revisions[0].ChangedBy != "tester" {
+			t.Errorf("revisions = %+v, want the imported employee as it was created", revisions)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// the request header carrying the idempotency key, and the response header
+// set when a response is a replay
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	headerReplayed       = "Idempotent-Replayed"
+)
+
+// maxIdempotencyKeyLength keeps clients from storing arbitrarily large keys
+const maxIdempotencyKeyLength = 255
+
+// releaseTimeout bounds releasing a key after a failed request
+const releaseTimeout = 5 * time.Second
+
+// idempotency makes retrying a request with the same Idempotency-Key header
+// safe. The first request with a key runs as usual and its successful
+// response is stored, any later one with the key gets that response back
+// without running again. Requests without the header are not affected.
+// Failed requests don't keep their key, so the client can retry them. A key
+// only replays for the caller who used it, with the same request, anything
+// else sent with it is a 422
+func idempotency(store IdempotencyRepository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// the header is only valid until fasthttp reuses the request, and the
+		// key outlives it in the store
+		key := utils.CopyString(c.Get(headerIdempotencyKey))
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return fiber.NewError(fiber.StatusBadRequest, "Idempotency-Key can be at most 255 characters")
+		}
+
+		subject, hash := idempotencySubject(c), requestHash(c)
+		err := store.Reserve(c.UserContext(), key, subject, hash)
+		if errors.Is(err, ErrIdempotencyKeyExists) {
+			return replay(c, store, key, subject, hash)
+		}
+		if err != nil {
+			return err
+		}
+
+		// the key is ours, run the request and remember how it went
+		if err := c.Next(); err != nil {
+			releaseKey(c, store, key)
+			return err
+		}
+		status := c.Response().StatusCode()
+		if status < 200 || status > 299 {
+			releaseKey(c, store, key)
+			return nil
+		}
+
+		// the response body is reused by fasthttp once the request is done, so keep a copy
+		body := append([]byte(nil), c.Response().Body()...)
+		contentType := string(c.Response().Header.ContentType())
+		if err := store.Complete(c.UserContext(), key, status, contentType, body); err != nil {
+			// the employee was created, so the request still succeeded. A retry
+			// will be told the key is in use rather than creating a duplicate
+			requestLog(c).Error("storing idempotency key", slog.Any("error", err))
+		}
+		return nil
+	}
+}
+
+// replay answers a request whose key was already used with the stored
+// response, or 409 while the first request with the key is still running. The
+// key being reused by someone else or for another request is a 422
+func replay(c *fiber.Ctx, store IdempotencyRepository, key, subject, hash string) error {
+	record, err := store.Find(c.UserContext(), key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	// keys reserved before the request was kept have no hash, and are let through
+	if record != nil && record.RequestHash != "" && (record.Subject != subject || record.RequestHash != hash) {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "this Idempotency-Key was already used for a different request, use a new key")
+	}
+	// not found means it was released between our reserve and find
+	if record == nil || !record.Completed {
+		return fiber.NewError(fiber.StatusConflict, "a request with this Idempotency-Key is still being processed, retry later")
+	}
+
+	c.Set(headerReplayed, "true")
+	contentType := record.ContentType
+	if contentType == "" {
+		contentType = fiber.MIMEApplicationJSON
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.Status(record.Status).Send(record.Body)
+}
+
+// idempotencySubject is who the request is made by, the token's subject
+func idempotencySubject(c *fiber.Ctx) string {
+	if claims := currentClaims(c); claims != nil {
+		return claims.Subject
+	}
+	return ""
+}
+
+// requestHash fingerprints the method, path and body of the request, which
+// a retry with the same key has to repeat
+func requestHash(c *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Method() + " " + c.Path() + "\n"))
+	hash.Write(c.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// releaseKey forgets the key after a failed request, logging if that fails too.
+// It doesn't use the request context, whose deadline may be what failed the
+// request, but still shares the request's limit on the database calls at once
+func releaseKey(c *fiber.Ctx, store IdempotencyRepository, key string) {
+	ctx := withConcurrencyLimiter(context.Background(), concurrencyLimiterOf(c.UserContext()))
+	ctx, cancel := context.WithTimeout(ctx, releaseTimeout)
+	defer cancel()
+
+	if err := store.Release(ctx, key); err != nil {
+		requestLog(c).Error("releasing idempotency key", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// idempotencyKeyTTL is how long an Idempotency-Key is remembered for
+const idempotencyKeyTTL = 24 * time.Hour
+
+// ErrIdempotencyKeyExists is returned when reserving a key that is already taken
+var ErrIdempotencyKeyExists = errors.New("idempotency key already used")
+
+// IdempotencyRecord is a remembered Idempotency-Key. It is reserved before the
+// request runs and completed with the response once the request succeeded.
+// Subject and RequestHash tell whether a later request with the key is the
+// same one again
+type IdempotencyRecord struct {
+	Key         string    `bson:"_id"`
+	Subject     string    `bson:"subject,omitempty"`
+	RequestHash string    `bson:"requestHash,omitempty"`
+	Completed   bool      `bson:"completed"`
+	Status      int       `bson:"status,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt"`
+
+	// ContentType is empty for keys stored before it was kept, which were all JSON
+	ContentType string `bson:"contentType,omitempty"`
+}
+
+// IdempotencyRepository is everything the idempotency middleware needs from the key store
+type IdempotencyRepository interface {
+	Reserve(ctx context.Context, key, subject, requestHash string) error
+	Find(ctx context.Context, key string) (*IdempotencyRecord, error)
+	Complete(ctx context.Context, key string, status int, contentType string, body []byte) error
+	Release(ctx context.Context, key string) error
+}
+
+// MongoIdempotencyRepository is the IdempotencyRepository backed by a mongo collection
+type MongoIdempotencyRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoIdempotencyRepository creates a repository storing idempotency keys in the collection
+func NewMongoIdempotencyRepository(collection *mongo.Collection) *MongoIdempotencyRepository {
+	return &MongoIdempotencyRepository{collection: collection}
+}
+
+// EnsureIndexes creates the TTL index that has mongo delete keys once they are
+// older than idempotencyKeyTTL. Mongo removes expired documents in the
+// background about once a minute, so a key can outlive its TTL slightly
+func (r *MongoIdempotencyRepository) EnsureIndexes(ctx context.Context) error {
+	return ensureIndexes(ctx, r.collection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "createdAt", Value: 1}},
+			Options: options.Index().SetName("created_at_ttl").SetExpireAfterSeconds(int32(idempotencyKeyTTL.Seconds())),
+		},
+	})
+}
+
+// Reserve claims the key for a request that is about to run, by the subject and
+// with the hash, or returns ErrIdempotencyKeyExists when another request
+// already claimed it. The key is the _id, so two requests racing for the same
+// key can't both win
+func (r *MongoIdempotencyRepository) Reserve(ctx context.Context, key, subject, requestHash string) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	record := IdempotencyRecord{Key: key, Subject: subject, RequestHash: requestHash, CreatedAt: time.Now().UTC()}
+	_, err = r.collection.InsertOne(ctx, record)
+	return mapError(err, ErrIdempotencyKeyExists)
+}
+
+// Find returns the record of the key, or ErrNotFound
+func (r *MongoIdempotencyRepository) Find(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	record := new(IdempotencyRecord)
+	if err := r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: key}}).Decode(record); err != nil {
+		return nil, mapError(err, ErrIdempotencyKeyExists)
+	}
+	return record, nil
+}
+
+// Complete stores the response the request with the key was answered with, so it can be replayed
+func (r *MongoIdempotencyRepository) Complete(ctx context.Context, key string, status int, contentType string, body []byte) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "completed", Value: true},
+			{Key: "status", Value: status},
+			{Key: "body", Value: body},
+			{Key: "contentType", Value: contentType},
+		}},
+	}
+	_, err = r.collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: key}}, update)
+	return err
+}
+
+// Release forgets the key, so a request that failed can be retried with it
+func (r *MongoIdempotencyRepository) Release(ctx context.Context, key string) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	_, err = r.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: key}})
+	return err
+}
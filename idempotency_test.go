@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// fakeIdempotencyRepository is an in-memory IdempotencyRepository
+type fakeIdempotencyRepository struct {
+	records map[string]IdempotencyRecord
+}
+
+func newFakeIdempotencyRepository() *fakeIdempotencyRepository {
+	return &fakeIdempotencyRepository{records: make(map[string]IdempotencyRecord)}
+}
+
+func (r *fakeIdempotencyRepository) Reserve(ctx context.Context, key, subject, requestHash string) error {
+	if _, ok := r.records[key]; ok {
+		return ErrIdempotencyKeyExists
+	}
+	r.records[key] = IdempotencyRecord{Key: key, Subject: subject, RequestHash: requestHash}
+	return nil
+}
+
+func (r *fakeIdempotencyRepository) Find(ctx context.Context, key string) (*IdempotencyRecord, error) {
+	record, ok := r.records[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &record, nil
+}
+
+func (r *fakeIdempotencyRepository) Complete(ctx context.Context, key string, status int, contentType string, body []byte) error {
+	record := r.records[key]
+	record.Completed, record.Status, record.Body, record.ContentType = true, status, body, contentType
+	r.records[key] = record
+	return nil
+}
+
+func (r *fakeIdempotencyRepository) Release(ctx context.Context, key string) error {
+	delete(r.records, key)
+	return nil
+}
+
+func TestIdempotentCreate(t *testing.T) {
+	repo := newFakeRepository()
+	keys := newFakeIdempotencyRepository()
+	app := newApp(testConfig(), Repositories{
+		Employees:       repo,
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: keys,
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+
+	create := func(key, body string) (int, string, string) {
+		t.Helper()
+		req := newRequest(t, roleAdmin, "POST", "/employee", body)
+		req.Header.Set(headerIdempotencyKey, key)
+		resp, respBody := send(t, app, req)
+		return resp.StatusCode, respBody, resp.Header.Get(headerReplayed)
+	}
+
+	valid := `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`
+	status, first, _ := create("key-1", valid)
+	if status != 201 {
+		t.Fatalf("first create: status = %d, body %q", status, first)
+	}
+
+	// the retry gets the same response and nothing new is created
+	status, second, replayed := create("key-1", valid)
+	if status != 201 || second != first || replayed != "true" {
+		t.Errorf("replay: status = %d, replayed = %q, body %q, want 201 with %q", status, replayed, second, first)
+	}
+	// the key only replays the same request by the same caller
+	if status, body, _ := create("key-1", `{"name":"Sam Roe","email":"sam@example.com","salary":1,"age":30}`); status != 422 || !strings.Contains(body, "different request") {
+		t.Errorf("reused with another body: status = %d, body %q, want 422", status, body)
+	}
+	token, _, err := issueToken(testConfig(), "someone else", roleAdmin)
+	if err != nil {
+		t.Fatalf("issuing token: %v", err)
+	}
+	req := newRequest(t, "", "POST", "/employee", valid)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(headerIdempotencyKey, "key-1")
+	if resp, body := send(t, app, req); resp.StatusCode != 422 || resp.Header.Get(headerReplayed) != "" {
+		t.Errorf("reused by someone else: status = %d, body %q, want 422 without the replay", resp.StatusCode, body)
+	}
+	if len(repo.employees) != 1 {
+		t.Errorf("%d employees stored, want 1", len(repo.employees))
+	}
+
+	// a failed request doesn't keep its key, so it can be retried once fixed
+	if status, body, _ := create("key-2", `{"name":""}`); status != 422 {
+		t.Fatalf("invalid create: status = %d, body %q", status, body)
+	}
+	if _, ok := keys.records["key-2"]; ok {
+		t.Error("key of the failed request was kept")
+	}
+	if status, body, _ := create("key-2", `{"name":"Sam Roe","email":"sam@example.com","salary":1,"age":30}`); status != 201 {
+		t.Errorf("retried create: status = %d, body %q", status, body)
+	}
+
+	// a key still reserved by a running request is a conflict
+	keys.records["key-3"] = IdempotencyRecord{Key: "key-3"}
+	if status, body, _ := create("key-3", valid); status != 409 {
+		t.Errorf("in progress: status = %d, body %q, want 409", status, body)
+	}
+}
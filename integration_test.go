@@ -0,0 +1,956 @@
+//go:build integration
+
+package main
+
+// These tests run the app against a real MongoDB started in docker, so they
+// need a working docker daemon. Run them with:
+//
+//	go test -tags integration ./...
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	integrationDB             *MongoInstance
+	integrationRepo           *MongoEmployeeRepository
+	integrationDepartmentRepo *MongoDepartmentRepository
+)
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image: "mongo:6",
+			// a single node replica set, for the writes made in transactions
+			Cmd:          []string{"--replSet", "rs0"},
+			ExposedPorts: []string{"27017/tcp"},
+			WaitingFor:   wait.ForListeningPort("27017/tcp"),
+		},
+		Started: true,
+	})
+	if err != nil {
+		log.Fatalf("starting mongo container: %v", err)
+	}
+
+	code := func() int {
+		defer func() {
+			if err := container.Terminate(ctx); err != nil {
+				log.Printf("terminating mongo container: %v", err)
+			}
+		}()
+
+		host, err := container.Host(ctx)
+		if err != nil {
+			log.Printf("getting container host: %v", err)
+			return 1
+		}
+		port, err := container.MappedPort(ctx, "27017")
+		if err != nil {
+			log.Printf("getting container port: %v", err)
+			return 1
+		}
+
+		cfg := testConfig()
+		cfg.DBName = "fiber-hrms-test"
+		// the member only knows itself by its address inside the container, so
+		// the driver is kept from looking the replica set up
+		cfg.MongoURI = fmt.Sprintf("mongodb://%s:%s/%s?directConnection=true", host, port.Port(), cfg.DBName)
+		mg, err := Connect(cfg)
+		if err != nil {
+			log.Printf("connecting to mongo: %v", err)
+			return 1
+		}
+		defer mg.Client.Disconnect(ctx)
+		if err := initiateReplicaSet(ctx, mg); err != nil {
+			log.Printf("initiating the replica set: %v", err)
+			return 1
+		}
+		integrationDB = mg
+
+		integrationRepo = NewMongoEmployeeRepository(mg.Db.Collection(cfg.EmployeesCollection), nil)
+		integrationDepartmentRepo = NewMongoDepartmentRepository(mg.Db.Collection("departments"))
+		return m.Run()
+	}()
+	os.Exit(code)
+}
+
+// initiateReplicaSet turns the mongo started with --replSet into a replica set
+// of itself, and waits for it to become the primary
+func initiateReplicaSet(ctx context.Context, mg *MongoInstance) error {
+	admin := mg.Client.Database("admin")
+	config := bson.D{
+		{Key: "_id", Value: "rs0"},
+		{Key: "members", Value: bson.A{bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: "localhost:27017"}}}},
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "replSetInitiate", Value: config}}).Err(); err != nil {
+		return err
+	}
+	for i := 0; i < 60; i++ {
+		var hello struct {
+			IsWritablePrimary bool `bson:"isWritablePrimary"`
+		}
+		if err := admin.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+			return err
+		}
+		if hello.IsWritablePrimary {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return errors.New("the replica set has no primary after 30s")
+}
+
+// resetCollection gives each test empty collections, with their indexes
+func resetCollection(t *testing.T) {
+	t.Helper()
+
+	ctx := context.Background()
+	if err := integrationRepo.collection.Drop(ctx); err != nil {
+		t.Fatalf("dropping employees collection: %v", err)
+	}
+	if err := integrationDepartmentRepo.collection.Drop(ctx); err != nil {
+		t.Fatalf("dropping departments collection: %v", err)
+	}
+	if err := integrationRepo.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("creating indexes: %v", err)
+	}
+	if err := integrationDepartmentRepo.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("creating indexes: %v", err)
+	}
+}
+
+func newIntegrationApp() *fiber.App {
+	return newApp(testConfig(), integrationRepositories(integrationDB, integrationRepo, integrationDepartmentRepo))
+}
+
+// integrationRepositories are the mongo repositories on the database of mg
+func integrationRepositories(mg *MongoInstance, employees *MongoEmployeeRepository, departments *MongoDepartmentRepository) Repositories {
+	return Repositories{
+		Database:        mg,
+		Employees:       employees,
+		Departments:     departments,
+		Transactor:      NewMongoTransactor(mg.Client),
+		IdempotencyKeys: NewMongoIdempotencyRepository(mg.Db.Collection("idempotency_keys")),
+		AuditLogs:       NewMongoAuditRepository(mg.Db.Collection("audit_logs")),
+		History:         NewMongoHistoryRepository(mg.Db.Collection("employee_history"), 0),
+		LeaveRequests:   NewMongoLeaveRepository(mg.Db.Collection(leaveRequestsCollection)),
+		SalaryChanges:   NewMongoSalaryChangeRepository(mg.Db.Collection(salaryChangesCollection)),
+		Attendance:      NewMongoAttendanceRepository(mg.Db.Collection("attendance")),
+		Photos:          NewMongoPhotoRepository(mg.Db),
+		Users:           NewMongoUserRepository(mg.Db.Collection("users")),
+		RefreshTokens:   NewMongoRefreshTokenRepository(mg.Db.Collection("refresh_tokens")),
+		Events:          NewWebhooks(nil, ""),
+	}
+}
+
+func TestIntegrationEmployeeLifecycle(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	// create
+	status, body := request(t, app, roleAdmin, "POST", "/employee", `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`)
+	if status != 201 {
+		t.Fatalf("create: status = %d, body %q", status, body)
+	}
+	var created Employee
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatalf("decoding created employee: %v", err)
+	}
+	if created.ID.IsZero() {
+		t.Fatalf("created employee has no id: %q", body)
+	}
+	path := "/employee/" + created.ID.Hex()
+
+	// read it back, on its own and in the list
+	if status, body := request(t, app, roleViewer, "GET", path, ""); status != 200 {
+		t.Fatalf("get: status = %d, body %q", status, body)
+	}
+	status, body = request(t, app, roleViewer, "GET", "/employee?search=jane", "")
+	var list EmployeeList
+	if err := json.Unmarshal([]byte(body), &list); err != nil || status != 200 {
+		t.Fatalf("list: status = %d, body %q, err %v", status, body, err)
+	}
+	if list.Total != 1 || len(list.Data) != 1 || list.Data[0].ID != created.ID {
+		t.Fatalf("list: got %+v, want just the created employee", list)
+	}
+
+	// update everything, then just the salary
+	resp, _ := send(t, app, newRequest(t, roleViewer, "GET", path, ""))
+	etag := resp.Header.Get("ETag")
+	put := func() *http.Request {
+		req := newRequest(t, roleAdmin, "PUT", path, `{"name":"Jane Smith","email":"jane@example.com","salary":65000,"age":29}`)
+		req.Header.Set("If-Match", etag)
+		return req
+	}
+	if resp, body := send(t, app, put()); resp.StatusCode != 200 {
+		t.Fatalf("put: status = %d, body %q", resp.StatusCode, body)
+	}
+	// the tag is out of date now
+	if resp, body := send(t, app, put()); resp.StatusCode != 412 {
+		t.Fatalf("stale put: status = %d, body %q", resp.StatusCode, body)
+	}
+	status, body = request(t, app, roleAdmin, "PATCH", path, `{"salary":70000}`)
+	var patched Employee
+	if err := json.Unmarshal([]byte(body), &patched); err != nil || status != 200 {
+		t.Fatalf("patch: status = %d, body %q, err %v", status, body, err)
+	}
+	if patched.Name != "Jane Smith" || patched.Salary != MoneyFromInt(70000) {
+		t.Fatalf("patch: got %+v, want the put name with the patched salary", patched)
+	}
+
+	// delete, after which the employee is gone from the other routes
+	if status, body := request(t, app, roleAdmin, "DELETE", path+"?confirm=true", ""); status != 200 {
+		t.Fatalf("delete: status = %d, body %q", status, body)
+	}
+	if status, _ := request(t, app, roleViewer, "GET", path, ""); status != 404 {
+		t.Fatalf("get after delete: status = %d, want 404", status)
+	}
+	if status, _ := request(t, app, roleAdmin, "DELETE", path+"?confirm=true", ""); status != 404 {
+		t.Fatalf("second delete: status = %d, want 404", status)
+	}
+}
+
+func TestIntegrationDuplicateEmail(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	body := `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`
+	if status, resp := request(t, app, roleAdmin, "POST", "/employee", body); status != 201 {
+		t.Fatalf("first create: status = %d, body %q", status, resp)
+	}
+	if status, resp := request(t, app, roleAdmin, "POST", "/employee", body); status != 409 {
+		t.Fatalf("second create: status = %d, body %q, want 409", status, resp)
+	}
+}
+
+func TestIntegrationAddress(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	status, body := request(t, app, roleAdmin, "POST", "/employee", `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28,"address":{"street":"1 Main St","city":"Springfield","country":"US"}}`)
+	var created Employee
+	if err := json.Unmarshal([]byte(body), &created); err != nil || status != 201 {
+		t.Fatalf("create: status = %d, body %q, err %v", status, body, err)
+	}
+	path := "/employee/" + created.ID.Hex()
+
+	// the patched fields are set on their own, the street is kept
+	status, body = request(t, app, roleAdmin, "PATCH", path, `{"address":{"city":"Shelbyville","postalCode":"62565"}}`)
+	var patched Employee
+	if err := json.Unmarshal([]byte(body), &patched); err != nil || status != 200 {
+		t.Fatalf("patch: status = %d, body %q, err %v", status, body, err)
+	}
+	want := Address{Street: "1 Main St", City: "Shelbyville", PostalCode: "62565", Country: "US"}
+	if patched.Address == nil || *patched.Address != want {
+		t.Fatalf("patch: address = %+v, want %+v", patched.Address, want)
+	}
+
+	// a PUT without an address removes it, and a later patch starts a new one
+	req := newRequest(t, roleAdmin, "PUT", path, `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`)
+	req.Header.Set("If-Match", "*")
+	if resp, body := send(t, app, req); resp.StatusCode != 200 || strings.Contains(body, "address") {
+		t.Fatalf("put: status = %d, body %q, want the address gone", resp.StatusCode, body)
+	}
+	status, body = request(t, app, roleAdmin, "PATCH", path, `{"address":{"country":"DE"}}`)
+	if status != 200 || !strings.Contains(body, `"address":{"street":"","city":"","state":"","postalCode":"","country":"DE"}`) {
+		t.Fatalf("patch after put: status = %d, body %q", status, body)
+	}
+}
+
+func TestIntegrationLoginFlow(t *testing.T) {
+	ctx := context.Background()
+	users := NewMongoUserRepository(integrationDB.Db.Collection("users"))
+	tokens := NewMongoRefreshTokenRepository(integrationDB.Db.Collection("refresh_tokens"))
+	for _, drop := range []*mongo.Collection{users.collection, tokens.collection} {
+		if err := drop.Drop(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := users.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	specs, err := tokens.collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, spec := range specs {
+		names[spec.Name] = true
+	}
+	for _, name := range []string{"expires_at_ttl", "user_tokens"} {
+		if !names[name] {
+			t.Errorf("refresh token indexes = %v, want %s among them", names, name)
+		}
+	}
+	cfg := testConfig()
+	cfg.AdminUsername, cfg.AdminPassword = "admin", "s3cret"
+	// the second start finds the admin already there
+	for i := 0; i < 2; i++ {
+		if err := ensureAdminUser(ctx, cfg, users); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newIntegrationApp()
+
+	status, body := request(t, app, "", "POST", "/login", `{"username":"admin","password":"s3cret"}`)
+	var login TokenResponse
+	if err := json.Unmarshal([]byte(body), &login); err != nil || status != 200 {
+		t.Fatalf("login: status = %d, body %q", status, body)
+	}
+	refreshBody := `{"refreshToken":"` + login.RefreshToken + `"}`
+	if status, body := request(t, app, "", "POST", "/refresh", refreshBody); status != 200 {
+		t.Fatalf("refresh: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, "", "POST", "/logout", refreshBody); status != 204 {
+		t.Fatalf("logout: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, "", "POST", "/refresh", refreshBody); status != 401 {
+		t.Fatalf("refresh after logout: status = %d, body %q, want 401", status, body)
+	}
+
+	// users stored before the active flag existed can still log in
+	if _, err := users.collection.UpdateMany(ctx, bson.D{}, bson.D{{Key: "$unset", Value: bson.D{{Key: "active", Value: ""}}}}); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := users.FindByUsername(ctx, "admin")
+	if err != nil || !admin.Active {
+		t.Fatalf("admin = %+v, err %v, want an active user", admin, err)
+	}
+	if _, err := users.Deactivate(ctx, admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status, body := request(t, app, "", "POST", "/login", `{"username":"admin","password":"s3cret"}`); status != 401 {
+		t.Errorf("login once deactivated: status = %d, body %q, want 401", status, body)
+	}
+}
+
+func TestIntegrationLoginLockout(t *testing.T) {
+	ctx := context.Background()
+	users := NewMongoUserRepository(integrationDB.Db.Collection("users"))
+	if err := users.collection.Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	user, err := users.Create(ctx, &User{Username: "alice", PasswordHash: "hash", Role: roleViewer, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := users.RecordFailedLogin(ctx, user.ID, 2, time.Minute)
+	if err != nil || first.FailedLogins != 1 || first.LockedUntil != nil {
+		t.Fatalf("first failure: user = %+v, err %v, want one failure and no lockout", first, err)
+	}
+	second, err := users.RecordFailedLogin(ctx, user.ID, 2, time.Minute)
+	if err != nil || second.FailedLogins != 0 || !second.locked(time.Now()) {
+		t.Fatalf("second failure: user = %+v, err %v, want a lockout and the count started over", second, err)
+	}
+	// failures during the lockout aren't counted
+	if _, err := users.RecordFailedLogin(ctx, user.ID, 2, time.Minute); !errors.Is(err, ErrNotFound) {
+		t.Errorf("failure while locked: err = %v, want ErrNotFound", err)
+	}
+
+	if err := users.ResetFailedLogins(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if reset, err := users.FindByID(ctx, user.ID); err != nil || reset.FailedLogins != 0 || reset.LockedUntil != nil {
+		t.Errorf("after the reset: user = %+v, err %v, want no failures or lockout", reset, err)
+	}
+}
+
+func TestIntegrationIndexes(t *testing.T) {
+	resetCollection(t)
+
+	// resetCollection made them already, so this only finds them present
+	ctx := context.Background()
+	if err := integrationRepo.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ensuring the indexes again: %v", err)
+	}
+	specs, err := integrationRepo.collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, spec := range specs {
+		names[spec.Name] = true
+	}
+	for _, name := range []string{"email_unique_active", "manager", "department", "name", "salary", "age", "name_text"} {
+		if !names[name] {
+			t.Errorf("indexes = %v, want %s among them", names, name)
+		}
+	}
+}
+
+func TestIntegrationReusedEmail(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+	body := `{"name":"Ann Lee","email":"ann@example.com","salary":40000,"age":25}`
+
+	status, resp := request(t, app, roleAdmin, "POST", "/employee", body)
+	var ann Employee
+	if err := json.Unmarshal([]byte(resp), &ann); err != nil || status != 201 {
+		t.Fatalf("create: status = %d, body %q", status, resp)
+	}
+	if status, resp := request(t, app, roleAdmin, "POST", "/employee", body); status != 409 {
+		t.Fatalf("create with a taken email: status = %d, body %q, want 409", status, resp)
+	}
+
+	// once deleted, her email can be used again
+	if status, resp := request(t, app, roleAdmin, "DELETE", "/employee/"+ann.ID.Hex()+"?confirm=true", ""); status != 200 {
+		t.Fatalf("delete: status = %d, body %q", status, resp)
+	}
+	if status, resp := request(t, app, roleAdmin, "POST", "/employee", body); status != 201 {
+		t.Fatalf("create with a deleted employee's email: status = %d, body %q, want 201", status, resp)
+	}
+	// but she can't be restored while someone else has it
+	if status, resp := request(t, app, roleAdmin, "POST", "/employee/"+ann.ID.Hex()+"/restore", ""); status != 409 {
+		t.Errorf("restore with the email taken: status = %d, body %q, want 409", status, resp)
+	}
+}
+
+func TestIntegrationEmailIndexMigration(t *testing.T) {
+	resetCollection(t)
+	ctx := context.Background()
+	indexes := integrationRepo.collection.Indexes()
+
+	// the collection as it was before the partial index: the sparse one, and
+	// employees without deletedAt
+	if _, err := indexes.DropOne(ctx, "email_unique_active"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := indexes.CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetName("email_unique").SetUnique(true).SetSparse(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := integrationRepo.collection.InsertOne(ctx, bson.D{{Key: "name", Value: "Old Timer"}, {Key: "email", Value: "old@example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := integrationRepo.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	specs, err := indexes.ListSpecifications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, spec := range specs {
+		names[spec.Name] = true
+	}
+	if names["email_unique"] || !names["email_unique_active"] {
+		t.Errorf("indexes = %v, want email_unique replaced by email_unique_active", names)
+	}
+	// the old employee got a deletedAt, so the new index keeps their email unique
+	if status, resp := request(t, newIntegrationApp(), roleAdmin, "POST", "/employee", `{"name":"New Hire","email":"old@example.com","salary":1,"age":20}`); status != 409 {
+		t.Errorf("create with the old employee's email: status = %d, body %q, want 409", status, resp)
+	}
+}
+
+func TestIntegrationSearch(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	for _, body := range []string{
+		`{"name":"Ann Lee","email":"ann@example.com","salary":40000,"age":25}`,
+		`{"name":"Bob Ray","email":"bob@example.com","salary":90000,"age":50}`,
+		`{"name":"Cat Ode","email":"cat@example.com","salary":60000,"age":35}`,
+	} {
+		if status, resp := request(t, app, roleAdmin, "POST", "/employee", body); status != 201 {
+			t.Fatalf("create: status = %d, body %q", status, resp)
+		}
+	}
+
+	// the young or the well paid, highest salary first
+	search := `{"filter":{"or":[{"field":"age","op":"lt","value":30},{"field":"salary","op":"gte","value":80000}]},"sortBy":"salary","order":"desc"}`
+	status, body := request(t, app, roleViewer, "POST", "/employee/search", search)
+	var list EmployeeList
+	if err := json.Unmarshal([]byte(body), &list); err != nil || status != 200 {
+		t.Fatalf("search: status = %d, body %q, err %v", status, body, err)
+	}
+	if list.Total != 2 || len(list.Data) != 2 || list.Data[0].Name != "Bob Ray" || list.Data[1].Name != "Ann Lee" {
+		t.Fatalf("search: got %+v, want Bob then Ann", list)
+	}
+}
+
+func TestIntegrationHistoryIsTrimmed(t *testing.T) {
+	history := NewMongoHistoryRepository(integrationDB.Db.Collection("employee_history_trimmed"), 2)
+	ctx := context.Background()
+	if err := history.collection.Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	employeeID := primitive.NewObjectID()
+	start := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 4; i++ {
+		revision := &EmployeeRevision{
+			EmployeeID: employeeID,
+			Employee:   Employee{ID: employeeID, Salary: MoneyFromInt(int64(i))},
+			RecordedAt: start.Add(time.Duration(i) * time.Second),
+		}
+		if err := history.Record(ctx, revision); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err := history.FindByEmployee(ctx, employeeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Employee.Salary != MoneyFromInt(2) || revisions[1].Employee.Salary != MoneyFromInt(3) {
+		t.Fatalf("revisions = %+v, want the newest two, oldest first", revisions)
+	}
+}
+
+func TestIntegrationRaise(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	status, body := request(t, app, roleAdmin, "POST", "/employee", `{"name":"Ann Lee","email":"ann@example.com","salary":61234,"age":25}`)
+	var created Employee
+	if err := json.Unmarshal([]byte(body), &created); err != nil || status != 201 {
+		t.Fatalf("create: status = %d, body %q", status, body)
+	}
+	// a salary stored as a double before salaries were decimals
+	legacyID := primitive.NewObjectID()
+	_, err := integrationRepo.collection.InsertOne(context.Background(), bson.D{
+		{Key: "_id", Value: legacyID}, {Key: "name", Value: "Bob Ray"}, {Key: "email", Value: "bob@example.com"}, {Key: "salary", Value: 1234.57},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body = request(t, app, roleAdmin, "POST", "/employee/"+created.ID.Hex()+"/raise", `{"percent":3}`)
+	if status != 200 || !strings.Contains(body, `"salary":63071.02`) {
+		t.Fatalf("raise: status = %d, body %q, want a salary of 63071.02", status, body)
+	}
+
+	status, body = request(t, app, roleAdmin, "POST", "/employee/raise", `{"percent":10,"filter":{"field":"name","op":"eq","value":"Bob Ray"}}`)
+	if status != 200 || !strings.Contains(body, `"raised":1`) || !strings.Contains(body, `"salary":1358.03`) {
+		t.Fatalf("bulk raise: status = %d, body %q, want Bob's salary to be 1358.03", status, body)
+	}
+}
+
+func TestIntegrationPhoto(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+	photos := NewMongoPhotoRepository(integrationDB.Db)
+
+	status, body := request(t, app, roleAdmin, "POST", "/employee", `{"name":"Ann Lee","email":"ann@example.com","salary":40000,"age":25}`)
+	var created Employee
+	if err := json.Unmarshal([]byte(body), &created); err != nil || status != 201 {
+		t.Fatalf("create: status = %d, body %q", status, body)
+	}
+	path := "/employee/" + created.ID.Hex() + "/photo"
+
+	if status, _ := request(t, app, roleViewer, "GET", path, ""); status != 404 {
+		t.Fatalf("get before an upload: status = %d, want 404", status)
+	}
+	// the second upload replaces the first, leaving one file
+	pngPhoto := testImage(t, func(w *bytes.Buffer, m image.Image) error { return png.Encode(w, m) })
+	jpegPhoto := testImage(t, func(w *bytes.Buffer, m image.Image) error { return jpeg.Encode(w, m, nil) })
+	for _, photo := range [][]byte{pngPhoto, jpegPhoto} {
+		if status, body := uploadPhoto(t, app, roleAdmin, path, "photo", photo); status != 201 {
+			t.Fatalf("upload: status = %d, body %q", status, body)
+		}
+	}
+	resp, got := send(t, app, newRequest(t, roleViewer, "GET", path, ""))
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "image/jpeg" || got != string(jpegPhoto) {
+		t.Fatalf("get: status = %d, Content-Type %q, want the jpeg back", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	deleted, err := photos.DeleteByEmployee(context.Background(), created.ID)
+	if err != nil || deleted != 1 {
+		t.Fatalf("deleting: %d files, err %v, want the one", deleted, err)
+	}
+	if _, err := photos.Find(context.Background(), created.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("find after deleting: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestIntegrationKeysetPagination(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	// two of them earn the same, so the order between them comes from the id
+	for i, salary := range []int{40000, 90000, 60000, 60000, 75000} {
+		body := fmt.Sprintf(`{"name":"Employee %d","email":"e%d@example.com","salary":%d,"age":30}`, i, i, salary)
+		if status, resp := request(t, app, roleAdmin, "POST", "/employee", body); status != 201 {
+			t.Fatalf("create: status = %d, body %q", status, resp)
+		}
+	}
+
+	var salaries []string
+	path := "/employee?sortBy=salary&order=desc&limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages > 3 {
+			t.Fatalf("still paging after %d pages", pages)
+		}
+		status, body := request(t, app, roleViewer, "GET", path, "")
+		var page struct {
+			Data []Employee `json:"data"`
+			// the first page is an offset one, the rest come from its cursor
+			NextCursor *string `json:"nextCursor"`
+		}
+		if err := json.Unmarshal([]byte(body), &page); err != nil || status != 200 {
+			t.Fatalf("%s: status = %d, body %q", path, status, body)
+		}
+		for _, e := range page.Data {
+			salaries = append(salaries, e.Salary.String())
+		}
+		path = ""
+		if page.NextCursor != nil {
+			path = "/employee?sortBy=salary&order=desc&limit=2&after=" + *page.NextCursor
+		}
+	}
+	if got := strings.Join(salaries, ","); got != "90000,75000,60000,60000,40000" {
+		t.Errorf("salaries = %s, want every employee once, highest first", got)
+	}
+}
+
+func TestIntegrationExportResume(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	for _, name := range []string{"Cat Ode", "Ann Lee", "Bob Ray"} {
+		body := fmt.Sprintf(`{"name":%q,"email":"%s@example.com","salary":50000,"age":30}`, name, strings.ToLower(name[:3]))
+		if status, resp := request(t, app, roleAdmin, "POST", "/employee", body); status != 201 {
+			t.Fatalf("create: status = %d, body %q", status, resp)
+		}
+	}
+
+	// the first two rows were saved before the download broke off
+	status, body := request(t, app, roleViewer, "GET", "/employee/export.json?offset=2", "")
+	var rest []Employee
+	if err := json.Unmarshal([]byte(body), &rest); err != nil || status != 200 {
+		t.Fatalf("resume: status = %d, body %q", status, body)
+	}
+	if len(rest) != 1 || rest[0].Name != "Cat Ode" {
+		t.Errorf("resume: got %+v, want only the last employee by name", rest)
+	}
+}
+
+func TestIntegrationReports(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	create := func(name, manager string) string {
+		t.Helper()
+		body := fmt.Sprintf(`{"name":%q,"email":"%s@example.com","salary":50000,"age":30`, name, strings.ToLower(name))
+		if manager != "" {
+			body += `,"managerId":"` + manager + `"`
+		}
+		status, resp := request(t, app, roleAdmin, "POST", "/employee", body+"}")
+		var created Employee
+		if err := json.Unmarshal([]byte(resp), &created); err != nil || status != 201 {
+			t.Fatalf("create %s: status = %d, body %q", name, status, resp)
+		}
+		return created.ID.Hex()
+	}
+	ceo := create("Ceo", "")
+	vp := create("Vp", ceo)
+	lead := create("Lead", vp)
+	create("Designer", vp)
+	create("Dev", lead)
+
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{path: "/employee/" + vp + "/reports", want: "Designer:1,Lead:1"},
+		{path: "/employee/" + ceo + "/reports?all=true", want: "Vp:1,Designer:2,Lead:2,Dev:3"},
+	} {
+		status, body := request(t, app, roleViewer, "GET", tt.path, "")
+		var reports []Report
+		if err := json.Unmarshal([]byte(body), &reports); err != nil || status != 200 {
+			t.Fatalf("%s: status = %d, body %q", tt.path, status, body)
+		}
+		var got []string
+		for _, r := range reports {
+			got = append(got, fmt.Sprintf("%s:%d", r.Name, r.Level))
+		}
+		if strings.Join(got, ",") != tt.want {
+			t.Errorf("%s: reports = %v, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	// the ceo can't report to someone under them
+	status, body := request(t, app, roleAdmin, "PATCH", "/employee/"+ceo, `{"managerId":"`+lead+`"}`)
+	if status != 422 || !strings.Contains(body, "reports to employee") {
+		t.Errorf("cycle: status = %d, body %q, want 422", status, body)
+	}
+}
+
+func TestIntegrationBulkUpdate(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	for _, body := range []string{
+		`{"name":"Ann Lee","email":"ann@example.com","salary":40000,"age":25,"position":"Accountant"}`,
+		`{"name":"Bob Ray","email":"bob@example.com","salary":40000,"age":30,"position":"Accountant"}`,
+		`{"name":"Cat Poe","email":"cat@example.com","salary":40000,"age":35,"position":"Engineer"}`,
+	} {
+		if status, resp := request(t, app, roleAdmin, "POST", "/employee", body); status != 201 {
+			t.Fatalf("create: status = %d, body %q", status, resp)
+		}
+	}
+
+	status, body := request(t, app, roleAdmin, "POST", "/employee/bulk-update", `{"filter":{"field":"position","op":"eq","value":"Accountant"},"set":{"position":"Analyst"}}`)
+	if status != 200 || body != `{"matched":2,"modified":2}` {
+		t.Fatalf("bulk update: status = %d, body %q, want both accountants modified", status, body)
+	}
+	status, body = request(t, app, roleAdmin, "POST", "/employee/search", `{"filter":{"field":"position","op":"eq","value":"Analyst"}}`)
+	if status != 200 || !strings.Contains(body, `"total":2`) {
+		t.Errorf("search: status = %d, body %q, want 2 analysts", status, body)
+	}
+}
+
+func TestIntegrationDeleteTakesRelatedRecords(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	status, body := request(t, app, roleAdmin, "POST", "/employee", `{"name":"Ann Lee","email":"ann@example.com","salary":40000,"age":25}`)
+	var created Employee
+	if err := json.Unmarshal([]byte(body), &created); err != nil || status != 201 {
+		t.Fatalf("create: status = %d, body %q", status, body)
+	}
+	path := "/employee/" + created.ID.Hex()
+	request(t, app, roleAdmin, "POST", path+"/leave", `{"startDate":"2026-07-01T00:00:00Z","endDate":"2026-07-02T00:00:00Z","type":"sick"}`)
+	request(t, app, roleAdmin, "POST", path+"/checkin", "")
+	uploadPhoto(t, app, roleAdmin, path+"/photo", "photo", testImage(t, func(w *bytes.Buffer, m image.Image) error { return png.Encode(w, m) }))
+
+	status, body = request(t, app, roleAdmin, "DELETE", path+"?confirm=true", "")
+	if want := `{"employeeId":"` + created.ID.Hex() + `","leaveRequests":1,"attendance":1,"photos":1}`; status != 200 || body != want {
+		t.Fatalf("delete: status = %d, body %q, want %q", status, body, want)
+	}
+	if _, body := request(t, app, roleAdmin, "GET", "/leave?status=pending", ""); strings.Contains(body, created.ID.Hex()) {
+		t.Errorf("pending leave after the delete = %s, want Ann's hidden", body)
+	}
+
+	if status, body := request(t, app, roleAdmin, "POST", path+"/restore", ""); status != 200 {
+		t.Fatalf("restore: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, roleAdmin, "POST", path+"/checkout", ""); status != 200 {
+		t.Errorf("checking out after the restore: status = %d, body %q", status, body)
+	}
+	if status, _ := request(t, app, roleViewer, "GET", path+"/photo", ""); status != 200 {
+		t.Errorf("photo after the restore: status = %d, want 200", status)
+	}
+}
+
+// TestIntegrationStandalone runs the writes made in transactions against a
+// standalone server, like the default MONGO_URI, which has no transactions
+func TestIntegrationStandalone(t *testing.T) {
+	ctx := context.Background()
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "mongo:6",
+			ExposedPorts: []string{"27017/tcp"},
+			WaitingFor:   wait.ForListeningPort("27017/tcp"),
+		},
+		Started: true,
+	})
+	if err != nil {
+		t.Fatalf("starting mongo container: %v", err)
+	}
+	defer container.Terminate(ctx)
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := container.MappedPort(ctx, "27017")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.DBName = "fiber-hrms-standalone"
+	cfg.MongoURI = fmt.Sprintf("mongodb://%s:%s/%s", host, port.Port(), cfg.DBName)
+	mg, err := Connect(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mg.Client.Disconnect(ctx)
+	employees := NewMongoEmployeeRepository(mg.Db.Collection(cfg.EmployeesCollection), nil)
+	if err := employees.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	repos := integrationRepositories(mg, employees, NewMongoDepartmentRepository(mg.Db.Collection(departmentsCollection)))
+	app := newApp(cfg, repos)
+
+	status, body := request(t, app, roleAdmin, "POST", "/employee", `{"name":"Ann Lee","email":"ann@example.com","salary":40000,"age":25}`)
+	var created Employee
+	if err := json.Unmarshal([]byte(body), &created); err != nil || status != 201 {
+		t.Fatalf("create: status = %d, body %q", status, body)
+	}
+	path := "/employee/" + created.ID.Hex()
+	request(t, app, roleAdmin, "POST", path+"/leave", `{"startDate":"2026-07-01T00:00:00Z","endDate":"2026-07-02T00:00:00Z","type":"sick"}`)
+
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1)
+	if status, body := request(t, app, roleAdmin, "POST", path+"/salary-history", `{"newSalary":45000,"effectiveDate":"`+tomorrow.Format(dateLayout)+`"}`); status != 201 {
+		t.Fatalf("scheduling a salary change: status = %d, body %q", status, body)
+	}
+	if applied, err := applyDueSalaryChanges(withOperationTimeout(ctx, cfg.MongoOperationTimeout), repos, tomorrow.AddDate(0, 0, 1)); err != nil || applied != 1 {
+		t.Fatalf("applying salary changes: applied %d, error %v, want 1", applied, err)
+	}
+
+	status, body = request(t, app, roleAdmin, "DELETE", path+"?confirm=true", "")
+	if want := `{"employeeId":"` + created.ID.Hex() + `","leaveRequests":1,"attendance":0,"photos":0}`; status != 200 || body != want {
+		t.Fatalf("delete: status = %d, body %q, want %q", status, body, want)
+	}
+	if status, body := request(t, app, roleAdmin, "POST", path+"/restore", ""); status != 200 {
+		t.Fatalf("restore: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, roleAdmin, "POST", "/employee/batch-delete", `["`+created.ID.Hex()+`"]`); status != 200 {
+		t.Fatalf("batch delete: status = %d, body %q", status, body)
+	}
+	request(t, app, roleAdmin, "POST", path+"/restore", "")
+	if status, body := request(t, app, roleAdmin, "DELETE", path+"/purge?confirm=true", ""); status != 200 {
+		t.Errorf("purge: status = %d, body %q", status, body)
+	}
+}
+
+func TestIntegrationRecalculate(t *testing.T) {
+	resetCollection(t)
+	ctx := context.Background()
+	var ids []primitive.ObjectID
+	for _, email := range []string{"ann@example.com", "bob@example.com", "cat@example.com"} {
+		e, err := integrationRepo.Create(ctx, &Employee{Name: "Someone", Email: email, Salary: MoneyFromInt(40000), Age: 30})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.ID)
+	}
+
+	// bob is edited after being read, so his write is skipped
+	var batches []int64
+	err := integrationRepo.Recalculate(ctx, 2, func(e *Employee) bson.D {
+		if e.ID == ids[1] {
+			if _, err := integrationRepo.Patch(ctx, e.ID, bson.D{{Key: "position", Value: "Analyst"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return bson.D{{Key: "bonus", Value: MoneyFromInt(4000)}}
+	}, func(processed, updated int64) {
+		batches = append(batches, processed, updated)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(batches) != "[2 1 3 2]" {
+		t.Errorf("progress (processed, updated) = %v, want [2 1 3 2]", batches)
+	}
+	for i, id := range ids {
+		e, err := integrationRepo.FindByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := e.Bonus != nil, i != 1; got != want {
+			t.Errorf("employee %d has a bonus: %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestIntegrationSalaryChanges(t *testing.T) {
+	ctx := context.Background()
+	collection := integrationDB.Db.Collection(salaryChangesCollection)
+	if err := collection.Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewMongoSalaryChangeRepository(collection)
+	if err := repo.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	employeeID := primitive.NewObjectID()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	var ids []primitive.ObjectID
+	salary, _ := ParseMoney("61000.50")
+	for _, effective := range []time.Time{now.AddDate(0, 1, 0), now.Add(-time.Hour), now.AddDate(0, 0, -1)} {
+		change, err := repo.Create(ctx, &SalaryChange{EmployeeID: employeeID, NewSalary: salary, EffectiveDate: effective})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, change.ID)
+	}
+
+	due, err := repo.FindDue(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 || due[0].ID != ids[2] || due[1].ID != ids[1] || due[0].NewSalary.String() != "61000.5" {
+		t.Fatalf("due = %+v, want the two past changes, earliest first", due)
+	}
+	if err := repo.MarkApplied(ctx, ids[2], MoneyFromInt(50000), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.MarkApplied(ctx, ids[2], MoneyFromInt(50000), now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("applying twice: err = %v, want ErrNotFound", err)
+	}
+
+	timeline, err := repo.FindByEmployee(ctx, employeeID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline) != 3 || timeline[0].Status != salaryApplied || timeline[0].OldSalary == nil || timeline[0].OldSalary.String() != "50000" || timeline[2].Status != salaryScheduled {
+		t.Errorf("timeline = %+v, want the applied change first and the future one last", timeline)
+	}
+	if deleted, err := repo.DeleteByEmployee(ctx, employeeID); err != nil || deleted != 3 {
+		t.Errorf("deleted %d changes, err %v, want 3", deleted, err)
+	}
+}
+
+func TestIntegrationDistinct(t *testing.T) {
+	resetCollection(t)
+	app := newIntegrationApp()
+
+	for _, body := range []string{
+		`{"name":"Ann Lee","email":"ann@example.com","salary":40000,"age":25,"position":"Engineer"}`,
+		`{"name":"Bob Ray","email":"bob@example.com","salary":40000,"age":30,"position":"Accountant"}`,
+		`{"name":"Cat Poe","email":"cat@example.com","salary":40000,"age":35,"position":"Engineer"}`,
+		`{"name":"Dan Orr","email":"dan@example.com","salary":40000,"age":40}`,
+	} {
+		if status, resp := request(t, app, roleAdmin, "POST", "/employee", body); status != 201 {
+			t.Fatalf("create: status = %d, body %q", status, resp)
+		}
+	}
+
+	status, body := request(t, app, roleViewer, "GET", "/employee/distinct/position", "")
+	if status != 200 || body != `{"field":"position","values":["Accountant","Engineer"]}` {
+		t.Errorf("positions: status = %d, body %q", status, body)
+	}
+	status, body = request(t, app, roleViewer, "GET", "/employee/distinct/position?minAge=30", "")
+	if status != 200 || body != `{"field":"position","values":["Accountant","Engineer"]}` {
+		t.Errorf("positions of the over 30s: status = %d, body %q", status, body)
+	}
+	status, body = request(t, app, roleViewer, "GET", "/employee/distinct/position?maxAge=25", "")
+	if status != 200 || body != `{"field":"position","values":["Engineer"]}` {
+		t.Errorf("positions of the under 25s: status = %d, body %q", status, body)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// the states a job goes through. It is running until it either succeeds or fails
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// jobTypeBonus is the recalculation of every employee's bonus
+const jobTypeBonus = "bonus"
+
+// maxJobBatchSize is the most employees a recalculation reads and writes at a time
+const maxJobBatchSize = 10000
+
+// maxJobsKept is how many finished jobs are remembered for GET /jobs/{id}
+const maxJobsKept = 100
+
+// ErrJobRunning is returned when starting a job while another one is running
+var ErrJobRunning = errors.New("a recalculation is already running")
+
+// Job is a background job and how far it has got. Total is how many employees
+// there were when it started, Processed how many it has been through and
+// Updated how many were changed. Employees edited while the job ran are left
+// alone, so Updated can be below Processed
+type Job struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     string     `json:"status"`
+	Total      int64      `json:"total"`
+	Processed  int64      `json:"processed"`
+	Updated    int64      `json:"updated"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// RecalculateRequest is the body of POST /jobs/recalculate. BonusPercent is the
+// bonus as a percentage of the salary, e.g 10. Employees hired during Year
+// get that share of it, Year being the current one when it is left out.
+// BatchSize is how many employees are read and written at a time
+type RecalculateRequest struct {
+	BonusPercent *Money `json:"bonusPercent" swaggertype:"number"`
+	Year         int    `json:"year"`
+	BatchSize    int    `json:"batchSize"`
+}
+
+// validate checks the request fields and returns a map of field name to the
+// reason it failed
+func (r *RecalculateRequest) validate() map[string]string {
+	errs := make(map[string]string)
+
+	switch {
+	case r.BonusPercent == nil:
+		errs["bonusPercent"] = "bonusPercent is required"
+	case r.BonusPercent.Sign() < 0 || r.BonusPercent.Cmp(maxRaisePercent) > 0:
+		errs["bonusPercent"] = "bonusPercent must be between 0 and 100"
+	case r.BonusPercent.DecimalPlaces() > 2:
+		errs["bonusPercent"] = "bonusPercent can have at most 2 decimal places"
+	}
+	if r.Year != 0 && (r.Year < 1900 || r.Year > 9999) {
+		errs["year"] = "year must be between 1900 and 9999"
+	}
+	if r.BatchSize < 0 || r.BatchSize > maxJobBatchSize {
+		errs["batchSize"] = fmt.Sprintf("batchSize must be between 1 and %d", maxJobBatchSize)
+	}
+	return errs
+}
+
+// proratedBonus is percent of the employee's salary, cut down to the share of
+// the year they worked when they were hired during it and rounded to the
+// cent. Someone hired after the year gets nothing, and records without a hire
+// date get the whole bonus
+func proratedBonus(e *Employee, percent Money, year int) Money {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+
+	bonus, _ := new(big.Rat).SetString(e.Salary.String())
+	by, _ := new(big.Rat).SetString(percent.String())
+	bonus.Mul(bonus, by).Quo(bonus, big.NewRat(100, 1))
+	if hired := e.HireDate.UTC(); hired.After(start) {
+		// counted in whole days, the day they started included
+		day := 24 * time.Hour
+		worked := int64(end.Sub(hired.Truncate(day)) / day)
+		if worked < 0 {
+			worked = 0
+		}
+		bonus.Mul(bonus, big.NewRat(worked, int64(end.Sub(start)/day)))
+	}
+	amount, _ := ParseMoney(bonus.FloatString(2))
+	return amount
+}
+
+// jobRunner runs background jobs one at a time and keeps their state in
+// memory. So GET /jobs/{id} has to reach the instance that started the job,
+// and a job stops when that instance does
+type jobRunner struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	order []string
+	busy  bool
+
+	// ctx is cancelled when the server shuts down, stopping the running job
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newJobRunner runs the jobs with ctx, which carries the limits their database
+// calls get
+func newJobRunner(ctx context.Context) *jobRunner {
+	ctx, cancel := context.WithCancel(ctx)
+	return &jobRunner{jobs: make(map[string]*Job), ctx: ctx, cancel: cancel}
+}
+
+// start runs the job in the background unless one is running already, in
+// which case it returns ErrJobRunning. run is given a report func to record
+// its progress with
+func (r *jobRunner) start(jobType string, total int64, run func(ctx context.Context, report func(processed, updated int64)) error) (Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.busy {
+		return Job{}, ErrJobRunning
+	}
+	r.busy = true
+
+	job := &Job{ID: utils.UUIDv4(), Type: jobType, Status: jobRunning, Total: total, StartedAt: time.Now().UTC()}
+	r.jobs[job.ID] = job
+	r.order = append(r.order, job.ID)
+	// forget the oldest jobs, which have long finished with only one running at a time
+	for len(r.order) > maxJobsKept {
+		delete(r.jobs, r.order[0])
+		r.order = r.order[1:]
+	}
+
+	log := slog.Default().With(slog.String("job_id", job.ID), slog.String("job_type", jobType))
+	report := func(processed, updated int64) {
+		r.mu.Lock()
+		job.Processed, job.Updated = processed, updated
+		r.mu.Unlock()
+		log.Info("job progress", slog.Int64("processed", processed), slog.Int64("updated", updated), slog.Int64("total", total))
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		log.Info("job started", slog.Int64("total", total))
+		err := run(r.ctx, report)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		finished := time.Now().UTC()
+		job.FinishedAt = &finished
+		job.Status = jobSucceeded
+		if err != nil {
+			job.Status, job.Error = jobFailed, err.Error()
+			log.Error("job failed", slog.Int64("processed", job.Processed), slog.Any("error", err))
+		} else {
+			log.Info("job finished", slog.Int64("processed", job.Processed), slog.Int64("updated", job.Updated))
+		}
+		r.busy = false
+	}()
+	return *job, nil
+}
+
+// get returns a copy of the job with the id
+func (r *jobRunner) get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// close cancels the running job and waits for it to stop
+func (r *jobRunner) close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// recalculateHandler starts the bonus recalculation in the background and
+// answers straight away with the job, whose progress GET /jobs/{id} reports.
+// The employees are read and written BatchSize at a time, so they never all
+// have to be in memory
+//
+// @Summary Recalculate every employee's bonus
+// @Tags jobs
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param job body RecalculateRequest true "The bonus to work out"
+// @Success 202 {object} Job
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /jobs/recalculate [post]
+func recalculateHandler(cfg Config, repo EmployeeRepository, runner *jobRunner) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := new(RecalculateRequest)
+		if err := parseJSON(c, req); err != nil {
+			return err
+		}
+		if errs := req.validate(); len(errs) > 0 {
+			return newValidationError(errs)
+		}
+		if req.Year == 0 {
+			req.Year = time.Now().UTC().Year()
+		}
+		if req.BatchSize == 0 {
+			req.BatchSize = cfg.JobBatchSize
+		}
+
+		total, err := repo.Count(c.UserContext(), bson.D{notDeleted})
+		if err != nil {
+			return err
+		}
+		percent, year := *req.BonusPercent, req.Year
+		job, err := runner.start(jobTypeBonus, total, func(ctx context.Context, report func(processed, updated int64)) error {
+			return repo.Recalculate(ctx, req.BatchSize, func(e *Employee) bson.D {
+				bonus := proratedBonus(e, percent, year)
+				if e.Bonus != nil && e.Bonus.Cmp(bonus) == 0 {
+					return nil
+				}
+				return bson.D{{Key: "bonus", Value: bonus}}
+			}, report)
+		})
+		if errors.Is(err, ErrJobRunning) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(job)
+	}
+}
+
+// jobHandler reports how far a job has got
+//
+// @Summary Get a job's status
+// @Tags jobs
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Job id"
+// @Success 200 {object} Job
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /jobs/{id} [get]
+func jobHandler(runner *jobRunner) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		job, ok := runner.get(c.Params("id"))
+		if !ok {
+			return fiber.NewError(fiber.StatusNotFound, "job not found")
+		}
+		return c.JSON(job)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestProratedBonus(t *testing.T) {
+	ten := MoneyFromInt(10)
+	tests := []struct {
+		name  string
+		hired time.Time
+		want  string
+	}{
+		{name: "no hire date", want: "5000"},
+		{name: "hired before the year", hired: time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC), want: "5000"},
+		{name: "hired on the first day", hired: time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC), want: "5000"},
+		// 184 of 365 days, from the 1st of July
+		{name: "hired mid year", hired: time.Date(2023, time.July, 1, 9, 30, 0, 0, time.UTC), want: "2520.55"},
+		{name: "hired on the last day", hired: time.Date(2023, time.December, 31, 0, 0, 0, 0, time.UTC), want: "13.7"},
+		{name: "hired after the year", hired: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), want: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Employee{Salary: MoneyFromInt(50000), HireDate: tt.hired}
+			if got := proratedBonus(&e, ten, 2023).String(); got != tt.want {
+				t.Errorf("proratedBonus = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecalculateValidation(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "missing percent", method: "POST", path: "/jobs/recalculate", body: `{}`, wantStatus: 422, wantBody: "bonusPercent is required"},
+		{name: "negative percent", method: "POST", path: "/jobs/recalculate", body: `{"bonusPercent":-1}`, wantStatus: 422, wantBody: "between 0 and 100"},
+		{name: "too precise", method: "POST", path: "/jobs/recalculate", body: `{"bonusPercent":1.234}`, wantStatus: 422, wantBody: "at most 2 decimal places"},
+		{name: "bad year", method: "POST", path: "/jobs/recalculate", body: `{"bonusPercent":10,"year":23}`, wantStatus: 422, wantBody: "year must be between"},
+		{name: "huge batch", method: "POST", path: "/jobs/recalculate", body: `{"bonusPercent":10,"batchSize":100000}`, wantStatus: 422, wantBody: "batchSize must be between 1 and 10000"},
+		{name: "viewer forbidden", role: roleViewer, method: "POST", path: "/jobs/recalculate", body: `{"bonusPercent":10}`, wantStatus: 403},
+		{name: "unknown job", method: "GET", path: "/jobs/nope", wantStatus: 404, wantBody: "job not found"},
+	})
+}
+
+// blockingRepository holds recalculations back until release is closed, so a
+// test can look at a job while it is still running
+type blockingRepository struct {
+	*fakeRepository
+	release chan struct{}
+}
+
+func (r blockingRepository) Recalculate(ctx context.Context, batchSize int, transform func(*Employee) bson.D, progress func(processed, updated int64)) error {
+	<-r.release
+	return r.fakeRepository.Recalculate(ctx, batchSize, transform, progress)
+}
+
+func TestRecalculateJob(t *testing.T) {
+	hired := time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)
+	jane := Employee{ID: primitive.NewObjectID(), Name: "Jane Doe", Email: "jane@example.com", Salary: MoneyFromInt(50000), HireDate: hired}
+	gone := time.Now().UTC()
+	old := Employee{ID: primitive.NewObjectID(), Name: "Old Timer", Email: "old@example.com", Salary: MoneyFromInt(90000), DeletedAt: &gone}
+	repo := blockingRepository{fakeRepository: newFakeRepository(john, jane, old), release: make(chan struct{})}
+	app := newApp(testConfig(), Repositories{
+		Database:        fakePinger{},
+		Employees:       repo,
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+
+	status, body := request(t, app, roleAdmin, "POST", "/jobs/recalculate", `{"bonusPercent":10,"year":2023,"batchSize":1}`)
+	var job Job
+	if err := json.Unmarshal([]byte(body), &job); err != nil || status != 202 {
+		t.Fatalf("start: status = %d, body %q", status, body)
+	}
+	if job.Status != jobRunning || job.Type != jobTypeBonus || job.Total != 2 {
+		t.Errorf("started job = %+v, want a running bonus job over the 2 active employees", job)
+	}
+
+	// only one runs at a time
+	if status, body := request(t, app, roleAdmin, "POST", "/jobs/recalculate", `{"bonusPercent":5}`); status != 409 || !strings.Contains(body, "already running") {
+		t.Errorf("second start: status = %d, body %q, want 409", status, body)
+	}
+	if status, _ := request(t, app, roleViewer, "GET", "/jobs/"+job.ID, ""); status != 403 {
+		t.Errorf("status as a viewer: status = %d, want 403", status)
+	}
+
+	close(repo.release)
+	deadline := time.Now().Add(2 * time.Second)
+	for job.Status == jobRunning && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		_, body = request(t, app, roleAdmin, "GET", "/jobs/"+job.ID, "")
+		if err := json.Unmarshal([]byte(body), &job); err != nil {
+			t.Fatalf("status: body %q", body)
+		}
+	}
+	if job.Status != jobSucceeded || job.Processed != 2 || job.Updated != 2 || job.FinishedAt == nil {
+		t.Fatalf("finished job = %+v, want 2 processed and updated", job)
+	}
+
+	bonuses := map[primitive.ObjectID]string{}
+	for id, e := range repo.employees {
+		if e.Bonus != nil {
+			bonuses[id] = e.Bonus.String()
+		}
+	}
+	if bonuses[johnID] != "5000" || bonuses[jane.ID] != "2520.55" || bonuses[old.ID] != "" {
+		t.Errorf("bonuses = %v, want 5000 for john, 2520.55 for jane and none for the deleted employee", bonuses)
+	}
+	if status, body := request(t, app, roleAdmin, "GET", "/employee/"+johnID.Hex(), ""); status != 200 || !strings.Contains(body, `"bonus":5000`) {
+		t.Errorf("get: status = %d, body %q, want the bonus", status, body)
+	}
+}
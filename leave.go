@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the kinds of leave an employee can request
+const (
+	leaveAnnual   = "annual"
+	leaveSick     = "sick"
+	leaveParental = "parental"
+	leaveUnpaid   = "unpaid"
+)
+
+// a leave request starts out pending, and is then approved or rejected once
+const (
+	leavePending  = "pending"
+	leaveApproved = "approved"
+	leaveRejected = "rejected"
+)
+
+const maxLeaveReasonLength = 500
+
+var leaveTypes = map[string]bool{leaveAnnual: true, leaveSick: true, leaveParental: true, leaveUnpaid: true}
+
+var leaveStatuses = map[string]bool{leavePending: true, leaveApproved: true, leaveRejected: true}
+
+// LeaveRequest is an employee asking for time off from StartDate to EndDate,
+// both days included. ReviewedBy and ReviewedAt are set when it is approved or
+// rejected
+type LeaveRequest struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty" swaggertype:"string"`
+	EmployeeID primitive.ObjectID `json:"employeeId" bson:"employeeId" swaggertype:"string"`
+	StartDate  time.Time          `json:"startDate" bson:"startDate"`
+	EndDate    time.Time          `json:"endDate" bson:"endDate"`
+	Type       string             `json:"type"`
+	Status     string             `json:"status"`
+	Reason     string             `json:"reason"`
+	ReviewedBy string             `json:"reviewedBy,omitempty" bson:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time         `json:"reviewedAt,omitempty" bson:"reviewedAt,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt" bson:"updatedAt"`
+	// DeletedAt is set while the employee is deleted, which hides the request
+	DeletedAt *time.Time `json:"-" bson:"deletedAt,omitempty"`
+}
+
+// validate checks the fields the employee fills in and returns a map of field
+// name to the reason it failed. An empty map means the request is valid
+func (l *LeaveRequest) validate() map[string]string {
+	errs := make(map[string]string)
+
+	if l.StartDate.IsZero() {
+		errs["startDate"] = "startDate is required"
+	}
+	if l.EndDate.IsZero() {
+		errs["endDate"] = "endDate is required"
+	}
+	if !l.StartDate.IsZero() && l.EndDate.Before(l.StartDate) {
+		errs["endDate"] = "endDate can't be before startDate"
+	}
+	if !leaveTypes[l.Type] {
+		errs["type"] = "type must be one of annual, sick, parental or unpaid"
+	}
+	if len(l.Reason) > maxLeaveReasonLength {
+		errs["reason"] = fmt.Sprintf("reason can be at most %d characters", maxLeaveReasonLength)
+	}
+	return errs
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// LeaveHandler holds the HTTP handlers for leave requests. It needs the
+// employees to check a request is made for one that exists
+type LeaveHandler struct {
+	repo      LeaveRepository
+	employees EmployeeRepository
+	audit     AuditRepository
+}
+
+// NewLeaveHandler creates the leave handlers on top of the repositories. Every
+// change is recorded in the audit log
+func NewLeaveHandler(repo LeaveRepository, employees EmployeeRepository, audit AuditRepository) *LeaveHandler {
+	return &LeaveHandler{repo: repo, employees: employees, audit: audit}
+}
+
+// Create adds a pending leave request for an employee
+//
+// @Summary Request leave for an employee
+// @Tags leave
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Param leave body LeaveRequest true "The dates, type and reason of the leave"
+// @Success 201 {object} LeaveRequest
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /employee/{id}/leave [post]
+func (h *LeaveHandler) Create(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	leave := new(LeaveRequest)
+	if err := parseJSON(c, leave); err != nil {
+		return err
+	}
+	if errs := leave.validate(); len(errs) > 0 {
+		return newValidationError(errs)
+	}
+	if _, err := h.employees.FindByID(c.UserContext(), employeeID); err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	leave.EmployeeID = employeeID
+	createdLeave, err := h.repo.Create(c.UserContext(), leave)
+	if err != nil {
+		return err
+	}
+	recordAudit(c, h.audit, auditCreate, leaveRequestsCollection, createdLeave.ID.Hex(), nil, createdLeave)
+	return c.Status(201).JSON(createdLeave)
+}
+
+// ListForEmployee returns every leave request of an employee, earliest first
+//
+// @Summary List the leave requests of an employee
+// @Tags leave
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Success 200 {array} LeaveRequest
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /employee/{id}/leave [get]
+func (h *LeaveHandler) ListForEmployee(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.employees.FindByID(c.UserContext(), employeeID); err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	requests, err := h.repo.FindAll(c.UserContext(), bson.D{{Key: "employeeId", Value: employeeID}})
+	if err != nil {
+		return err
+	}
+	return c.JSON(requests)
+}
+
+// List returns the leave requests of every employee, earliest first. Managers
+// review the ones waiting for them with ?status=pending
+//
+// @Summary List leave requests
+// @Tags leave
+// @Produce json
+// @Security BearerAuth
+// @Param status query string false "Only requests with this status" Enums(pending, approved, rejected)
+// @Success 200 {array} LeaveRequest
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Router /leave [get]
+func (h *LeaveHandler) List(c *fiber.Ctx) error {
+	filter := bson.D{}
+	if status := c.Query("status"); status != "" {
+		if !leaveStatuses[status] {
+			return fiber.NewError(fiber.StatusBadRequest, "status must be pending, approved or rejected")
+		}
+		filter = append(filter, bson.E{Key: "status", Value: status})
+	}
+
+	requests, err := h.repo.FindAll(c.UserContext(), filter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(requests)
+}
+
+// Get returns a single leave request by id
+//
+// @Summary Get a leave request
+// @Tags leave
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Leave request id"
+// @Success 200 {object} LeaveRequest
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /leave/{id} [get]
+func (h *LeaveHandler) Get(c *fiber.Ctx) error {
+	leaveID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	leave, err := h.repo.FindByID(c.UserContext(), leaveID)
+	if err != nil {
+		return repositoryError(err, "leave request")
+	}
+	return c.JSON(leave)
+}
+
+// Approve approves a pending leave request
+//
+// @Summary Approve a leave request
+// @Tags leave
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Leave request id"
+// @Success 200 {object} LeaveRequest
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /leave/{id}/approve [post]
+func (h *LeaveHandler) Approve(c *fiber.Ctx) error {
+	return h.decide(c, leaveApproved)
+}
+
+// Reject rejects a pending leave request
+//
+// @Summary Reject a leave request
+// @Tags leave
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Leave request id"
+// @Success 200 {object} LeaveRequest
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /leave/{id}/reject [post]
+func (h *LeaveHandler) Reject(c *fiber.Ctx) error {
+	return h.decide(c, leaveRejected)
+}
+
+// decide moves the pending request in the path to the status, recording the
+// user from the token as its reviewer
+func (h *LeaveHandler) decide(c *fiber.Ctx, status string) error {
+	leaveID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	reviewer := ""
+	if claims := currentClaims(c); claims != nil {
+		reviewer = claims.Subject
+	}
+
+	before, _ := h.repo.FindByID(c.UserContext(), leaveID)
+	decided, err := h.repo.Decide(c.UserContext(), leaveID, status, reviewer)
+	if err != nil {
+		return repositoryError(err, "leave request")
+	}
+	recordAudit(c, h.audit, auditUpdate, leaveRequestsCollection, leaveID.Hex(), before, decided)
+	return c.JSON(decided)
+}
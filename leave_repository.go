@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrLeaveDecided is returned when approving or rejecting a leave request that
+// is no longer pending
+var ErrLeaveDecided = errors.New("the leave request has already been approved or rejected")
+
+// LeaveRepository is everything the handlers need from the leave request store
+type LeaveRepository interface {
+	// FindAll returns the requests matching the filter, earliest start first
+	FindAll(ctx context.Context, filter bson.D) ([]LeaveRequest, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*LeaveRequest, error)
+	Create(ctx context.Context, leave *LeaveRequest) (*LeaveRequest, error)
+	// Decide moves a pending request to the status, or fails with ErrLeaveDecided
+	Decide(ctx context.Context, id primitive.ObjectID, status, reviewer string) (*LeaveRequest, error)
+	// DeleteByEmployee removes all of the employee's leave requests, returning how many there were
+	DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error)
+	// SoftDeleteByEmployees hides the requests of the employees, who are being
+	// deleted, returning how many it hid
+	SoftDeleteByEmployees(ctx context.Context, employeeIDs []primitive.ObjectID) (int64, error)
+	// RestoreByEmployee brings back the requests of the employee, who is being
+	// restored, returning how many came back
+	RestoreByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error)
+}
+
+// MongoLeaveRepository is the LeaveRepository backed by a mongo collection
+type MongoLeaveRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoLeaveRepository creates a repository storing leave requests in the collection
+func NewMongoLeaveRepository(collection *mongo.Collection) *MongoLeaveRepository {
+	return &MongoLeaveRepository{collection: collection}
+}
+
+// EnsureIndexes creates the indexes an employee's requests and the requests
+// waiting for review are read with
+func (r *MongoLeaveRepository) EnsureIndexes(ctx context.Context) error {
+	return ensureIndexes(ctx, r.collection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "employeeId", Value: 1}, {Key: "startDate", Value: 1}},
+			Options: options.Index().SetName("employee_leave"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "startDate", Value: 1}},
+			Options: options.Index().SetName("status_leave"),
+		},
+	})
+}
+
+// FindAll returns the requests matching the filter, earliest start first. The
+// requests of deleted employees are left out
+func (r *MongoLeaveRepository) FindAll(ctx context.Context, filter bson.D) ([]LeaveRequest, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	opts := options.Find().SetSort(bson.D{{Key: "startDate", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, append(bson.D{notDeleted}, filter...), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]LeaveRequest, 0)
+	if err := cursor.All(ctx, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// FindByID returns the request with the id, or ErrNotFound
+func (r *MongoLeaveRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*LeaveRequest, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	leave := new(LeaveRequest)
+	if err := r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}, notDeleted}).Decode(leave); err != nil {
+		return nil, mapError(err, nil)
+	}
+	return leave, nil
+}
+
+// Create inserts the request as pending and returns the record as it was stored
+func (r *MongoLeaveRepository) Create(ctx context.Context, leave *LeaveRequest) (*LeaveRequest, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	now := time.Now().UTC()
+	leave.ID = primitive.NilObjectID
+	leave.Status = leavePending
+	leave.ReviewedBy = ""
+	leave.ReviewedAt = nil
+	leave.CreatedAt = now
+	leave.UpdatedAt = now
+
+	insertionResult, err := r.collection.InsertOne(ctx, leave)
+	if err != nil {
+		return nil, err
+	}
+
+	createdLeave := new(LeaveRequest)
+	filter := bson.D{{Key: "_id", Value: insertionResult.InsertedID}}
+	if err := r.collection.FindOne(ctx, filter).Decode(createdLeave); err != nil {
+		return nil, fmt.Errorf("reading back the created leave request: %w", err)
+	}
+	return createdLeave, nil
+}
+
+// Decide sets the status of a pending request and who reviewed it. The status
+// is part of the filter, so two reviewers deciding at once can't both succeed
+func (r *MongoLeaveRepository) Decide(ctx context.Context, id primitive.ObjectID, status, reviewer string) (*LeaveRequest, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	now := time.Now().UTC()
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "status", Value: leavePending}, notDeleted}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: status},
+			{Key: "reviewedBy", Value: reviewer},
+			{Key: "reviewedAt", Value: now},
+			{Key: "updatedAt", Value: now},
+		}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	decided := new(LeaveRequest)
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(decided)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// either there is no such request, or it isn't pending any more
+		exists, countErr := r.collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}, notDeleted})
+		if countErr != nil {
+			return nil, countErr
+		}
+		if exists > 0 {
+			return nil, ErrLeaveDecided
+		}
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decided, nil
+}
+
+// DeleteByEmployee removes all of the employee's leave requests
+func (r *MongoLeaveRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	result, err := r.collection.DeleteMany(ctx, bson.D{{Key: "employeeId", Value: employeeID}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// SoftDeleteByEmployees sets deletedAt on the employees' requests that don't
+// have it yet
+func (r *MongoLeaveRepository) SoftDeleteByEmployees(ctx context.Context, employeeIDs []primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	return softDeleteByEmployees(ctx, r.collection, employeeIDs)
+}
+
+// RestoreByEmployee unsets deletedAt on the employee's requests
+func (r *MongoLeaveRepository) RestoreByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	return restoreByEmployee(ctx, r.collection, employeeID)
+}
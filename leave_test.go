@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeLeaveRepository is an in-memory LeaveRepository. It only filters by the
+// exact employeeId and status matches the leave handlers build, and hides the
+// deleted requests like the real one
+type fakeLeaveRepository struct {
+	requests map[primitive.ObjectID]LeaveRequest
+}
+
+func newFakeLeaveRepository() *fakeLeaveRepository {
+	return &fakeLeaveRepository{requests: make(map[primitive.ObjectID]LeaveRequest)}
+}
+
+func (r *fakeLeaveRepository) FindAll(ctx context.Context, filter bson.D) ([]LeaveRequest, error) {
+	requests := make([]LeaveRequest, 0)
+	for _, l := range r.requests {
+		fields := map[string]interface{}{"employeeId": l.EmployeeID, "status": l.Status}
+		matches := l.DeletedAt == nil
+		for _, condition := range filter {
+			if fields[condition.Key] != condition.Value {
+				matches = false
+			}
+		}
+		if matches {
+			requests = append(requests, l)
+		}
+	}
+	return requests, nil
+}
+
+func (r *fakeLeaveRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*LeaveRequest, error) {
+	l, ok := r.requests[id]
+	if !ok || l.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	return &l, nil
+}
+
+func (r *fakeLeaveRepository) Create(ctx context.Context, leave *LeaveRequest) (*LeaveRequest, error) {
+	leave.ID = primitive.NewObjectID()
+	leave.Status = leavePending
+	r.requests[leave.ID] = *leave
+	return leave, nil
+}
+
+func (r *fakeLeaveRepository) Decide(ctx context.Context, id primitive.ObjectID, status, reviewer string) (*LeaveRequest, error) {
+	l, ok := r.requests[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if l.Status != leavePending {
+		return nil, ErrLeaveDecided
+	}
+	now := time.Now().UTC()
+	l.Status, l.ReviewedBy, l.ReviewedAt = status, reviewer, &now
+	r.requests[id] = l
+	return &l, nil
+}
+
+func (r *fakeLeaveRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	var deleted int64
+	for id, l := range r.requests {
+		if l.EmployeeID == employeeID {
+			delete(r.requests, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *fakeLeaveRepository) SoftDeleteByEmployees(ctx context.Context, employeeIDs []primitive.ObjectID) (int64, error) {
+	now := time.Now().UTC()
+	var deleted int64
+	for id, l := range r.requests {
+		if l.DeletedAt == nil && isOneOf(employeeIDs, l.EmployeeID) {
+			l.DeletedAt = &now
+			r.requests[id] = l
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *fakeLeaveRepository) RestoreByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	var restored int64
+	for id, l := range r.requests {
+		if l.DeletedAt != nil && l.EmployeeID == employeeID {
+			l.DeletedAt = nil
+			r.requests[id] = l
+			restored++
+		}
+	}
+	return restored, nil
+}
+
+// isOneOf reports whether id is one of ids
+func isOneOf(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLeaveRequests(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+	path := "/employee/" + johnID.Hex() + "/leave"
+
+	status, body := request(t, app, roleAdmin, "POST", path, `{"startDate":"2026-07-01T00:00:00Z","endDate":"2026-07-10T00:00:00Z","type":"annual","reason":"summer"}`)
+	var created LeaveRequest
+	if err := json.Unmarshal([]byte(body), &created); err != nil || status != 201 {
+		t.Fatalf("create: status = %d, body %q", status, body)
+	}
+	if created.Status != leavePending || created.EmployeeID != johnID {
+		t.Errorf("created request = %+v, want a pending request for john", created)
+	}
+	leavePath := "/leave/" + created.ID.Hex()
+
+	runHandlerTests(t, []handlerTest{
+		{name: "ends before it starts", method: "POST", path: path, body: `{"startDate":"2026-07-10T00:00:00Z","endDate":"2026-07-01T00:00:00Z","type":"annual"}`, wantStatus: 422, wantBody: "endDate can't be before startDate"},
+		{name: "unknown type", method: "POST", path: path, body: `{"startDate":"2026-07-01T00:00:00Z","endDate":"2026-07-01T00:00:00Z","type":"holiday"}`, wantStatus: 422, wantBody: "type must be one of"},
+		{name: "unknown employee", method: "POST", path: "/employee/" + missingID + "/leave", body: `{"startDate":"2026-07-01T00:00:00Z","endDate":"2026-07-01T00:00:00Z","type":"sick"}`, wantStatus: 404, wantBody: "employee not found"},
+		{name: "viewer can't request", role: roleViewer, method: "POST", path: path, body: `{"startDate":"2026-07-01T00:00:00Z","endDate":"2026-07-01T00:00:00Z","type":"sick"}`, wantStatus: 403},
+		{name: "bad status filter", method: "GET", path: "/leave?status=maybe", wantStatus: 400},
+		{name: "unknown request", method: "POST", path: "/leave/" + missingID + "/approve", wantStatus: 404, wantBody: "leave request not found"},
+	})
+
+	if status, body := request(t, app, roleViewer, "GET", "/leave?status=pending", ""); status != 200 || !containsID(body, created.ID) {
+		t.Errorf("pending requests: status = %d, body %q", status, body)
+	}
+	if status, _ := request(t, app, roleViewer, "POST", leavePath+"/approve", ""); status != 403 {
+		t.Errorf("viewer approving: status = %d, want 403", status)
+	}
+
+	status, body = request(t, app, roleAdmin, "POST", leavePath+"/approve", "")
+	var approved LeaveRequest
+	if err := json.Unmarshal([]byte(body), &approved); err != nil || status != 200 {
+		t.Fatalf("approve: status = %d, body %q", status, body)
+	}
+	if approved.Status != leaveApproved || approved.ReviewedBy != "tester" || approved.ReviewedAt == nil {
+		t.Errorf("approved request = %+v, want it approved by tester", approved)
+	}
+	if status, _ := request(t, app, roleAdmin, "POST", leavePath+"/reject", ""); status != 409 {
+		t.Errorf("rejecting an approved request: status = %d, want 409", status)
+	}
+
+	if _, body := request(t, app, roleViewer, "GET", "/leave?status=pending", ""); body != "[]" {
+		t.Errorf("pending requests after the approval: %s", body)
+	}
+	if _, body := request(t, app, roleViewer, "GET", path, ""); !containsID(body, created.ID) {
+		t.Errorf("john's requests: %s", body)
+	}
+}
+
+// containsID reports whether the JSON list of leave requests has one with the id
+func containsID(body string, id primitive.ObjectID) bool {
+	var requests []LeaveRequest
+	if err := json.Unmarshal([]byte(body), &requests); err != nil {
+		return false
+	}
+	for _, l := range requests {
+		if l.ID == id {
+			return true
+		}
+	}
+	return false
+}
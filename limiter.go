@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrDatabaseBusy is returned by the repositories when no slot for a database
+// operation frees up in time. The error handler answers it with a 503
+var ErrDatabaseBusy = errors.New("the server is too busy, try again shortly")
+
+// concurrencyLimiter caps how many database operations can run at once, so a
+// traffic spike queues here instead of every caller waiting on the mongo pool
+// and timing out together. The limiter goes on the context like the operation
+// timeout, and the repositories take a slot for each call they make, so the
+// requests, the salary scheduler and the jobs all share it. A streamed read,
+// like an export, holds its slot until its cursor is closed
+type concurrencyLimiter struct {
+	slots chan struct{}
+	// wait is how long an operation queues for a slot before it is turned away
+	wait time.Duration
+}
+
+// newConcurrencyLimiter lets max requests in at once, queueing the rest for up
+// to wait. A max of zero means no limit, and a nil limiter
+func newConcurrencyLimiter(max int, wait time.Duration) *concurrencyLimiter {
+	if max == 0 {
+		return nil
+	}
+	return &concurrencyLimiter{slots: make(chan struct{}, max), wait: wait}
+}
+
+// acquire takes a slot, queueing for up to the limiter's wait or until the
+// context is done. It reports whether it got one, which must then be released
+func (l *concurrencyLimiter) acquire(ctx context.Context) bool {
+	// a free slot is taken straight away, even with no wait allowed
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.wait == 0 {
+		return false
+	}
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+}
+
+// Middleware has the database calls of the request share the limit
+func (l *concurrencyLimiter) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.SetUserContext(withConcurrencyLimiter(c.UserContext(), l))
+		return c.Next()
+	}
+}
+
+// concurrencyLimiterKey holds the limiter the database calls made with the
+// context take their slots from
+type concurrencyLimiterKey struct{}
+
+// heldSlotKey marks a context that already holds a slot, so the calls a
+// repository makes within one of its own don't queue behind it
+type heldSlotKey struct{}
+
+// withConcurrencyLimiter has the database calls made with ctx take their slots
+// from l. A nil l means no limit
+func withConcurrencyLimiter(ctx context.Context, l *concurrencyLimiter) context.Context {
+	return context.WithValue(ctx, concurrencyLimiterKey{}, l)
+}
+
+// concurrencyLimiterOf is the limiter on ctx, or nil when there is none
+func concurrencyLimiterOf(ctx context.Context) *concurrencyLimiter {
+	l, _ := ctx.Value(concurrencyLimiterKey{}).(*concurrencyLimiter)
+	return l
+}
+
+// acquireSlot takes a slot from the limiter on ctx, queueing for it like
+// acquire, and returns ctx marked as holding it along with the func that
+// releases it. It fails with ErrDatabaseBusy when none frees up in time, or
+// with the context's error when it is done first. Without a limiter on ctx, or with a slot already held, there is nothing to
+// take
+func acquireSlot(ctx context.Context) (context.Context, func(), error) {
+	l := concurrencyLimiterOf(ctx)
+	if l == nil || ctx.Value(heldSlotKey{}) == true {
+		return ctx, func() {}, nil
+	}
+	if !l.acquire(ctx) {
+		if ctx.Err() != nil {
+			return ctx, func() {}, ctx.Err()
+		}
+		dbRequestsRejected.Inc()
+		return ctx, func() {}, ErrDatabaseBusy
+	}
+	dbRequestsInFlight.Inc()
+	var once sync.Once
+	return context.WithValue(ctx, heldSlotKey{}, true), func() {
+		once.Do(func() {
+			dbRequestsInFlight.Dec()
+			l.release()
+		})
+	}, nil
+}
+
+// startOperation begins a database call with ctx: it takes a slot with
+// acquireSlot and gives the call its deadline with operationContext. done
+// ends both, and must be called once the call is finished
+func startOperation(ctx context.Context) (context.Context, func(), error) {
+	ctx, release, err := acquireSlot(ctx)
+	if err != nil {
+		return ctx, release, err
+	}
+	ctx, cancel := operationContext(ctx)
+	return ctx, func() {
+		cancel()
+		release()
+	}, nil
+}
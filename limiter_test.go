@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	if newConcurrencyLimiter(0, time.Second) != nil {
+		t.Error("a max of zero made a limiter, want none")
+	}
+
+	// with no wait a full limiter turns requests away straight away
+	limiter := newConcurrencyLimiter(1, 0)
+	ctx := context.Background()
+	if !limiter.acquire(ctx) {
+		t.Fatal("first acquire failed")
+	}
+	if limiter.acquire(ctx) {
+		t.Fatal("second acquire got a slot, want none free")
+	}
+	limiter.release()
+	if !limiter.acquire(ctx) {
+		t.Fatal("acquire after the release failed")
+	}
+
+	// a queued request gets the slot when it is released in time
+	limiter.wait = time.Second
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		limiter.release()
+	}()
+	if !limiter.acquire(ctx) {
+		t.Error("queued acquire failed, want the released slot")
+	}
+
+	// and gives up when its context is done first
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	start := time.Now()
+	if limiter.acquire(cancelled) || time.Since(start) >= time.Second {
+		t.Errorf("acquire with a cancelled context took %v, want it to give up", time.Since(start))
+	}
+}
+
+func TestAcquireSlot(t *testing.T) {
+	ctx := withConcurrencyLimiter(context.Background(), newConcurrencyLimiter(1, 0))
+	held, release, err := acquireSlot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// calls made within the one holding the slot don't need another
+	if _, done, err := startOperation(held); err != nil {
+		t.Errorf("nested call: %v, want it to run in the held slot", err)
+	} else {
+		done()
+	}
+	if _, _, err := startOperation(ctx); !errors.Is(err, ErrDatabaseBusy) {
+		t.Errorf("second call = %v, want ErrDatabaseBusy", err)
+	}
+	// releasing twice only gives back the one slot
+	release()
+	release()
+	if _, done, err := startOperation(ctx); err != nil {
+		t.Errorf("call after the release: %v", err)
+	} else {
+		done()
+	}
+
+	// without a limiter there is nothing to wait for
+	if _, done, err := startOperation(context.Background()); err != nil {
+		t.Errorf("call without a limiter: %v", err)
+	} else {
+		done()
+	}
+}
+
+// limitedRepository takes a slot for each call like the mongo repositories
+// do, and holds it for delay
+type limitedRepository struct {
+	*fakeRepository
+	delay time.Duration
+}
+
+func (r limitedRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	time.Sleep(r.delay)
+	return r.fakeRepository.FindByID(ctx, id)
+}
+
+func (r limitedRepository) Stream(ctx context.Context, filter bson.D, opts *options.FindOptions, fn func(*Employee) error) error {
+	ctx, release, err := acquireSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	time.Sleep(r.delay)
+	return r.fakeRepository.Stream(ctx, filter, opts, fn)
+}
+
+// limitedApp serves employees from a limitedRepository, with a single slot
+// and no queueing
+func limitedApp() *fiber.App {
+	cfg := testConfig()
+	cfg.DBMaxInFlight, cfg.DBQueueTimeout = 1, 0
+	return newApp(cfg, Repositories{
+		Database:        fakePinger{},
+		Employees:       limitedRepository{fakeRepository: newFakeRepository(john), delay: 300 * time.Millisecond},
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+}
+
+// waitForSlotTaken waits until the only slot shows up in the metrics as taken
+func waitForSlotTaken(t *testing.T, app *fiber.App) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if _, body := request(t, app, "", "GET", metricsPath, ""); strings.Contains(body, "hrms_db_requests_in_flight 1") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the slow call never showed up in hrms_db_requests_in_flight")
+		}
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	app := limitedApp()
+
+	done := make(chan int)
+	go func() {
+		status, _ := request(t, app, roleViewer, "GET", "/employee/"+johnID.Hex(), "")
+		done <- status
+	}()
+	waitForSlotTaken(t, app)
+
+	// the versioned and the old paths share the slot
+	for _, path := range []string{"/employee/" + johnID.Hex(), apiV1Prefix + "/employee/" + johnID.Hex()} {
+		resp, body := send(t, app, newRequest(t, roleViewer, "GET", path, ""))
+		if resp.StatusCode != 503 || resp.Header.Get("Retry-After") != "1" || !strings.Contains(body, "too busy") {
+			t.Errorf("GET %s while full: status = %d, Retry-After %q, body %q, want a 503", path, resp.StatusCode, resp.Header.Get("Retry-After"), body)
+		}
+	}
+	// the probes don't use a slot
+	if status, _ := request(t, app, "", "GET", "/health", ""); status != 200 {
+		t.Errorf("health while full: status = %d, want 200", status)
+	}
+
+	if status := <-done; status != 200 {
+		t.Errorf("slow request: status = %d, want 200", status)
+	}
+	if status, _ := request(t, app, roleViewer, "GET", "/employee/"+johnID.Hex(), ""); status != 200 {
+		t.Errorf("GET once the slot is free: status = %d, want 200", status)
+	}
+	if _, body := request(t, app, "", "GET", metricsPath, ""); !strings.Contains(body, "hrms_db_requests_rejected_total") {
+		t.Error("metrics are missing hrms_db_requests_rejected_total")
+	}
+}
+
+func TestConcurrencyLimitExport(t *testing.T) {
+	app := limitedApp()
+
+	// the handler returns before the stream is written, the slot is held
+	// until it is
+	done := make(chan string)
+	go func() {
+		_, body := send(t, app, newRequest(t, roleViewer, "GET", "/employee/export.json", ""))
+		done <- body
+	}()
+	waitForSlotTaken(t, app)
+	if status, body := request(t, app, roleViewer, "GET", "/employee/"+johnID.Hex(), ""); status != 503 {
+		t.Errorf("GET during the export: status = %d, body %q, want a 503", status, body)
+	}
+	if body := <-done; !strings.Contains(body, "John Doe") {
+		t.Errorf("export = %q, want john in it", body)
+	}
+	if status, body := request(t, app, roleViewer, "GET", "/employee/"+johnID.Hex(), ""); status != 200 {
+		t.Errorf("GET after the export: status = %d, body %q", status, body)
+	}
+}
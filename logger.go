@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// the formats LOG_FORMAT can pick. JSON is for shipping logs to an
+// aggregator, text is for reading them in a terminal
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logLevels are the values LOG_LEVEL can be set to. Messages below the level are dropped
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// newLogger builds the logger for the configured format and level, writing to w
+func newLogger(cfg Config, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: logLevels[cfg.LogLevel]}
+	if cfg.LogFormat == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// requestLog is the logger for messages about a request, which tags them with
+// the request id, method, route and path. The route is the pattern that
+// matched, like /employee/:id, so the messages of one endpoint can be grouped
+func requestLog(c *fiber.Ctx) *slog.Logger {
+	return slog.Default().With(
+		slog.Any("request_id", c.Locals(requestIDKey)),
+		slog.String("method", c.Method()),
+		slog.String("route", c.Route().Path),
+		slog.String("path", c.Path()),
+	)
+}
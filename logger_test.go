@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var out bytes.Buffer
+	cfg := testConfig()
+	cfg.LogFormat, cfg.LogLevel = logFormatJSON, "warn"
+	logger := newLogger(cfg, &out)
+
+	logger.Info("dropped")
+	logger.Warn("kept", slog.String("employee_id", "42"))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %q, want just the warning", out.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("line %q is not JSON: %v", lines[0], err)
+	}
+	if entry["level"] != "WARN" || entry["msg"] != "kept" || entry["employee_id"] != "42" {
+		t.Errorf("entry = %v, want the warning with its fields", entry)
+	}
+
+	out.Reset()
+	cfg.LogFormat, cfg.LogLevel = logFormatText, "debug"
+	newLogger(cfg, &out).Debug("details", slog.Int("count", 3))
+	if got := out.String(); !strings.Contains(got, "level=DEBUG msg=details count=3") {
+		t.Errorf("text line = %q, want a key=value line at debug", got)
+	}
+}
+
+func TestRequestLogFields(t *testing.T) {
+	var out bytes.Buffer
+	cfg := testConfig()
+	cfg.LogFormat = logFormatJSON
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(newLogger(cfg, &out))
+
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+	req := newRequest(t, roleAdmin, "GET", "/employee/"+johnID.Hex(), "")
+	req.Header.Set("X-Request-ID", "req-123")
+	if resp, body := send(t, app, req); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %q", resp.StatusCode, body)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("request log %q is not one JSON line: %v", out.String(), err)
+	}
+	want := map[string]interface{}{
+		"msg":        "request",
+		"request_id": "req-123",
+		"method":     "GET",
+		"route":      "/employee/:id",
+		"path":       "/employee/" + johnID.Hex(),
+		"status":     float64(200),
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("%s = %v, want %v", key, entry[key], value)
+		}
+	}
+}
@@ -2,203 +2,549 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/gofiber/swagger"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	// the generated OpenAPI spec, served at /docs
+	_ "github.com/clinton-felix/golang-fibre-mongo-HRMS/docs"
 )
 
+// the API docs in docs/ are generated from the annotations on the handlers,
+// run "go generate" after changing them to keep the docs in sync
+//go:generate swag init --outputTypes go,json,yaml
+
 // creating a MongoDB struct instance
 type MongoInstance struct {
 	Client		*mongo.Client
 	Db			*mongo.Database
 }
 
-var mg MongoInstance
+// Ping checks the database can be reached, for the readiness probe
+func (mg *MongoInstance) Ping(ctx context.Context) error {
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+	return mg.Client.Ping(ctx, nil)
+}
 
-const (
-	dbName   = "fiber-hrms"
-	mongoURI = "mongodb://localhost:27017/" + dbName
-)
+// creating our connect function. Mongo is often still starting when the app
+// does (e.g. under docker-compose), so a failed attempt is retried up to
+// cfg.MongoConnectRetries times, waiting twice as long after each one. The
+// connection is handed back rather than kept in a global, so each caller
+// (the server, the tests) can have its own
+func Connect(cfg Config) (*MongoInstance, error) {
+	backoff := cfg.MongoRetryBackoff
+	var err error
+	for attempt := 1; attempt <= cfg.MongoConnectRetries+1; attempt++ {
+		var client *mongo.Client
+		client, err = connectOnce(cfg)
+		if err == nil {
+			// initializing mg struct
+			return &MongoInstance{
+				Client: client,
+				Db: client.Database(cfg.DBName),
+			}, nil
+		}
+		if attempt > cfg.MongoConnectRetries {
+			break
+		}
 
-// creating a struct instance for the employees of the company
-type Employee struct {
-	ID 			string		`json:"id,omitempty" bson:"_id,omitempty"`
-	Name 		string		`json:"name"`
-	Salary 		float64		`json:"salary"`
-	Age 		float64		`json:"age"`
+		slog.Warn("connecting to mongo failed, retrying",
+			slog.Int("attempt", attempt),
+			slog.Int("attempts", cfg.MongoConnectRetries+1),
+			slog.Duration("backoff", backoff),
+			slog.Any("error", err),
+		)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return nil, err
 }
 
-// creating our connect function
-func Connect() error {
-	client, err := mongo.NewClient(options.Client().ApplyURI(mongoURI))
-	// setting a timeout to exit blocking code after stipulated seconds
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// mongoClientOptions are the driver options for the configured connection. An
+// SRV URI is looked up in DNS here, so a bad one fails before connecting
+func mongoClientOptions(cfg Config) (*options.ClientOptions, error) {
+	clientOptions := options.Client().
+		ApplyURI(cfg.MongoURI).
+		SetMaxPoolSize(cfg.MongoMaxPoolSize).
+		SetMinPoolSize(cfg.MongoMinPoolSize).
+		SetConnectTimeout(cfg.MongoConnectTimeout).
+		SetPoolMonitor(poolMonitor())
+	// zero leaves the driver default of no socket timeout
+	if cfg.MongoSocketTimeout > 0 {
+		clientOptions.SetSocketTimeout(cfg.MongoSocketTimeout)
+	}
+	// set on the client rather than each database so transactions use it too.
+	// Without one the URI's, or the driver's acknowledged writes, apply
+	writeConcern, err := parseWriteConcern(cfg.MongoWriteConcern)
+	if err != nil {
+		return nil, err
+	}
+	if writeConcern != nil {
+		clientOptions.SetWriteConcern(writeConcern)
+	}
+	// the username and password from the env take over from any in the URI
+	if cfg.MongoUsername != "" {
+		clientOptions.SetAuth(options.Credential{
+			Username:   cfg.MongoUsername,
+			Password:   cfg.MongoPassword,
+			AuthSource: cfg.MongoAuthSource,
+		})
+	}
+	if cfg.MongoTLS || cfg.MongoTLSCAFile != "" {
+		tlsConfig, err := mongoTLSConfig(cfg.MongoTLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		clientOptions.SetTLSConfig(tlsConfig)
+	}
+	if err := clientOptions.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mongo settings: %w", err)
+	}
+	return clientOptions, nil
+}
 
-	// connecting now to the client using the right context
-	err = client.Connect(ctx)
-	db := client.Database(dbName)
+// mongoTLSConfig trusts the certificates in the PEM file at caFile, or the
+// system ones when it is empty
+func mongoTLSConfig(caFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return tlsConfig, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading MONGO_TLS_CA_FILE: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("MONGO_TLS_CA_FILE %s has no PEM certificates in it", caFile)
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
 
-	// handling errors
+// connectOnce makes a single attempt at connecting to mongo
+func connectOnce(cfg Config) (*mongo.Client, error) {
+	clientOptions, err := mongoClientOptions(cfg)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	client, err := mongo.NewClient(clientOptions)
+	// handling errors straight away, there is no client to work with if this failed
+	if err != nil {
+		return nil, fmt.Errorf("creating mongo client: %w", err)
+	}
+
+	// setting a timeout to exit blocking code after the configured connect timeout
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.MongoConnectTimeout)
+	defer cancel()
 
-	// initializing mg struct
-	mg = MongoInstance{
-		Client: client,
-		Db: db,
+	// connecting now to the client using the right context
+	if err := client.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("connecting to mongo: %w", err)
 	}
-	return nil
-}
 
-func main() {
-	// connect to the database first..
-	if err:= Connect() ; err != nil {
-		log.Fatal("Error: %v", err)
+	// Connect does not actually talk to the server, so ping it to make sure
+	// it is reachable and fail at startup rather than on the first request
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("pinging mongo: %w", err)
 	}
+	return client, nil
+}
 
+// Pinger is a database the readiness probe can check on
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
 
-	app := fiber.New()
-	collection := mg.Db.Collection("employees")
-	// using fibre handles the response and request using fibre.Ctx
-	// creating the get route
-	app.Get("/employee", func (c *fiber.Ctx) error {
-		// opening a connection with the Mongo DB database
-		query := bson.D{{}}
+// Repositories are the stores the routes are served from
+type Repositories struct {
+	// Database is only pinged, by /ready. Everything else goes through the repositories
+	Database    Pinger
+	Employees   EmployeeRepository
+	Departments DepartmentRepository
+	// Transactor makes the writes that touch several records atomic
+	Transactor      Transactor
+	IdempotencyKeys IdempotencyRepository
+	AuditLogs       AuditRepository
+	History         HistoryRepository
+	LeaveRequests   LeaveRepository
+	SalaryChanges   SalaryChangeRepository
+	Attendance      AttendanceRepository
+	Photos          PhotoRepository
+	Users           UserRepository
+	RefreshTokens   RefreshTokenRepository
+	// Events is told about every change to the employees, for the webhooks
+	Events EventPublisher
+}
 
-		// access the data of employees and capture the result in cursor
-		cursor, err := collection.Find(c.Context(), query)
-		if err != nil {
-			return c.Status(500).SendString(err.Error())
-		}
+// apiV1Prefix is where version 1 of the API is served
+const apiV1Prefix = "/api/v1"
 
-		// define an employee variable of type Employee and make it a slice
-		var employees []Employee = make([]Employee, 0)
+// newApp builds the fiber app with all of its middleware and routes. The
+// routes are served from the repositories that are passed in
+func newApp(cfg Config, repos Repositories) *fiber.App {
+	// every error is answered in the same JSON envelope by the error handler, including
+	// the 413 for a body over the limit, which fasthttp refuses before reading it all
+	app := fiber.New(fiber.Config{
+		ErrorHandler: newErrorHandler(cfg),
+		BodyLimit:    cfg.BodyLimit,
+		// c.IP() reads the client IP from the header only when the request came
+		// from a trusted proxy, and then takes the first valid IP in it
+		ProxyHeader:             cfg.ProxyHeader,
+		EnableTrustedProxyCheck: cfg.ProxyHeader != "",
+		TrustedProxies:          cfg.TrustedProxies,
+		EnableIPValidation:      true,
+	})
 
-		// format the data received in cursor and format them to be understandable by GoLang
-		if err := cursor.All(c.Context(), &employees) ; err != nil {
-			c.Status(500).SendString(err.Error())
-		}
-		// if all goes well, return employees. No need to marshal the json file because 
-		// fiber c client take care of it underhood
-		return c.JSON(employees)
+	// tag every request with an id, count it, then log it
+	app.Use(requestID())
+	app.Use(metrics())
+	app.Use(requestLogger())
+
+	// a panicking handler is answered with a 500 like any other error. This
+	// comes after the metrics and logger so they still see the request
+	app.Use(recoverPanics(cfg))
+
+	// let the frontend on other origins call the API
+	app.Use(corsHandler(cfg))
+
+	// compress the responses, outside the list cache so it keeps the plain bodies
+	app.Use(compressResponses(cfg))
+
+	// give up on requests that take too long, and on each database call of
+	// theirs that does
+	app.Use(requestTimeout(cfg.RequestTimeout))
+	app.Use(operationTimeout(cfg.MongoOperationTimeout))
+
+	// cap the database calls running at once, those of the requests and of the
+	// work done in the background alike
+	dbLimiter := newConcurrencyLimiter(cfg.DBMaxInFlight, cfg.DBQueueTimeout)
+	app.Use(dbLimiter.Middleware())
+	background := withConcurrencyLimiter(withOperationTimeout(context.Background(), cfg.MongoOperationTimeout), dbLimiter)
+
+	// cached list responses are thrown away whenever anything is changed
+	listCache := newResponseCache(cfg.ListCacheTTL)
+	app.Use(listCache.Invalidate())
+
+	// liveness probe, if the process can answer at all it is alive
+	app.Get("/health", func(c *fiber.Ctx) error {
+		return c.Status(200).JSON(fiber.Map{"status": "ok"})
 	})
 
-	// creating the post Route with FIber
-	app.Post("/employee", func(c *fiber.Ctx) error {
-		// creating a new employee variable
-		employee := new(Employee)
-		// this APi reads the incoming request from user(employee details being 
-		// added to the db). The Body Parser elps to also format the details into the struct template
-		if err:= c.BodyParser(employee) ; err != nil{
-			return c.Status(400).SendString(err.Error())
+	// readiness probe, we are only ready to serve traffic while mongo is reachable
+	app.Get("/ready", func(c *fiber.Ctx) error {
+		if err := repos.Database.Ping(c.UserContext()); err != nil {
+			return c.Status(503).JSON(fiber.Map{"status": "unavailable", "error": err.Error()})
 		}
+		return c.Status(200).JSON(fiber.Map{"status": "ok"})
+	})
 
-		// we want mongoDB to always create its own ids.
-		employee.ID = ""
-		insertionResult, err := collection.InsertOne(c.Context(), employee)
-		if err != nil {
-			return c.Status(500).SendString(err.Error())
-		}
+	// the build that is running, public like the probes
+	app.Get("/version", versionHandler())
+
+	// prometheus metrics, public like the probes so the scraper needs no token
+	app.Get(metricsPath, metricsHandler())
+
+	// interactive API docs, public like the probes
+	app.Get("/docs/*", swagger.HandlerDefault)
+
+	// reads and writes are throttled separately, writes with the stricter limit
+	readLimiter := rateLimiter(cfg.RateLimit, cfg.RateWindow)
+	writeLimiter := rateLimiter(cfg.WriteRateLimit, cfg.RateWindow)
 
-		/*
-			We will now use the mongo id of the just inserted result, captured in the insertion result
-			to search for the corresponding data to that ID instance, and then 
-			serve it to the FE. This makes us doubly sure that the data was inserted well.
-			1. Query the database using bson.D key value
-		*/
-		filter := bson.D{{Key: "_id", Value: insertionResult.InsertedID}}	// database query
-		createdRecord := collection.FindOne(c.Context(), filter)	// assign query result
-
-		// formatting the result to the fit the Employee struct instance
-		createdEmployee := new(Employee)		
-		createdRecord.Decode(createdEmployee)
-		
-		// serve the formatted result in JSON format to the front end
-		return c.Status(201).JSON(createdEmployee)
+	// in read-only mode every route that changes data is refused before its
+	// write limit. Logging in and out and turning the mode off again only
+	// touch the sessions and the mode, so they keep the limit without the guard
+	readOnly := newReadOnlyMode(cfg.ReadOnly)
+	sessionLimiter := writeLimiter
+	writeLimiter = readOnly.Guard(writeLimiter)
+
+	handler := NewEmployeeHandler(repos)
+	departmentHandler := NewDepartmentHandler(repos.Departments, repos.Employees, repos.Transactor, repos.AuditLogs)
+	leaveHandler := NewLeaveHandler(repos.LeaveRequests, repos.Employees, repos.AuditLogs)
+	salaryHandler := NewSalaryChangeHandler(repos.SalaryChanges, repos.Employees, repos.AuditLogs)
+	attendanceHandler := NewAttendanceHandler(repos.Attendance, repos.Employees, repos.AuditLogs)
+	photoHandler := NewPhotoHandler(repos.Photos, repos.Employees, repos.AuditLogs)
+	userHandler := NewUserHandler(repos.Users, repos.RefreshTokens, repos.AuditLogs)
+
+	// the jobs run in the background, and are stopped when the server shuts down
+	jobRunner := newJobRunner(background)
+	app.Hooks().OnShutdown(func() error {
+		jobRunner.close()
+		return nil
 	})
 
-	// PUT 
-	app.Put("/employee/:id", func(c *fiber.Ctx) error {
-		// capturing the id of the employee to be updated using c.Params
-		idParam := c.Params("id")
-		employeeID, err := primitive.ObjectIDFromHex(idParam)
-		if err != nil {
-			return c.SendStatus(400)
-		}
+	// due salary changes are applied in the background, except in read-only mode
+	if cfg.SalaryChangeInterval > 0 {
+		salaryScheduler := startSalaryScheduler(background, cfg.SalaryChangeInterval, repos, readOnly)
+		app.Hooks().OnShutdown(func() error {
+			salaryScheduler.close()
+			return nil
+		})
+	}
 
-		// get the data into the BodyParser using a variable Employee declaration
-		employee := new(Employee)
-		if err := c.BodyParser(employee) ; err != nil {
-			return c.Status(400).SendString(err.Error())
+	// mountAPI registers the API routes on the router, each one running the
+	// extra middleware first
+	mountAPI := func(router fiber.Router, extra ...fiber.Handler) {
+		chain := func(handlers ...fiber.Handler) []fiber.Handler {
+			return append(append([]fiber.Handler{}, extra...), handlers...)
 		}
 
-		/*
-			We will build a query with Id that will find the corresponding data to the ID
-			from the database, and will then replace the found data, with the new data captured
-			in employee above; thus updating the database with fresh data instance
-			
-			1. querying the database for the employee id in question, that needs updating
-			2. build an update query
-		*/
-
-		query := bson.D{{Key: "_id", Value: employeeID}}	// querying for the employee id
-		// building an update query using the $set
-		update := bson.D{
-			{Key: "$set",
-				Value: bson.D{
-					{Key: "name", Value: employee.Name},
-					{Key: "age", Value: employee.Age},
-					{Key: "salary", Value: employee.Salary},
-				},
-			},
-		}
+		// exchange credentials for a token. This uses the write limit to slow down password guessing
+		router.Post("/login", chain(sessionLimiter, loginHandler(cfg, repos.Users, repos.RefreshTokens))...)
+		router.Post("/refresh", chain(sessionLimiter, refreshHandler(cfg, repos.Users, repos.RefreshTokens))...)
+		router.Post("/logout", chain(sessionLimiter, logoutHandler(repos.RefreshTokens))...)
+
+		// every employee route needs a valid token, and the ones that change data
+		// are restricted to admins with RequireRole
+		employees := router.Group("/employee", chain(readLimiter, jwtMiddleware(cfg))...)
+		employees.Get("", listReads(), listCache.Conditional(), listCache.Middleware(), handler.List)
+		// registered before /:id so "count" and the exports aren't taken for ids
+		employees.Get("/count", listReads(), handler.Count)
+		employees.Get("/distinct/:field", listReads(), handler.Distinct)
+		employees.Get("/export.csv", listReads(), handler.ExportCSV)
+		employees.Get("/export.json", listReads(), handler.ExportJSON)
+		employees.Get("/:id", handler.Get)
+		employees.Get("/:id/history", handler.History)
+		employees.Get("/:id/leave", leaveHandler.ListForEmployee)
+		employees.Get("/:id/salary-history", salaryHandler.List)
+		employees.Get("/:id/attendance", attendanceHandler.Report)
+		employees.Get("/:id/photo", photoHandler.Get)
+		employees.Get("/:id/reports", handler.Reports)
+		// a search only reads, even though its filter is sent in a POST body
+		employees.Post("/search", listReads(), listCache.Keep(), handler.Search)
+		// a create retried with the same Idempotency-Key gets the first response back
+		employees.Post("", writeLimiter, RequireRole(roleAdmin), matchesSchema(employeeSchema), idempotency(repos.IdempotencyKeys), handler.Create)
+		employees.Post("/import", writeLimiter, RequireRole(roleAdmin), handler.ImportCSV)
+		employees.Post("/batch-delete", writeLimiter, RequireRole(roleAdmin), handler.BatchDelete)
+		employees.Post("/raise", writeLimiter, RequireRole(roleAdmin), handler.RaiseMany)
+		employees.Post("/bulk-update", writeLimiter, RequireRole(roleAdmin), handler.BulkUpdate)
+		employees.Put("/:id", writeLimiter, RequireRole(roleAdmin), matchesSchema(employeeSchema), handler.Update)
+		employees.Patch("/:id", writeLimiter, RequireRole(roleAdmin), handler.Patch)
+		employees.Delete("/:id", writeLimiter, RequireRole(roleAdmin), requireConfirm(), handler.Delete)
+		employees.Post("/:id/restore", writeLimiter, RequireRole(roleAdmin), handler.Restore)
+		employees.Post("/:id/raise", writeLimiter, RequireRole(roleAdmin), handler.Raise)
+		// unlike DELETE /:id this can't be undone, it is for erasure requests
+		employees.Delete("/:id/purge", writeLimiter, RequireRole(roleAdmin), requireConfirm(), purgeHandler(repos))
+		employees.Post("/:id/leave", writeLimiter, RequireRole(roleAdmin), leaveHandler.Create)
+		employees.Post("/:id/salary-history", writeLimiter, RequireRole(roleAdmin), salaryHandler.Schedule)
+		employees.Post("/:id/checkin", writeLimiter, RequireRole(roleAdmin), attendanceHandler.CheckIn)
+		employees.Post("/:id/checkout", writeLimiter, RequireRole(roleAdmin), attendanceHandler.CheckOut)
+		employees.Post("/:id/photo", writeLimiter, RequireRole(roleAdmin), photoHandler.Upload)
 
-		// update the database
-		err = collection.FindOneAndUpdate(c.Context(), query, update).Err()
-		// if there is an error, it means that the filter did not match documents
+		// salary analytics, readable by anyone who can read the employees
+		stats := router.Group("/stats", chain(readLimiter, jwtMiddleware(cfg))...)
+		stats.Get("/salary", listReads(), handler.SalaryStats)
+
+		// departments follow the same rules as the employees
+		departments := router.Group("/department", chain(readLimiter, jwtMiddleware(cfg))...)
+		departments.Get("", departmentHandler.List)
+		departments.Get("/:id", departmentHandler.Get)
+		departments.Get("/:id/employees", listReads(), departmentHandler.Employees)
+		departments.Post("", writeLimiter, RequireRole(roleAdmin), departmentHandler.Create)
+		departments.Put("/:id", writeLimiter, RequireRole(roleAdmin), departmentHandler.Update)
+		departments.Delete("/:id", writeLimiter, RequireRole(roleAdmin), departmentHandler.Delete)
+
+		// leave requests are reviewed by admins, who approve or reject each one once
+		leave := router.Group("/leave", chain(readLimiter, jwtMiddleware(cfg))...)
+		leave.Get("", leaveHandler.List)
+		leave.Get("/:id", leaveHandler.Get)
+		leave.Post("/:id/approve", writeLimiter, RequireRole(roleAdmin), leaveHandler.Approve)
+		leave.Post("/:id/reject", writeLimiter, RequireRole(roleAdmin), leaveHandler.Reject)
+
+		// only admins manage who can log in
+		users := router.Group("/user", chain(readLimiter, jwtMiddleware(cfg), RequireRole(roleAdmin))...)
+		users.Get("", userHandler.List)
+		users.Post("", writeLimiter, userHandler.Create)
+		users.Post("/:id/deactivate", writeLimiter, userHandler.Deactivate)
+		users.Put("/:id/password", writeLimiter, userHandler.ChangePassword)
+
+		// the background jobs rewrite every employee, so only admins run them
+		jobs := router.Group("/jobs", chain(readLimiter, jwtMiddleware(cfg), RequireRole(roleAdmin))...)
+		jobs.Post("/recalculate", writeLimiter, recalculateHandler(cfg, repos.Employees, jobRunner))
+		jobs.Get("/:id", jobHandler(jobRunner))
+
+		// only admins put the service in and out of read-only mode
+		router.Get("/read-only", chain(readLimiter, jwtMiddleware(cfg), RequireRole(roleAdmin), readOnlyHandler(readOnly))...)
+		router.Put("/read-only", chain(sessionLimiter, jwtMiddleware(cfg), RequireRole(roleAdmin), setReadOnlyHandler(readOnly))...)
+
+		// the audit log holds salaries and the like, so only admins can read it
+		router.Get("/audit", chain(readLimiter, jwtMiddleware(cfg), RequireRole(roleAdmin), auditHandler(repos.AuditLogs))...)
+	}
+
+	// the API lives under /api/v1. It is still served from the root too, for
+	// the clients that don't use the versioned paths yet, but those responses
+	// are marked deprecated and point at their versioned path
+	mountAPI(app.Group(apiV1Prefix))
+	mountAPI(app, deprecated(apiV1Prefix))
+
+	return app
+}
+
+// @title HRMS API
+// @version 1.0
+// @description Manage the employees and departments of the HR management system
+// @BasePath /api/v1
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Send "Bearer <token>", with a token from POST /login
+func main() {
+	// -seed fills an empty database with fake employees for local development, then exits
+	seedFlag := flag.Bool("seed", false, "insert fake employees into an empty database and exit")
+	seedCount := flag.Int("seed-count", 50, "how many fake employees -seed inserts")
+	force := flag.Bool("force", false, "with -seed, insert the employees even if there are some already")
+	flag.Parse()
+
+	// read the config from the environment, then connect to the database first..
+	cfg, err := LoadConfig()
+	if err != nil {
+		fatal("loading config", err)
+	}
+	// everything logged from here on, the log package included, goes through
+	// the configured format and level
+	slog.SetDefault(newLogger(cfg, os.Stderr))
+
+	mg, err := Connect(cfg)
+	if err != nil {
+		fatal("connecting to mongo", err)
+	}
+
+	listReadPreference, err := parseReadPreference(cfg.MongoReadPreference)
+	if err != nil {
+		fatal("parsing MONGO_READ_PREFERENCE", err)
+	}
+	repo := NewMongoEmployeeRepository(
+		mg.Db.Collection(cfg.EmployeesCollection),
+		mg.Db.Collection(cfg.EmployeesCollection, options.Collection().SetReadPreference(listReadPreference)),
+	)
+	departmentRepo := NewMongoDepartmentRepository(mg.Db.Collection(departmentsCollection))
+	idempotencyRepo := NewMongoIdempotencyRepository(mg.Db.Collection("idempotency_keys"))
+	auditRepo := NewMongoAuditRepository(mg.Db.Collection("audit_logs"))
+	historyRepo := NewMongoHistoryRepository(mg.Db.Collection("employee_history"), cfg.HistoryMaxRevisions)
+	leaveRepo := NewMongoLeaveRepository(mg.Db.Collection(leaveRequestsCollection))
+	salaryChangeRepo := NewMongoSalaryChangeRepository(mg.Db.Collection(salaryChangesCollection))
+	attendanceRepo := NewMongoAttendanceRepository(mg.Db.Collection(attendanceCollection))
+	photoRepo := NewMongoPhotoRepository(mg.Db)
+	userRepo := NewMongoUserRepository(mg.Db.Collection("users"))
+	refreshTokenRepo := NewMongoRefreshTokenRepository(mg.Db.Collection("refresh_tokens"))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err = repo.EnsureIndexes(ctx)
+	if err == nil {
+		err = departmentRepo.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = idempotencyRepo.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = auditRepo.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = historyRepo.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = leaveRepo.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = salaryChangeRepo.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = attendanceRepo.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = userRepo.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = refreshTokenRepo.EnsureIndexes(ctx)
+	}
+	if err == nil {
+		err = ensureAdminUser(ctx, cfg, userRepo)
+	}
+	cancel()
+	if err != nil {
+		fatal("preparing the database", err)
+	}
+
+	if *seedFlag {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := seed(ctx, repo, *seedCount, *force)
+		cancel()
 		if err != nil {
-			if err == mongo.ErrNoDocuments{
-				return c.SendStatus(400)		// Internal server error
-			}
-			return c.SendStatus(500)	// regular error
+			fatal("seeding the database", err)
 		}
-		employee.ID = idParam
-		return c.Status(200).JSON(employee)
+		_ = mg.Client.Disconnect(context.Background())
+		return
+	}
+
+	webhooks := NewWebhooks(cfg.WebhookURLs, cfg.WebhookSecret)
+	app := newApp(cfg, Repositories{
+		Database:        mg,
+		Employees:       repo,
+		Departments:     departmentRepo,
+		Transactor:      NewMongoTransactor(mg.Client),
+		IdempotencyKeys: idempotencyRepo,
+		AuditLogs:       auditRepo,
+		History:         historyRepo,
+		LeaveRequests:   leaveRepo,
+		SalaryChanges:   salaryChangeRepo,
+		Attendance:      attendanceRepo,
+		Photos:          photoRepo,
+		Users:           userRepo,
+		RefreshTokens:   refreshTokenRepo,
+		Events:          webhooks,
 	})
 
+	// shut the server down gracefully when the process is asked to stop, so
+	// in-flight requests get to finish and the mongo client is disconnected
+	shutdownComplete := make(chan struct{})
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
 
-	app.Delete("/employee/:id", func(c *fiber.Ctx) error {
-		// capturing the ID of the employer and handling errors
-		employeeID, err := primitive.ObjectIDFromHex(c.Params("id"))
-		if err != nil {
-			return c.Status(400).SendString(err.Error())
-		}
-		/*
-			Finding the corresp record for the ID just captured and delete
-			1. query the database
-			2. Use the Deleteone method of mongoInstance
-			3.
-		*/
-		query := bson.D{{ Key: "_id", Value: employeeID}}
-		result, err := collection.DeleteOne(c.Context(), &query)
-		if err != nil {
-			return c.SendStatus(500)		// return an internal server error
+		slog.Info("shutting down server")
+		if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
+			slog.Error("shutting down server", slog.Any("error", err))
 		}
 
-		// if the data did not get deleted, then it was most likely not found. Error 404
-		if result.DeletedCount < 1 {
-			return c.SendStatus(404)	// not Found Error
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		// no more requests can publish events, send off the ones still waiting
+		if err := webhooks.Close(ctx); err != nil {
+			slog.Error("delivering webhooks", slog.Any("error", err))
 		}
-		return c.Status(200).JSON("record deleted...")
-	})
+		if err := mg.Client.Disconnect(ctx); err != nil {
+			slog.Error("disconnecting from mongo", slog.Any("error", err))
+		}
+		close(shutdownComplete)
+	}()
+
+	// starting our server... Listen only returns once the server has been shut down
+	slog.Info("starting server", slog.String("addr", cfg.ListenAddr()), slog.String("env", cfg.Env))
+	if err := app.Listen(cfg.ListenAddr()); err != nil {
+		fatal("serving", err)
+	}
+
+	// wait for the cleanup to finish before exiting
+	<-shutdownComplete
+	slog.Info("server stopped")
+}
 
-	// starting our server...
-	log.Fatal(app.Listen(":3000"))
+// fatal logs the error that stopped the server from starting, and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
 }
\ No newline at end of file
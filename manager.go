@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Report is an employee somewhere under a manager. Level is how far down they
+// are, 1 for a direct report, 2 for a report of a direct report and so on
+type Report struct {
+	Employee `bson:",inline"`
+	Level    int `json:"level" bson:"level"`
+}
+
+// checkManager makes sure the manager the employees are being given exists and
+// isn't one of them or anyone under them, which would make the reporting lines
+// go round in a circle. It answers 422 when it is. There are no employees for
+// one that is still being created, who can't have anyone under them yet
+func (h *EmployeeHandler) checkManager(c *fiber.Ctx, managerID *primitive.ObjectID, employeeIDs ...primitive.ObjectID) error {
+	if managerID == nil {
+		return nil
+	}
+	employees := make(map[primitive.ObjectID]bool, len(employeeIDs))
+	for _, id := range employeeIDs {
+		employees[id] = true
+	}
+	if employees[*managerID] {
+		return newValidationError(map[string]string{"managerId": "an employee can't be their own manager"})
+	}
+
+	_, err := h.repo.FindByID(c.UserContext(), *managerID)
+	if errors.Is(err, ErrNotFound) {
+		return newValidationError(map[string]string{"managerId": "manager does not exist"})
+	}
+	if err != nil || len(employees) == 0 {
+		return err
+	}
+
+	chain, err := h.repo.ManagementChain(c.UserContext(), *managerID)
+	if err != nil {
+		return err
+	}
+	for _, id := range chain {
+		if employees[id] {
+			return newValidationError(map[string]string{"managerId": "the manager reports to employee " + id.Hex() + ", directly or through others"})
+		}
+	}
+	return nil
+}
+
+// Reports returns the employees who report to an employee. By default only
+// their direct reports, with ?all=true everyone under them, for drawing an org
+// chart. The reports come nearest first, then by name
+//
+// @Summary Get the reports of an employee
+// @Tags employees
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Param all query bool false "Include everyone under the employee, not only their direct reports"
+// @Success 200 {array} Report
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /employee/{id}/reports [get]
+func (h *EmployeeHandler) Reports(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.repo.FindByID(c.UserContext(), employeeID); err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	reports, err := h.repo.Reports(c.UserContext(), employeeID, c.Query("all") == "true")
+	if err != nil {
+		return err
+	}
+	return c.JSON(reports)
+}
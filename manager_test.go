@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// orgChart is ceo <- vp <- (lead, designer), lead <- dev, with a deleted
+// employee reporting to the ceo
+func orgChart() (ceo, vp, lead, designer, dev, gone Employee) {
+	ceo = Employee{ID: primitive.NewObjectID(), Name: "Ceo", Email: "ceo@example.com"}
+	vp = Employee{ID: primitive.NewObjectID(), Name: "Vp", Email: "vp@example.com", ManagerID: &ceo.ID}
+	lead = Employee{ID: primitive.NewObjectID(), Name: "Lead", Email: "lead@example.com", ManagerID: &vp.ID}
+	designer = Employee{ID: primitive.NewObjectID(), Name: "Designer", Email: "designer@example.com", ManagerID: &vp.ID}
+	dev = Employee{ID: primitive.NewObjectID(), Name: "Dev", Email: "dev@example.com", ManagerID: &lead.ID}
+	deletedAt := time.Now().UTC()
+	gone = Employee{ID: primitive.NewObjectID(), Name: "Gone", Email: "gone@example.com", ManagerID: &ceo.ID, DeletedAt: &deletedAt}
+	return
+}
+
+func TestReports(t *testing.T) {
+	ceo, vp, lead, designer, dev, gone := orgChart()
+	app := newTestApp(newFakeRepository(ceo, vp, lead, designer, dev, gone), newFakeDepartmentRepository())
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		want       []string
+	}{
+		{name: "direct reports", path: "/employee/" + vp.ID.Hex() + "/reports", wantStatus: 200, want: []string{"Designer:1", "Lead:1"}},
+		{name: "whole subtree", path: "/employee/" + ceo.ID.Hex() + "/reports?all=true", wantStatus: 200, want: []string{"Vp:1", "Designer:2", "Lead:2", "Dev:3"}},
+		{name: "no reports", path: "/employee/" + dev.ID.Hex() + "/reports", wantStatus: 200, want: []string{}},
+		{name: "unknown employee", path: "/employee/" + missingID + "/reports", wantStatus: 404},
+		{name: "malformed id", path: "/employee/nope/reports", wantStatus: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := request(t, app, roleViewer, "GET", tt.path, "")
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, body %q, want %d", status, body, tt.wantStatus)
+			}
+			if tt.want == nil {
+				return
+			}
+			var reports []Report
+			if err := json.Unmarshal([]byte(body), &reports); err != nil {
+				t.Fatalf("decoding %q: %v", body, err)
+			}
+			got := []string{}
+			for _, r := range reports {
+				got = append(got, r.Name+":"+strconv.Itoa(r.Level))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("reports = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManagerIsValidated(t *testing.T) {
+	ceo, vp, lead, _, dev, gone := orgChart()
+	employee := func(manager string) string {
+		return `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"managerId":"` + manager + `"}`
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "create with a manager", method: "POST", path: "/employee", body: employee(lead.ID.Hex()), wantStatus: 201, wantBody: `"managerId":"` + lead.ID.Hex() + `"`},
+		{name: "unknown manager", method: "POST", path: "/employee", body: employee(missingID), wantStatus: 422, wantBody: `"field":"managerId","message":"manager does not exist"`},
+		{name: "deleted manager", method: "POST", path: "/employee", body: employee(gone.ID.Hex()), wantStatus: 422, wantBody: `"field":"managerId","message":"manager does not exist"`},
+		{name: "own manager", method: "PATCH", path: "/employee/" + vp.ID.Hex(), body: `{"managerId":"` + vp.ID.Hex() + `"}`, wantStatus: 422, wantBody: "their own manager"},
+		{name: "direct report as manager", method: "PATCH", path: "/employee/" + vp.ID.Hex(), body: `{"managerId":"` + lead.ID.Hex() + `"}`, wantStatus: 422, wantBody: "reports to employee"},
+		{name: "report's report as manager", method: "PUT", path: "/employee/" + ceo.ID.Hex(), body: employee(dev.ID.Hex()), wantStatus: 422, wantBody: "reports to employee"},
+		{name: "move to another manager", method: "PATCH", path: "/employee/" + dev.ID.Hex(), body: `{"managerId":"` + ceo.ID.Hex() + `"}`, wantStatus: 200, wantBody: `"managerId":"` + ceo.ID.Hex() + `"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(newFakeRepository(ceo, vp, lead, dev, gone), newFakeDepartmentRepository())
+			req := newRequest(t, roleAdmin, tt.method, tt.path, tt.body)
+			req.Header.Set("If-Match", "*")
+			resp, body := send(t, app, req)
+			if resp.StatusCode != tt.wantStatus || !strings.Contains(body, tt.wantBody) {
+				t.Errorf("status = %d, body %q, want %d and %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
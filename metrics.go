@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// metricsPath is where prometheus scrapes the metrics from
+const metricsPath = "/metrics"
+
+// the metrics the app exports. They are registered with the default
+// prometheus registry, which also carries the go runtime and process metrics
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests answered, by route and status. Error rates come from the 4xx and 5xx statuses",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "How long HTTP requests took to answer, by route",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	mongoConnectionsOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mongo_pool_connections_open",
+		Help: "Connections open in the mongo connection pool",
+	})
+
+	mongoConnectionsInUse = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mongo_pool_connections_in_use",
+		Help: "Connections of the mongo connection pool checked out by an operation",
+	})
+
+	dbRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "hrms_db_requests_in_flight",
+		Help: "Database operations holding one of the DB_MAX_IN_FLIGHT slots, streamed reads for as long as their cursor is open",
+	})
+
+	dbRequestsRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hrms_db_requests_rejected_total",
+		Help: "Database operations turned away because no slot freed up within DB_QUEUE_TIMEOUT",
+	})
+
+	employeeChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hrms_employee_changes_total",
+		Help: "Employees created, updated, deleted and purged",
+	}, []string{"action"})
+)
+
+// the actions employeeChanges counts
+const (
+	actionCreated = "created"
+	actionUpdated = "updated"
+	actionDeleted = "deleted"
+	actionPurged  = "purged"
+)
+
+// metrics records the count and latency of every request except the scrapes
+// themselves. Requests are labelled with their route pattern, like
+// /employee/:id, rather than the path so each employee isn't its own series
+func metrics() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Path() == metricsPath {
+			return c.Next()
+		}
+
+		start := time.Now()
+		// the error handler sets the status for errors, so run it here to
+		// record the status the client really gets
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		route := c.Route().Path
+		status := c.Response().StatusCode()
+		// unmatched paths are only seen by the catch all middleware, label them
+		// together so scanners can't create a series per path they try
+		if status == fiber.StatusNotFound && route == "/" && c.Path() != "/" {
+			route = "unmatched"
+		}
+
+		// fiber's Method points into a buffer that is reused for the next
+		// request, and prometheus keeps the label values, so it needs a copy
+		method := utils.CopyString(c.Method())
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		return nil
+	}
+}
+
+// metricsHandler serves the metrics in the prometheus text format
+func metricsHandler() fiber.Handler {
+	handler := fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+	return func(c *fiber.Ctx) error {
+		handler(c.Context())
+		return nil
+	}
+}
+
+// poolMonitor keeps the mongo pool gauges up to date from the driver's pool events
+func poolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.ConnectionCreated:
+				mongoConnectionsOpen.Inc()
+			case event.ConnectionClosed:
+				mongoConnectionsOpen.Dec()
+			case event.GetSucceeded:
+				mongoConnectionsInUse.Inc()
+			case event.ConnectionReturned:
+				mongoConnectionsInUse.Dec()
+			}
+		},
+	}
+}
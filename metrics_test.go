@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+
+	request(t, app, roleViewer, "GET", "/employee/"+johnID.Hex(), "")
+	request(t, app, roleViewer, "GET", "/employee/"+missingID, "")
+	request(t, app, "", "GET", "/no/such/path", "")
+	request(t, app, roleAdmin, "POST", "/employee", `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`)
+	request(t, app, "", "GET", metricsPath, "")
+
+	status, body := request(t, app, "", "GET", metricsPath, "")
+	if status != 200 {
+		t.Fatalf("status = %d, want 200: %s", status, body)
+	}
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/employee/:id",status="200"}`,
+		`http_requests_total{method="GET",route="/employee/:id",status="404"}`,
+		`http_requests_total{method="GET",route="unmatched",status="404"}`,
+		`http_request_duration_seconds_bucket{method="GET",route="/employee/:id"`,
+		`mongo_pool_connections_in_use`,
+		`hrms_employee_changes_total{action="created"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %s", want)
+		}
+	}
+	if strings.Contains(body, `route="/metrics"`) {
+		t.Error("scrapes of /metrics were counted")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/gofiber/fiber/v2/middleware/timeout"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// requestIDKey is the key the request id is stored under in the fiber locals
+const requestIDKey = "requestid"
+
+// requestID gives every request a UUID, or keeps the one the caller sent in the
+// X-Request-ID header, so a request can be traced from the frontend through our
+// logs. The id is stored in the locals and echoed back in the response header
+func requestID() fiber.Handler {
+	return requestid.New(requestid.Config{
+		Header:     fiber.HeaderXRequestID,
+		Generator:  utils.UUIDv4,
+		ContextKey: requestIDKey,
+	})
+}
+
+// requestLogger logs the method, route, path, status, latency, client IP and
+// request id of every request at info level, except the health check which
+// the orchestrator hits constantly
+func requestLogger() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Path() == "/health" {
+			return c.Next()
+		}
+
+		start := time.Now()
+		// like metrics, run the error handler here to log the status the client really gets
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		requestLog(c).Info("request",
+			slog.Int("status", c.Response().StatusCode()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("ip", c.IP()),
+		)
+		return nil
+	}
+}
+
+// recoverPanics turns a panic in a handler into an error, so the client gets
+// the usual 500 error response instead of a dropped connection and the server
+// keeps running. The panic is logged with its stack trace and the request id.
+// In development the stack is also kept for the error handler to send back
+func recoverPanics(cfg Config) fiber.Handler {
+	return recover.New(recover.Config{
+		EnableStackTrace: true,
+		StackTraceHandler: func(c *fiber.Ctx, e interface{}) {
+			stack := debug.Stack()
+			requestLog(c).Error("panic", slog.Any("panic", e), slog.String("stack", string(stack)))
+			if cfg.Development() {
+				c.Locals(panicStackKey, string(stack))
+			}
+		},
+	})
+}
+
+// requireConfirm refuses a destructive request unless it is sent with
+// ?confirm=true, so a stray DELETE from a script or a test run never gets through
+func requireConfirm() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Query("confirm") != "true" {
+			return fiber.NewError(fiber.StatusBadRequest, "this can't be undone from the API, send it again with ?confirm=true to go ahead")
+		}
+		return c.Next()
+	}
+}
+
+// corsHandler lets browsers on the allowed origins call the API, including the
+// preflight OPTIONS requests sent before PUT, PATCH and DELETE
+func corsHandler(cfg Config) fiber.Handler {
+	// tidy up the comma separated list, e.g "https://a.com, https://b.com"
+	origins := strings.Split(cfg.AllowedOrigins, ",")
+	for i := range origins {
+		origins[i] = strings.TrimSpace(origins[i])
+	}
+
+	return cors.New(cors.Config{
+		AllowOrigins: strings.Join(origins, ","),
+		AllowMethods: "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		AllowHeaders: "Origin,Content-Type,Accept,Authorization,X-Request-ID,If-Match," + headerIdempotencyKey,
+		// the headers scripts on the page get to read, besides the few every response exposes
+		ExposeHeaders: "X-Request-ID,ETag," + headerReplayed + ",Retry-After,Deprecation,Link",
+	})
+}
+
+// compressLevels are the COMPRESS_LEVEL settings
+var compressLevels = map[string]compress.Level{
+	"off":     compress.LevelDisabled,
+	"speed":   compress.LevelBestSpeed,
+	"default": compress.LevelDefault,
+	"best":    compress.LevelBestCompression,
+}
+
+// compressResponses gzips (or deflates, or brotlis) the response body for
+// clients that send a matching Accept-Encoding, which shrinks the big list and
+// export responses a lot. Everyone else gets the body as it is
+func compressResponses(cfg Config) fiber.Handler {
+	return compress.New(compress.Config{Level: compressLevels[cfg.CompressLevel]})
+}
+
+// rateLimiter allows each client IP max requests per window, the one the proxy
+// passed on when the app is behind a trusted one. Once the limit is
+// hit the client gets a 429, with a Retry-After header saying when to come back.
+// Every call returns a limiter with its own counters, so route groups can have
+// different limits
+func rateLimiter(max int, window time.Duration) fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:        max,
+		Expiration: window,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return c.IP()
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			return fiber.NewError(fiber.StatusTooManyRequests, "too many requests, slow down")
+		},
+	})
+}
+
+// requestTimeout puts a deadline on the whole request with fiber's timeout
+// middleware, so every route gets one without having to remember it. The
+// deadline is on the context, so it cuts short the database calls the handler
+// makes with c.UserContext() however long each of them is allowed on its own,
+// and running past it is a 504. The exports stream their body after the handler has returned,
+// and keep to their own exportTimeout instead. Zero turns it off
+func requestTimeout(limit time.Duration) fiber.Handler {
+	if limit == 0 {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+	return func(c *fiber.Ctx) error {
+		// timeout.New turns a deadline into a 408, the error itself is returned
+		// instead so it is answered like any other call that timed out
+		var err error
+		_ = timeout.New(func(c *fiber.Ctx) error {
+			err = c.Next()
+			return err
+		}, limit)(c)
+		return err
+	}
+}
+
+// operationTimeout gives each database call the request makes its own
+// deadline of timeout, so a slow query is cancelled instead of hanging the
+// request and holding on to a pooled connection, while a handler making
+// several calls gets timeout for each of them. The limit goes on the context
+// and the repositories start each call's deadline with startOperation, so
+// handlers must use c.UserContext() for it to apply. The error handler answers
+// 504 when a deadline is hit
+func operationTimeout(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.SetUserContext(withOperationTimeout(c.UserContext(), timeout))
+		return c.Next()
+	}
+}
+
+// operationTimeoutKey holds the deadline each database call made with the
+// context gets
+type operationTimeoutKey struct{}
+
+// withOperationTimeout limits each database call made with ctx to timeout
+func withOperationTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, operationTimeoutKey{}, timeout)
+}
+
+// operationContext is ctx with the deadline of one database call, which the
+// repositories derive at the start of every call through startOperation. A deadline already on ctx
+// that is sooner, like the request's own, still applies. Without a limit on
+// ctx it is ctx as it is
+func operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, ok := ctx.Value(operationTimeoutKey{}).(time.Duration)
+	if !ok || timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// listReads lets the employees the request reads come from wherever
+// MONGO_READ_PREFERENCE allows, e.g a secondary, to take load off the primary.
+// It is for the list and stats endpoints, which can show data a moment old.
+// Anything that reads before writing has to read from the primary
+func listReads() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.SetUserContext(withListReads(c.UserContext()))
+		return c.Next()
+	}
+}
+
+// deprecated marks responses from a path that is going away, telling the
+// client in the Deprecation header and pointing it at the same path under
+// successorPrefix with a Link header, e.g
+//
+//	Deprecation: true
+//	Link: </api/v1/employee>; rel="successor-version"
+func deprecated(successorPrefix string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("Deprecation", "true")
+		c.Set("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, successorPrefix, c.Path()))
+		return c.Next()
+	}
+}
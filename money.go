@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Money is an amount of money. It is stored in mongo as a Decimal128 so an
+// amount like 1234.57 is kept exactly rather than as the nearest float, and it
+// is read and written in JSON as a plain number. The zero value is 0
+type Money primitive.Decimal128
+
+// moneyRegistry decodes the decimals in schemaless documents, like audit
+// snapshots, as Money so they are still written as numbers in JSON
+var moneyRegistry = func() *bsoncodec.Registry {
+	rb := bson.NewRegistryBuilder()
+	rb.RegisterTypeMapEntry(bsontype.Decimal128, reflect.TypeOf(Money{}))
+	return rb.Build()
+}()
+
+// ParseMoney reads an amount like "1234.57"
+func ParseMoney(s string) (Money, error) {
+	d, err := primitive.ParseDecimal128(strings.TrimSpace(s))
+	if err != nil || d.IsNaN() || d.IsInf() != 0 {
+		return Money{}, fmt.Errorf("%q is not an amount of money", s)
+	}
+	return Money(d), nil
+}
+
+// MoneyFromInt is a whole amount of money
+func MoneyFromInt(amount int64) Money {
+	m, _ := ParseMoney(strconv.FormatInt(amount, 10))
+	return m
+}
+
+// String formats the amount without rounding or trailing zeros, e.g "1234.5"
+func (m Money) String() string {
+	digits, exp := m.parts()
+	if exp >= 0 {
+		return new(big.Int).Mul(digits, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)).String()
+	}
+
+	sign, s := "", digits.String()
+	if digits.Sign() < 0 {
+		sign, s = "-", s[1:]
+	}
+	if len(s) <= -exp {
+		s = strings.Repeat("0", -exp-len(s)+1) + s
+	}
+	point := len(s) + exp
+	return sign + s[:point] + "." + s[point:]
+}
+
+// IsZero reports whether m is the zero value
+func (m Money) IsZero() bool {
+	return primitive.Decimal128(m).IsZero()
+}
+
+// parts splits the amount into its digits and exponent, so that the amount is
+// digits * 10^exp. Trailing zeros are dropped, so equal amounts have equal parts
+func (m Money) parts() (*big.Int, int) {
+	digits, exp, err := primitive.Decimal128(m).BigInt()
+	if err != nil || digits.Sign() == 0 {
+		return new(big.Int), 0
+	}
+	ten := big.NewInt(10)
+	for {
+		quotient, remainder := new(big.Int).QuoRem(digits, ten, new(big.Int))
+		if remainder.Sign() != 0 {
+			return digits, exp
+		}
+		digits, exp = quotient, exp+1
+	}
+}
+
+// aligned returns the digits of m and o scaled to the same exponent
+func (m Money) aligned(o Money) (*big.Int, *big.Int, int) {
+	a, aExp := m.parts()
+	b, bExp := o.parts()
+	for aExp > bExp {
+		a, aExp = new(big.Int).Mul(a, big.NewInt(10)), aExp-1
+	}
+	for bExp > aExp {
+		b, bExp = new(big.Int).Mul(b, big.NewInt(10)), bExp-1
+	}
+	return a, b, aExp
+}
+
+// Sign returns -1, 0 or 1 for a negative, zero or positive amount
+func (m Money) Sign() int {
+	digits, _ := m.parts()
+	return digits.Sign()
+}
+
+// Cmp compares the amounts, returning -1, 0 or 1 when m is less than, equal
+// to or more than o
+func (m Money) Cmp(o Money) int {
+	a, b, _ := m.aligned(o)
+	return a.Cmp(b)
+}
+
+// Add returns the exact sum of the amounts
+func (m Money) Add(o Money) Money {
+	a, b, exp := m.aligned(o)
+	sum, _ := primitive.ParseDecimal128FromBigInt(new(big.Int).Add(a, b), exp)
+	return Money(sum)
+}
+
+// DecimalPlaces is how many digits the amount has after the decimal point,
+// ignoring trailing zeros
+func (m Money) DecimalPlaces() int {
+	if _, exp := m.parts(); exp < 0 {
+		return -exp
+	}
+	return 0
+}
+
+// MarshalJSON writes the amount as a JSON number
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// MarshalText writes the amount as its decimal digits, for XML
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON reads the amount from a JSON number. A null leaves it as it is,
+// like it does for the other fields
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		return errors.New("an amount of money must be a number, not a string")
+	}
+	parsed, err := ParseMoney(string(b))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// MarshalBSONValue stores the amount as a Decimal128
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bsontype.Decimal128, bsoncore.AppendDecimal128(nil, primitive.Decimal128(m)), nil
+}
+
+// UnmarshalBSONValue reads a Decimal128, or the doubles and integers salaries
+// were stored as before they were decimals
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bsontype.Decimal128:
+		d, _, ok := bsoncore.ReadDecimal128(data)
+		if !ok {
+			return errors.New("reading money: truncated decimal")
+		}
+		*m = Money(d)
+		return nil
+	case bsontype.Double:
+		f, _, ok := bsoncore.ReadDouble(data)
+		if !ok {
+			return errors.New("reading money: truncated double")
+		}
+		// the shortest decimal that reads back as the same float, so 1234.57
+		// comes back as 1234.57 rather than 1234.569999...
+		parsed, err := ParseMoney(strconv.FormatFloat(f, 'f', -1, 64))
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	case bsontype.Int32:
+		i, _, ok := bsoncore.ReadInt32(data)
+		if !ok {
+			return errors.New("reading money: truncated int32")
+		}
+		*m = MoneyFromInt(int64(i))
+		return nil
+	case bsontype.Int64:
+		i, _, ok := bsoncore.ReadInt64(data)
+		if !ok {
+			return errors.New("reading money: truncated int64")
+		}
+		*m = MoneyFromInt(i)
+		return nil
+	case bsontype.Null:
+		*m = Money{}
+		return nil
+	default:
+		return fmt.Errorf("reading money: cannot decode %v", t)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMoney(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"1234.57", "1234.57"},
+		{"1234.50", "1234.5"},
+		{"60000", "60000"},
+		{"6E+4", "60000"},
+		{"0.05", "0.05"},
+		{"-0.5", "-0.5"},
+		{"0", "0"},
+	} {
+		m, err := ParseMoney(tt.in)
+		if err != nil {
+			t.Fatalf("ParseMoney(%q): %v", tt.in, err)
+		}
+		if got := m.String(); got != tt.want {
+			t.Errorf("ParseMoney(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"", "lots", "NaN", "Infinity"} {
+		if _, err := ParseMoney(in); err == nil {
+			t.Errorf("ParseMoney(%q) didn't fail", in)
+		}
+	}
+
+	a, _ := ParseMoney("0.1")
+	b, _ := ParseMoney("0.2")
+	if sum := a.Add(b); sum.String() != "0.3" || sum.Cmp(a) <= 0 || a.Cmp(b) >= 0 {
+		t.Errorf("0.1 + 0.2 = %s, want exactly 0.3", sum)
+	}
+	if m, _ := ParseMoney("1.005"); m.DecimalPlaces() != 3 {
+		t.Errorf("DecimalPlaces(1.005) = %d, want 3", m.DecimalPlaces())
+	}
+}
+
+func TestMoneyRoundTrips(t *testing.T) {
+	type payslip struct {
+		Salary Money `json:"salary" bson:"salary"`
+	}
+
+	var p payslip
+	if err := json.Unmarshal([]byte(`{"salary":1234.57}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := json.Marshal(p)
+	if string(out) != `{"salary":1234.57}` {
+		t.Errorf("JSON = %s, want the salary as the same number", out)
+	}
+	if err := json.Unmarshal([]byte(`{"salary":"1234.57"}`), &p); err == nil {
+		t.Error("a salary sent as a string was accepted")
+	}
+
+	// stored as a decimal and read back exactly
+	raw, err := bson.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := bson.Raw(raw).Lookup("salary").Decimal128OK(); !ok {
+		t.Errorf("salary stored as %v, want a decimal", bson.Raw(raw).Lookup("salary").Type)
+	}
+	var back payslip
+	if err := bson.Unmarshal(raw, &back); err != nil || back.Salary.String() != "1234.57" {
+		t.Errorf("read back %s, err %v, want 1234.57", back.Salary, err)
+	}
+
+	// salaries stored before they were decimals are still read
+	for _, legacy := range []interface{}{1234.57, int32(1234), int64(1234)} {
+		raw, _ := bson.Marshal(bson.M{"salary": legacy})
+		var old payslip
+		if err := bson.Unmarshal(raw, &old); err != nil {
+			t.Fatalf("reading %T salary: %v", legacy, err)
+		}
+		if got := old.Salary.String(); got != "1234.57" && got != "1234" {
+			t.Errorf("read %v (%T) as %s", legacy, legacy, got)
+		}
+	}
+}
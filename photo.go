@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// maxPhotoSize is the largest employee photo that can be uploaded, 2MB
+const maxPhotoSize = 2 * 1024 * 1024
+
+// photoTypes are the image formats a photo can be in
+var photoTypes = map[string]bool{"image/jpeg": true, "image/png": true}
+
+// Photo is an employee's profile photo. Data is only served as the image
+// itself, never in JSON, nor kept in the audit log
+type Photo struct {
+	ContentType string    `json:"contentType" bson:"contentType"`
+	Size        int       `json:"size" bson:"size"`
+	UploadedAt  time.Time `json:"uploadedAt" bson:"uploadedAt"`
+	Data        []byte    `json:"-" bson:"-"`
+}
+
+// photoContentType works out the format of an uploaded image from its first
+// bytes rather than trusting what the client says it is
+func photoContentType(data []byte) (string, bool) {
+	contentType := http.DetectContentType(data)
+	return contentType, photoTypes[contentType]
+}
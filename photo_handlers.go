@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PhotoHandler holds the HTTP handlers for employee profile photos
+type PhotoHandler struct {
+	repo      PhotoRepository
+	employees EmployeeRepository
+	audit     AuditRepository
+}
+
+// NewPhotoHandler creates the photo handlers on top of the repositories
+func NewPhotoHandler(repo PhotoRepository, employees EmployeeRepository, audit AuditRepository) *PhotoHandler {
+	return &PhotoHandler{repo: repo, employees: employees, audit: audit}
+}
+
+// Upload sets an employee's photo from the "photo" field of a multipart form,
+// replacing the one they had. It must be a JPEG or PNG of at most 2MB. The
+// audit log keeps what the photo is, not the image
+//
+// @Summary Upload an employee's photo
+// @Tags employees
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Param photo formData file true "JPEG or PNG image, at most 2MB"
+// @Success 201 {object} Photo
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Router /employee/{id}/photo [post]
+func (h *PhotoHandler) Upload(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.employees.FindByID(c.UserContext(), employeeID); err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	fileHeader, err := c.FormFile("photo")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "an image must be uploaded in the photo field")
+	}
+	tooLarge := fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("photos can be at most %dMB", maxPhotoSize/1024/1024))
+	if fileHeader.Size > maxPhotoSize {
+		return tooLarge
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// the header's size is the client's word for it, so the read is capped too
+	data, err := io.ReadAll(io.LimitReader(file, maxPhotoSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxPhotoSize {
+		return tooLarge
+	}
+
+	contentType, ok := photoContentType(data)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "photos must be JPEG or PNG images")
+	}
+
+	previous, err := h.repo.Find(c.UserContext(), employeeID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	photo := &Photo{ContentType: contentType, Size: len(data), UploadedAt: time.Now().UTC(), Data: data}
+	if err := h.repo.Save(c.UserContext(), employeeID, photo); err != nil {
+		return err
+	}
+	action := auditUpdate
+	if previous == nil {
+		action = auditCreate
+	}
+	recordAudit(c, h.audit, action, photosCollection, employeeID.Hex(), previous, photo)
+	return c.Status(201).JSON(photo)
+}
+
+// Get serves an employee's photo as the image itself
+//
+// @Summary Get an employee's photo
+// @Tags employees
+// @Produce jpeg
+// @Produce png
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Success 200 {file} file
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /employee/{id}/photo [get]
+func (h *PhotoHandler) Get(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.employees.FindByID(c.UserContext(), employeeID); err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	photo, err := h.repo.Find(c.UserContext(), employeeID)
+	if err != nil {
+		return repositoryError(err, "photo")
+	}
+	c.Set(fiber.HeaderContentType, photo.ContentType)
+	return c.Send(photo.Data)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// photosBucket is the GridFS bucket employee photos are stored in
+const photosBucket = "employee_photos"
+
+// PhotoRepository is everything the handlers need from the photo store
+type PhotoRepository interface {
+	// Save stores the photo as the employee's, replacing the one they had
+	Save(ctx context.Context, employeeID primitive.ObjectID, photo *Photo) error
+	// Find returns the employee's photo, or ErrNotFound when they don't have one
+	Find(ctx context.Context, employeeID primitive.ObjectID) (*Photo, error)
+	// DeleteByEmployee removes the employee's photo, returning how many files it was stored in
+	DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error)
+	// SoftDeleteByEmployees hides the photos of the employees, who are being
+	// deleted, returning how many files it hid
+	SoftDeleteByEmployees(ctx context.Context, employeeIDs []primitive.ObjectID) (int64, error)
+	// RestoreByEmployee brings back the photo of the employee, who is being
+	// restored, returning how many files it brought back
+	RestoreByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error)
+}
+
+// MongoPhotoRepository is the PhotoRepository backed by GridFS. Each photo is
+// a file named after the employee id, with its content type in the metadata
+type MongoPhotoRepository struct {
+	db *mongo.Database
+}
+
+// NewMongoPhotoRepository creates a repository storing photos in the database's photo bucket
+func NewMongoPhotoRepository(db *mongo.Database) *MongoPhotoRepository {
+	return &MongoPhotoRepository{db: db}
+}
+
+// bucket opens the photo bucket for one call. GridFS takes deadlines instead
+// of contexts, and they are set on the bucket, so each call gets its own
+// bucket with the deadline of its context
+func (r *MongoPhotoRepository) bucket(ctx context.Context) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(r.db, options.GridFSBucket().SetName(photosBucket))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := bucket.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		if err := bucket.SetWriteDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
+}
+
+// files is the bucket's collection of files. Updating it directly, unlike the
+// bucket, takes part in the transaction of the context
+func (r *MongoPhotoRepository) files() *mongo.Collection {
+	return r.db.Collection(photosBucket + ".files")
+}
+
+// Save uploads the photo, then removes the employee's older ones. Find reads
+// the newest file, so the old photo is served until the new one is complete
+func (r *MongoPhotoRepository) Save(ctx context.Context, employeeID primitive.ObjectID, photo *Photo) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	bucket, err := r.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	metadata := bson.D{{Key: "contentType", Value: photo.ContentType}}
+	fileID, err := bucket.UploadFromStream(employeeID.Hex(), bytes.NewReader(photo.Data), options.GridFSUpload().SetMetadata(metadata))
+	if err != nil {
+		return fmt.Errorf("uploading photo: %w", err)
+	}
+	_, err = r.deleteFiles(ctx, bucket, bson.D{
+		{Key: "filename", Value: employeeID.Hex()},
+		{Key: "_id", Value: bson.D{{Key: "$ne", Value: fileID}}},
+	})
+	return err
+}
+
+// Find downloads the employee's newest photo that isn't soft deleted
+func (r *MongoPhotoRepository) Find(ctx context.Context, employeeID primitive.ObjectID) (*Photo, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	var newest struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	filter := bson.D{{Key: "filename", Value: employeeID.Hex()}, {Key: "metadata.deletedAt", Value: nil}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "uploadDate", Value: -1}}).SetProjection(bson.D{{Key: "_id", Value: 1}})
+	if err := r.files().FindOne(ctx, filter, opts).Decode(&newest); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	bucket, err := r.bucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := bucket.OpenDownloadStream(newest.ID)
+	// the file can go between finding it and opening it, when it's replaced
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(stream); err != nil {
+		return nil, fmt.Errorf("downloading photo: %w", err)
+	}
+	file := stream.GetFile()
+	contentType, _ := file.Metadata.Lookup("contentType").StringValueOK()
+	return &Photo{
+		ContentType: contentType,
+		Size:        data.Len(),
+		UploadedAt:  file.UploadDate,
+		Data:        data.Bytes(),
+	}, nil
+}
+
+// DeleteByEmployee removes every file of the employee's
+func (r *MongoPhotoRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	bucket, err := r.bucket(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return r.deleteFiles(ctx, bucket, bson.D{{Key: "filename", Value: employeeID.Hex()}})
+}
+
+// SoftDeleteByEmployees sets deletedAt in the metadata of the employees' files
+// that don't have it yet
+func (r *MongoPhotoRepository) SoftDeleteByEmployees(ctx context.Context, employeeIDs []primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	names := make([]string, len(employeeIDs))
+	for i, employeeID := range employeeIDs {
+		names[i] = employeeID.Hex()
+	}
+	filter := bson.D{{Key: "filename", Value: bson.D{{Key: "$in", Value: names}}}, {Key: "metadata.deletedAt", Value: nil}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "metadata.deletedAt", Value: time.Now().UTC()}}}}
+	result, err := r.files().UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// RestoreByEmployee unsets deletedAt in the metadata of the employee's files
+func (r *MongoPhotoRepository) RestoreByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	filter := bson.D{{Key: "filename", Value: employeeID.Hex()}, {Key: "metadata.deletedAt", Value: bson.D{{Key: "$ne", Value: nil}}}}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "metadata.deletedAt", Value: ""}}}}
+	result, err := r.files().UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// deleteFiles removes the files matching the filter, with their chunks
+func (r *MongoPhotoRepository) deleteFiles(ctx context.Context, bucket *gridfs.Bucket, filter bson.D) (int64, error) {
+	cursor, err := bucket.Find(filter)
+	if err != nil {
+		return 0, err
+	}
+	var files []gridfs.File
+	if err := cursor.All(ctx, &files); err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if err := bucket.Delete(file.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return 0, fmt.Errorf("deleting photo: %w", err)
+		}
+	}
+	return int64(len(files)), nil
+}
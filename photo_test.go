@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakePhotoRepository is an in-memory PhotoRepository, keeping the soft
+// deleted photos apart
+type fakePhotoRepository struct {
+	photos  map[primitive.ObjectID]Photo
+	deleted map[primitive.ObjectID]Photo
+}
+
+func newFakePhotoRepository() *fakePhotoRepository {
+	return &fakePhotoRepository{photos: map[primitive.ObjectID]Photo{}, deleted: map[primitive.ObjectID]Photo{}}
+}
+
+func (r *fakePhotoRepository) Save(ctx context.Context, employeeID primitive.ObjectID, photo *Photo) error {
+	r.photos[employeeID] = *photo
+	return nil
+}
+
+func (r *fakePhotoRepository) Find(ctx context.Context, employeeID primitive.ObjectID) (*Photo, error) {
+	photo, ok := r.photos[employeeID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &photo, nil
+}
+
+func (r *fakePhotoRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	_, live := r.photos[employeeID]
+	_, deleted := r.deleted[employeeID]
+	if !live && !deleted {
+		return 0, nil
+	}
+	delete(r.photos, employeeID)
+	delete(r.deleted, employeeID)
+	return 1, nil
+}
+
+func (r *fakePhotoRepository) SoftDeleteByEmployees(ctx context.Context, employeeIDs []primitive.ObjectID) (int64, error) {
+	var deleted int64
+	for _, employeeID := range employeeIDs {
+		if photo, ok := r.photos[employeeID]; ok {
+			r.deleted[employeeID] = photo
+			delete(r.photos, employeeID)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (r *fakePhotoRepository) RestoreByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	photo, ok := r.deleted[employeeID]
+	if !ok {
+		return 0, nil
+	}
+	r.photos[employeeID] = photo
+	delete(r.deleted, employeeID)
+	return 1, nil
+}
+
+// testImage encodes a small image with encode, e.g png.Encode
+func testImage(t *testing.T, encode func(*bytes.Buffer, image.Image) error) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// uploadPhoto posts the image to the employee's photo as the role
+func uploadPhoto(t *testing.T, app *fiber.App, role, path, field string, content []byte) (int, string) {
+	t.Helper()
+
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile(field, "photo")
+	if err != nil {
+		t.Fatalf("creating form file: %v", err)
+	}
+	part.Write(content)
+	form.Close()
+
+	req := newRequest(t, role, "POST", path, body.String())
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, respBody := send(t, app, req)
+	return resp.StatusCode, respBody
+}
+
+func TestEmployeePhoto(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+	path := "/employee/" + johnID.Hex() + "/photo"
+	pngPhoto := testImage(t, func(w *bytes.Buffer, m image.Image) error { return png.Encode(w, m) })
+	jpegPhoto := testImage(t, func(w *bytes.Buffer, m image.Image) error { return jpeg.Encode(w, m, nil) })
+
+	if status, body := request(t, app, roleViewer, "GET", path, ""); status != 404 || !strings.Contains(body, "photo not found") {
+		t.Errorf("before an upload: status = %d, body %q, want 404", status, body)
+	}
+
+	for _, photo := range []struct {
+		data        []byte
+		contentType string
+	}{{pngPhoto, "image/png"}, {jpegPhoto, "image/jpeg"}} {
+		// the second upload replaces the first
+		status, body := uploadPhoto(t, app, roleAdmin, path, "photo", photo.data)
+		if status != 201 || !strings.Contains(body, `"contentType":"`+photo.contentType+`"`) {
+			t.Fatalf("upload %s: status = %d, body %q", photo.contentType, status, body)
+		}
+		resp, got := send(t, app, newRequest(t, roleViewer, "GET", path, ""))
+		if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != photo.contentType || got != string(photo.data) {
+			t.Errorf("get %s: status = %d, Content-Type %q, %d bytes, want the uploaded image back",
+				photo.contentType, resp.StatusCode, resp.Header.Get("Content-Type"), len(got))
+		}
+	}
+}
+
+func TestEmployeePhotoAudit(t *testing.T) {
+	audit := newFakeAuditRepository()
+	app := newApp(testConfig(), Repositories{
+		Employees:       newFakeRepository(john),
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       audit,
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+	path := "/employee/" + johnID.Hex() + "/photo"
+	pngPhoto := testImage(t, func(w *bytes.Buffer, m image.Image) error { return png.Encode(w, m) })
+	jpegPhoto := testImage(t, func(w *bytes.Buffer, m image.Image) error { return jpeg.Encode(w, m, nil) })
+
+	for _, data := range [][]byte{pngPhoto, jpegPhoto} {
+		if status, body := uploadPhoto(t, app, roleAdmin, path, "photo", data); status != 201 {
+			t.Fatalf("upload: status = %d, body %q", status, body)
+		}
+	}
+
+	if len(audit.entries) != 2 {
+		t.Fatalf("audit entries = %+v, want one per upload", audit.entries)
+	}
+	for _, entry := range audit.entries {
+		if entry.Collection != photosCollection || entry.DocumentID != johnID.Hex() || entry.Actor != "tester" {
+			t.Errorf("entry %+v, want one for john's photo by tester", entry)
+		}
+		// the image itself stays out of the log
+		for _, doc := range []bson.M{entry.Before, entry.After} {
+			if _, ok := doc["data"]; ok {
+				t.Errorf("entry %+v holds the image", entry)
+			}
+		}
+	}
+	first, second := audit.entries[0], audit.entries[1]
+	if first.Action != auditCreate || first.Before != nil || first.After["contentType"] != "image/png" {
+		t.Errorf("first upload entry = %+v, want the png created", first)
+	}
+	if second.Action != auditUpdate || second.Before["contentType"] != "image/png" || second.After["contentType"] != "image/jpeg" {
+		t.Errorf("second upload entry = %+v, want the png replaced by the jpeg", second)
+	}
+}
+
+func TestEmployeePhotoIsValidated(t *testing.T) {
+	pngPhoto := testImage(t, func(w *bytes.Buffer, m image.Image) error { return png.Encode(w, m) })
+	// a PNG header with enough padding after it to go over the limit
+	tooLarge := append(append([]byte{}, pngPhoto...), make([]byte, maxPhotoSize)...)
+
+	tests := []struct {
+		name       string
+		role       string
+		path       string
+		field      string
+		data       []byte
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "not an image", role: roleAdmin, data: []byte("GIF89a, or close enough"), wantStatus: 415, wantBody: "JPEG or PNG"},
+		{name: "too large", role: roleAdmin, data: tooLarge, wantStatus: 413, wantBody: "at most 2MB"},
+		{name: "wrong field", role: roleAdmin, field: "file", data: pngPhoto, wantStatus: 400, wantBody: "photo field"},
+		{name: "unknown employee", role: roleAdmin, path: "/employee/" + missingID + "/photo", data: pngPhoto, wantStatus: 404, wantBody: "employee not found"},
+		{name: "viewer forbidden", role: roleViewer, data: pngPhoto, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository())
+			path, field := tt.path, tt.field
+			if path == "" {
+				path = "/employee/" + johnID.Hex() + "/photo"
+			}
+			if field == "" {
+				field = "photo"
+			}
+
+			status, body := uploadPhoto(t, app, tt.role, path, field, tt.data)
+			if status != tt.wantStatus || !strings.Contains(body, tt.wantBody) {
+				t.Errorf("status = %d, body %q, want %d and %q", status, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
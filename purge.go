@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PurgeResult is how much was removed by purging an employee
+type PurgeResult struct {
+	EmployeeID    string `json:"employeeId"`
+	Revisions     int64  `json:"revisions"`
+	LeaveRequests int64  `json:"leaveRequests"`
+	SalaryChanges int64  `json:"salaryChanges"`
+	Attendance    int64  `json:"attendance"`
+	AuditEntries  int64  `json:"auditEntries"`
+	Photos        int64  `json:"photos"`
+}
+
+// purgeHandler removes an employee for good, for erasure requests, together
+// with everything about them: their history, leave requests, salary changes,
+// attendance, audit entries and photo. It is all one transaction, so a failure part way through
+// leaves the employee as they were. The photo is in GridFS, outside the
+// transaction, so it is deleted last, once everything else has gone through. The purge itself is audited, without any
+// details of the employee
+//
+// @Summary Permanently remove an employee and their records
+// @Tags employees
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Param confirm query bool true "Must be true"
+// @Success 200 {object} PurgeResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /employee/{id}/purge [delete]
+func purgeHandler(repos Repositories) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		employeeID, err := parseID(c)
+		if err != nil {
+			return err
+		}
+
+		var result PurgeResult
+		// a photo deleted on an attempt that is retried stays deleted, so its
+		// count is kept across the attempts
+		var photos int64
+		err = repos.Transactor.WithTransaction(c.UserContext(), func(ctx context.Context) error {
+			// the transaction can be retried, so the counts start over each time
+			result = PurgeResult{EmployeeID: employeeID.Hex()}
+			if err := repos.Employees.Purge(ctx, employeeID); err != nil {
+				return err
+			}
+			if result.Revisions, err = repos.History.DeleteByEmployee(ctx, employeeID); err != nil {
+				return err
+			}
+			if result.LeaveRequests, err = repos.LeaveRequests.DeleteByEmployee(ctx, employeeID); err != nil {
+				return err
+			}
+			if result.SalaryChanges, err = repos.SalaryChanges.DeleteByEmployee(ctx, employeeID); err != nil {
+				return err
+			}
+			if result.Attendance, err = repos.Attendance.DeleteByEmployee(ctx, employeeID); err != nil {
+				return err
+			}
+			if result.AuditEntries, err = repos.AuditLogs.DeleteByEmployee(ctx, employeeID); err != nil {
+				return err
+			}
+			deleted, err := repos.Photos.DeleteByEmployee(ctx, employeeID)
+			photos += deleted
+			return err
+		})
+		if err != nil {
+			return repositoryError(err, "employee")
+		}
+		result.Photos = photos
+
+		employeeChanges.WithLabelValues(actionPurged).Inc()
+		recordAudit(c, repos.AuditLogs, auditPurge, employeesCollection, employeeID.Hex(), nil, nil)
+		repos.Events.Publish(eventEmployeePurged, fiber.Map{"id": employeeID.Hex()})
+		return c.JSON(result)
+	}
+}
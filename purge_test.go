@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestPurgeEmployee(t *testing.T) {
+	audit := newFakeAuditRepository()
+	app := newApp(testConfig(), Repositories{
+		Employees:       newFakeRepository(john),
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       audit,
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+	path := "/employee/" + johnID.Hex()
+
+	// leave some traces of john around
+	request(t, app, roleAdmin, "PATCH", path, `{"salary":55000}`)
+	request(t, app, roleAdmin, "POST", path+"/leave", `{"startDate":"2026-07-01T00:00:00Z","endDate":"2026-07-02T00:00:00Z","type":"sick"}`)
+	request(t, app, roleAdmin, "POST", path+"/salary-history", `{"newSalary":60000,"effectiveDate":"2099-01-01T00:00:00Z"}`)
+	request(t, app, roleAdmin, "POST", path+"/checkin", "")
+	uploadPhoto(t, app, roleAdmin, path+"/photo", "photo", testImage(t, func(w *bytes.Buffer, m image.Image) error { return png.Encode(w, m) }))
+
+	if status, _ := request(t, app, roleAdmin, "DELETE", path+"/purge", ""); status != 400 {
+		t.Errorf("purge without confirm: status = %d, want 400", status)
+	}
+	if status, _ := request(t, app, roleViewer, "DELETE", path+"/purge?confirm=true", ""); status != 403 {
+		t.Errorf("viewer purging: status = %d, want 403", status)
+	}
+
+	status, body := request(t, app, roleAdmin, "DELETE", path+"/purge?confirm=true", "")
+	var result PurgeResult
+	if err := json.Unmarshal([]byte(body), &result); err != nil || status != 200 {
+		t.Fatalf("purge: status = %d, body %q", status, body)
+	}
+	// the fake audit log only matches the patch and the photo upload by id
+	want := PurgeResult{EmployeeID: johnID.Hex(), Revisions: 1, LeaveRequests: 1, SalaryChanges: 1, Attendance: 1, AuditEntries: 2, Photos: 1}
+	if result != want {
+		t.Errorf("purge result = %+v, want %+v", result, want)
+	}
+
+	for _, p := range []string{path, path + "/leave", path + "/salary-history", path + "/attendance", path + "/photo"} {
+		if status, _ := request(t, app, roleAdmin, "GET", p, ""); status != 404 {
+			t.Errorf("GET %s after the purge: status = %d, want 404", p, status)
+		}
+	}
+	if status, _ := request(t, app, roleAdmin, "POST", path+"/restore", ""); status != 404 {
+		t.Errorf("restoring a purged employee: status = %d, want 404", status)
+	}
+	// all that is left of john is the record of the purge
+	for _, e := range audit.entries {
+		if e.DocumentID == johnID.Hex() && (e.Action != auditPurge || e.Before != nil || e.After != nil) {
+			t.Errorf("audit entry left after the purge: %+v", e)
+		}
+	}
+
+	if status, _ := request(t, app, roleAdmin, "DELETE", path+"/purge?confirm=true", ""); status != 404 {
+		t.Errorf("purging twice: status = %d, want 404", status)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// pagination defaults, and the upper bound on how many records a client can ask for at once
+const (
+	defaultPage  = 1
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// parsePagination reads the page and limit query params, falling back to the
+// defaults when they are missing, malformed or negative, and capping limit at maxLimit
+func parsePagination(c *fiber.Ctx) (page int64, limit int64) {
+	page, err := strconv.ParseInt(c.Query("page"), 10, 64)
+	if err != nil {
+		page = 0
+	}
+	limit, err = strconv.ParseInt(c.Query("limit"), 10, 64)
+	if err != nil {
+		limit = 0
+	}
+	return clampPagination(page, limit)
+}
+
+// clampPagination swaps a page or limit below 1 for the default, and caps
+// limit at maxLimit
+func clampPagination(page, limit int64) (int64, int64) {
+	if page < 1 {
+		page = defaultPage
+	}
+	if limit < 1 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return page, limit
+}
+
+// pageLinks builds the links to the pages after and before the current one,
+// keeping the path and every other query param of the request. A link is nil
+// when there is no page there
+func pageLinks(c *fiber.Ctx, page, limit, total int64) (next, prev *string) {
+	link := func(page int64) *string {
+		query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+		query.Set("page", strconv.FormatInt(page, 10))
+		query.Set("limit", strconv.FormatInt(limit, 10))
+		s := c.Path() + "?" + query.Encode()
+		return &s
+	}
+
+	if page*limit < total {
+		next = link(page + 1)
+	}
+	if page > 1 {
+		prev = link(page - 1)
+	}
+	return next, prev
+}
+
+// cursorLink is the link to the page after the cursor, keeping the path and
+// the other query params, apart from page which the cursor replaces
+func cursorLink(c *fiber.Ctx, cursor string, limit int64) *string {
+	query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	query.Del("page")
+	query.Set("after", cursor)
+	query.Set("limit", strconv.FormatInt(limit, 10))
+	s := c.Path() + "?" + query.Encode()
+	return &s
+}
+
+// notDeleted matches employees that have not been soft deleted. A missing
+// deletedAt field also matches, which covers records created before soft delete
+var notDeleted = bson.E{Key: "deletedAt", Value: nil}
+
+// buildEmployeeFilter turns the list query params (search, minSalary, maxSalary,
+// minAge, maxAge and includeDeleted) into a mongo filter. Every
+// param is optional, and the conditions that are given are ANDed together.
+// Bad params are a 400, and includeDeleted is a 403 for anyone but an admin
+func buildEmployeeFilter(c *fiber.Ctx) (bson.D, error) {
+	filter := bson.D{}
+
+	// soft deleted employees are hidden unless an admin asks for them with ?includeDeleted=true
+	if c.Query("includeDeleted") != "true" {
+		filter = append(filter, notDeleted)
+	} else if claims := currentClaims(c); claims == nil || claims.Role != roleAdmin {
+		return nil, fiber.NewError(fiber.StatusForbidden, "only admins can see deleted employees")
+	}
+
+	// case insensitive partial match on the name. The search term is escaped so
+	// regex metacharacters like "." are matched literally
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		filter = append(filter, bson.E{
+			Key:   "name",
+			Value: primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"},
+		})
+	}
+
+	salaryRange, err := rangeCondition(c, "minSalary", "maxSalary", parseMoneyParam)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if len(salaryRange) > 0 {
+		filter = append(filter, bson.E{Key: "salary", Value: salaryRange})
+	}
+
+	ageRange, err := rangeCondition(c, "minAge", "maxAge", parseNumberParam)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if len(ageRange) > 0 {
+		filter = append(filter, bson.E{Key: "age", Value: ageRange})
+	}
+	return filter, nil
+}
+
+// rangeCondition builds a $gte/$lte condition from a pair of query params,
+// read with parse. Missing params are left out, and non numeric ones are an error
+func rangeCondition(c *fiber.Ctx, minParam, maxParam string, parse func(string) (interface{}, error)) (bson.D, error) {
+	condition := bson.D{}
+
+	if raw := c.Query(minParam); raw != "" {
+		value, err := parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", minParam)
+		}
+		condition = append(condition, bson.E{Key: "$gte", Value: value})
+	}
+
+	if raw := c.Query(maxParam); raw != "" {
+		value, err := parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", maxParam)
+		}
+		condition = append(condition, bson.E{Key: "$lte", Value: value})
+	}
+	return condition, nil
+}
+
+// parseNumberParam and parseMoneyParam read the bounds of a range. Salaries are
+// compared as decimals, since a float bound like 1234.57 isn't exactly the
+// stored salary
+func parseNumberParam(raw string) (interface{}, error) {
+	return strconv.ParseFloat(raw, 64)
+}
+
+func parseMoneyParam(raw string) (interface{}, error) {
+	return ParseMoney(raw)
+}
+
+// the fields clients are allowed to sort the employee list by
+var sortableFields = map[string]bool{
+	"name":   true,
+	"salary": true,
+	"age":    true,
+}
+
+// parseSort reads the sortBy and order query params into a mongo sort
+// document, defaulting to name ascending. Only whitelisted fields can be
+// sorted on, to stop clients from sorting on arbitrary fields
+func parseSort(c *fiber.Ctx) (bson.D, error) {
+	return sortDocument(c.Query("sortBy"), c.Query("order"))
+}
+
+// sortDocument builds the sort for a sortBy field and asc or desc order, empty
+// values meaning name and asc
+func sortDocument(sortBy, order string) (bson.D, error) {
+	if sortBy == "" {
+		sortBy = "name"
+	}
+	if !sortableFields[sortBy] {
+		return nil, fmt.Errorf("cannot sort by %q, must be one of name, salary or age", sortBy)
+	}
+	if order == "" {
+		order = "asc"
+	}
+
+	direction := 1
+	switch strings.ToLower(order) {
+	case "asc":
+	case "desc":
+		direction = -1
+	default:
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+
+	// sort on _id as well so employees with the same value keep a stable order across pages
+	return bson.D{{Key: sortBy, Value: direction}, {Key: "_id", Value: 1}}, nil
+}
+
+// the fields GET /employee/distinct/{field} lists the values of, the ones a
+// filter dropdown is made from
+var distinctFields = map[string]bool{
+	"position":        true,
+	"departmentId":    true,
+	"managerId":       true,
+	"address.city":    true,
+	"address.state":   true,
+	"address.country": true,
+}
+
+// projectableFields maps the employee fields a list can be trimmed to, by
+// their JSON name, to where they are stored
+var projectableFields = map[string]string{
+	"id":           "_id",
+	"name":         "name",
+	"email":        "email",
+	"salary":       "salary",
+	"bonus":        "bonus",
+	"age":          "age",
+	"position":     "position",
+	"hireDate":     "hireDate",
+	"departmentId": "departmentId",
+	"managerId":    "managerId",
+	"address":      "address",
+	"createdAt":    "createdAt",
+	"updatedAt":    "updatedAt",
+	"deletedAt":    "deletedAt",
+}
+
+// parseFields reads ?fields=id,name into the projection that only fetches
+// those fields, and the fields themselves. Both are nil when fields is missing,
+// which means the whole employee. A field asked for twice is only taken once.
+// Fields are whole top level fields, address.city is as unknown as any other
+func parseFields(c *fiber.Ctx) (bson.D, []string, error) {
+	if c.Query("fields") == "" {
+		return nil, nil, nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(c.Query("fields"), ",") {
+		field = strings.TrimSpace(field)
+		if containsField(fields, field) {
+			continue
+		}
+		fields = append(fields, field)
+	}
+
+	// _id is always returned unless it is left out explicitly
+	projection := bson.D{{Key: "_id", Value: 0}}
+	for _, field := range fields {
+		stored, ok := projectableFields[field]
+		if !ok {
+			return nil, nil, fmt.Errorf("fields can't include %q", field)
+		}
+		if stored == "_id" {
+			projection[0].Value = 1
+		} else {
+			projection = append(projection, bson.E{Key: stored, Value: 1})
+		}
+	}
+	return projection, fields, nil
+}
+
+// containsField reports whether field is one of the fields
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// pickFields writes each employee with only the fields. The others would
+// otherwise be written with their zero values, as if they were set to them
+func pickFields(employees []Employee, fields []string) ([]map[string]json.RawMessage, error) {
+	picked := make([]map[string]json.RawMessage, len(employees))
+	for i, employee := range employees {
+		b, err := json.Marshal(employee)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(b, &all); err != nil {
+			return nil, err
+		}
+		picked[i] = make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := all[field]; ok {
+				picked[i][field] = value
+			}
+		}
+	}
+	return picked, nil
+}
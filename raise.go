@@ -0,0 +1,168 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the range of raises we accept, a cut of 100% or more would leave no salary
+var (
+	minRaisePercent = MoneyFromInt(-100)
+	maxRaisePercent = MoneyFromInt(100)
+)
+
+// RaiseRequest is the body of a raise. Percent is the change to the salary,
+// e.g 5 for a 5% raise or -10 for a 10% cut. Filter picks the employees a bulk
+// raise applies to, like a search does, and is ignored for a single employee
+type RaiseRequest struct {
+	Percent *Money       `json:"percent" swaggertype:"number"`
+	Filter  SearchFilter `json:"filter"`
+}
+
+// RaiseResult is the response of a bulk raise. When DryRun is set nothing was
+// raised, Raised is how many employees would have been and Employees are as
+// they are now
+type RaiseResult struct {
+	Raised    int64      `json:"raised"`
+	Employees []Employee `json:"employees"`
+	DryRun    bool       `json:"dryRun,omitempty"`
+}
+
+// parseRaise reads the raise from the body and works out the factor the
+// salaries are multiplied by
+func parseRaise(c *fiber.Ctx) (*RaiseRequest, Money, error) {
+	raise := new(RaiseRequest)
+	if err := parseJSON(c, raise); err != nil {
+		return nil, Money{}, err
+	}
+	switch {
+	case raise.Percent == nil:
+		return nil, Money{}, newValidationError(map[string]string{"percent": "percent is required"})
+	case raise.Percent.Cmp(minRaisePercent) <= 0 || raise.Percent.Cmp(maxRaisePercent) > 0:
+		return nil, Money{}, newValidationError(map[string]string{"percent": "percent must be more than -100 and at most 100"})
+	case raise.Percent.DecimalPlaces() > 2:
+		return nil, Money{}, newValidationError(map[string]string{"percent": "percent can have at most 2 decimal places"})
+	}
+	return raise, raiseFactor(*raise.Percent), nil
+}
+
+// raiseFactor is what a salary is multiplied by for a raise of percent, e.g
+// 1.05 for 5. It is worked out exactly, as a decimal
+func raiseFactor(percent Money) Money {
+	digits, exp := percent.parts()
+	fraction, _ := primitive.ParseDecimal128FromBigInt(digits, exp-2)
+	return MoneyFromInt(1).Add(Money(fraction))
+}
+
+// Raise changes an employee's salary by a percentage. The new salary is worked
+// out in the database, rounded to the cent, so two raises at once both apply
+//
+// @Summary Give an employee a raise
+// @Tags employees
+// @Accept json
+// @Produce json
+// @Produce xml
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Param raise body RaiseRequest true "The percentage to change the salary by"
+// @Success 200 {object} Employee
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /employee/{id}/raise [post]
+func (h *EmployeeHandler) Raise(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	_, factor, err := parseRaise(c)
+	if err != nil {
+		return err
+	}
+
+	before := h.snapshot(c, employeeID)
+	raisedEmployee, err := h.repo.Raise(c.UserContext(), employeeID, factor)
+	if err != nil {
+		return repositoryError(err, "employee")
+	}
+	employeeChanges.WithLabelValues(actionUpdated).Inc()
+	recordAudit(c, h.audit, auditUpdate, employeesCollection, employeeID.Hex(), before, raisedEmployee)
+	h.events.Publish(eventEmployeeUpdated, raisedEmployee)
+	recordRevision(c, h.history, raisedEmployee)
+	c.Set(fiber.HeaderETag, employeeETag(raisedEmployee))
+	return respond(c, fiber.StatusOK, raisedEmployee)
+}
+
+// RaiseMany changes the salary of every employee matching the filter by a
+// percentage, e.g everyone in a department with
+// {"percent": 3, "filter": {"field": "departmentId", "op": "eq", "value": "<id>"}}.
+// Leaving the filter out raises every employee. ?dryRun=true only lists the
+// employees that would be raised
+//
+// @Summary Give the employees matching a filter a raise
+// @Tags employees
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param raise body RaiseRequest true "The percentage to change the salaries by, and which employees"
+// @Param dryRun query bool false "Only list the employees that would be raised"
+// @Success 200 {object} RaiseResult
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /employee/raise [post]
+func (h *EmployeeHandler) RaiseMany(c *fiber.Ctx) error {
+	raise, factor, err := parseRaise(c)
+	if err != nil {
+		return err
+	}
+	filter, err := raise.Filter.toQuery()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	query := bson.D{notDeleted}
+	if len(filter) > 0 {
+		query = append(query, bson.E{Key: "$and", Value: bson.A{filter}})
+	}
+
+	// the employees as they were, for the audit log
+	matching, err := h.repo.FindAll(c.UserContext(), query, nil)
+	if err != nil {
+		return err
+	}
+	result := RaiseResult{Employees: make([]Employee, 0)}
+	if isDryRun(c) {
+		result.Raised, result.Employees, result.DryRun = int64(len(matching)), matching, true
+		return c.JSON(result)
+	}
+	if len(matching) == 0 {
+		return c.JSON(result)
+	}
+	before := make(map[primitive.ObjectID]*Employee, len(matching))
+	ids := make([]primitive.ObjectID, len(matching))
+	for i := range matching {
+		before[matching[i].ID] = &matching[i]
+		ids[i] = matching[i].ID
+	}
+
+	raised, err := h.repo.RaiseMany(c.UserContext(), ids, factor)
+	if err != nil {
+		return err
+	}
+	for i := range raised {
+		recordAudit(c, h.audit, auditUpdate, employeesCollection, raised[i].ID.Hex(), before[raised[i].ID], &raised[i])
+		h.events.Publish(eventEmployeeUpdated, &raised[i])
+		recordRevision(c, h.history, &raised[i])
+	}
+	employeeChanges.WithLabelValues(actionUpdated).Add(float64(len(raised)))
+
+	result.Raised = int64(len(raised))
+	result.Employees = raised
+	return c.JSON(result)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRaiseFactor(t *testing.T) {
+	for percent, want := range map[string]string{"5": "1.05", "-10": "0.9", "2.5": "1.025", "100": "2", "0.01": "1.0001"} {
+		p, _ := ParseMoney(percent)
+		if got := raiseFactor(p).String(); got != want {
+			t.Errorf("raiseFactor(%s) = %s, want %s", percent, got, want)
+		}
+	}
+}
+
+func TestRaiseEmployee(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "raise", method: "POST", path: "/employee/" + johnID.Hex() + "/raise", body: `{"percent":5}`, wantStatus: 200, wantBody: `"salary":52500`},
+		{name: "cut", method: "POST", path: "/employee/" + johnID.Hex() + "/raise", body: `{"percent":-2.5}`, wantStatus: 200, wantBody: `"salary":48750`},
+		{name: "missing percent", method: "POST", path: "/employee/" + johnID.Hex() + "/raise", body: `{}`, wantStatus: 422, wantBody: "percent is required"},
+		{name: "too big", method: "POST", path: "/employee/" + johnID.Hex() + "/raise", body: `{"percent":150}`, wantStatus: 422, wantBody: "at most 100"},
+		{name: "whole salary", method: "POST", path: "/employee/" + johnID.Hex() + "/raise", body: `{"percent":-100}`, wantStatus: 422},
+		{name: "too precise", method: "POST", path: "/employee/" + johnID.Hex() + "/raise", body: `{"percent":1.234}`, wantStatus: 422},
+		{name: "unknown employee", method: "POST", path: "/employee/" + missingID + "/raise", body: `{"percent":5}`, wantStatus: 404},
+		{name: "viewer forbidden", role: roleViewer, method: "POST", path: "/employee/" + johnID.Hex() + "/raise", body: `{"percent":5}`, wantStatus: 403},
+		{name: "bad filter", method: "POST", path: "/employee/raise", body: `{"percent":5,"filter":{"field":"password","op":"eq","value":1}}`, wantStatus: 400},
+	})
+}
+
+func TestRaiseManyEmployees(t *testing.T) {
+	jane := Employee{ID: primitive.NewObjectID(), Name: "Jane Doe", Email: "jane@example.com", Salary: MoneyFromInt(61234)}
+	app := newTestApp(newFakeRepository(john, jane), newFakeDepartmentRepository())
+
+	status, body := request(t, app, roleAdmin, "POST", "/employee/raise", `{"percent":3}`)
+	var result RaiseResult
+	if err := json.Unmarshal([]byte(body), &result); err != nil || status != 200 {
+		t.Fatalf("status = %d, body %q", status, body)
+	}
+	if result.Raised != 2 {
+		t.Fatalf("raised %d employees, want 2: %s", result.Raised, body)
+	}
+	salaries := map[primitive.ObjectID]string{}
+	for _, e := range result.Employees {
+		salaries[e.ID] = e.Salary.String()
+	}
+	if salaries[johnID] != "51500" || salaries[jane.ID] != "63071.02" {
+		t.Errorf("salaries = %v, want 51500 for john and 63071.02 for jane", salaries)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// readOnlyMode stops every change to the data while it is on, for a database
+// migration or other maintenance, while the reads carry on as usual. It starts
+// as READ_ONLY says and admins can turn it on and off at runtime. That only
+// changes the instance the request reached, the others keep their own mode
+type readOnlyMode struct {
+	on atomic.Bool
+}
+
+func newReadOnlyMode(on bool) *readOnlyMode {
+	mode := new(readOnlyMode)
+	mode.on.Store(on)
+	return mode
+}
+
+// Guard runs next, normally the write rate limit, unless the mode is on, in
+// which case the request is answered 503 without reaching the handler
+func (m *readOnlyMode) Guard(next fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if m.on.Load() {
+			return fiber.NewError(fiber.StatusServiceUnavailable, "the service is in read-only mode for maintenance, try again later")
+		}
+		return next(c)
+	}
+}
+
+// ReadOnlyStatus is the body of PUT /read-only, and its response
+type ReadOnlyStatus struct {
+	ReadOnly *bool `json:"readOnly"`
+}
+
+// readOnlyHandler reports whether the service is in read-only mode
+//
+// @Summary Get the read-only mode
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ReadOnlyStatus
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /read-only [get]
+func readOnlyHandler(mode *readOnlyMode) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		on := mode.on.Load()
+		return c.JSON(ReadOnlyStatus{ReadOnly: &on})
+	}
+}
+
+// setReadOnlyHandler turns read-only mode on or off on this instance. It is
+// never refused itself, so the mode can always be turned off again
+//
+// @Summary Turn the read-only mode on or off
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param mode body ReadOnlyStatus true "Whether writes are refused"
+// @Success 200 {object} ReadOnlyStatus
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /read-only [put]
+func setReadOnlyHandler(mode *readOnlyMode) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := new(ReadOnlyStatus)
+		if err := parseJSON(c, req); err != nil {
+			return err
+		}
+		if req.ReadOnly == nil {
+			return newValidationError(map[string]string{"readOnly": "readOnly is required"})
+		}
+
+		if mode.on.Swap(*req.ReadOnly) != *req.ReadOnly {
+			by := ""
+			if claims := currentClaims(c); claims != nil {
+				by = claims.Subject
+			}
+			requestLog(c).Warn("read-only mode changed", slog.Bool("read_only", *req.ReadOnly), slog.String("by", by))
+		}
+		return c.JSON(req)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyMode(t *testing.T) {
+	alice := newTestUser(t, "alice", "correct horse", roleViewer)
+	app := newAuthTestApp(newFakeUserRepository(alice), newFakeRefreshTokenRepository())
+	path := "/employee/" + johnID.Hex()
+
+	if status, body := request(t, app, roleViewer, "PUT", "/read-only", `{"readOnly":true}`); status != 403 {
+		t.Errorf("toggle as a viewer: status = %d, body %q, want 403", status, body)
+	}
+	if status, body := request(t, app, roleAdmin, "PUT", "/read-only", `{}`); status != 422 || !strings.Contains(body, "readOnly is required") {
+		t.Errorf("toggle without a value: status = %d, body %q, want 422", status, body)
+	}
+	if status, body := request(t, app, roleAdmin, "PUT", "/read-only", `{"readOnly":true}`); status != 200 || body != `{"readOnly":true}` {
+		t.Fatalf("turn on: status = %d, body %q", status, body)
+	}
+
+	writes := []struct{ method, path, body string }{
+		{"POST", "/employee", `{"name":"Jane","email":"jane@example.com","salary":1,"age":20}`},
+		{"PATCH", path, `{"salary":2}`},
+		{"DELETE", path, ""},
+		{"POST", "/department", `{"name":"Sales"}`},
+		{"POST", "/jobs/recalculate", `{"bonusPercent":10}`},
+	}
+	for _, w := range writes {
+		if status, body := request(t, app, roleAdmin, w.method, w.path, w.body); status != 503 || !strings.Contains(body, "read-only mode") {
+			t.Errorf("%s %s: status = %d, body %q, want 503", w.method, w.path, status, body)
+		}
+	}
+
+	// reads, searching and logging in carry on
+	reads := []struct{ method, path, body string }{
+		{"GET", path, ""},
+		{"GET", "/employee", ""},
+		{"POST", "/employee/search", `{"filter":{}}`},
+		{"GET", "/read-only", ""},
+	}
+	for _, r := range reads {
+		if status, body := request(t, app, roleAdmin, r.method, r.path, r.body); status != 200 {
+			t.Errorf("%s %s: status = %d, body %q, want 200", r.method, r.path, status, body)
+		}
+	}
+	if status, body := request(t, app, "", "POST", "/login", `{"username":"alice","password":"correct horse"}`); status != 200 {
+		t.Errorf("login: status = %d, body %q, want 200", status, body)
+	}
+
+	if status, body := request(t, app, roleAdmin, "PUT", "/read-only", `{"readOnly":false}`); status != 200 {
+		t.Fatalf("turn off: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, roleAdmin, "PATCH", path, `{"salary":2}`); status != 200 {
+		t.Errorf("patch once turned off: status = %d, body %q, want 200", status, body)
+	}
+}
+
+func TestReadOnlyConfig(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("READ_ONLY", "true")
+	cfg, err := LoadConfig()
+	if err != nil || !cfg.ReadOnly {
+		t.Fatalf("READ_ONLY=true: ReadOnly = %v, err = %v, want true", cfg.ReadOnly, err)
+	}
+
+	t.Setenv("READ_ONLY", "maybe")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("READ_ONLY=maybe: want an error")
+	}
+
+	// the service starts refusing writes
+	cfg = testConfig()
+	cfg.ReadOnly = true
+	app := newApp(cfg, Repositories{
+		Database:        fakePinger{},
+		Employees:       newFakeRepository(john),
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+	if status, _ := request(t, app, roleAdmin, "PATCH", "/employee/"+johnID.Hex(), `{"salary":2}`); status != 503 {
+		t.Errorf("patch: status = %d, want 503", status)
+	}
+}
@@ -0,0 +1,911 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// errors the repository returns, so handlers don't need to know about mongo's own errors
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrDuplicateEmail      = errors.New("an employee with that email already exists")
+	ErrDuplicateDepartment = errors.New("a department with that name already exists")
+	ErrDepartmentInUse     = errors.New("department still has employees")
+	ErrNotDeleted          = errors.New("employee is not deleted")
+	ErrVersionMismatch     = errors.New("the employee has been changed since it was read")
+)
+
+// EmployeeRepository is everything the handlers need from the employee store.
+// Soft deleted employees are invisible to FindByID, Update, Patch and Delete,
+// while FindAll and Count return whatever the filter matches
+type EmployeeRepository interface {
+	FindAll(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]Employee, error)
+	Count(ctx context.Context, filter bson.D) (int64, error)
+	Distinct(ctx context.Context, field string, filter bson.D) ([]interface{}, error)
+	Stream(ctx context.Context, filter bson.D, opts *options.FindOptions, fn func(*Employee) error) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*Employee, error)
+	Create(ctx context.Context, employee *Employee) (*Employee, error)
+	CreateMany(ctx context.Context, employees []*Employee) ([]error, error)
+	Update(ctx context.Context, id primitive.ObjectID, employee *Employee, version *time.Time) (*Employee, error)
+	Patch(ctx context.Context, id primitive.ObjectID, fields bson.D) (*Employee, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	DeleteMany(ctx context.Context, ids []primitive.ObjectID) ([]Employee, error)
+	Raise(ctx context.Context, id primitive.ObjectID, factor Money) (*Employee, error)
+	RaiseMany(ctx context.Context, ids []primitive.ObjectID, factor Money) ([]Employee, error)
+	UpdateMany(ctx context.Context, ids []primitive.ObjectID, fields bson.D) ([]Employee, error)
+	Restore(ctx context.Context, id primitive.ObjectID) (*Employee, error)
+	Purge(ctx context.Context, id primitive.ObjectID) error
+	ReassignDepartment(ctx context.Context, from primitive.ObjectID, to *primitive.ObjectID) (int64, error)
+	SalaryStats(ctx context.Context, departmentID *primitive.ObjectID) ([]SalaryStats, error)
+	ManagementChain(ctx context.Context, id primitive.ObjectID) ([]primitive.ObjectID, error)
+	Reports(ctx context.Context, id primitive.ObjectID, all bool) ([]Report, error)
+	Recalculate(ctx context.Context, batchSize int, transform func(*Employee) bson.D, progress func(processed, updated int64)) error
+}
+
+// SalaryStats summarises the salaries of the employees in one department.
+// Employees without a department are grouped together with a nil DepartmentID
+type SalaryStats struct {
+	DepartmentID   *primitive.ObjectID `json:"departmentId" bson:"_id"`
+	DepartmentName string              `json:"departmentName,omitempty" bson:"departmentName,omitempty"`
+	Count          int64               `json:"count" bson:"count"`
+	Total          Money               `json:"total" bson:"total" swaggertype:"number"`
+	Average        Money               `json:"average" bson:"average" swaggertype:"number"`
+	Min            Money               `json:"min" bson:"min" swaggertype:"number"`
+	Max            Money               `json:"max" bson:"max" swaggertype:"number"`
+}
+
+// MongoEmployeeRepository is the EmployeeRepository backed by a mongo collection
+type MongoEmployeeRepository struct {
+	collection *mongo.Collection
+	// listReads is the same collection with MONGO_READ_PREFERENCE, for the
+	// reads of requests that went through listReads()
+	listReads *mongo.Collection
+}
+
+// NewMongoEmployeeRepository creates a repository storing employees in the
+// collection. listReads is the collection handle the list and stats endpoints
+// read from, nil reads everything from collection
+func NewMongoEmployeeRepository(collection, listReads *mongo.Collection) *MongoEmployeeRepository {
+	if listReads == nil {
+		listReads = collection
+	}
+	return &MongoEmployeeRepository{collection: collection, listReads: listReads}
+}
+
+// listReadsKey marks a context whose reads can go to listReads
+type listReadsKey struct{}
+
+// withListReads marks ctx so the employees are read with the list read
+// preference, which may hand back data a moment behind the primary
+func withListReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, listReadsKey{}, true)
+}
+
+// hasListReads reports whether ctx was marked by withListReads
+func hasListReads(ctx context.Context) bool {
+	return ctx.Value(listReadsKey{}) == true
+}
+
+// reader is the collection to read from for ctx
+func (r *MongoEmployeeRepository) reader(ctx context.Context) *mongo.Collection {
+	if hasListReads(ctx) {
+		return r.listReads
+	}
+	return r.collection
+}
+
+// EnsureIndexes makes sure the indexes the employees collection relies on
+// exist. Creating an index that already exists with the same spec is a no-op,
+// so this is safe to run on every startup
+func (r *MongoEmployeeRepository) EnsureIndexes(ctx context.Context) error {
+	if err := r.backfillDeletedAt(ctx); err != nil {
+		return err
+	}
+	// the sparse index email_unique kept deleted employees' emails too. It
+	// has the same keys as its replacement, which mongo won't create alongside it
+	if err := r.dropIndex(ctx, "email_unique"); err != nil {
+		return err
+	}
+	return ensureIndexes(ctx, r.collection, []mongo.IndexModel{
+		// no two active employees can share an email, deleted ones are left out
+		// so their email can be used again, by a new hire or by them when they
+		// are rehired. A partial index can't pick out a missing field, so it
+		// goes by deletedAt being null, which it is until the employee is deleted.
+		// Records created before email existed have none and are left out too
+		{
+			Keys: bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("email_unique_active").SetUnique(true).SetPartialFilterExpression(bson.D{
+				{Key: "email", Value: bson.D{{Key: "$type", Value: "string"}}},
+				{Key: "deletedAt", Value: bson.D{{Key: "$type", Value: "null"}}},
+			}),
+		},
+		// reports are looked up by their manager, one level at a time by $graphLookup
+		{
+			Keys:    bson.D{{Key: "managerId", Value: 1}},
+			Options: options.Index().SetName("manager"),
+		},
+		// the list, the exports and the salary stats by department filter on these.
+		// The sortable fields end with _id like the sorts do, so a page is read
+		// in order off the index
+		{
+			Keys:    bson.D{{Key: "departmentId", Value: 1}},
+			Options: options.Index().SetName("department"),
+		},
+		{
+			Keys:    bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("name"),
+		},
+		{
+			Keys:    bson.D{{Key: "salary", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("salary"),
+		},
+		{
+			Keys:    bson.D{{Key: "age", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("age"),
+		},
+		// for $text searches on whole words of a name, which the name index can't
+		// answer case insensitively
+		{
+			Keys:    bson.D{{Key: "name", Value: "text"}},
+			Options: options.Index().SetName("name_text"),
+		},
+	})
+}
+
+// backfillDeletedAt sets deletedAt to null on the employees stored without
+// one, back when it was left out until the delete. Until it is, the partial
+// email index doesn't see them. Once every employee has it this matches nothing
+func (r *MongoEmployeeRepository) backfillDeletedAt(ctx context.Context) error {
+	filter := bson.D{{Key: "deletedAt", Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "deletedAt", Value: nil}}}}
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("setting deletedAt on the employees without one: %w", err)
+	}
+	if result.ModifiedCount > 0 {
+		slog.Info("set deletedAt to null on employees stored without it", slog.Int64("employees", result.ModifiedCount))
+	}
+	return nil
+}
+
+// dropIndex drops an index that has been replaced, and does nothing when it
+// is already gone, or there is no collection yet
+func (r *MongoEmployeeRepository) dropIndex(ctx context.Context, name string) error {
+	_, err := r.collection.Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("dropping the index %s.%s: %w", r.collection.Name(), name, err)
+	}
+	slog.Info("index dropped", slog.String("collection", r.collection.Name()), slog.String("index", name))
+	return nil
+}
+
+// FindAll returns the employees matching the filter, sorted and paged by opts
+func (r *MongoEmployeeRepository) FindAll(ctx context.Context, filter bson.D, opts *options.FindOptions) ([]Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	// access the data of employees and capture the result in cursor
+	cursor, err := r.reader(ctx).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// format the data received in cursor into an Employee slice. It is made
+	// up front so an empty result is encoded as [] rather than null
+	employees := make([]Employee, 0)
+	if err := cursor.All(ctx, &employees); err != nil {
+		return nil, err
+	}
+	return employees, nil
+}
+
+// Stream calls fn with each employee matching the filter, one at a time as they
+// come off the cursor, so the whole result never has to be held in memory. It
+// stops at the first error fn returns. It runs for as long as the export does,
+// so only the deadline on ctx applies rather than the one of a single call
+func (r *MongoEmployeeRepository) Stream(ctx context.Context, filter bson.D, opts *options.FindOptions, fn func(*Employee) error) error {
+	// the slot is held for as long as the cursor is open
+	ctx, release, err := acquireSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	cursor, err := r.reader(ctx).Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		employee := new(Employee)
+		if err := cursor.Decode(employee); err != nil {
+			return err
+		}
+		if err := fn(employee); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Count returns how many employees match the filter
+func (r *MongoEmployeeRepository) Count(ctx context.Context, filter bson.D) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	return r.reader(ctx).CountDocuments(ctx, filter)
+}
+
+// Distinct returns the different values the field has among the employees
+// matching the filter, in no particular order. Employees without the field
+// are left out
+func (r *MongoEmployeeRepository) Distinct(ctx context.Context, field string, filter bson.D) ([]interface{}, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return r.reader(ctx).Distinct(ctx, field, filter)
+}
+
+// FindByID returns the employee with the id, or ErrNotFound
+func (r *MongoEmployeeRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	query := bson.D{{Key: "_id", Value: id}, notDeleted}
+
+	employee := new(Employee)
+	if err := r.collection.FindOne(ctx, query).Decode(employee); err != nil {
+		return nil, mapError(err, ErrDuplicateEmail)
+	}
+	return employee, nil
+}
+
+// Create inserts the employee and returns the record as it was stored. Mongo
+// always creates the id, and the timestamps are set here whatever the caller sent
+func (r *MongoEmployeeRepository) Create(ctx context.Context, employee *Employee) (*Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	now := time.Now().UTC()
+	employee.ID = primitive.NilObjectID
+	employee.CreatedAt = now
+	employee.UpdatedAt = now
+	employee.DeletedAt = nil
+	employee.Bonus = nil
+
+	insertionResult, err := r.collection.InsertOne(ctx, employee)
+	if err != nil {
+		return nil, mapError(err, ErrDuplicateEmail)
+	}
+
+	/*
+		We will now use the mongo id of the just inserted result, captured in the insertion result
+		to search for the corresponding data to that ID instance. This makes us doubly sure
+		that the data was inserted well.
+	*/
+	filter := bson.D{{Key: "_id", Value: insertionResult.InsertedID}}
+	createdEmployee := new(Employee)
+	// the insert worked, but the handler must not answer 201 with an empty record
+	// if reading it back fails, so this is an error like any other
+	if err := r.collection.FindOne(ctx, filter).Decode(createdEmployee); err != nil {
+		return nil, fmt.Errorf("reading back the created employee: %w", err)
+	}
+	return createdEmployee, nil
+}
+
+// CreateMany inserts the employees in one go. One failing employee doesn't stop
+// the rest, so alongside the overall error it returns an error per employee,
+// nil for the ones that were inserted. The employees are given their ids and
+// timestamps, so those inserted are as stored
+func (r *MongoEmployeeRepository) CreateMany(ctx context.Context, employees []*Employee) ([]error, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	now := time.Now().UTC()
+	documents := make([]interface{}, len(employees))
+	for i, employee := range employees {
+		employee.ID = primitive.NewObjectID()
+		employee.CreatedAt = now
+		employee.UpdatedAt = now
+		employee.DeletedAt = nil
+		employee.Bonus = nil
+		documents[i] = employee
+	}
+
+	rowErrors := make([]error, len(employees))
+	if len(documents) == 0 {
+		return rowErrors, nil
+	}
+
+	// unordered, so mongo carries on past the rows it can't insert
+	_, err = r.collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if mongo.IsDuplicateKeyError(writeErr) {
+				rowErrors[writeErr.Index] = ErrDuplicateEmail
+			} else {
+				rowErrors[writeErr.Index] = errors.New(writeErr.Message)
+			}
+		}
+		return rowErrors, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rowErrors, nil
+}
+
+// Update replaces the editable fields of the employee and returns the stored
+// result. Unless version is nil the update only happens while the employee's
+// updatedAt is still version, otherwise it returns ErrVersionMismatch
+func (r *MongoEmployeeRepository) Update(ctx context.Context, id primitive.ObjectID, employee *Employee, version *time.Time) (*Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	fields := bson.D{
+		{Key: "name", Value: employee.Name},
+		{Key: "email", Value: employee.Email},
+		{Key: "age", Value: employee.Age},
+		{Key: "salary", Value: employee.Salary},
+		{Key: "position", Value: employee.Position},
+		{Key: "hireDate", Value: employee.HireDate},
+		{Key: "departmentId", Value: employee.DepartmentID},
+		{Key: "managerId", Value: employee.ManagerID},
+	}
+	// the address is replaced as a whole, and removed rather than set to null
+	// when there is none, since the fields of a null one can't be patched
+	unset := bson.D{}
+	if employee.Address != nil {
+		fields = append(fields, bson.E{Key: "address", Value: employee.Address})
+	} else {
+		unset = append(unset, bson.E{Key: "address", Value: ""})
+	}
+	return r.update(ctx, id, fields, unset, version)
+}
+
+// Patch sets only the given fields on the employee and returns the stored result
+func (r *MongoEmployeeRepository) Patch(ctx context.Context, id primitive.ObjectID, fields bson.D) (*Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return r.update(ctx, id, fields, nil, nil)
+}
+
+// update $sets the fields and $unsets the unset ones, bumping updatedAt, and
+// returns the document as it is after the update so callers see what is
+// really stored. A version, if there is one, has to match updatedAt for the
+// update to happen
+func (r *MongoEmployeeRepository) update(ctx context.Context, id primitive.ObjectID, fields, unset bson.D, version *time.Time) (*Employee, error) {
+	query := bson.D{{Key: "_id", Value: id}, notDeleted}
+	if version != nil {
+		query = append(query, bson.E{Key: "updatedAt", Value: *version})
+	}
+	// the server owns updatedAt, so any value the client sent is ignored
+	fields = append(fields, bson.E{Key: "updatedAt", Value: time.Now().UTC()})
+	update := bson.D{{Key: "$set", Value: fields}}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	updatedEmployee := new(Employee)
+	err := r.collection.FindOneAndUpdate(ctx, query, update, opts).Decode(updatedEmployee)
+	if errors.Is(err, mongo.ErrNoDocuments) && version != nil {
+		// the employee is either gone or has moved on to another version
+		count, countErr := r.collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}, notDeleted})
+		if countErr != nil {
+			return nil, countErr
+		}
+		if count > 0 {
+			return nil, ErrVersionMismatch
+		}
+	}
+	if err != nil {
+		return nil, mapError(err, ErrDuplicateEmail)
+	}
+	return updatedEmployee, nil
+}
+
+// Delete soft deletes the employee. The record stays in the database for
+// history and payroll reconciliation, it is just marked with the time it was
+// deleted and hidden from everything else
+func (r *MongoEmployeeRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	query := bson.D{{Key: "_id", Value: id}, notDeleted}
+	now := time.Now().UTC()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "deletedAt", Value: now},
+			{Key: "updatedAt", Value: now},
+		}},
+	}
+	return mapError(r.collection.FindOneAndUpdate(ctx, query, update).Err(), ErrDuplicateEmail)
+}
+
+// DeleteMany soft deletes every employee with one of the ids in one update, and
+// returns the employees it deleted as they were before. Ids that don't match
+// an employee, or match one that is already deleted, are skipped
+func (r *MongoEmployeeRepository) DeleteMany(ctx context.Context, ids []primitive.ObjectID) ([]Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	query := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}, notDeleted}
+	employees, err := r.FindAll(ctx, query, options.Find())
+	if err != nil {
+		return nil, err
+	}
+	if len(employees) == 0 {
+		return employees, nil
+	}
+
+	// only touch the employees we found, so what we return is what was deleted
+	found := make([]primitive.ObjectID, len(employees))
+	for i, employee := range employees {
+		found[i] = employee.ID
+	}
+	now := time.Now().UTC()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "deletedAt", Value: now},
+			{Key: "updatedAt", Value: now},
+		}},
+	}
+	query = bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: found}}}, notDeleted}
+	if _, err := r.collection.UpdateMany(ctx, query, update); err != nil {
+		return nil, err
+	}
+	return employees, nil
+}
+
+// raiseUpdate multiplies the salary by the factor in the database, so raises
+// made at the same time can't overwrite each other. It is a pipeline rather
+// than a $mul so the result can be rounded to the cent in the same update
+func raiseUpdate(factor Money) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "salary", Value: bson.D{{Key: "$round", Value: bson.A{
+				bson.D{{Key: "$multiply", Value: bson.A{bson.D{{Key: "$toDecimal", Value: "$salary"}}, factor}}},
+				2,
+			}}}},
+			{Key: "updatedAt", Value: time.Now().UTC()},
+		}}},
+	}
+}
+
+// Raise multiplies the employee's salary by the factor and returns the
+// employee as it is after the raise
+func (r *MongoEmployeeRepository) Raise(ctx context.Context, id primitive.ObjectID, factor Money) (*Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	query := bson.D{{Key: "_id", Value: id}, notDeleted}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	raisedEmployee := new(Employee)
+	if err := r.collection.FindOneAndUpdate(ctx, query, raiseUpdate(factor), opts).Decode(raisedEmployee); err != nil {
+		return nil, mapError(err, ErrDuplicateEmail)
+	}
+	return raisedEmployee, nil
+}
+
+// RaiseMany multiplies the salaries of every employee with one of the ids by
+// the factor in one update, and returns the employees as they are after it.
+// Ids that don't match an employee, or match a deleted one, are skipped
+func (r *MongoEmployeeRepository) RaiseMany(ctx context.Context, ids []primitive.ObjectID, factor Money) ([]Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	query := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}, notDeleted}
+	if _, err := r.collection.UpdateMany(ctx, query, raiseUpdate(factor)); err != nil {
+		return nil, err
+	}
+	return r.FindAll(ctx, query, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+// UpdateMany $sets the fields on every employee with one of the ids in one
+// update, bumping their updatedAt, and returns the employees as they are after
+// it. Ids that don't match an employee, or match a deleted one, are skipped
+func (r *MongoEmployeeRepository) UpdateMany(ctx context.Context, ids []primitive.ObjectID, fields bson.D) ([]Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	query := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}, notDeleted}
+	fields = append(fields, bson.E{Key: "updatedAt", Value: time.Now().UTC()})
+	if _, err := r.collection.UpdateMany(ctx, query, bson.D{{Key: "$set", Value: fields}}); err != nil {
+		return nil, err
+	}
+	return r.FindAll(ctx, query, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+}
+
+// Restore brings back a soft deleted employee and returns it. It returns
+// ErrNotFound when there is no employee with the id at all, and ErrNotDeleted
+// when the employee exists but was never deleted
+func (r *MongoEmployeeRepository) Restore(ctx context.Context, id primitive.ObjectID) (*Employee, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	query := bson.D{{Key: "_id", Value: id}, {Key: "deletedAt", Value: bson.D{{Key: "$ne", Value: nil}}}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "deletedAt", Value: nil},
+			{Key: "updatedAt", Value: time.Now().UTC()},
+		}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	restoredEmployee := new(Employee)
+	err = r.collection.FindOneAndUpdate(ctx, query, update, opts).Decode(restoredEmployee)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		if err != nil {
+			return nil, mapError(err, ErrDuplicateEmail)
+		}
+		return restoredEmployee, nil
+	}
+
+	// nothing deleted matched, work out whether the employee is missing or active
+	count, err := r.collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrNotFound
+	}
+	return nil, ErrNotDeleted
+}
+
+// Purge removes the employee for good, whether or not they were soft deleted
+// first. It returns ErrNotFound when there is no employee with the id
+func (r *MongoEmployeeRepository) Purge(ctx context.Context, id primitive.ObjectID) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	result, err := r.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount < 1 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ReassignDepartment moves every employee in the from department to the to
+// department, or out of any department when to is nil, returning how many were
+// moved. Soft deleted employees are moved too, so they don't point at a
+// department that is gone if they are ever brought back
+func (r *MongoEmployeeRepository) ReassignDepartment(ctx context.Context, from primitive.ObjectID, to *primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	query := bson.D{{Key: "departmentId", Value: from}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "departmentId", Value: to},
+			{Key: "updatedAt", Value: time.Now().UTC()},
+		}},
+	}
+	result, err := r.collection.UpdateMany(ctx, query, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// Recalculate goes through the active employees in id order, batchSize at a
+// time, and $sets the fields transform returns on each, or leaves it alone
+// when it returns none. The changes of a batch are written with one bulk
+// write, and progress is told how many employees have been processed and
+// updated after each. An employee edited between being read and written is
+// skipped, rather than having the edit overwritten by a value worked out from
+// the old record
+func (r *MongoEmployeeRepository) Recalculate(ctx context.Context, batchSize int, transform func(*Employee) bson.D, progress func(processed, updated int64)) error {
+	// one slot is held for the whole recalculation, its cursor stays open throughout
+	ctx, release, err := acquireSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetBatchSize(int32(batchSize))
+	cursor, err := r.collection.Find(ctx, bson.D{notDeleted}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var processed, updated int64
+	models := make([]mongo.WriteModel, 0, batchSize)
+	flush := func() error {
+		if len(models) > 0 {
+			// unordered, since the updates don't depend on each other. Each
+			// batch gets the deadline of one call, the job as a whole has none
+			writeCtx, cancel := operationContext(ctx)
+			result, err := r.collection.BulkWrite(writeCtx, models, options.BulkWrite().SetOrdered(false))
+			cancel()
+			if err != nil {
+				return err
+			}
+			updated += result.ModifiedCount
+			models = models[:0]
+		}
+		progress(processed, updated)
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		employee := new(Employee)
+		if err := cursor.Decode(employee); err != nil {
+			return err
+		}
+		processed++
+		if fields := transform(employee); len(fields) > 0 {
+			fields = append(fields, bson.E{Key: "updatedAt", Value: time.Now().UTC()})
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.D{{Key: "_id", Value: employee.ID}, {Key: "updatedAt", Value: employee.UpdatedAt}, notDeleted}).
+				SetUpdate(bson.D{{Key: "$set", Value: fields}}))
+		}
+		if processed%int64(batchSize) == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if processed%int64(batchSize) != 0 || processed == 0 {
+		return flush()
+	}
+	return nil
+}
+
+// SalaryStats groups the active employees by department and works out the
+// count, total, average, min and max salary of each. The average is rounded
+// to the cent. When departmentID is given only that department is included
+func (r *MongoEmployeeRepository) SalaryStats(ctx context.Context, departmentID *primitive.ObjectID) ([]SalaryStats, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	match := bson.D{notDeleted}
+	if departmentID != nil {
+		match = append(match, bson.E{Key: "departmentId", Value: *departmentID})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$departmentId"},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "total", Value: bson.D{{Key: "$sum", Value: "$salary"}}},
+			{Key: "average", Value: bson.D{{Key: "$avg", Value: "$salary"}}},
+			{Key: "min", Value: bson.D{{Key: "$min", Value: "$salary"}}},
+			{Key: "max", Value: bson.D{{Key: "$max", Value: "$salary"}}},
+		}}},
+		{{Key: "$set", Value: bson.D{
+			{Key: "average", Value: bson.D{{Key: "$round", Value: bson.A{"$average", 2}}}},
+		}}},
+		// pull in the department name so the results can be shown without another request
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: departmentsCollection},
+			{Key: "localField", Value: "_id"},
+			{Key: "foreignField", Value: "_id"},
+			{Key: "as", Value: "department"},
+		}}},
+		{{Key: "$set", Value: bson.D{
+			{Key: "departmentName", Value: bson.D{{Key: "$arrayElemAt", Value: bson.A{"$department.name", 0}}}},
+		}}},
+		{{Key: "$project", Value: bson.D{{Key: "department", Value: 0}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "departmentName", Value: 1}}}},
+	}
+
+	cursor, err := r.reader(ctx).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]SalaryStats, 0)
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// ManagementChain returns the ids of the employee's manager, their manager and
+// so on up to the top of the organisation, in no particular order.
+// $graphLookup keeps track of who it has visited, so a chain that loops back on
+// itself ends rather than going round forever
+func (r *MongoEmployeeRepository) ManagementChain(ctx context.Context, id primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "_id", Value: id}}}},
+		{{Key: "$graphLookup", Value: bson.D{
+			{Key: "from", Value: r.collection.Name()},
+			{Key: "startWith", Value: "$managerId"},
+			{Key: "connectFromField", Value: "managerId"},
+			{Key: "connectToField", Value: "_id"},
+			{Key: "as", Value: "chain"},
+		}}},
+		{{Key: "$project", Value: bson.D{{Key: "chain", Value: "$chain._id"}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		Chain []primitive.ObjectID `bson:"chain"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrNotFound
+	}
+	return results[0].Chain, nil
+}
+
+// Reports returns the active employees the employee manages, sorted by name.
+// With all set it returns everyone under them rather than only their direct
+// reports, level by level, walking down the managerId links with $graphLookup.
+// A soft deleted employee ends the walk, the people under them aren't
+// reachable until they are given a new manager or the employee is restored
+func (r *MongoEmployeeRepository) Reports(ctx context.Context, id primitive.ObjectID, all bool) ([]Report, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	lookup := bson.D{
+		{Key: "from", Value: r.collection.Name()},
+		{Key: "startWith", Value: "$_id"},
+		{Key: "connectFromField", Value: "_id"},
+		{Key: "connectToField", Value: "managerId"},
+		{Key: "as", Value: "reports"},
+		{Key: "depthField", Value: "level"},
+		{Key: "restrictSearchWithMatch", Value: bson.D{notDeleted}},
+	}
+	if !all {
+		// depth 0 is the employees managed by the employee themselves
+		lookup = append(lookup, bson.E{Key: "maxDepth", Value: 0})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "_id", Value: id}}}},
+		{{Key: "$graphLookup", Value: lookup}},
+		{{Key: "$unwind", Value: "$reports"}},
+		{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$reports"}}}},
+		// depthField counts from 0 for the direct reports
+		{{Key: "$set", Value: bson.D{{Key: "level", Value: bson.D{{Key: "$add", Value: bson.A{"$level", 1}}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "level", Value: 1}, {Key: "name", Value: 1}, {Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]Report, 0)
+	if err := cursor.All(ctx, &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// mapError translates the mongo errors handlers care about into our own.
+// Duplicate key errors become duplicateErr, which says what was duplicated
+func mapError(err error, duplicateErr error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return duplicateErr
+	default:
+		return err
+	}
+}
+
+// softDeleteByEmployees sets deletedAt on the records in the collection that
+// belong to the employees and aren't deleted yet, returning how many it set
+func softDeleteByEmployees(ctx context.Context, collection *mongo.Collection, employeeIDs []primitive.ObjectID) (int64, error) {
+	filter := bson.D{{Key: "employeeId", Value: bson.D{{Key: "$in", Value: employeeIDs}}}, notDeleted}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "deletedAt", Value: time.Now().UTC()}}}}
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// restoreByEmployee unsets deletedAt on the employee's records in the
+// collection, returning how many it unset
+func restoreByEmployee(ctx context.Context, collection *mongo.Collection, employeeID primitive.ObjectID) (int64, error) {
+	filter := bson.D{{Key: "employeeId", Value: employeeID}, {Key: "deletedAt", Value: bson.D{{Key: "$ne", Value: nil}}}}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "deletedAt", Value: ""}}}}
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ensureIndexes creates the indexes of a collection that aren't there yet in
+// one call, and logs which were created and which were already present. Every
+// model must have a name, that's how the present ones are told apart
+func ensureIndexes(ctx context.Context, collection *mongo.Collection, models []mongo.IndexModel) error {
+	existing, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return fmt.Errorf("listing the indexes of %s: %w", collection.Name(), err)
+	}
+	present := make(map[string]bool, len(existing))
+	for _, spec := range existing {
+		present[spec.Name] = true
+	}
+
+	// the present ones are sent too, so one whose spec changed is reported
+	// rather than silently kept
+	if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+		return fmt.Errorf("creating the indexes of %s: %w", collection.Name(), err)
+	}
+	for _, model := range models {
+		name := *model.Options.Name
+		if present[name] {
+			slog.Info("index already present", slog.String("collection", collection.Name()), slog.String("index", name))
+		} else {
+			slog.Info("index created", slog.String("collection", collection.Name()), slog.String("index", name))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// a salary change is scheduled until its effective date has passed and it has
+// been applied to the employee
+const (
+	salaryScheduled = "scheduled"
+	salaryApplied   = "applied"
+)
+
+const maxSalaryReasonLength = 500
+
+// SalaryChange is an employee's salary becoming NewSalary from EffectiveDate
+// on. OldSalary is the salary it replaced, and is only known once the change
+// has been applied, at AppliedAt
+type SalaryChange struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty" swaggertype:"string"`
+	EmployeeID    primitive.ObjectID `json:"employeeId" bson:"employeeId" swaggertype:"string"`
+	OldSalary     *Money             `json:"oldSalary,omitempty" bson:"oldSalary,omitempty" swaggertype:"number"`
+	NewSalary     Money              `json:"newSalary" bson:"newSalary" swaggertype:"number"`
+	EffectiveDate time.Time          `json:"effectiveDate" bson:"effectiveDate"`
+	Reason        string             `json:"reason"`
+	Status        string             `json:"status"`
+	AppliedAt     *time.Time         `json:"appliedAt,omitempty" bson:"appliedAt,omitempty"`
+	CreatedBy     string             `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// SalaryChangeRequest is the body of scheduling a salary change. NewSalary
+// is the whole new salary, not the difference
+type SalaryChangeRequest struct {
+	NewSalary     *Money        `json:"newSalary" swaggertype:"number"`
+	EffectiveDate EffectiveDate `json:"effectiveDate" swaggertype:"string" example:"2026-11-01"`
+	Reason        string        `json:"reason"`
+}
+
+// EffectiveDate is the day a salary change takes effect, sent as a plain date
+// like 2026-11-01, which is midnight UTC, or as a full RFC 3339 time
+type EffectiveDate struct {
+	time.Time
+}
+
+// UnmarshalJSON reads the date in either layout
+func (d *EffectiveDate) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("effectiveDate must be a date like 2026-11-01")
+	}
+	for _, layout := range []string{dateLayout, time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			d.Time = t
+			return nil
+		}
+	}
+	return fmt.Errorf("effectiveDate must be a date like 2026-11-01, not %q", s)
+}
+
+// validate checks the request fields against now and returns a map of field
+// name to the reason it failed. The effective date can be earlier today, the
+// change is then applied straight away
+func (r *SalaryChangeRequest) validate(now time.Time) map[string]string {
+	errs := make(map[string]string)
+
+	if r.NewSalary == nil {
+		errs["newSalary"] = "newSalary is required"
+	} else if r.NewSalary.Sign() < 0 {
+		errs["newSalary"] = "newSalary must be greater than or equal to 0"
+	} else if r.NewSalary.DecimalPlaces() > 2 {
+		errs["newSalary"] = "newSalary can have at most 2 decimal places"
+	}
+	switch {
+	case r.EffectiveDate.IsZero():
+		errs["effectiveDate"] = "effectiveDate is required"
+	case r.EffectiveDate.Before(now.UTC().Truncate(24 * time.Hour)):
+		errs["effectiveDate"] = "effectiveDate can't be in the past"
+	}
+	if len(r.Reason) > maxSalaryReasonLength {
+		errs["reason"] = fmt.Sprintf("reason can be at most %d characters", maxSalaryReasonLength)
+	}
+	return errs
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SalaryChangeHandler holds the HTTP handlers for an employee's salary
+// timeline. It needs the employees to check a change is scheduled for one
+// that exists
+type SalaryChangeHandler struct {
+	repo      SalaryChangeRepository
+	employees EmployeeRepository
+	audit     AuditRepository
+}
+
+// NewSalaryChangeHandler creates the salary change handlers on top of the
+// repositories. Every change scheduled is recorded in the audit log
+func NewSalaryChangeHandler(repo SalaryChangeRepository, employees EmployeeRepository, audit AuditRepository) *SalaryChangeHandler {
+	return &SalaryChangeHandler{repo: repo, employees: employees, audit: audit}
+}
+
+// Schedule dates a change to an employee's salary, e.g a raise effective from
+// the first of next month. The salary itself is only changed once the
+// effective date has passed, by the salary scheduler
+//
+// @Summary Schedule a change to an employee's salary
+// @Tags employees
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Param change body SalaryChangeRequest true "The new salary, when it takes effect and why"
+// @Success 201 {object} SalaryChange
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /employee/{id}/salary-history [post]
+func (h *SalaryChangeHandler) Schedule(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	req := new(SalaryChangeRequest)
+	if err := parseJSON(c, req); err != nil {
+		return err
+	}
+	if errs := req.validate(time.Now()); len(errs) > 0 {
+		return newValidationError(errs)
+	}
+	if _, err := h.employees.FindByID(c.UserContext(), employeeID); err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	change := &SalaryChange{
+		EmployeeID:    employeeID,
+		NewSalary:     *req.NewSalary,
+		EffectiveDate: req.EffectiveDate.UTC(),
+		Reason:        req.Reason,
+	}
+	if claims := currentClaims(c); claims != nil {
+		change.CreatedBy = claims.Subject
+	}
+	createdChange, err := h.repo.Create(c.UserContext(), change)
+	if err != nil {
+		return err
+	}
+	recordAudit(c, h.audit, auditCreate, salaryChangesCollection, createdChange.ID.Hex(), nil, createdChange)
+	return c.Status(201).JSON(createdChange)
+}
+
+// List returns an employee's salary timeline: the changes already applied,
+// with the salary each one replaced, and the ones still scheduled, earliest
+// effective date first
+//
+// @Summary List the salary changes of an employee
+// @Tags employees
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Employee id"
+// @Success 200 {array} SalaryChange
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Router /employee/{id}/salary-history [get]
+func (h *SalaryChangeHandler) List(c *fiber.Ctx) error {
+	employeeID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	if _, err := h.employees.FindByID(c.UserContext(), employeeID); err != nil {
+		return repositoryError(err, "employee")
+	}
+
+	changes, err := h.repo.FindByEmployee(c.UserContext(), employeeID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(changes)
+}
+
+// applyDueSalaryChanges sets the salary of every employee with a scheduled
+// change effective at or before now, earliest first, and returns how many it
+// applied. Each change is claimed by marking it applied, which only succeeds
+// once, before the salary is set, so it happens once even with several
+// instances applying at once, and one that loses the claim leaves the salary
+// alone. Where the server supports them the two happen in a transaction,
+// without one a failure in between leaves the change applied and the salary
+// as it was, which is logged. The changes of deleted employees are left
+// scheduled, they are applied if the employee is restored. Every database call
+// is bounded by the operation timeout on ctx
+func applyDueSalaryChanges(ctx context.Context, repos Repositories, now time.Time) (int, error) {
+	due, err := repos.SalaryChanges.FindDue(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, change := range due {
+		ok, err := applySalaryChange(ctx, repos, change, now)
+		if err != nil {
+			if ctx.Err() != nil {
+				return applied, err
+			}
+			slog.Error("applying salary change", slog.String("salary_change_id", change.ID.Hex()), slog.String("employee_id", change.EmployeeID.Hex()), slog.Any("error", err))
+		}
+		if ok {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// applySalaryChange sets the employee's salary to the change's and records it
+// like any other update, all within one operation timeout.
+// It reports false without an error when the employee is deleted, or another
+// instance claimed the change first
+func applySalaryChange(ctx context.Context, repos Repositories, change SalaryChange, now time.Time) (bool, error) {
+	ctx, cancel := operationContext(ctx)
+	defer cancel()
+
+	var before, after *Employee
+	var claimed bool
+	err := repos.Transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		// the transaction may be retried from the start
+		claimed = false
+		var err error
+		if before, err = repos.Employees.FindByID(ctx, change.EmployeeID); err != nil {
+			return err
+		}
+		// claimed first, so an instance that lost the race can't overwrite a
+		// salary a later change has set since
+		if err := repos.SalaryChanges.MarkApplied(ctx, change.ID, before.Salary, now); err != nil {
+			return err
+		}
+		claimed = true
+		after, err = repos.Employees.Patch(ctx, change.EmployeeID, bson.D{{Key: "salary", Value: change.NewSalary}})
+		return err
+	})
+	if errors.Is(err, ErrNotFound) && !claimed {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	log := slog.Default().With(slog.String("salary_change_id", change.ID.Hex()), slog.String("employee_id", change.EmployeeID.Hex()))
+	log.Info("salary change applied", slog.String("old_salary", before.Salary.String()), slog.String("new_salary", change.NewSalary.String()))
+	employeeChanges.WithLabelValues(actionUpdated).Inc()
+	// there is no request to take the user from, so the change is put down
+	// to whoever scheduled it
+	entry := &AuditEntry{
+		Action:     auditUpdate,
+		Collection: employeesCollection,
+		DocumentID: change.EmployeeID.Hex(),
+		Actor:      change.CreatedBy,
+		Before:     auditSnapshot(before),
+		After:      auditSnapshot(after),
+		Timestamp:  now,
+	}
+	if err := repos.AuditLogs.Record(ctx, entry); err != nil {
+		log.Error("writing audit entry", slog.Any("error", err))
+	}
+	revision := &EmployeeRevision{EmployeeID: after.ID, Employee: *after, ChangedBy: change.CreatedBy, RecordedAt: now}
+	if err := repos.History.Record(ctx, revision); err != nil {
+		log.Error("writing employee revision", slog.Any("error", err))
+	}
+	repos.Events.Publish(eventEmployeeUpdated, after)
+	return true, nil
+}
+
+// salaryScheduler applies the salary changes that have become due, every
+// interval. It leaves them be while the service is in read-only mode
+type salaryScheduler struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// startSalaryScheduler applies the due changes straight away and then every
+// interval, until it is closed. ctx carries the limits its database calls get,
+// the operation timeout and the concurrency limit, like those of a request,
+// so a slow database can't hold it up for good
+func startSalaryScheduler(ctx context.Context, interval time.Duration, repos Repositories, readOnly *readOnlyMode) *salaryScheduler {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &salaryScheduler{cancel: cancel}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if !readOnly.on.Load() {
+				if _, err := applyDueSalaryChanges(ctx, repos, time.Now().UTC()); err != nil && ctx.Err() == nil {
+					slog.Error("applying salary changes", slog.Any("error", err))
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return s
+}
+
+// close stops the scheduler, cancelling the change it is applying, and waits
+// for it to return
+func (s *salaryScheduler) close() {
+	s.cancel()
+	s.wg.Wait()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SalaryChangeRepository is everything the handlers and the scheduler need
+// from the salary change store
+type SalaryChangeRepository interface {
+	// FindByEmployee returns the employee's changes, earliest effective date first
+	FindByEmployee(ctx context.Context, employeeID primitive.ObjectID) ([]SalaryChange, error)
+	Create(ctx context.Context, change *SalaryChange) (*SalaryChange, error)
+	// FindDue returns the scheduled changes effective at or before now,
+	// earliest first
+	FindDue(ctx context.Context, now time.Time) ([]SalaryChange, error)
+	// MarkApplied records a scheduled change as applied over oldSalary, or
+	// fails with ErrNotFound when it isn't scheduled any more
+	MarkApplied(ctx context.Context, id primitive.ObjectID, oldSalary Money, at time.Time) error
+	// DeleteByEmployee removes all of the employee's changes, returning how many there were
+	DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error)
+}
+
+// MongoSalaryChangeRepository is the SalaryChangeRepository backed by a mongo collection
+type MongoSalaryChangeRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoSalaryChangeRepository creates a repository storing salary changes in the collection
+func NewMongoSalaryChangeRepository(collection *mongo.Collection) *MongoSalaryChangeRepository {
+	return &MongoSalaryChangeRepository{collection: collection}
+}
+
+// EnsureIndexes creates the indexes an employee's timeline and the changes
+// waiting to be applied are read with
+func (r *MongoSalaryChangeRepository) EnsureIndexes(ctx context.Context) error {
+	return ensureIndexes(ctx, r.collection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "employeeId", Value: 1}, {Key: "effectiveDate", Value: 1}},
+			Options: options.Index().SetName("employee_salary_changes"),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "effectiveDate", Value: 1}},
+			Options: options.Index().SetName("status_salary_changes"),
+		},
+	})
+}
+
+// FindByEmployee returns the employee's changes, earliest effective date first
+func (r *MongoSalaryChangeRepository) FindByEmployee(ctx context.Context, employeeID primitive.ObjectID) ([]SalaryChange, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return r.find(ctx, bson.D{{Key: "employeeId", Value: employeeID}})
+}
+
+// Create inserts the change as scheduled and returns the record as it was stored
+func (r *MongoSalaryChangeRepository) Create(ctx context.Context, change *SalaryChange) (*SalaryChange, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	change.ID = primitive.NilObjectID
+	change.Status = salaryScheduled
+	change.OldSalary = nil
+	change.AppliedAt = nil
+	change.CreatedAt = time.Now().UTC()
+
+	insertionResult, err := r.collection.InsertOne(ctx, change)
+	if err != nil {
+		return nil, err
+	}
+
+	createdChange := new(SalaryChange)
+	filter := bson.D{{Key: "_id", Value: insertionResult.InsertedID}}
+	if err := r.collection.FindOne(ctx, filter).Decode(createdChange); err != nil {
+		return nil, fmt.Errorf("reading back the created salary change: %w", err)
+	}
+	return createdChange, nil
+}
+
+// FindDue returns the scheduled changes effective at or before now, earliest first
+func (r *MongoSalaryChangeRepository) FindDue(ctx context.Context, now time.Time) ([]SalaryChange, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return r.find(ctx, bson.D{
+		{Key: "status", Value: salaryScheduled},
+		{Key: "effectiveDate", Value: bson.D{{Key: "$lte", Value: now}}},
+	})
+}
+
+// find returns the changes matching the filter, earliest effective date
+// first, and the earliest scheduled first among changes on the same date
+func (r *MongoSalaryChangeRepository) find(ctx context.Context, filter bson.D) ([]SalaryChange, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "effectiveDate", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]SalaryChange, 0)
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// MarkApplied sets the change as applied over oldSalary. The status is part of
+// the filter, so two instances applying the same change can't both succeed
+func (r *MongoSalaryChangeRepository) MarkApplied(ctx context.Context, id primitive.ObjectID, oldSalary Money, at time.Time) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "status", Value: salaryScheduled}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: salaryApplied},
+			{Key: "oldSalary", Value: oldSalary},
+			{Key: "appliedAt", Value: at},
+		}},
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteByEmployee removes all of the employee's changes
+func (r *MongoSalaryChangeRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	result, err := r.collection.DeleteMany(ctx, bson.D{{Key: "employeeId", Value: employeeID}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeSalaryChangeRepository is an in-memory SalaryChangeRepository
+type fakeSalaryChangeRepository struct {
+	changes map[primitive.ObjectID]SalaryChange
+}
+
+func newFakeSalaryChangeRepository() *fakeSalaryChangeRepository {
+	return &fakeSalaryChangeRepository{changes: make(map[primitive.ObjectID]SalaryChange)}
+}
+
+func (r *fakeSalaryChangeRepository) FindByEmployee(ctx context.Context, employeeID primitive.ObjectID) ([]SalaryChange, error) {
+	return r.find(func(s SalaryChange) bool { return s.EmployeeID == employeeID }), nil
+}
+
+func (r *fakeSalaryChangeRepository) Create(ctx context.Context, change *SalaryChange) (*SalaryChange, error) {
+	change.ID = primitive.NewObjectID()
+	change.Status = salaryScheduled
+	change.CreatedAt = time.Now().UTC()
+	r.changes[change.ID] = *change
+	return change, nil
+}
+
+func (r *fakeSalaryChangeRepository) FindDue(ctx context.Context, now time.Time) ([]SalaryChange, error) {
+	return r.find(func(s SalaryChange) bool { return s.Status == salaryScheduled && !s.EffectiveDate.After(now) }), nil
+}
+
+func (r *fakeSalaryChangeRepository) find(matches func(SalaryChange) bool) []SalaryChange {
+	changes := make([]SalaryChange, 0)
+	for _, s := range r.changes {
+		if matches(s) {
+			changes = append(changes, s)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].EffectiveDate.Before(changes[j].EffectiveDate) })
+	return changes
+}
+
+func (r *fakeSalaryChangeRepository) MarkApplied(ctx context.Context, id primitive.ObjectID, oldSalary Money, at time.Time) error {
+	s, ok := r.changes[id]
+	if !ok || s.Status != salaryScheduled {
+		return ErrNotFound
+	}
+	s.Status, s.OldSalary, s.AppliedAt = salaryApplied, &oldSalary, &at
+	r.changes[id] = s
+	return nil
+}
+
+func (r *fakeSalaryChangeRepository) DeleteByEmployee(ctx context.Context, employeeID primitive.ObjectID) (int64, error) {
+	var deleted int64
+	for id, s := range r.changes {
+		if s.EmployeeID == employeeID {
+			delete(r.changes, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func TestSalaryChangeValidation(t *testing.T) {
+	path := "/employee/" + johnID.Hex() + "/salary-history"
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(time.RFC3339)
+
+	runHandlerTests(t, []handlerTest{
+		{name: "missing salary", method: "POST", path: path, body: `{"effectiveDate":"` + tomorrow + `"}`, wantStatus: 422, wantBody: "newSalary is required"},
+		{name: "negative salary", method: "POST", path: path, body: `{"newSalary":-1,"effectiveDate":"` + tomorrow + `"}`, wantStatus: 422, wantBody: "newSalary must be greater than or equal to 0"},
+		{name: "too precise", method: "POST", path: path, body: `{"newSalary":1.234,"effectiveDate":"` + tomorrow + `"}`, wantStatus: 422, wantBody: "at most 2 decimal places"},
+		{name: "missing date", method: "POST", path: path, body: `{"newSalary":60000}`, wantStatus: 422, wantBody: "effectiveDate is required"},
+		{name: "in the past", method: "POST", path: path, body: `{"newSalary":60000,"effectiveDate":"2020-01-01T00:00:00Z"}`, wantStatus: 422, wantBody: "effectiveDate can't be in the past"},
+		{name: "plain date", method: "POST", path: path, body: `{"newSalary":60000,"effectiveDate":"` + tomorrow[:len("2006-01-02")] + `"}`, wantStatus: 201, wantBody: `"newSalary":60000`},
+		{name: "not a date", method: "POST", path: path, body: `{"newSalary":60000,"effectiveDate":"soon"}`, wantStatus: 400, wantBody: "effectiveDate must be a date like 2026-11-01"},
+		{name: "plain date in the past", method: "POST", path: path, body: `{"newSalary":60000,"effectiveDate":"2020-01-01"}`, wantStatus: 422, wantBody: "effectiveDate can't be in the past"},
+		{name: "unknown employee", method: "POST", path: "/employee/" + missingID + "/salary-history", body: `{"newSalary":60000,"effectiveDate":"` + tomorrow + `"}`, wantStatus: 404, wantBody: "employee not found"},
+		{name: "viewer can't schedule", role: roleViewer, method: "POST", path: path, body: `{"newSalary":60000,"effectiveDate":"` + tomorrow + `"}`, wantStatus: 403},
+		{name: "timeline of an unknown employee", method: "GET", path: "/employee/" + missingID + "/salary-history", wantStatus: 404},
+	})
+}
+
+func TestSalaryChanges(t *testing.T) {
+	gone := time.Now().UTC()
+	old := Employee{ID: primitive.NewObjectID(), Name: "Old Timer", Email: "old@example.com", Salary: MoneyFromInt(90000), DeletedAt: &gone}
+	salaries := newFakeSalaryChangeRepository()
+	history := newFakeHistoryRepository()
+	repos := Repositories{
+		Database:        fakePinger{},
+		Employees:       newFakeRepository(john, old),
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         history,
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   salaries,
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	}
+	app := newApp(testConfig(), repos)
+	path := "/employee/" + johnID.Hex()
+
+	nextMonth := time.Now().UTC().AddDate(0, 1, 0).Truncate(24 * time.Hour)
+	later := nextMonth.AddDate(0, 1, 0)
+	for i, body := range []string{
+		`{"newSalary":60000,"effectiveDate":"` + later.Format(time.RFC3339) + `","reason":"promotion"}`,
+		`{"newSalary":55000,"effectiveDate":"` + nextMonth.Format(time.RFC3339) + `","reason":"annual raise"}`,
+	} {
+		status, body := request(t, app, roleAdmin, "POST", path+"/salary-history", body)
+		var created SalaryChange
+		if err := json.Unmarshal([]byte(body), &created); err != nil || status != 201 {
+			t.Fatalf("schedule %d: status = %d, body %q", i, status, body)
+		}
+		if created.Status != salaryScheduled || created.EmployeeID != johnID || created.CreatedBy != "tester" || created.OldSalary != nil {
+			t.Errorf("scheduled change = %+v, want a scheduled change for john by tester", created)
+		}
+	}
+	// the deleted employee's change is kept for when they are restored
+	salaries.Create(context.Background(), &SalaryChange{EmployeeID: old.ID, NewSalary: MoneyFromInt(1), EffectiveDate: nextMonth})
+
+	// nothing is due yet
+	if applied, err := applyDueSalaryChanges(context.Background(), repos, time.Now().UTC()); applied != 0 || err != nil {
+		t.Errorf("applied %d changes before they were due, err %v", applied, err)
+	}
+	if _, body := request(t, app, roleViewer, "GET", path, ""); salaryOf(t, body) != "50000" {
+		t.Errorf("salary before the changes = %s, want 50000", salaryOf(t, body))
+	}
+
+	// a day into next month only the first change applies
+	if applied, err := applyDueSalaryChanges(context.Background(), repos, nextMonth.AddDate(0, 0, 1)); applied != 1 || err != nil {
+		t.Errorf("applied %d changes next month, err %v, want 1", applied, err)
+	}
+	if _, body := request(t, app, roleViewer, "GET", path, ""); salaryOf(t, body) != "55000" {
+		t.Errorf("salary next month = %s, want 55000", salaryOf(t, body))
+	}
+	if revisions, _ := history.FindByEmployee(context.Background(), johnID); len(revisions) != 1 || revisions[0].ChangedBy != "tester" {
+		t.Errorf("revisions = %+v, want one put down to tester", revisions)
+	}
+
+	// then the second, over the salary the first left, and never twice
+	if applied, err := applyDueSalaryChanges(context.Background(), repos, later.AddDate(0, 0, 1)); applied != 1 || err != nil {
+		t.Errorf("applied %d changes the month after, err %v, want 1", applied, err)
+	}
+
+	status, body := request(t, app, roleViewer, "GET", path+"/salary-history", "")
+	var timeline []SalaryChange
+	if err := json.Unmarshal([]byte(body), &timeline); err != nil || status != 200 || len(timeline) != 2 {
+		t.Fatalf("timeline: status = %d, body %q", status, body)
+	}
+	for i, want := range []struct{ reason, old, new string }{{"annual raise", "50000", "55000"}, {"promotion", "55000", "60000"}} {
+		got := timeline[i]
+		if got.Reason != want.reason || got.Status != salaryApplied || got.OldSalary == nil || got.OldSalary.String() != want.old || got.NewSalary.String() != want.new || got.AppliedAt == nil {
+			t.Errorf("timeline[%d] = %+v, want %s applied from %s to %s", i, got, want.reason, want.old, want.new)
+		}
+	}
+	if _, body := request(t, app, roleViewer, "GET", path, ""); salaryOf(t, body) != "60000" {
+		t.Errorf("salary at the end = %s, want 60000", salaryOf(t, body))
+	}
+	if changes, _ := salaries.FindByEmployee(context.Background(), old.ID); len(changes) != 1 || changes[0].Status != salaryScheduled {
+		t.Errorf("deleted employee's changes = %+v, want it still scheduled", changes)
+	}
+}
+
+func TestSalaryScheduler(t *testing.T) {
+	employees := newFakeRepository(john)
+	salaries := newFakeSalaryChangeRepository()
+	salaries.Create(context.Background(), &SalaryChange{EmployeeID: johnID, NewSalary: MoneyFromInt(52000), EffectiveDate: time.Now().UTC().Add(-time.Hour)})
+	repos := Repositories{
+		Employees:     employees,
+		Transactor:    fakeTransactor{},
+		AuditLogs:     newFakeAuditRepository(),
+		History:       newFakeHistoryRepository(),
+		SalaryChanges: salaries,
+		Events:        newFakeEventPublisher(),
+	}
+
+	// closing waits for the first run, which is straight away
+	startSalaryScheduler(withOperationTimeout(context.Background(), time.Second), time.Hour, repos, newReadOnlyMode(true)).close()
+	if e, _ := employees.FindByID(context.Background(), johnID); e.Salary.String() != "50000" {
+		t.Errorf("salary in read-only mode = %s, want it left at 50000", e.Salary)
+	}
+	startSalaryScheduler(withOperationTimeout(context.Background(), time.Second), time.Hour, repos, newReadOnlyMode(false)).close()
+	if e, _ := employees.FindByID(context.Background(), johnID); e.Salary.String() != "52000" {
+		t.Errorf("salary = %s, want the due change applied", e.Salary)
+	}
+}
+
+func TestSalaryChangeRace(t *testing.T) {
+	ctx := context.Background()
+	employees := newFakeRepository(john)
+	salaries := newFakeSalaryChangeRepository()
+	audit := newFakeAuditRepository()
+	repos := Repositories{
+		Employees:     employees,
+		Transactor:    fakeTransactor{},
+		AuditLogs:     audit,
+		History:       newFakeHistoryRepository(),
+		SalaryChanges: salaries,
+		Events:        newFakeEventPublisher(),
+	}
+	now := time.Now().UTC()
+	first, _ := salaries.Create(ctx, &SalaryChange{EmployeeID: johnID, NewSalary: MoneyFromInt(52000), EffectiveDate: now.Add(-2 * time.Hour)})
+
+	// two instances find the first change due, one applies it and then a
+	// newer change before the other gets to the first. fakeTransactor doesn't
+	// roll back, like a standalone server
+	due, _ := salaries.FindDue(ctx, now)
+	if applied, err := applyDueSalaryChanges(ctx, repos, now); applied != 1 || err != nil {
+		t.Fatalf("first instance applied %d, err %v, want 1", applied, err)
+	}
+	salaries.Create(ctx, &SalaryChange{EmployeeID: johnID, NewSalary: MoneyFromInt(54000), EffectiveDate: now.Add(-time.Hour)})
+	if applied, err := applyDueSalaryChanges(ctx, repos, now); applied != 1 || err != nil {
+		t.Fatalf("newer change: applied %d, err %v, want 1", applied, err)
+	}
+	entries := len(audit.entries)
+
+	if len(due) != 1 || due[0].ID != first.ID {
+		t.Fatalf("due = %+v, want the first change", due)
+	}
+	if ok, err := applySalaryChange(ctx, repos, due[0], now); ok || err != nil {
+		t.Errorf("second instance applied the change again: %v, err %v", ok, err)
+	}
+	if e, _ := employees.FindByID(ctx, johnID); e.Salary.String() != "54000" {
+		t.Errorf("salary = %s, want the newer change's 54000 kept", e.Salary)
+	}
+	if len(audit.entries) != entries {
+		t.Errorf("the losing instance wrote %d audit entries", len(audit.entries)-entries)
+	}
+}
+
+// salaryOf returns the salary of the employee in the JSON body
+func salaryOf(t *testing.T, body string) string {
+	t.Helper()
+	var e Employee
+	if err := json.Unmarshal([]byte(body), &e); err != nil {
+		t.Fatalf("decoding employee %q: %v", body, err)
+	}
+	return e.Salary.String()
+}
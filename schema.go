@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaFiles are the JSON schemas the request bodies are checked against
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// employeeSchema is the body of creating or replacing an employee
+var employeeSchema = mustCompileSchema("employee.json")
+
+// mustCompileSchema compiles one of the embedded schemas. They are part of the
+// binary, so one that doesn't compile is a bug and panics at startup
+func mustCompileSchema(name string) *jsonschema.Schema {
+	data, err := schemaFiles.ReadFile("schemas/" + name)
+	if err != nil {
+		panic(err)
+	}
+	compiler := jsonschema.NewCompiler()
+	// formats like date-time are only annotations in the newer drafts unless asked for
+	compiler.AssertFormat = true
+	if err := compiler.AddResource(name, bytes.NewReader(data)); err != nil {
+		panic(err)
+	}
+	return compiler.MustCompile(name)
+}
+
+// matchesSchema checks the JSON body against the schema before the handler
+// parses it, and answers 400 listing every field that doesn't match: ones
+// of the wrong type, and ones the schema doesn't know of. Empty, non JSON and
+// malformed bodies are left to the handler, so they get its usual 400 and 415
+func matchesSchema(schema *jsonschema.Schema) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if len(bytes.TrimSpace(c.Body())) == 0 || !c.Is("json") {
+			return c.Next()
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(c.Body()))
+		// so numbers are checked as they were sent, not as a float64
+		decoder.UseNumber()
+		var body interface{}
+		if err := decoder.Decode(&body); err != nil {
+			return c.Next()
+		}
+
+		err := schema.Validate(body)
+		var validationErr *jsonschema.ValidationError
+		if errors.As(err, &validationErr) {
+			fields := schemaFieldErrors(validationErr)
+			sort.SliceStable(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+			return &APIError{Code: fiber.StatusBadRequest, Message: "the body doesn't match the schema", Details: fields}
+		}
+		if err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// schemaFieldErrors flattens the tree of schema errors into one FieldError per
+// problem, each at the dotted path of the field it is about
+func schemaFieldErrors(err *jsonschema.ValidationError) []FieldError {
+	if len(err.Causes) > 0 {
+		var fields []FieldError
+		for _, cause := range err.Causes {
+			fields = append(fields, schemaFieldErrors(cause)...)
+		}
+		return fields
+	}
+
+	field := strings.ReplaceAll(strings.TrimPrefix(err.InstanceLocation, "/"), "/", ".")
+	// the unknown fields of an object are reported together, as
+	// "additionalProperties 'a', 'b' not allowed", so they are split up here
+	if strings.HasSuffix(err.KeywordLocation, "/additionalProperties") {
+		names := strings.TrimSuffix(strings.TrimPrefix(err.Message, "additionalProperties "), " not allowed")
+		var fields []FieldError
+		for _, name := range strings.Split(names, ", ") {
+			name = strings.Trim(name, "'")
+			if field != "" {
+				name = field + "." + name
+			}
+			fields = append(fields, FieldError{Field: name, Message: fmt.Sprintf("%s is not a known field", name)})
+		}
+		return fields
+	}
+	return []FieldError{{Field: field, Message: err.Message}}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEmployeeSchema(t *testing.T) {
+	path := "/employee/" + johnID.Hex()
+
+	runHandlerTests(t, []handlerTest{
+		{name: "unknown field", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"nickname":"JJ"}`, wantStatus: 400, wantBody: `"message":"the body doesn't match the schema","details":[{"field":"nickname","message":"nickname is not a known field","value":"JJ"}]`},
+		{name: "several unknown fields", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"role":"admin","team":"a"}`, wantStatus: 400, wantBody: `[{"field":"role","message":"role is not a known field","value":"admin"},{"field":"team"`},
+		{name: "unknown address field", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"address":{"city":"Berlin","planet":"Earth"}}`, wantStatus: 400, wantBody: `"field":"address.planet","message":"address.planet is not a known field"`},
+		{name: "wrong type", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":"1000","age":20}`, wantStatus: 400, wantBody: `{"field":"salary","message":"expected number, but got string","value":"1000"}`},
+		{name: "bad id", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"departmentId":"engineering"}`, wantStatus: 400, wantBody: `"field":"departmentId"`},
+		{name: "bad date", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"hireDate":"last year"}`, wantStatus: 400, wantBody: `"field":"hireDate"`},
+		{name: "not an object", method: "POST", path: "/employee", body: `[{"name":"Jane"}]`, wantStatus: 400, wantBody: "expected object, but got array"},
+		// the ranges and required fields are still the handler's 422
+		{name: "missing fields", method: "POST", path: "/employee", body: `{"name":"Jane"}`, wantStatus: 422, wantBody: `"field":"email","message":"email is required"`},
+		{name: "null ids", method: "POST", path: "/employee", body: `{"name":"Jane","email":"jane@example.com","salary":1,"age":20,"departmentId":null,"managerId":null,"address":null}`, wantStatus: 201},
+		{name: "unknown field in a replace", method: "PUT", path: path, body: `{"name":"John","email":"john@example.com","salary":1,"age":30,"salaryBand":3}`, ifMatch: "*", wantStatus: 400, wantBody: `"field":"salaryBand"`},
+		{name: "not JSON", method: "POST", path: "/employee", body: `{"name":`, wantStatus: 400, wantBody: "unexpected EOF"},
+	})
+}
+
+func TestEmployeeSchemaTakesReadRecord(t *testing.T) {
+	app := newTestApp(newFakeRepository(john), newFakeDepartmentRepository(engineering))
+	path := "/employee/" + johnID.Hex()
+
+	// a record as GET returns it can be edited and sent back
+	_, body := request(t, app, roleViewer, "GET", path, "")
+	req := newRequest(t, roleAdmin, "PUT", path, strings.Replace(body, `"John Doe"`, `"John Smith"`, 1))
+	req.Header.Set("If-Match", "*")
+	resp, body := send(t, app, req)
+	if resp.StatusCode != 200 || !strings.Contains(body, `"name":"John Smith"`) {
+		t.Errorf("status = %d, body %q, want the record sent back to be taken", resp.StatusCode, body)
+	}
+}
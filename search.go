@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SearchRequest is the body of POST /employee/search, a filter together with
+// the pagination and sorting the list takes as query params
+type SearchRequest struct {
+	Filter SearchFilter `json:"filter"`
+	Page   int64        `json:"page"`
+	Limit  int64        `json:"limit"`
+	SortBy string       `json:"sortBy"`
+	Order  string       `json:"order"`
+}
+
+// SearchFilter is one node of a search filter. It is either a condition on a
+// single field, e.g {"field": "salary", "op": "gte", "value": 50000}, or the
+// AND or OR of other filters. An empty filter matches every employee
+type SearchFilter struct {
+	And   []SearchFilter `json:"and,omitempty"`
+	Or    []SearchFilter `json:"or,omitempty"`
+	Field string         `json:"field,omitempty"`
+	// Op is one of eq, ne, gt, gte, lt, lte, in or contains
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// the kinds of value a searchable field holds, which decide the operators it
+// takes and how the JSON value is converted for mongo
+type fieldKind int
+
+const (
+	stringField fieldKind = iota
+	numberField
+	moneyField
+	dateField
+	idField
+)
+
+// the fields clients can search on
+var searchableFields = map[string]fieldKind{
+	"name":         stringField,
+	"email":        stringField,
+	"position":     stringField,
+	"salary":       moneyField,
+	"age":          numberField,
+	"hireDate":     dateField,
+	"departmentId": idField,
+	"managerId":    idField,
+}
+
+// the operators each kind of field takes
+var searchOperators = map[fieldKind]map[string]bool{
+	stringField: {"eq": true, "ne": true, "in": true, "contains": true},
+	numberField: {"eq": true, "ne": true, "gt": true, "gte": true, "lt": true, "lte": true, "in": true},
+	moneyField:  {"eq": true, "ne": true, "gt": true, "gte": true, "lt": true, "lte": true, "in": true},
+	dateField:   {"eq": true, "ne": true, "gt": true, "gte": true, "lt": true, "lte": true},
+	idField:     {"eq": true, "ne": true, "in": true},
+}
+
+// limits on the size of a filter, so one search can't make mongo do unbounded work
+const (
+	maxSearchDepth      = 5
+	maxSearchConditions = 50
+)
+
+// toQuery turns the filter into a mongo query, checking every field, operator
+// and value on the way. The error says what is wrong with the filter
+func (f *SearchFilter) toQuery() (bson.D, error) {
+	conditions := 0
+	return f.query(1, &conditions)
+}
+
+func (f *SearchFilter) query(depth int, conditions *int) (bson.D, error) {
+	if depth > maxSearchDepth {
+		return nil, fmt.Errorf("filters can be nested at most %d deep", maxSearchDepth)
+	}
+
+	set := 0
+	for _, present := range []bool{f.And != nil, f.Or != nil, f.Field != ""} {
+		if present {
+			set++
+		}
+	}
+	switch {
+	case set == 0:
+		return bson.D{}, nil
+	case set > 1:
+		return nil, fmt.Errorf("a filter must have only one of and, or and field")
+	case f.And != nil:
+		return f.combine("$and", f.And, depth, conditions)
+	case f.Or != nil:
+		return f.combine("$or", f.Or, depth, conditions)
+	}
+
+	*conditions++
+	if *conditions > maxSearchConditions {
+		return nil, fmt.Errorf("a filter can have at most %d conditions", maxSearchConditions)
+	}
+	return f.condition()
+}
+
+// combine builds an $and or $or of the sub filters
+func (f *SearchFilter) combine(operator string, filters []SearchFilter, depth int, conditions *int) (bson.D, error) {
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("%s needs at least one filter", operator[1:])
+	}
+	queries := make(bson.A, len(filters))
+	for i := range filters {
+		query, err := filters[i].query(depth+1, conditions)
+		if err != nil {
+			return nil, err
+		}
+		queries[i] = query
+	}
+	return bson.D{{Key: operator, Value: queries}}, nil
+}
+
+// condition builds the query for a filter on a single field
+func (f *SearchFilter) condition() (bson.D, error) {
+	kind, ok := searchableFields[f.Field]
+	if !ok {
+		return nil, fmt.Errorf("cannot search on %q", f.Field)
+	}
+	if !searchOperators[kind][f.Op] {
+		return nil, fmt.Errorf("%q is not an operator %s takes", f.Op, f.Field)
+	}
+
+	// contains is a case insensitive partial match, with the term escaped like ?search=
+	if f.Op == "contains" {
+		term, ok := f.Value.(string)
+		if !ok || term == "" {
+			return nil, fmt.Errorf("%s contains needs a string", f.Field)
+		}
+		return bson.D{{Key: f.Field, Value: primitive.Regex{Pattern: regexp.QuoteMeta(term), Options: "i"}}}, nil
+	}
+
+	var value interface{}
+	if f.Op == "in" {
+		values, ok := f.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("%s in needs a list of values", f.Field)
+		}
+		converted := make(bson.A, len(values))
+		for i, v := range values {
+			c, err := searchValue(f.Field, kind, v)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = c
+		}
+		value = converted
+	} else {
+		var err error
+		if value, err = searchValue(f.Field, kind, f.Value); err != nil {
+			return nil, err
+		}
+	}
+	return bson.D{{Key: f.Field, Value: bson.D{{Key: "$" + f.Op, Value: value}}}}, nil
+}
+
+// checkOperatorKeys refuses a JSON body with a key starting with $ anywhere in
+// it. It runs before the body is decoded, which would refuse the key too but
+// only as a field it doesn't know. Bodies that aren't JSON are left to parseJSON
+func checkOperatorKeys(c *fiber.Ctx) error {
+	var body interface{}
+	if !c.Is("json") || json.Unmarshal(c.Body(), &body) != nil {
+		return nil
+	}
+	if key, ok := operatorKey(body, ""); ok {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("keys can't start with $, found %q", key))
+	}
+	return nil
+}
+
+// operatorKey finds a key starting with $ anywhere in the decoded JSON body,
+// returning where it is, e.g "filter.and.0.$where". The filter only ever puts
+// operators into the query itself, from the ops it knows, so a $ key can only
+// be an attempt to sneak one in and the whole search is refused rather than
+// the key ignored
+func operatorKey(value interface{}, path string) (string, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			if strings.HasPrefix(key, "$") {
+				return childPath, true
+			}
+			if found, ok := operatorKey(child, childPath); ok {
+				return found, true
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if found, ok := operatorKey(child, path+"."+strconv.Itoa(i)); ok {
+				return found, true
+			}
+		}
+	}
+	return "", false
+}
+
+// searchValue converts a value from the JSON body to what the field holds in
+// mongo. Only plain values are taken, never objects, so a value can't carry an
+// operator into the query. A null department id matches employees without a
+// department
+func searchValue(field string, kind fieldKind, value interface{}) (interface{}, error) {
+	switch kind {
+	case stringField:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("%s must be compared with a string", field)
+	case numberField:
+		if n, ok := value.(float64); ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("%s must be compared with a number", field)
+	case moneyField:
+		// the body was decoded into a float, its shortest form is the number the client sent
+		if n, ok := value.(float64); ok {
+			return ParseMoney(strconv.FormatFloat(n, 'f', -1, 64))
+		}
+		return nil, fmt.Errorf("%s must be compared with a number", field)
+	case dateField:
+		if s, ok := value.(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("%s must be compared with an RFC 3339 date, e.g 2020-01-31T00:00:00Z", field)
+	default:
+		if value == nil {
+			return nil, nil
+		}
+		if s, ok := value.(string); ok {
+			if id, err := primitive.ObjectIDFromHex(s); err == nil {
+				return id, nil
+			}
+		}
+		return nil, fmt.Errorf("%s must be compared with a 24 character hex string or null", field)
+	}
+}
+
+// Search returns a page of the employees matching a filter sent in the body,
+// for searches too involved for the list's query params
+//
+// @Summary Search employees
+// @Tags employees
+// @Accept json
+// @Produce json
+// @Produce xml
+// @Security BearerAuth
+// @Param search body SearchRequest true "The filter, page and sort"
+// @Success 200 {object} EmployeeList
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Router /employee/search [post]
+func (h *EmployeeHandler) Search(c *fiber.Ctx) error {
+	if err := checkOperatorKeys(c); err != nil {
+		return err
+	}
+	search := new(SearchRequest)
+	if err := parseJSON(c, search); err != nil {
+		return err
+	}
+
+	filter, err := search.Filter.toQuery()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	query := bson.D{notDeleted}
+	if len(filter) > 0 {
+		query = append(query, bson.E{Key: "$and", Value: bson.A{filter}})
+	}
+
+	sort, err := sortDocument(search.SortBy, search.Order)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	page, limit := clampPagination(search.Page, search.Limit)
+	findOptions := options.Find().SetSort(sort).SetSkip((page - 1) * limit).SetLimit(limit)
+
+	total, err := h.repo.Count(c.UserContext(), query)
+	if err != nil {
+		return err
+	}
+	employees, err := h.repo.FindAll(c.UserContext(), query, findOptions)
+	if err != nil {
+		return err
+	}
+
+	return respond(c, fiber.StatusOK, EmployeeList{
+		Data:  employees,
+		Page:  page,
+		Limit: limit,
+		Total: total,
+	})
+}
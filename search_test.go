@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSearchFilterToQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		// want is the query as relaxed extended JSON, wantErr part of the error
+		want    string
+		wantErr string
+	}{
+		{name: "empty", filter: `{}`, want: `{}`},
+		{
+			name:   "single condition",
+			filter: `{"field":"salary","op":"gte","value":50000}`,
+			want:   `{"salary":{"$gte":{"$numberDecimal":"50000"}}}`,
+		},
+		{
+			name:   "and with nested or",
+			filter: `{"and":[{"field":"name","op":"contains","value":"a.b"},{"or":[{"field":"age","op":"lt","value":30},{"field":"departmentId","op":"in","value":["` + engineeringID.Hex() + `",null]}]}]}`,
+			want:   `{"$and":[{"name":{"$regularExpression":{"pattern":"a\\.b","options":"i"}}},{"$or":[{"age":{"$lt":30.0}},{"departmentId":{"$in":[{"$oid":"` + engineeringID.Hex() + `"},null]}}]}]}`,
+		},
+		{
+			name:   "date",
+			filter: `{"field":"hireDate","op":"gt","value":"2020-01-01T00:00:00Z"}`,
+			want:   `{"hireDate":{"$gt":{"$date":"2020-01-01T00:00:00Z"}}}`,
+		},
+		{name: "unknown field", filter: `{"field":"password","op":"eq","value":"x"}`, wantErr: `cannot search on "password"`},
+		{name: "operator the field doesn't take", filter: `{"field":"name","op":"gt","value":"x"}`, wantErr: `"gt" is not an operator name takes`},
+		{name: "wrong value type", filter: `{"field":"age","op":"eq","value":"old"}`, wantErr: "age must be compared with a number"},
+		{name: "bad date", filter: `{"field":"hireDate","op":"eq","value":"yesterday"}`, wantErr: "RFC 3339"},
+		{name: "bad id", filter: `{"field":"departmentId","op":"eq","value":"nope"}`, wantErr: "24 character hex string"},
+		{name: "in without a list", filter: `{"field":"age","op":"in","value":30}`, wantErr: "needs a list"},
+		{name: "empty or", filter: `{"or":[]}`, wantErr: "or needs at least one filter"},
+		{name: "field and combinator", filter: `{"field":"age","op":"eq","value":1,"and":[{}]}`, wantErr: "only one of"},
+		{name: "too deep", filter: `{"and":[{"and":[{"and":[{"and":[{"and":[{"and":[{}]}]}]}]}]}]}`, wantErr: "nested at most 5 deep"},
+		// values are compared as they are, an object can't smuggle in an operator
+		{name: "operator as a value", filter: `{"field":"name","op":"eq","value":{"$ne":""}}`, wantErr: "must be compared with a string"},
+		{name: "operator in a list", filter: `{"field":"age","op":"in","value":[{"$gt":0}]}`, wantErr: "must be compared with a number"},
+		{name: "operator as the op", filter: `{"field":"name","op":"where","value":"1"}`, wantErr: "not an operator"},
+		{name: "too many conditions", filter: `{"or":[` + strings.Repeat(`{"field":"age","op":"eq","value":1},`, 50) + `{"field":"age","op":"eq","value":1}]}`, wantErr: "at most 50 conditions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var filter SearchFilter
+			if err := json.Unmarshal([]byte(tt.filter), &filter); err != nil {
+				t.Fatal(err)
+			}
+			query, err := filter.toQuery()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := bson.MarshalExtJSON(query, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("query = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchEmployees(t *testing.T) {
+	runHandlerTests(t, []handlerTest{
+		{name: "success", role: roleViewer, method: "POST", path: "/employee/search", body: `{"filter":{"field":"salary","op":"gte","value":1},"limit":500}`, wantStatus: 200, wantBody: `"page":1,"limit":100,"total":1`},
+		{name: "bad filter", method: "POST", path: "/employee/search", body: `{"filter":{"field":"ssn","op":"eq","value":"1"}}`, wantStatus: 400, wantBody: `cannot search on \"ssn\"`},
+		{name: "bad sort", method: "POST", path: "/employee/search", body: `{"sortBy":"email"}`, wantStatus: 400, wantBody: "cannot sort by"},
+		{name: "database error", repoErr: errDatabase, method: "POST", path: "/employee/search", body: `{}`, wantStatus: 500},
+		{name: "operator key", method: "POST", path: "/employee/search", body: `{"filter":{"$where":"sleep(1000)"}}`, wantStatus: 400, wantBody: `found \"filter.$where\"`},
+		{name: "nested operator key", method: "POST", path: "/employee/search", body: `{"filter":{"or":[{"field":"age","op":"eq","value":1},{"$expr":{}}]}}`, wantStatus: 400, wantBody: `found \"filter.or.1.$expr\"`},
+		{name: "top level operator key", method: "POST", path: "/employee/search", body: `{"$comment":"x"}`, wantStatus: 400, wantBody: "keys can't start with $"},
+	})
+}
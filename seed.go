@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// seed fills an empty employees collection with count fake employees, so a
+// fresh checkout has something to show. A collection that already has
+// employees is left alone unless force is set, in which case the fakes are
+// added alongside them
+func seed(ctx context.Context, repo EmployeeRepository, count int, force bool) error {
+	existing, err := repo.Count(ctx, bson.D{})
+	if err != nil {
+		return fmt.Errorf("counting employees: %w", err)
+	}
+	if existing > 0 && !force {
+		slog.Info("not seeding, there are already employees. Pass -force to seed anyway", slog.Int64("employees", existing))
+		return nil
+	}
+
+	employees := make([]*Employee, count)
+	for i := range employees {
+		employees[i] = fakeEmployee()
+	}
+
+	rowErrors, err := repo.CreateMany(ctx, employees)
+	if err != nil {
+		return fmt.Errorf("inserting employees: %w", err)
+	}
+	// an email gofakeit already handed out, or one that is taken, only skips that employee
+	inserted := 0
+	for _, rowErr := range rowErrors {
+		if rowErr == nil {
+			inserted++
+		}
+	}
+	slog.Info("seeded employees", slog.Int("inserted", inserted), slog.Int("skipped", count-inserted))
+	return nil
+}
+
+// fakeEmployee makes up an employee that passes validate
+func fakeEmployee() *Employee {
+	person := gofakeit.Person()
+	now := time.Now()
+	return &Employee{
+		Name:     person.FirstName + " " + person.LastName,
+		Email:    gofakeit.Email(),
+		Salary:   MoneyFromInt(int64(gofakeit.Number(30, 200) * 1000)),
+		Age:      float64(gofakeit.Number(21, 65)),
+		Position: person.Job.Title,
+		HireDate: gofakeit.DateRange(now.AddDate(-20, 0, 0), now).UTC().Truncate(24 * time.Hour),
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// illegalOperationCode is the error a standalone server answers the first
+// write of a transaction with, as it only supports them on a replica set.
+// Other illegal operations have the same code, so the message is checked too
+const illegalOperationCode = 20
+
+// Transactor runs several repository calls as one unit that commits or rolls
+// back together. fn must pass the ctx it is given to the repositories, that is
+// what ties their writes to the transaction
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// MongoTransactor is the Transactor backed by mongo sessions. Mongo only
+// supports transactions on a replica set or sharded cluster. Against a
+// standalone server, which is what the default MONGO_URI points at, the writes
+// are made one after the other without one instead
+type MongoTransactor struct {
+	client *mongo.Client
+	// unsupported is set once the server has refused a transaction, so the
+	// later ones aren't tried at all
+	unsupported atomic.Bool
+}
+
+// NewMongoTransactor creates a transactor starting its sessions on the client
+func NewMongoTransactor(client *mongo.Client) *MongoTransactor {
+	return &MongoTransactor{client: client}
+}
+
+// WithTransaction runs fn in a transaction, committing it when fn returns nil
+// and aborting it otherwise. The driver retries fn and the commit on transient
+// errors, so fn can run more than once and must not have other side effects.
+// When the server doesn't support transactions fn is run again without one,
+// its writes are then in order but not atomic
+func (t *MongoTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if t.unsupported.Load() {
+		return fn(ctx)
+	}
+
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	})
+	if transactionsUnsupported(err) {
+		// the write that was refused was the first of the transaction, so
+		// nothing of fn has been written yet
+		if !t.unsupported.Swap(true) {
+			slog.Warn("mongo doesn't support transactions, writing without them", slog.Any("error", err))
+		}
+		return fn(ctx)
+	}
+	return err
+}
+
+// transactionsUnsupported reports whether err is a server refusing a
+// transaction because it isn't part of a replica set
+func transactionsUnsupported(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(illegalOperationCode) &&
+		strings.Contains(serverErr.Error(), "Transaction numbers are only allowed")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestTransactionsUnsupported(t *testing.T) {
+	standalone := mongo.CommandError{Code: illegalOperationCode, Name: "IllegalOperation", Message: "Transaction numbers are only allowed on a replica set member or mongos"}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "standalone server", err: standalone, want: true},
+		{name: "wrapped", err: fmt.Errorf("deleting the employee: %w", standalone), want: true},
+		{name: "other illegal operation", err: mongo.CommandError{Code: illegalOperationCode, Message: "something else"}},
+		{name: "other error", err: errors.New("connection refused")},
+		{name: "no error"},
+	}
+	for _, tt := range tests {
+		if got := transactionsUnsupported(tt.err); got != tt.want {
+			t.Errorf("%s: transactionsUnsupported = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User is someone who can log in. Only the bcrypt hash of the password is
+// kept, and it is never sent back out
+type User struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty" swaggertype:"string"`
+	Username     string             `json:"username" bson:"username"`
+	PasswordHash string             `json:"-" bson:"passwordHash"`
+	// Role is roleAdmin or roleViewer, and goes into the user's access tokens
+	Role string `json:"role" bson:"role"`
+	// Active is cleared when the user is deactivated, after which they can't
+	// log in or refresh their tokens
+	Active bool `json:"active" bson:"active"`
+	// FailedLogins counts the wrong passwords since the last successful login
+	// or lockout. When it reaches LOGIN_MAX_ATTEMPTS the user can't log in until LockedUntil
+	FailedLogins int        `json:"failedLogins" bson:"failedLogins"`
+	LockedUntil  *time.Time `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt" bson:"updatedAt"`
+}
+
+// locked reports whether the user is locked out at now
+func (u *User) locked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
+
+// RefreshToken is a refresh token given out at login. Only the SHA-256 of the
+// token is stored, so the tokens can't be read back out of the database
+type RefreshToken struct {
+	Hash      string             `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	// RevokedAt is set at logout, after which the token no longer works
+	RevokedAt *time.Time `bson:"revokedAt,omitempty"`
+}
+
+// usable reports whether the token can still be exchanged for an access token at now
+func (t *RefreshToken) usable(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// hashPassword is the bcrypt hash of the password to store
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// dummyPasswordHash is checked against when there is no user with the
+// username, so an unknown username takes as long to refuse as a wrong password
+var dummyPasswordHash, _ = hashPassword("not the password of anyone")
+
+// checkPassword reports whether the password is the one hashed. A nil user,
+// one that doesn't exist, never matches
+func checkPassword(user *User, password string) bool {
+	if user == nil {
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
+
+// newRefreshToken generates a random refresh token, returning it along with
+// the hash it is stored under
+func newRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+// hashRefreshToken is what a refresh token is stored and looked up by
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
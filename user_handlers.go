@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// the limits on passwords. bcrypt only looks at the first 72 bytes, so longer
+// ones are refused rather than silently cut short
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// usernamePattern is what a username can be made of
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,64}$`)
+
+// CreateUserRequest is the body of POST /user
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Role is admin or viewer
+	Role string `json:"role"`
+}
+
+// validate checks the new user's fields and returns a map of field name to
+// the reason it failed
+func (r *CreateUserRequest) validate() map[string]string {
+	errs := make(map[string]string)
+
+	if !usernamePattern.MatchString(r.Username) {
+		errs["username"] = "username must be 3 to 64 letters, digits, dots, dashes or underscores"
+	}
+	if msg := checkNewPassword(r.Password); msg != "" {
+		errs["password"] = msg
+	}
+	if r.Role != roleAdmin && r.Role != roleViewer {
+		errs["role"] = fmt.Sprintf("role must be %s or %s", roleAdmin, roleViewer)
+	}
+	return errs
+}
+
+// PasswordRequest is the body of PUT /user/{id}/password
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// validate checks the new password with the same rules as a new user's
+func (r *PasswordRequest) validate() map[string]string {
+	errs := make(map[string]string)
+
+	if msg := checkNewPassword(r.Password); msg != "" {
+		errs["password"] = msg
+	}
+	return errs
+}
+
+func checkNewPassword(password string) string {
+	switch {
+	case len(password) < minPasswordLength:
+		return fmt.Sprintf("password must be at least %d characters", minPasswordLength)
+	case len(password) > maxPasswordLength:
+		return fmt.Sprintf("password can be at most %d bytes", maxPasswordLength)
+	}
+	return ""
+}
+
+// UserHandler holds the HTTP handlers for managing the users who can log in.
+// Every route is for admins only
+type UserHandler struct {
+	users  UserRepository
+	tokens RefreshTokenRepository
+	audit  AuditRepository
+}
+
+// NewUserHandler creates the user handlers on top of the repositories. The
+// refresh tokens are revoked when a user is deactivated or their password
+// changes, and every change is written to the audit log
+func NewUserHandler(users UserRepository, tokens RefreshTokenRepository, audit AuditRepository) *UserHandler {
+	return &UserHandler{users: users, tokens: tokens, audit: audit}
+}
+
+// auditUser is the user as kept in the audit log, without the password hash
+func auditUser(user *User) bson.M {
+	doc := auditSnapshot(user)
+	delete(doc, "passwordHash")
+	return doc
+}
+
+// List returns every user, ordered by username
+//
+// @Summary List users
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} User
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Router /user [get]
+func (h *UserHandler) List(c *fiber.Ctx) error {
+	users, err := h.users.FindAll(c.UserContext())
+	if err != nil {
+		return err
+	}
+	return c.JSON(users)
+}
+
+// Create adds a user who can log in with the password. Only its bcrypt hash is stored
+//
+// @Summary Create a user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param user body CreateUserRequest true "The new user"
+// @Success 201 {object} User
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /user [post]
+func (h *UserHandler) Create(c *fiber.Ctx) error {
+	req := new(CreateUserRequest)
+	if err := parseJSON(c, req); err != nil {
+		return err
+	}
+	if errs := req.validate(); len(errs) > 0 {
+		return newValidationError(errs)
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+	user, err := h.users.Create(c.UserContext(), &User{Username: req.Username, PasswordHash: hash, Role: req.Role, Active: true})
+	if err != nil {
+		return repositoryError(err, "user")
+	}
+	recordAudit(c, h.audit, auditCreate, usersCollection, user.ID.Hex(), nil, auditUser(user))
+	return c.Status(201).JSON(user)
+}
+
+// Deactivate stops a user from logging in, and revokes their refresh tokens.
+// The access tokens they already have work until they expire
+//
+// @Summary Deactivate a user
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User id"
+// @Success 200 {object} User
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Router /user/{id}/deactivate [post]
+func (h *UserHandler) Deactivate(c *fiber.Ctx) error {
+	userID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+
+	// an admin locking themselves out could leave nobody to undo it
+	user, err := h.users.FindByID(c.UserContext(), userID)
+	if err != nil {
+		return repositoryError(err, "user")
+	}
+	if claims := currentClaims(c); claims != nil && claims.Subject == user.Username {
+		return fiber.NewError(fiber.StatusConflict, "you can't deactivate yourself")
+	}
+
+	before := user
+	user, err = h.users.Deactivate(c.UserContext(), userID)
+	if err != nil {
+		return repositoryError(err, "user")
+	}
+	recordAudit(c, h.audit, auditUpdate, usersCollection, userID.Hex(), auditUser(before), auditUser(user))
+	if err := h.tokens.RevokeAll(c.UserContext(), userID); err != nil {
+		return err
+	}
+	return c.JSON(user)
+}
+
+// ChangePassword sets a new password for the user, and revokes their refresh
+// tokens so every session has to log in again with it
+//
+// @Summary Change a user's password
+// @Tags users
+// @Accept json
+// @Security BearerAuth
+// @Param id path string true "User id"
+// @Param password body PasswordRequest true "The new password"
+// @Success 204
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 415 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
+// @Router /user/{id}/password [put]
+func (h *UserHandler) ChangePassword(c *fiber.Ctx) error {
+	userID, err := parseID(c)
+	if err != nil {
+		return err
+	}
+	req := new(PasswordRequest)
+	if err := parseJSON(c, req); err != nil {
+		return err
+	}
+	if errs := req.validate(); len(errs) > 0 {
+		return newValidationError(errs)
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+	if err := h.users.SetPassword(c.UserContext(), userID, hash); err != nil {
+		return repositoryError(err, "user")
+	}
+	recordAudit(c, h.audit, auditPassword, usersCollection, userID.Hex(), nil, nil)
+	if err := h.tokens.RevokeAll(c.UserContext(), userID); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestUserManagement(t *testing.T) {
+	users := newFakeUserRepository()
+	tokens := newFakeRefreshTokenRepository()
+	app := newAuthTestApp(users, tokens)
+
+	// managing users is for admins only
+	if status, _ := request(t, app, roleViewer, "GET", "/user", ""); status != 403 {
+		t.Errorf("GET as a viewer: status = %d, want 403", status)
+	}
+	if status, _ := request(t, app, "", "POST", "/user", `{"username":"bob","password":"long enough","role":"viewer"}`); status != 401 {
+		t.Errorf("POST without a token: status = %d, want 401", status)
+	}
+
+	status, body := request(t, app, roleAdmin, "POST", "/user", `{"username":"bob","password":"long enough","role":"viewer"}`)
+	if status != 201 || strings.Contains(body, "password") || strings.Contains(body, "$2a$") {
+		t.Fatalf("create: status = %d, body %q, want a 201 without the password or its hash", status, body)
+	}
+	var bob User
+	if err := json.Unmarshal([]byte(body), &bob); err != nil || !bob.Active || bob.Role != roleViewer {
+		t.Fatalf("created user = %+v, err %v, want an active viewer", bob, err)
+	}
+	if stored := users.users[bob.ID]; stored.PasswordHash == "long enough" || !checkPassword(&stored, "long enough") {
+		t.Errorf("stored hash = %q, want the bcrypt hash of the password", stored.PasswordHash)
+	}
+	if status, _ := request(t, app, roleAdmin, "POST", "/user", `{"username":"bob","password":"another one","role":"admin"}`); status != 409 {
+		t.Errorf("duplicate username: status = %d, want 409", status)
+	}
+
+	status, body = request(t, app, roleAdmin, "GET", "/user", "")
+	if status != 200 || !strings.Contains(body, `"username":"bob"`) || strings.Contains(body, "$2a$") {
+		t.Errorf("list: status = %d, body %q", status, body)
+	}
+
+	// a new password works at login, and the old one and its sessions don't
+	_, body = request(t, app, "", "POST", "/login", `{"username":"bob","password":"long enough"}`)
+	var login TokenResponse
+	if err := json.Unmarshal([]byte(body), &login); err != nil || login.RefreshToken == "" {
+		t.Fatalf("login: body %q", body)
+	}
+	if status, body := request(t, app, roleAdmin, "PUT", "/user/"+bob.ID.Hex()+"/password", `{"password":"even longer"}`); status != 204 {
+		t.Fatalf("change password: status = %d, body %q", status, body)
+	}
+	if status, _ := request(t, app, "", "POST", "/refresh", `{"refreshToken":"`+login.RefreshToken+`"}`); status != 401 {
+		t.Errorf("refresh after a password change: status = %d, want 401", status)
+	}
+	if status, _ := request(t, app, "", "POST", "/login", `{"username":"bob","password":"long enough"}`); status != 401 {
+		t.Errorf("login with the old password: status = %d, want 401", status)
+	}
+	_, body = request(t, app, "", "POST", "/login", `{"username":"bob","password":"even longer"}`)
+	if err := json.Unmarshal([]byte(body), &login); err != nil || login.RefreshToken == "" {
+		t.Fatalf("login with the new password: body %q", body)
+	}
+
+	// a deactivated user can neither log in nor refresh
+	status, body = request(t, app, roleAdmin, "POST", "/user/"+bob.ID.Hex()+"/deactivate", "")
+	if status != 200 || !strings.Contains(body, `"active":false`) {
+		t.Fatalf("deactivate: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, "", "POST", "/login", `{"username":"bob","password":"even longer"}`); status != 401 || !strings.Contains(body, "invalid username or password") {
+		t.Errorf("login once deactivated: status = %d, body %q, want 401", status, body)
+	}
+	if status, _ := request(t, app, "", "POST", "/refresh", `{"refreshToken":"`+login.RefreshToken+`"}`); status != 401 {
+		t.Errorf("refresh once deactivated: status = %d, want 401", status)
+	}
+}
+
+func TestUserAudit(t *testing.T) {
+	users := newFakeUserRepository()
+	audit := newFakeAuditRepository()
+	app := newApp(testConfig(), Repositories{
+		Database:        fakePinger{},
+		Employees:       newFakeRepository(john),
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       audit,
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           users,
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          newFakeEventPublisher(),
+	})
+
+	status, body := request(t, app, roleAdmin, "POST", "/user", `{"username":"bob","password":"long enough","role":"viewer"}`)
+	var bob User
+	if err := json.Unmarshal([]byte(body), &bob); err != nil || status != 201 {
+		t.Fatalf("create: status = %d, body %q", status, body)
+	}
+	path := "/user/" + bob.ID.Hex()
+	if status, body := request(t, app, roleAdmin, "PUT", path+"/password", `{"password":"even longer"}`); status != 204 {
+		t.Fatalf("change password: status = %d, body %q", status, body)
+	}
+	if status, body := request(t, app, roleAdmin, "POST", path+"/deactivate", ""); status != 200 {
+		t.Fatalf("deactivate: status = %d, body %q", status, body)
+	}
+
+	var actions []string
+	for _, entry := range audit.entries {
+		if entry.Collection != usersCollection || entry.DocumentID != bob.ID.Hex() || entry.Actor != "tester" {
+			t.Errorf("entry %+v, want one for bob by tester", entry)
+		}
+		// the hash never makes it into the audit log
+		for _, doc := range []bson.M{entry.Before, entry.After} {
+			if _, ok := doc["passwordHash"]; ok {
+				t.Errorf("entry %+v holds the password hash", entry)
+			}
+		}
+		actions = append(actions, entry.Action)
+	}
+	if strings.Join(actions, ",") != "create,password,update" {
+		t.Fatalf("actions = %v, want create, password then update", actions)
+	}
+	if before, after := audit.entries[2].Before["active"], audit.entries[2].After["active"]; before != true || after != false {
+		t.Errorf("deactivation took active from %v to %v, want true to false", before, after)
+	}
+}
+
+func TestUserManagementErrors(t *testing.T) {
+	// the test tokens are issued to "tester"
+	tester := newTestUser(t, "tester", "long enough", roleAdmin)
+	app := newAuthTestApp(newFakeUserRepository(tester), newFakeRefreshTokenRepository())
+	missing := "/user/5f1d7f0e2b3c4d5e6f708192"
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "short password", method: "POST", path: "/user", body: `{"username":"bob","password":"short","role":"viewer"}`, wantStatus: 422, wantBody: "password must be at least 8 characters"},
+		{name: "long password", method: "POST", path: "/user", body: `{"username":"bob","password":"` + strings.Repeat("x", maxPasswordLength+1) + `","role":"viewer"}`, wantStatus: 422, wantBody: "password can be at most 72 bytes"},
+		{name: "bad username", method: "POST", path: "/user", body: `{"username":"b b","password":"long enough","role":"viewer"}`, wantStatus: 422, wantBody: `"field":"username"`},
+		{name: "unknown role", method: "POST", path: "/user", body: `{"username":"bob","password":"long enough","role":"owner"}`, wantStatus: 422, wantBody: "role must be admin or viewer"},
+		{name: "deactivate yourself", method: "POST", path: "/user/" + tester.ID.Hex() + "/deactivate", wantStatus: 409, wantBody: "you can't deactivate yourself"},
+		{name: "deactivate missing user", method: "POST", path: missing + "/deactivate", wantStatus: 404, wantBody: "user not found"},
+		{name: "password of missing user", method: "PUT", path: missing + "/password", body: `{"password":"long enough"}`, wantStatus: 404, wantBody: "user not found"},
+		{name: "short new password", method: "PUT", path: "/user/" + tester.ID.Hex() + "/password", body: `{"password":"short"}`, wantStatus: 422, wantBody: "password must be at least 8 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := request(t, app, roleAdmin, tt.method, tt.path, tt.body)
+			if status != tt.wantStatus || !strings.Contains(body, tt.wantBody) {
+				t.Errorf("status = %d, body %q, want %d and %q", status, body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrDuplicateUsername is returned when creating a user with a username that is taken
+var ErrDuplicateUsername = errors.New("a user with that username already exists")
+
+// UserRepository is everything the handlers need from the user store
+type UserRepository interface {
+	FindByID(ctx context.Context, id primitive.ObjectID) (*User, error)
+	FindByUsername(ctx context.Context, username string) (*User, error)
+	// FindAll returns every user, ordered by username
+	FindAll(ctx context.Context) ([]User, error)
+	// Create stores a new user, or fails with ErrDuplicateUsername
+	Create(ctx context.Context, user *User) (*User, error)
+	// Deactivate clears the active flag and returns the updated user
+	Deactivate(ctx context.Context, id primitive.ObjectID) (*User, error)
+	// SetPassword replaces the stored hash of the user's password
+	SetPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error
+	// RecordFailedLogin counts a wrong password against the user, locking them
+	// out for lockout once it is the maxAttempts one in a row. It returns the
+	// updated user, or ErrNotFound when the user doesn't exist or is locked already
+	RecordFailedLogin(ctx context.Context, id primitive.ObjectID, maxAttempts int, lockout time.Duration) (*User, error)
+	// ResetFailedLogins clears the count of wrong passwords and any lockout
+	ResetFailedLogins(ctx context.Context, id primitive.ObjectID) error
+}
+
+// RefreshTokenRepository is everything the handlers need from the refresh token store
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token *RefreshToken) error
+	// Find returns the token stored under the hash, revoked or expired ones too
+	Find(ctx context.Context, hash string) (*RefreshToken, error)
+	// Revoke stops the token from being used again. Revoking one that is
+	// already revoked, or doesn't exist, is not an error
+	Revoke(ctx context.Context, hash string) error
+	// RevokeAll revokes every token of the user
+	RevokeAll(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// MongoUserRepository is the UserRepository backed by a mongo collection
+type MongoUserRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoUserRepository creates a repository storing users in the collection
+func NewMongoUserRepository(collection *mongo.Collection) *MongoUserRepository {
+	return &MongoUserRepository{collection: collection}
+}
+
+// EnsureIndexes makes sure usernames are unique, which is also the index
+// logins look the user up with
+func (r *MongoUserRepository) EnsureIndexes(ctx context.Context) error {
+	return ensureIndexes(ctx, r.collection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetName("username_unique").SetUnique(true),
+		},
+	})
+}
+
+// FindByID returns the user with the id, or ErrNotFound
+func (r *MongoUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return r.findOne(ctx, bson.D{{Key: "_id", Value: id}})
+}
+
+// FindByUsername returns the user with the username, or ErrNotFound
+func (r *MongoUserRepository) FindByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return r.findOne(ctx, bson.D{{Key: "username", Value: username}})
+}
+
+// users stored before there was an active flag have none, and decoding leaves
+// the fields missing from the document as they are, so those users stay active
+func (r *MongoUserRepository) findOne(ctx context.Context, filter bson.D) (*User, error) {
+	user := &User{Active: true}
+	if err := r.collection.FindOne(ctx, filter).Decode(user); err != nil {
+		return nil, mapError(err, ErrDuplicateUsername)
+	}
+	return user, nil
+}
+
+// FindAll returns every user, ordered by username
+func (r *MongoUserRepository) FindAll(ctx context.Context) ([]User, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	opts := options.Find().SetSort(bson.D{{Key: "username", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := make([]User, 0)
+	for cursor.Next(ctx) {
+		user := User{Active: true}
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, cursor.Err()
+}
+
+// Create inserts the user, stamping createdAt and updatedAt
+func (r *MongoUserRepository) Create(ctx context.Context, user *User) (*User, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	now := time.Now().UTC()
+	user.ID = primitive.NilObjectID
+	user.CreatedAt, user.UpdatedAt = now, now
+
+	result, err := r.collection.InsertOne(ctx, user)
+	if err != nil {
+		return nil, mapError(err, ErrDuplicateUsername)
+	}
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	return user, nil
+}
+
+// Deactivate clears the active flag, or returns ErrNotFound
+func (r *MongoUserRepository) Deactivate(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "active", Value: false},
+		{Key: "updatedAt", Value: time.Now().UTC()},
+	}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	user := new(User)
+	if err := r.collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: id}}, update, opts).Decode(user); err != nil {
+		return nil, mapError(err, nil)
+	}
+	return user, nil
+}
+
+// SetPassword stores the new hash, or returns ErrNotFound
+func (r *MongoUserRepository) SetPassword(ctx context.Context, id primitive.ObjectID, passwordHash string) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "passwordHash", Value: passwordHash},
+		{Key: "updatedAt", Value: time.Now().UTC()},
+	}}}
+	result, err := r.collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordFailedLogin increments failedLogins, and on the attempt that reaches
+// maxAttempts sets lockedUntil and starts the count over, so the user gets
+// maxAttempts more tries once the lockout ends. It is one pipeline update
+// deciding from the stored count, so concurrent attempts can't both miss the lock
+func (r *MongoUserRepository) RecordFailedLogin(ctx context.Context, id primitive.ObjectID, maxAttempts int, lockout time.Duration) (*User, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	now := time.Now().UTC()
+	// attempts made while the user is locked out don't count towards the next lockout
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "$or", Value: bson.A{
+		bson.D{{Key: "lockedUntil", Value: nil}},
+		bson.D{{Key: "lockedUntil", Value: bson.D{{Key: "$lte", Value: now}}}},
+	}}}
+	attempts := bson.D{{Key: "$add", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$failedLogins", 0}}}, 1}}}
+	locks := bson.D{{Key: "$gte", Value: bson.A{attempts, maxAttempts}}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		{Key: "failedLogins", Value: bson.D{{Key: "$cond", Value: bson.A{locks, 0, attempts}}}},
+		{Key: "lockedUntil", Value: bson.D{{Key: "$cond", Value: bson.A{locks, now.Add(lockout), nil}}}},
+	}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	user := &User{Active: true}
+	if err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(user); err != nil {
+		return nil, mapError(err, nil)
+	}
+	return user, nil
+}
+
+// ResetFailedLogins sets failedLogins back to zero and removes lockedUntil
+func (r *MongoUserRepository) ResetFailedLogins(ctx context.Context, id primitive.ObjectID) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	update := bson.D{
+		{Key: "$set", Value: bson.D{{Key: "failedLogins", Value: 0}}},
+		{Key: "$unset", Value: bson.D{{Key: "lockedUntil", Value: ""}}},
+	}
+	_, err = r.collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, update)
+	return err
+}
+
+// MongoRefreshTokenRepository is the RefreshTokenRepository backed by a mongo collection
+type MongoRefreshTokenRepository struct {
+	collection *mongo.Collection
+}
+
+// NewMongoRefreshTokenRepository creates a repository storing refresh tokens in the collection
+func NewMongoRefreshTokenRepository(collection *mongo.Collection) *MongoRefreshTokenRepository {
+	return &MongoRefreshTokenRepository{collection: collection}
+}
+
+// EnsureIndexes creates the TTL index that has mongo delete the tokens once
+// they have expired, revoked or not, and the one RevokeAll finds the tokens of
+// a user with, rather than going through every token
+func (r *MongoRefreshTokenRepository) EnsureIndexes(ctx context.Context) error {
+	return ensureIndexes(ctx, r.collection, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}},
+			Options: options.Index().SetName("user_tokens"),
+		},
+	})
+}
+
+// Create stores the token
+func (r *MongoRefreshTokenRepository) Create(ctx context.Context, token *RefreshToken) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	_, err = r.collection.InsertOne(ctx, token)
+	return err
+}
+
+// Find returns the token stored under the hash, or ErrNotFound
+func (r *MongoRefreshTokenRepository) Find(ctx context.Context, hash string) (*RefreshToken, error) {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	token := new(RefreshToken)
+	if err := r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: hash}}).Decode(token); err != nil {
+		return nil, mapError(err, nil)
+	}
+	return token, nil
+}
+
+// Revoke sets revokedAt on the token, unless it is revoked already
+func (r *MongoRefreshTokenRepository) Revoke(ctx context.Context, hash string) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	filter := bson.D{{Key: "_id", Value: hash}, {Key: "revokedAt", Value: nil}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: time.Now().UTC()}}}}
+	_, err = r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// RevokeAll sets revokedAt on every token of the user that isn't revoked yet
+func (r *MongoRefreshTokenRepository) RevokeAll(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, done, err := startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	filter := bson.D{{Key: "userId", Value: userID}, {Key: "revokedAt", Value: nil}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "revokedAt", Value: time.Now().UTC()}}}}
+	_, err = r.collection.UpdateMany(ctx, filter, update)
+	return err
+}
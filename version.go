@@ -0,0 +1,54 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// the build the binary came from, set at build time with e.g
+//
+//	go build -ldflags "-X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// When they are not set they fall back to what the go tool recorded from git, if anything
+var (
+	commit    string
+	buildTime string
+)
+
+// BuildInfo says which build is running
+type BuildInfo struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// buildInfo is worked out once, it can't change while the process runs
+var buildInfo = func() BuildInfo {
+	info := BuildInfo{Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}()
+
+// versionHandler reports the build that is running, to check a deploy shipped what it should
+func versionHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(buildInfo)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// the events sent to the webhooks
+const (
+	eventEmployeeCreated   = "employee.created"
+	eventEmployeeUpdated   = "employee.updated"
+	eventEmployeeDeleted   = "employee.deleted"
+	eventEmployeeRestored  = "employee.restored"
+	eventEmployeePurged    = "employee.purged"
+	eventEmployeesImported = "employees.imported"
+)
+
+// the headers of a webhook delivery. The signature is the hex HMAC-SHA256 of
+// the body with WEBHOOK_SECRET, prefixed with "sha256="
+const (
+	headerWebhookEvent     = "X-Webhook-Event"
+	headerWebhookID        = "X-Webhook-ID"
+	headerWebhookSignature = "X-Webhook-Signature"
+)
+
+// how deliveries are made. A delivery is tried webhookAttempts times, waiting
+// webhookBackoff after the first failure and twice as long after each one
+// after that. Events are dropped, and logged, when more than webhookQueueSize
+// deliveries are waiting
+const (
+	webhookAttempts  = 3
+	webhookBackoff   = time.Second
+	webhookTimeout   = 10 * time.Second
+	webhookWorkers   = 4
+	webhookQueueSize = 1000
+)
+
+// EventPublisher is told about every change made to the employees
+type EventPublisher interface {
+	// Publish sends the event about data, the record as it is after the change.
+	// It doesn't wait for the event to be delivered
+	Publish(eventType string, data interface{})
+}
+
+// WebhookEvent is the body POSTed to the webhooks
+type WebhookEvent struct {
+	// ID is the same for every delivery of the event, including retries, so
+	// receivers can tell a retry from another event
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookDelivery is an event waiting to be sent to one of the urls
+type webhookDelivery struct {
+	url       string
+	eventID   string
+	eventType string
+	body      []byte
+}
+
+// Webhooks is the EventPublisher POSTing each event to a list of urls. The
+// requests are made in the background by a few workers, so a slow or failing
+// receiver doesn't hold up the request that made the change
+type Webhooks struct {
+	urls    []string
+	secret  []byte
+	client  *http.Client
+	backoff time.Duration
+
+	queue chan webhookDelivery
+	wg    sync.WaitGroup
+}
+
+// NewWebhooks creates the publisher sending the events to the urls, signed
+// with the secret. With no urls the events go nowhere
+func NewWebhooks(urls []string, secret string) *Webhooks {
+	w := &Webhooks{
+		urls:    urls,
+		secret:  []byte(secret),
+		client:  &http.Client{Timeout: webhookTimeout},
+		backoff: webhookBackoff,
+		queue:   make(chan webhookDelivery, webhookQueueSize),
+	}
+	if len(urls) > 0 {
+		for i := 0; i < webhookWorkers; i++ {
+			w.wg.Add(1)
+			go w.work()
+		}
+	}
+	return w
+}
+
+// Publish queues the event for every url. The data is encoded straight away,
+// so later changes to it aren't sent
+func (w *Webhooks) Publish(eventType string, data interface{}) {
+	if len(w.urls) == 0 {
+		return
+	}
+	event := WebhookEvent{ID: utils.UUIDv4(), Type: eventType, Timestamp: time.Now().UTC(), Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("encoding webhook event", slog.String("event_id", event.ID), slog.String("event_type", eventType), slog.Any("error", err))
+		return
+	}
+	for _, url := range w.urls {
+		select {
+		case w.queue <- webhookDelivery{url: url, eventID: event.ID, eventType: eventType, body: body}:
+		default:
+			slog.Warn("dropping webhook, too many deliveries waiting", slog.String("event_id", event.ID), slog.String("event_type", eventType), slog.String("url", url))
+		}
+	}
+}
+
+// Close stops taking events and waits for the queued ones to be delivered,
+// or for ctx to be done, whichever comes first
+func (w *Webhooks) Close(ctx context.Context) error {
+	close(w.queue)
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for webhook deliveries: %w", ctx.Err())
+	}
+}
+
+// work delivers queued events until the queue is closed and empty
+func (w *Webhooks) work() {
+	defer w.wg.Done()
+	for delivery := range w.queue {
+		w.deliver(delivery)
+	}
+}
+
+// deliver sends the event, retrying it when it fails. Anything but a 2xx
+// answer is a failure
+func (w *Webhooks) deliver(d webhookDelivery) {
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		err := w.send(d)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			slog.Error("giving up on webhook",
+				slog.String("event_id", d.eventID),
+				slog.String("event_type", d.eventType),
+				slog.String("url", d.url),
+				slog.Int("attempts", attempt),
+				slog.Any("error", err),
+			)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send makes one attempt at delivering the event
+func (w *Webhooks) send(d webhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerWebhookEvent, d.eventType)
+	req.Header.Set(headerWebhookID, d.eventID)
+	req.Header.Set(headerWebhookSignature, signWebhook(w.secret, d.body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhook is the signature header for the body. Receivers work it out
+// from the body they got and compare it with the header's
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
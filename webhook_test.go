@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEventPublisher keeps the events it is told about
+type fakeEventPublisher struct {
+	mu     sync.Mutex
+	events []WebhookEvent
+}
+
+func newFakeEventPublisher() *fakeEventPublisher {
+	return &fakeEventPublisher{}
+}
+
+func (p *fakeEventPublisher) Publish(eventType string, data interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, WebhookEvent{Type: eventType, Data: data})
+}
+
+func (p *fakeEventPublisher) types() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	types := []string{}
+	for _, e := range p.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestWebhooksDeliver(t *testing.T) {
+	var mu sync.Mutex
+	var received []*http.Request
+	var bodies [][]byte
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r)
+		bodies = append(bodies, body)
+		// the first attempt fails, the retry goes through
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	webhooks := NewWebhooks([]string{server.URL}, "webhook-secret")
+	webhooks.backoff = time.Millisecond
+	webhooks.Publish(eventEmployeeCreated, &john)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := webhooks.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("%d deliveries, want a failed one and its retry", len(received))
+	}
+	req, body := received[1], bodies[1]
+	if got, want := req.Header.Get(headerWebhookSignature), signWebhook([]byte("webhook-secret"), body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if req.Header.Get(headerWebhookEvent) != eventEmployeeCreated || req.Header.Get(headerWebhookID) != received[0].Header.Get(headerWebhookID) {
+		t.Errorf("headers = %v, want the event type and the same id as the first attempt", req.Header)
+	}
+
+	var event struct {
+		ID   string   `json:"id"`
+		Type string   `json:"type"`
+		Data Employee `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatalf("decoding %q: %v", body, err)
+	}
+	if event.Type != eventEmployeeCreated || event.ID == "" || event.Data.ID != johnID {
+		t.Errorf("event = %+v, want john's creation", event)
+	}
+}
+
+func TestWebhooksGiveUp(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	webhooks := NewWebhooks([]string{server.URL}, "webhook-secret")
+	webhooks.backoff = time.Millisecond
+	webhooks.Publish(eventEmployeeDeleted, &john)
+	if err := webhooks.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != webhookAttempts {
+		t.Errorf("%d attempts, want %d", attempts, webhookAttempts)
+	}
+}
+
+func TestEmployeeChangesArePublished(t *testing.T) {
+	repo := newFakeRepository(john)
+	events := newFakeEventPublisher()
+	cfg := testConfig()
+	app := newApp(cfg, Repositories{
+		Database:        fakePinger{},
+		Employees:       repo,
+		Departments:     newFakeDepartmentRepository(),
+		Transactor:      fakeTransactor{},
+		IdempotencyKeys: newFakeIdempotencyRepository(),
+		AuditLogs:       newFakeAuditRepository(),
+		History:         newFakeHistoryRepository(),
+		LeaveRequests:   newFakeLeaveRepository(),
+		SalaryChanges:   newFakeSalaryChangeRepository(),
+		Attendance:      newFakeAttendanceRepository(),
+		Photos:          newFakePhotoRepository(),
+		Users:           newFakeUserRepository(),
+		RefreshTokens:   newFakeRefreshTokenRepository(),
+		Events:          events,
+	})
+	path := "/employee/" + johnID.Hex()
+
+	request(t, app, roleAdmin, "POST", "/employee", `{"name":"Jane Doe","email":"jane@example.com","salary":60000,"age":28}`)
+	request(t, app, roleAdmin, "PATCH", path, `{"age":31}`)
+	// a failed change isn't published
+	request(t, app, roleAdmin, "PATCH", path, `{"age":900}`)
+	request(t, app, roleAdmin, "DELETE", path+"?confirm=true", "")
+	request(t, app, roleAdmin, "POST", path+"/restore", "")
+
+	want := []string{eventEmployeeCreated, eventEmployeeUpdated, eventEmployeeDeleted, eventEmployeeRestored}
+	if got := events.types(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}